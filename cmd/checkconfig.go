@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/kirsrus/termopad-server/pkg/config"
+)
+
+// Команда check-config [файл]: проверка конфигурации с выводом всех найденных проблем. Файл
+// по умолчанию задаётся глобальным параметром --config
+func checkConfig(args []string) error {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if flags.NArg() > 0 {
+		configFile = flags.Arg(0)
+	}
+	if err := loadConfig(); err != nil {
+		return err
+	}
+	fmt.Printf("Конфигурация %s корректна\n", configFile)
+	return nil
+}
+
+// Вывод проблем конфигурации, по одной на строку
+func printConfigProblems(filepath string, problems config.ValidationError) {
+	fmt.Printf("ОШИБКА: конфигурация %s некорректна (%d):\n", filepath, len(problems))
+	for _, v := range problems {
+		fmt.Printf("  - %s\n", v)
+	}
+}
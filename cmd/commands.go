@@ -0,0 +1,675 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	reportCtlMod "github.com/kirsrus/termopad-server/controller/report"
+	retentionCtlMod "github.com/kirsrus/termopad-server/controller/retention"
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/imagecrypt"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	"github.com/kirsrus/termopad-server/pkg/validator"
+	thresholdsSvcMod "github.com/kirsrus/termopad-server/service/thresholds"
+	"github.com/kirsrus/termopad-server/store"
+	dbStoreMod "github.com/kirsrus/termopad-server/store/db"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Формат дат в параметрах командной строки
+const dateLayout = "2006-01-02"
+
+// Колонки CSV с описанием персоны (используются в export и import-persons)
+var personColumns = []string{"wigand", "family", "name", "middle_name", "organization", "department", "position"}
+
+// Чтение конфигурации и подключение к БД для служебных команд. Лог выводится только в stderr,
+// чтобы не смешиваться с выводом команды
+func openDb() (store.DbStore, error) {
+	if err := loadConfig(); err != nil {
+		return nil, err
+	}
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		level = logrus.WarnLevel
+	}
+	log = logrus.New()
+	log.Out = os.Stderr
+	log.Level = level
+
+	dbStore, err := dbStoreMod.NewDb(context.Background(), &dbStoreMod.ConfigDb{
+		Log:          log,
+		DbFile:       cfg.Db.Filename,
+		GlobalConfig: cfg,
+	})
+	if err != nil {
+		return nil, dbError(errors.Trace(err))
+	}
+	return dbStore, nil
+}
+
+// Запись в журнал аудита действия служебной команды от имени пользователя ОС. Выгрузка персональных данных
+// без записи в журнал не выполняется
+func auditCommand(dbStore store.DbStore, action string, target string, details string) error {
+	name := model.AuditSystemUser
+	if current, err := user.Current(); err == nil {
+		name = "cli:" + current.Username
+	}
+	err := dbStore.SetAudit(model.Audit{
+		CreateAt: time.Now(),
+		User:     name,
+		Action:   action,
+		Target:   target,
+		Details:  details,
+	})
+	if err != nil {
+		return dbError(errors.Annotate(err, "ошибка записи в журнал аудита"))
+	}
+	return nil
+}
+
+// Команда migrate: создание и миграция структуры БД (выполняется при подключении)
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if _, err := openDb(); err != nil {
+		return err
+	}
+	fmt.Printf("Структура БД %s актуальна\n", cfg.Db.Filename)
+	return nil
+}
+
+// Команда export [--from дата] [--to дата] [--out файл]: выгрузка лога замеров в CSV
+func export(args []string) error {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	from := flags.String("from", today.AddDate(0, 0, -7).Format(dateLayout), "начальная дата (включительно)")
+	to := flags.String("to", today.Format(dateLayout), "конечная дата (включительно)")
+	out := flags.String("out", "-", "файл для выгрузки (- для stdout)")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	fromDate, err := time.ParseInLocation(dateLayout, *from, time.Local)
+	if err != nil {
+		return usageError(errors.Annotate(err, "некорректная дата --from"))
+	}
+	toDate, err := time.ParseInLocation(dateLayout, *to, time.Local)
+	if err != nil {
+		return usageError(errors.Annotate(err, "некорректная дата --to"))
+	}
+
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	temps, err := dbStore.TemperatureLogByPeriod(fromDate, toDate.AddDate(0, 0, 1))
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
+	if err := auditCommand(dbStore, model.AuditExport, "", "from="+*from+" to="+*to); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer func() { _ = file.Close() }()
+		w = file
+	}
+	writer := csv.NewWriter(w)
+	header := append([]string{"time", "termopad", "cabina"}, personColumns...)
+	header = append(header, "temperature", "invalid", "image")
+	if err := writer.Write(header); err != nil {
+		return errors.Trace(err)
+	}
+	cabins := make(map[int]string)
+	for _, v := range cfg.TermopadsInfo() {
+		cabins[int(v.ID)] = strconv.Itoa(int(v.SudosID))
+	}
+	for _, v := range temps {
+		err := writer.Write([]string{
+			v.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(v.TermopadID),
+			cabins[v.TermopadID],
+			strconv.Itoa(int(v.Person.Wigand.ID)),
+			v.Person.Family,
+			v.Person.Name,
+			v.Person.MiddleName,
+			v.Person.Organization,
+			v.Person.Department,
+			v.Person.Position,
+			strconv.FormatFloat(v.Temperature, 'f', 1, 64),
+			strconv.FormatBool(v.Invalid),
+			v.ImageName,
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Fprintf(os.Stderr, "Выгружено замеров: %d\n", len(temps))
+	return nil
+}
+
+// Команда contacts --wigand номер [--from дата] [--to дата] [--window минуты] [--cabins id,id] [--out файл]:
+// выгрузка в CSV персон, измерявшихся на тех же термопадах рядом по времени с замерами указанной персоны
+func contacts(args []string) error {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	flags := flag.NewFlagSet("contacts", flag.ContinueOnError)
+	wigand := flags.Uint("wigand", 0, "номер карты виганд персоны")
+	from := flags.String("from", today.AddDate(0, 0, -14).Format(tool.DateLayout), "начало периода (дата или \"дата время\")")
+	to := flags.String("to", today.Format(tool.DateLayout), "окончание периода (дата включительно или \"дата время\")")
+	window := flags.Uint("window", 15, "окно контакта до и после замера в минутах")
+	cabins := flags.String("cabins", "", "идентификаторы термопадов через запятую (по умолчанию все)")
+	out := flags.String("out", "-", "файл для выгрузки (- для stdout)")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *wigand == 0 {
+		return usageError(errors.New("не указан номер карты --wigand"))
+	}
+	if *window == 0 {
+		return usageError(errors.New("окно контакта --window должно быть положительным"))
+	}
+	fromTime, toTime, err := tool.ParsePeriod(*from, *to)
+	if err != nil {
+		return usageError(err)
+	}
+	termopads := make([]uint, 0)
+	for _, v := range strings.Split(*cabins, ",") {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || id <= 0 {
+			return usageError(errors.Errorf("некорректный идентификатор термопада \"%s\" в --cabins", v))
+		}
+		termopads = append(termopads, uint(id))
+	}
+
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	result, err := dbStore.Contacts(*wigand, fromTime, toTime, time.Duration(*window)*time.Minute, termopads)
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
+	details := fmt.Sprintf("from=%s to=%s windowMinutes=%d", *from, *to, *window)
+	if err := auditCommand(dbStore, model.AuditContacts, strconv.Itoa(int(*wigand)), details); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer func() { _ = file.Close() }()
+		w = file
+	}
+	writer := csv.NewWriter(w)
+	header := append([]string{}, personColumns...)
+	header = append(header, "overlaps", "termopads", "first_at", "last_at")
+	if err := writer.Write(header); err != nil {
+		return errors.Trace(err)
+	}
+	for _, v := range result {
+		ids := make([]string, 0, len(v.Termopads))
+		for _, id := range v.Termopads {
+			ids = append(ids, strconv.Itoa(int(id)))
+		}
+		err := writer.Write([]string{
+			strconv.Itoa(int(v.Person.Wigand.ID)),
+			v.Person.Family,
+			v.Person.Name,
+			v.Person.MiddleName,
+			v.Person.Organization,
+			v.Person.Department,
+			v.Person.Position,
+			strconv.Itoa(int(v.Overlaps)),
+			strings.Join(ids, " "),
+			v.FirstAt.Format(time.RFC3339),
+			v.LastAt.Format(time.RFC3339),
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Fprintf(os.Stderr, "Найдено контактов: %d\n", len(result))
+	return nil
+}
+
+// Команда report [--date дата] [--shift смена] [--format html|csv] [--out файл]: формирование сводного отчёта.
+// Отчёт за завершившийся период сохраняется в БД и выгружается в директорию report.path
+func report(args []string) error {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	date := flags.String("date", today.AddDate(0, 0, -1).Format(tool.DateLayout), "сутки отчёта")
+	shift := flags.String("shift", "", "имя смены из report.shifts (по умолчанию сутки целиком)")
+	format := flags.String("format", model.ReportFormatHTML, "формат вывода: html или csv")
+	out := flags.String("out", "-", "файл для вывода (- для stdout)")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	day, err := time.ParseInLocation(tool.DateLayout, *date, time.Local)
+	if err != nil {
+		return usageError(errors.Annotate(err, "некорректная дата --date"))
+	}
+	if *format != model.ReportFormatHTML && *format != model.ReportFormatCSV {
+		return usageError(errors.Errorf("неизвестный формат --format \"%s\"", *format))
+	}
+
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	thresholdsSvc, err := thresholdsSvcMod.NewThresholds(context.Background(), dbStore, &thresholdsSvcMod.ConfigThresholds{
+		Log:            log,
+		MaxTemperature: cfg.Termopad.MaxTemperature,
+		MinTemperature: cfg.Termopad.MinTemperature,
+	})
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
+	reportCtl, err := reportCtlMod.NewReport(context.Background(), dbStore, thresholdsSvc, &reportCtlMod.ConfigReport{
+		Log:       log,
+		Termopads: cfg.TermopadsInfo(),
+		Shifts:    shiftsFromConfig(cfg),
+		Path:      cfg.Report.Path,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	result, err := reportCtl.Generate(day, *shift)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return usageError(err)
+		}
+		return dbError(errors.Trace(err))
+	}
+	target := *date
+	if *shift != "" {
+		target += "/" + *shift
+	}
+	if err := auditCommand(dbStore, model.AuditReport, target, "format="+*format); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer func() { _ = file.Close() }()
+		w = file
+	}
+	if err := reportCtl.Render(w, result, *format); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Fprintf(os.Stderr, "Замеров: %d, с повышенной температурой: %d, по неизвестным картам: %d\n",
+		result.Total, result.Fever, result.Unknown)
+	return nil
+}
+
+// Команда import-persons [--delimiter символ] файл.csv: загрузка персон, внесённых вручную. Первая строка
+// файла - заголовок с именами колонок (wigand, family, name, middle_name, organization, department, position)
+func importPersons(args []string) error {
+	flags := flag.NewFlagSet("import-persons", flag.ContinueOnError)
+	delimiter := flags.String("delimiter", ",", "разделитель колонок")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if flags.NArg() != 1 || len([]rune(*delimiter)) != 1 {
+		return usageError(errors.New("укажите один файл CSV и односимвольный разделитель"))
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer func() { _ = file.Close() }()
+	reader := csv.NewReader(file)
+	reader.Comma = []rune(*delimiter)[0]
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return errors.Annotate(err, "ошибка чтения заголовка CSV")
+	}
+	columns := make(map[string]int)
+	for i, v := range header {
+		columns[strings.ToLower(strings.TrimSpace(v))] = i
+	}
+	for _, v := range []string{"wigand", "family", "name"} {
+		if _, ok := columns[v]; !ok {
+			return errors.Errorf("в заголовке CSV нет колонки %s", v)
+		}
+	}
+
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	var added, updated, failed int
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Annotatef(err, "ошибка чтения строки %d", line)
+		}
+		value := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		wigand, err := strconv.ParseUint(value("wigand"), 10, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "строка %d: некорректный виганд \"%s\"\n", line, value("wigand"))
+			failed++
+			continue
+		}
+		person := model.Person{
+			Wigand:       model.Wigand{ID: uint(wigand)},
+			Family:       value("family"),
+			Name:         value("name"),
+			MiddleName:   value("middle_name"),
+			Organization: value("organization"),
+			Department:   value("department"),
+			Position:     value("position"),
+			Manual:       true,
+		}
+		// Некорректные строки пропускаются, а ошибка записи в БД прерывает загрузку
+		if err := validator.Get().Validate(&person); err != nil {
+			fmt.Fprintf(os.Stderr, "строка %d: %v\n", line, err)
+			failed++
+			continue
+		}
+		_, isNew, err := dbStore.SetPerson(person)
+		if err != nil {
+			fmt.Printf("Добавлено персон: %d, обновлено: %d, с ошибками: %d\n", added, updated, failed)
+			return dbError(errors.Annotatef(err, "ошибка записи персоны из строки %d", line))
+		}
+		if isNew {
+			added++
+		} else {
+			updated++
+		}
+	}
+	fmt.Printf("Добавлено персон: %d, обновлено: %d, с ошибками: %d\n", added, updated, failed)
+	if failed != 0 {
+		return errors.Errorf("не загружено персон: %d", failed)
+	}
+	return nil
+}
+
+// Команда clean [--dry-run] [--days N]: очистка архива замеров
+func clean(args []string) error {
+	flags := flag.NewFlagSet("clean", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "только показать, что будет удалено")
+	days := flags.Int("days", 0, "срок хранения архива в днях (по умолчанию db.archivedays)")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	if *days == 0 {
+		*days = cfg.Db.ArchiveDays
+	}
+	if *days <= 0 {
+		return usageError(errors.Errorf("некорректный срок хранения архива: %d", *days))
+	}
+
+	result, err := dbStore.Clean(*days, *dryRun)
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
+	action := "Удалено"
+	if *dryRun {
+		action = "Будет удалено"
+	}
+	fmt.Printf("%s записей замеров старше %d дней: %d\n", action, *days, result.Temperatures)
+	fmt.Printf("%s записей журнала доставки вебхуков: %d\n", action, result.WebhookDeliveries)
+	fmt.Printf("%s тревог: %d\n", action, result.Alarms)
+	fmt.Printf("%s директорий изображений: %d\n", action, len(result.ImageDirs))
+	for _, v := range result.ImageDirs {
+		fmt.Printf("  %s\n", v)
+	}
+	return nil
+}
+
+// Команда reprocess-images [--dry-run]: проверка файлов изображений замеров и персон
+func reprocessImages(args []string) error {
+	flags := flag.NewFlagSet("reprocess-images", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "только показать, что будет перемещено")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	result, err := dbStore.ReprocessImages(*dryRun)
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
+	fmt.Printf("Проверено изображений: %d\n", result.Checked)
+	action := "Перемещено"
+	if *dryRun {
+		action = "Будет перемещено"
+	}
+	fmt.Printf("%s в директории своего дня и часа: %d\n", action, len(result.Moved))
+	for _, v := range result.Moved {
+		fmt.Printf("  %s\n", v)
+	}
+	fmt.Printf("Некорректных файлов: %d\n", len(result.Invalid))
+	for _, v := range result.Invalid {
+		fmt.Printf("  %s\n", v)
+	}
+	return nil
+}
+
+// Команда reconcile [--recreate [--termopad N]] [--clean-images] [--clean-log] [--min-age D]: сверка изображений
+// замеров с логом температуры. Без параметров только выводит изображения без записей в логе и записи без
+// изображений
+func reconcile(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	recreate := flags.Bool("recreate", false, "восстановить записи лога для изображений без записей")
+	termopad := flags.Uint("termopad", 0, "термопад восстанавливаемых записей, не найденных в тревогах (температура неизвестна)")
+	cleanImages := flags.Bool("clean-images", false, "удалить изображения без записей, для которых запись не восстановлена")
+	cleanLog := flags.Bool("clean-log", false, "удалить записи лога без изображений")
+	minAge := flags.Duration("min-age", 10*time.Minute, "не учитывать замеры моложе указанного времени")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *termopad != 0 && !*recreate {
+		return usageError(errors.New("--termopad указывается вместе с --recreate"))
+	}
+	if *minAge < 0 {
+		return usageError(errors.Errorf("некорректный возраст замеров: %s", *minAge))
+	}
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	result, err := dbStore.Reconcile(time.Now().Add(-*minAge), store.ReconcileOptions{
+		Recreate:          *recreate,
+		Termopad:          *termopad,
+		CleanImages:       *cleanImages,
+		CleanTemperatures: *cleanLog,
+	})
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
+
+	fmt.Printf("Проверено изображений: %d, записей лога: %d\n", result.Images, result.Temperatures)
+	fmt.Printf("Изображений без записей в логе: %d\n", len(result.OrphanImages))
+	for _, v := range result.OrphanImages {
+		fmt.Printf("  %s\n", v)
+	}
+	fmt.Printf("Записей лога без изображений: %d\n", len(result.OrphanTemperatures))
+	for _, v := range result.OrphanTemperatures {
+		fmt.Printf("  %d: %s, термопад %d, карта %d, %s\n", v.ID, v.CreatedAt.Format("2006-01-02 15:04:05"),
+			v.TermopadID, v.Person.Wigand.ID, v.ImageName)
+	}
+	if *recreate {
+		fmt.Printf("Восстановлено записей: %d\n", len(result.Recreated))
+		for _, v := range result.Recreated {
+			fmt.Printf("  %s\n", v)
+		}
+		if len(result.Erased) != 0 {
+			fmt.Printf("Не восстановлено (данные персоны стёрты): %d\n", len(result.Erased))
+			for _, v := range result.Erased {
+				fmt.Printf("  %s\n", v)
+			}
+		}
+	}
+	if *cleanImages {
+		fmt.Printf("Удалено изображений: %d\n", len(result.RemovedImages))
+	}
+	if *cleanLog {
+		fmt.Printf("Удалено записей лога: %d\n", result.RemovedTemperatures)
+	}
+	return nil
+}
+
+// Команда rekey (--new-key-file F | --new-key-env E | --decrypt): перешифрование всех изображений замеров и
+// персон новым ключом. Текущий ключ берётся из images.encryption. Выполняется при остановленном сервере, после
+// чего в images.encryption указывается новый ключ
+func rekey(args []string) error {
+	flags := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	keyFile := flags.String("new-key-file", "", "файл с новым ключом шифрования")
+	keyEnv := flags.String("new-key-env", "", "переменная окружения с новым ключом шифрования")
+	decrypt := flags.Bool("decrypt", false, "расшифровать изображения (отключение шифрования)")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *decrypt == (*keyFile != "" || *keyEnv != "") {
+		return usageError(errors.New("укажите новый ключ (--new-key-file или --new-key-env) либо --decrypt"))
+	}
+	newKey, err := imagecrypt.LoadKey(*keyFile, *keyEnv)
+	if err != nil {
+		return usageError(err)
+	}
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	result, err := dbStore.RekeyImages(newKey)
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
+	fmt.Printf("Проверено изображений: %d\n", result.Checked)
+	fmt.Printf("Перешифровано: %d\n", result.Rekeyed)
+	fmt.Printf("Уже зашифровано новым ключом: %d\n", result.Skipped)
+	if len(result.Failed) != 0 {
+		fmt.Printf("Не удалось расшифровать текущим ключом: %d\n", len(result.Failed))
+		for _, v := range result.Failed {
+			fmt.Printf("  %s\n", v)
+		}
+		return errors.New("часть изображений не перешифрована")
+	}
+	if newKey == nil {
+		fmt.Println("Изображения расшифрованы. Удалите ключ из секции images.encryption конфигурации")
+	} else {
+		fmt.Println("Укажите новый ключ в секции images.encryption конфигурации")
+	}
+	return nil
+}
+
+// Команда hash-password [--cost N]: формирование хеша bcrypt пароля пользователя WEB-интерфейса для
+// http.users[].password. Пароль читается из первой строки stdin
+func hashPassword(args []string) error {
+	flags := flag.NewFlagSet("hash-password", flag.ContinueOnError)
+	cost := flags.Int("cost", bcrypt.DefaultCost, "сложность хеширования")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *cost < bcrypt.MinCost || *cost > bcrypt.MaxCost {
+		return usageError(errors.Errorf("сложность --cost должна быть от %d до %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	fmt.Fprintln(os.Stderr, "Введите пароль:")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Trace(err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return usageError(errors.New("пароль не может быть пустым"))
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(string(hash))
+	return nil
+}
+
+// Команда erase-person --wigand номер: стирание персональных данных персоны в режиме privacy.erasure
+// с записью в журнал аудита
+func erasePerson(args []string) error {
+	flags := flag.NewFlagSet("erase-person", flag.ContinueOnError)
+	wigand := flags.Uint("wigand", 0, "номер карты виганд персоны")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *wigand == 0 {
+		return usageError(errors.New("не указан номер карты --wigand"))
+	}
+
+	dbStore, err := openDb()
+	if err != nil {
+		return err
+	}
+	retentionCtl, err := retentionCtlMod.NewRetention(context.Background(), dbStore, &retentionCtlMod.ConfigRetention{
+		Log:  log,
+		Mode: cfg.Privacy.Erasure,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	erasure, err := retentionCtl.ErasePerson(*wigand)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return usageError(err)
+		}
+		return dbError(errors.Trace(err))
+	}
+	if err := auditCommand(dbStore, model.AuditErasePerson, strconv.Itoa(int(*wigand)), erasure.Summary()); err != nil {
+		return err
+	}
+	fmt.Printf("Данные персоны %d стёрты: %s\n", *wigand, erasure.Summary())
+	return nil
+}
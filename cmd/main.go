@@ -2,20 +2,34 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/kirsrus/termopad-server/controller/manager"
+	personSyncCtlMod "github.com/kirsrus/termopad-server/controller/personsync"
+	queueCtlMod "github.com/kirsrus/termopad-server/controller/queue"
+	reportCtlMod "github.com/kirsrus/termopad-server/controller/report"
+	retentionCtlMod "github.com/kirsrus/termopad-server/controller/retention"
+	supervisorCtlMod "github.com/kirsrus/termopad-server/controller/supervisor"
 	termopadCtlMod "github.com/kirsrus/termopad-server/controller/termopad"
 	"github.com/kirsrus/termopad-server/model"
 	"github.com/kirsrus/termopad-server/pkg/config"
 	"github.com/kirsrus/termopad-server/pkg/logger"
+	"github.com/kirsrus/termopad-server/pkg/wiegand"
 	"github.com/kirsrus/termopad-server/service"
+	mqttSvcMod "github.com/kirsrus/termopad-server/service/mqtt"
+	notifySvcMod "github.com/kirsrus/termopad-server/service/notify"
 	sudosStoreMod "github.com/kirsrus/termopad-server/service/sudos"
 	termopadStoreMod "github.com/kirsrus/termopad-server/service/termopad"
+	thresholdsSvcMod "github.com/kirsrus/termopad-server/service/thresholds"
 	webSvcMod "github.com/kirsrus/termopad-server/service/web"
+	webhookSvcMod "github.com/kirsrus/termopad-server/service/webhook"
 	dbStoreMod "github.com/kirsrus/termopad-server/store/db"
 
 	"github.com/juju/errors"
@@ -23,12 +37,237 @@ import (
 )
 
 var (
-	cfg *config.Config
-	log *logrus.Logger
+	cfg        *config.Config
+	log        *logrus.Logger
+	configFile string
 )
 
-func init() {
-	cfg = config.Get()
+// Коды завершения программы
+const (
+	exitOK      = 0
+	exitRuntime = 1
+	exitUsage   = 2
+	exitConfig  = 3
+	exitDb      = 4
+)
+
+// Команда командной строки
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+// Список команд (первая выполняется, если команда не указана)
+var commands = []command{
+	{"serve", "запуск сервера", serve},
+	{"replay", "запуск сервера с воспроизведением захвата сообщений термопадов", replay},
+	{"migrate", "создание и миграция структуры БД", migrate},
+	{"check-config", "проверка конфигурации с выводом всех проблем", checkConfig},
+	{"export", "выгрузка лога замеров температуры в CSV", export},
+	{"contacts", "выгрузка контактов персоны на термопадах в CSV", contacts},
+	{"report", "формирование сводного отчёта за сутки или смену", report},
+	{"import-persons", "загрузка персон из CSV как внесённых вручную", importPersons},
+	{"clean", "очистка архива замеров старше db.archivedays дней", clean},
+	{"reprocess-images", "проверка и раскладка файлов изображений по директориям", reprocessImages},
+	{"reconcile", "сверка изображений замеров с логом температуры", reconcile},
+	{"rekey", "перешифрование изображений новым ключом (при остановленном сервере)", rekey},
+	{"hash-password", "хеш bcrypt пароля пользователя WEB-интерфейса", hashPassword},
+	{"erase-person", "стирание персональных данных персоны", erasePerson},
+}
+
+// Ошибка с кодом завершения программы
+type exitError struct {
+	code int
+	err  error
+}
+
+func (m exitError) Error() string {
+	return m.err.Error()
+}
+
+// Ошибка конфигурации
+func configError(err error) error {
+	return exitError{code: exitConfig, err: err}
+}
+
+// Ошибка работы с БД
+func dbError(err error) error {
+	return exitError{code: exitDb, err: err}
+}
+
+// Ошибка в параметрах командной строки
+func usageError(err error) error {
+	return exitError{code: exitUsage, err: err}
+}
+
+func main() {
+	flags := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	flags.StringVar(&configFile, "config", config.FileName, "путь к файлу конфигурации")
+	flags.Usage = func() { usage(flags) }
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitUsage)
+	}
+
+	args := flags.Args()
+	cmd := commands[0]
+	if len(args) > 0 {
+		found := false
+		for _, v := range commands {
+			if v.name == args[0] {
+				cmd, found = v, true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "ОШИБКА: неизвестная команда \"%s\"\n", args[0])
+			usage(flags)
+			os.Exit(exitUsage)
+		}
+		args = args[1:]
+	}
+
+	err := cmd.run(args)
+	if err == nil {
+		os.Exit(exitOK)
+	}
+	code := exitRuntime
+	if e, ok := err.(exitError); ok {
+		code = e.code
+		err = e.err
+	}
+	if problems, ok := err.(config.ValidationError); ok {
+		printConfigProblems(configFile, problems)
+	} else if err != flag.ErrHelp {
+		fmt.Fprintf(os.Stderr, "ОШИБКА: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+// Вывод справки по командам
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Использование: %s [--config файл] [команда] [параметры]\n\nКоманды:\n", flags.Name())
+	for _, v := range commands {
+		fmt.Fprintf(out, "  %-18s %s\n", v.name, v.description)
+	}
+	fmt.Fprintf(out, "\nГлобальные параметры:\n")
+	flags.PrintDefaults()
+	fmt.Fprintf(out, "\nКоды завершения: %d - конфигурация, %d - БД, %d - параметры, %d - прочие ошибки\n",
+		exitConfig, exitDb, exitUsage, exitRuntime)
+}
+
+// Чтение и проверка конфигурации из configFile
+func loadConfig() error {
+	var err error
+	cfg, err = config.Check(configFile)
+	if err != nil {
+		return configError(err)
+	}
+	// Формат карт объекта используется для всех карт, формат которых не указан явно
+	if err := wiegand.SetDefault(cfg.Termopad.CardFormat); err != nil {
+		return configError(err)
+	}
+	return nil
+}
+
+// Команда serve [--record файл]: запуск сервера. С --record сообщения термопадов, скачанные изображения и
+// изменения доступности термопадов записываются в файл захвата для воспроизведения командой replay
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	record := flags.String("record", "", "файл захвата сообщений термопадов (дописывается)")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if err := setupServer(); err != nil {
+		return err
+	}
+
+	var recorder *termopadStoreMod.Recorder
+	if *record != "" {
+		var err error
+		recorder, err = termopadStoreMod.NewRecorder(&termopadStoreMod.ConfigRecorder{
+			Log:      log,
+			FileName: *record,
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer func() { _ = recorder.Close() }()
+	}
+	dedupeWindow := time.Minute * time.Duration(cfg.Termopad.DedupeWindow)
+
+	return startServer(func(ctx context.Context, info model.TermopadInfo, onStatus func(online bool)) (service.TermopadSvc, error) {
+		return termopadStoreMod.NewWebsocket(ctx, &termopadStoreMod.ConfigWebsocket{
+			Log:          log,
+			TermopadInfo: info,
+			DedupeWindow: dedupeWindow,
+			OnStatus:     onStatus,
+			Recorder:     recorder,
+		})
+	})
+}
+
+// Команда replay --capture файл [--speed N]: запуск сервера, в котором термопады воспроизводят сообщения из
+// файла захвата, записанного serve --record. Замеры проходят всю обработку сервера (БД, СУДОС, уведомления),
+// поэтому воспроизводить захват следует с отдельной конфигурацией и БД
+func replay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	captureFile := flags.String("capture", "", "файл захвата сообщений термопадов")
+	speed := flags.Float64("speed", 1, "скорость воспроизведения: 1 - реальное время, 10 - в 10 раз быстрее, 0 - без пауз")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if *captureFile == "" {
+		return usageError(errors.New("не указан файл захвата --capture"))
+	}
+	if *speed < 0 {
+		return usageError(errors.New("скорость воспроизведения --speed не может быть отрицательной"))
+	}
+	capture, err := termopadStoreMod.LoadCapture(*captureFile)
+	if err != nil {
+		return usageError(err)
+	}
+	if err := setupServer(); err != nil {
+		return err
+	}
+
+	configured := make(map[uint]bool)
+	for _, v := range cfg.TermopadsInfo() {
+		configured[v.ID] = true
+	}
+	for _, id := range capture.Termopads() {
+		if !configured[id] {
+			log.Warnf("термопада %d из захвата нет в конфигурации, его сообщения не воспроизводятся", id)
+		}
+	}
+	dedupeWindow := time.Minute * time.Duration(cfg.Termopad.DedupeWindow)
+
+	// Начало воспроизведения общее для всех термопадов, чтобы сохранить порядок их сообщений
+	var begin time.Time
+	var once sync.Once
+	return startServer(func(ctx context.Context, info model.TermopadInfo, onStatus func(online bool)) (service.TermopadSvc, error) {
+		once.Do(func() { begin = time.Now() })
+		return termopadStoreMod.NewReplay(ctx, &termopadStoreMod.ConfigReplay{
+			Log:          log,
+			TermopadInfo: info,
+			Capture:      capture,
+			Speed:        *speed,
+			Begin:        begin,
+			DedupeWindow: dedupeWindow,
+			OnStatus:     onStatus,
+		})
+	})
+}
+
+// Чтение конфигурации и настройка логирования сервера
+func setupServer() error {
+	if err := loadConfig(); err != nil {
+		return err
+	}
 	level, err := logrus.ParseLevel(cfg.Log.Level)
 	if err != nil {
 		level = logrus.WarnLevel
@@ -38,26 +277,34 @@ func init() {
 		Level:   level,
 		Console: cfg.Log.Console,
 	})
+	return nil
 }
 
-func main() {
-
-	err := run()
-	if err != nil {
-		fmt.Printf("ОШИБКА: в процессе работы произошла ошибка: %v\n", err)
+// Запуск сервера с созданием служб термопадов через newTermopad до завершения работы
+func startServer(newTermopad termopadFactory) error {
+	if err := run(newTermopad); err != nil {
+		cause := err
+		if e, ok := err.(exitError); ok {
+			cause = e.err
+		}
+		log.Error(errors.ErrorStack(cause))
 		fmt.Printf("Для подробностей смотри лог: %s/%s\n", cfg.Log.Path, cfg.Log.Filename)
-		log.Fatal(errors.ErrorStack(err))
+		return err
 	}
+	return nil
 }
 
-func run() error {
+func run(newTermopad termopadFactory) error {
 	// Отлавливаем сигнал завершения работы программы
 	chanInterrupt := make(chan os.Signal, 1)
-	signal.Notify(chanInterrupt, os.Interrupt)
+	signal.Notify(chanInterrupt, os.Interrupt, syscall.SIGTERM)
 
-	done := make(chan error)
+	done := make(chan error, 1)
 	ctx, cancel := context.WithCancel(context.Background())
-	_ = cancel
+	defer cancel()
+	// Отдельный контекст термопадов, чтобы при завершении работы первым прекратить приём замеров
+	termopadCtx, termopadCancel := context.WithCancel(ctx)
+	defer termopadCancel()
 
 	// region Настройка БД
 
@@ -67,51 +314,233 @@ func run() error {
 		GlobalConfig: cfg,
 	})
 	if err != nil {
-		return errors.Trace(err)
+		return dbError(errors.Trace(err))
 	}
 
 	// endregion
-	// region Инициализация термопадов
-	// Формирование списка опрашиваемых термопадов и запуск их мониторинга
+	// region Пороги температуры
 
-	termopads := make([]service.TermopadSvc, 0)
-	termopadsInfo := make([]model.TermopadInfo, 0)
-	for _, i := range cfg.Termopad.Info {
-		termopadInfo := model.TermopadInfo{
-			ID:           i.ID,
-			URL:          i.Address,
-			SudosID:      i.Cabina,
-			Name:         i.Name,
-			SerialNumber: 0,
-			Description:  i.Description,
-		}
+	thresholdsSvc, err := thresholdsSvcMod.NewThresholds(ctx, dbStore, &thresholdsSvcMod.ConfigThresholds{
+		Log:            log,
+		MaxTemperature: cfg.Termopad.MaxTemperature,
+		MinTemperature: cfg.Termopad.MinTemperature,
+	})
+	if err != nil {
+		return dbError(errors.Trace(err))
+	}
 
-		termoStore, err := termopadStoreMod.NewWebsocket(ctx, &termopadStoreMod.ConfigWebsocket{
-			Log:          log,
-			TermopadInfo: termopadInfo,
+	// endregion
+	// region Вебхуки
+
+	hooks := make([]webhookSvcMod.Hook, 0, len(cfg.Webhook.Hooks))
+	for _, v := range cfg.Webhook.Hooks {
+		hooks = append(hooks, webhookSvcMod.Hook{
+			Name:   v.Name,
+			URL:    v.URL,
+			Secret: v.Secret,
+			Events: v.Events,
 		})
-		if err != nil {
-			return errors.Trace(err)
-		}
-		termopads = append(termopads, termoStore)
-		termopadsInfo = append(termopadsInfo, termopadInfo)
 	}
+	webhookSvc, err := webhookSvcMod.NewWebhook(ctx, dbStore, &webhookSvcMod.ConfigWebhook{
+		Log:        log,
+		Hooks:      hooks,
+		Retries:    uint(cfg.Webhook.Retries),
+		Backoff:    time.Second * time.Duration(cfg.Webhook.Backoff),
+		MaxBackoff: time.Second * time.Duration(cfg.Webhook.MaxBackoff),
+		Timeout:    time.Second * time.Duration(cfg.Webhook.Timeout),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// endregion
+	// region Супервизор фоновых обработчиков
 
-	termopadsAll, err := termopadCtlMod.NewTermopad(ctx, termopads, dbStore, &termopadCtlMod.ConfigTermopad{
+	supervisorCtl, err := supervisorCtlMod.NewSupervisor(ctx, &supervisorCtlMod.ConfigSupervisor{
 		Log: log,
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	// Очередь принятых замеров. Работает в контексте менеджера, чтобы после остановки
+	// термопадов оставшиеся замеры были обработаны
+	queueCtl, err := queueCtlMod.NewQueue(ctx, &queueCtlMod.ConfigQueue{
+		Log:      log,
+		Capacity: cfg.Queue.Capacity,
+		Overflow: cfg.Queue.Overflow,
+		SpillDir: cfg.Queue.SpillDir,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	// endregion
 	// region Настройка СУДОС
 
 	sudosStore, err := sudosStoreMod.NewSudos(ctx, &sudosStoreMod.ConfigSudos{
 		Log:            log,
 		SudosUrl:       cfg.Sudos.Address,
-		MaxTemperature: cfg.Termopad.MaxTemperature,
-		MinTemperature: cfg.Termopad.MinTemperature,
+		TemplateNormal: cfg.Sudos.TemplateNormal,
+		TemplateAlarm:  cfg.Sudos.TemplateAlarm,
+		TemplateLower:  cfg.Sudos.TemplateLower,
+		ThresholdsSvc:  thresholdsSvc,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// Плановая синхронизация справочника персон с СУДОС (0 в конфигурации отключает её)
+	syncInterval := time.Minute * time.Duration(cfg.Sudos.SyncInterval)
+	if syncInterval == 0 {
+		syncInterval = -1
+	}
+	personSyncCtl, err := personSyncCtlMod.NewPersonSync(ctx, sudosStore, dbStore, &personSyncCtlMod.ConfigPersonSync{
+		Log:             log,
+		SyncInterval:    syncInterval,
+		RequestInterval: time.Millisecond * time.Duration(cfg.Sudos.SyncRequestInterval),
+		WebhookSvc:      webhookSvc,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// endregion
+	// region Публикация в MQTT
+
+	mqttSvc, err := mqttSvcMod.NewMQTT(ctx, &mqttSvcMod.ConfigMQTT{
+		Log:                log,
+		Address:            cfg.Mqtt.Address,
+		ClientID:           cfg.Mqtt.ClientID,
+		Username:           cfg.Mqtt.Username,
+		Password:           cfg.Mqtt.Password,
+		CAFile:             cfg.Mqtt.CAFile,
+		InsecureSkipVerify: cfg.Mqtt.InsecureSkipVerify,
+		QoS:                byte(cfg.Mqtt.QoS),
+		KeepAlive:          time.Second * time.Duration(cfg.Mqtt.KeepAlive),
+		Reconnect:          time.Second * time.Duration(cfg.Mqtt.Reconnect),
+		TopicMeasurement:   cfg.Mqtt.TopicMeasurement,
+		TopicStatus:        cfg.Mqtt.TopicStatus,
+		TopicServer:        cfg.Mqtt.TopicServer,
+		TopicControl:       cfg.Mqtt.TopicControl,
+		ThresholdsSvc:      thresholdsSvc,
+		PersonSyncCtl:      personSyncCtl,
+		DbStore:            dbStore,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// endregion
+	// region Инициализация термопадов
+	// Формирование списка опрашиваемых термопадов и запуск их мониторинга
+
+	termopadsInfo := cfg.TermopadsInfo()
+	// Доступность термопадов отмечается в БД для учёта времени их недоступности в отчётах
+	termopadStatus := func(termopadID uint, online bool) {
+		at := time.Now()
+		if err := dbStore.SetTermopadStatus(termopadID, online, at); err != nil {
+			log.Warnf("ошибка отметки доступности термопада %d: %v", termopadID, err)
+		}
+		if !online {
+			webhookSvc.TermopadDown(termopadID, at)
+		}
+		mqttSvc.TermopadStatus(termopadID, online)
+	}
+	termopads := newTermopadSet(termopadCtx, log, newTermopad, termopadStatus)
+	if err := termopads.apply(termopadsInfo); err != nil {
+		return errors.Trace(err)
+	}
+
+	termopadsAll, err := termopadCtlMod.NewTermopad(termopadCtx, termopads.services(), dbStore, &termopadCtlMod.ConfigTermopad{
+		Log:          log,
+		Supervisor:   supervisorCtl,
+		DedupeWindow: time.Minute * time.Duration(cfg.Termopad.DedupeWindow),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// endregion
+	// region Сводные отчёты
+
+	reportCtl, err := reportCtlMod.NewReport(ctx, dbStore, thresholdsSvc, &reportCtlMod.ConfigReport{
+		Log:        log,
+		Termopads:  termopadsInfo,
+		Schedule:   cfg.Report.Schedule,
+		Shifts:     shiftsFromConfig(cfg),
+		Path:       cfg.Report.Path,
+		Supervisor: supervisorCtl,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// endregion
+	// region Хранение персональных данных
+
+	retentionCtl, err := retentionCtlMod.NewRetention(ctx, dbStore, &retentionCtlMod.ConfigRetention{
+		Log:                 log,
+		PersonRetentionDays: uint(cfg.Privacy.PersonRetentionDays),
+		Mode:                cfg.Privacy.Erasure,
+		Interval:            time.Minute * time.Duration(cfg.Db.CleanArchiveInterval),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// endregion
+	// region Оповещение о тревогах
+
+	recipients := make([]notifySvcMod.Recipients, 0, len(cfg.Notify.Recipients))
+	for _, v := range cfg.Notify.Recipients {
+		recipients = append(recipients, notifySvcMod.Recipients{
+			Organization: v.Organization,
+			Department:   v.Department,
+			Emails:       v.Emails,
+			Chats:        v.Chats,
+		})
+	}
+	notifiers := make([]notifySvcMod.Notifier, 0, 2)
+	if cfg.Notify.Smtp.Host != "" {
+		email, err := notifySvcMod.NewEmail(ctx, &notifySvcMod.ConfigEmail{
+			Log: log,
+			SMTP: notifySvcMod.ConfigSMTP{
+				Host:               cfg.Notify.Smtp.Host,
+				Port:               cfg.Notify.Smtp.Port,
+				Username:           cfg.Notify.Smtp.Username,
+				Password:           cfg.Notify.Smtp.Password,
+				From:               cfg.Notify.Smtp.From,
+				TLS:                cfg.Notify.Smtp.TLS,
+				InsecureSkipVerify: cfg.Notify.Smtp.InsecureSkipVerify,
+			},
+			Recipients:      recipients,
+			TemplateSubject: cfg.Notify.Smtp.Subject,
+			TemplateBody:    cfg.Notify.Smtp.Template,
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		notifiers = append(notifiers, email)
+	}
+	if cfg.Notify.Telegram.Token != "" {
+		telegram, err := notifySvcMod.NewTelegram(ctx, dbStore, &notifySvcMod.ConfigTelegram{
+			Log:         log,
+			Token:       cfg.Notify.Telegram.Token,
+			APIURL:      cfg.Notify.Telegram.APIURL,
+			Recipients:  recipients,
+			PollTimeout: time.Second * time.Duration(cfg.Notify.Telegram.PollTimeout),
+			Supervisor:  supervisorCtl,
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		notifiers = append(notifiers, telegram)
+	}
+	notifySvc, err := notifySvcMod.NewNotify(ctx, dbStore, &notifySvcMod.ConfigNotify{
+		Log:       log,
+		Notifiers: notifiers,
+		Throttle:  time.Minute * time.Duration(cfg.Notify.Throttle),
 	})
 	if err != nil {
 		return errors.Trace(err)
@@ -121,8 +550,22 @@ func run() error {
 	// region Контроллер WEB
 
 	webSvc, err := webSvcMod.NewWeb(ctx, termopadsInfo, dbStore, &webSvcMod.ConfigWeb{
-		Log:            log,
-		PersonPhotoDir: cfg.Images.Path,
+		Log:             log,
+		SudosSvc:        sudosStore,
+		PersonSyncCtl:   personSyncCtl,
+		Supervisor:      supervisorCtl,
+		Queue:           queueCtl,
+		ReportCtl:       reportCtl,
+		WebhookSvc:      webhookSvc,
+		ThresholdsSvc:   thresholdsSvc,
+		PersonPhotoDir:  cfg.Images.Path,
+		TermopadsOnPage: uint(cfg.Http.TermopadsOnPage),
+		Users:           webUsersFromConfig(cfg),
+		RetentionCtl:    retentionCtl,
+		PrivacyClients:  cfg.Http.PrivacyMode.Clients,
+		PrivacyBlur:     cfg.Http.PrivacyMode.Blur,
+		TrustedProxies:  cfg.Http.TrustedProxies,
+		MaxImageSize:    int64(cfg.Http.MaxImageSize) * 1024,
 	})
 	if err != nil {
 		return errors.Trace(err)
@@ -133,18 +576,34 @@ func run() error {
 	webSvc.GraphQLPlayground("/playground")
 	webSvc.TemperatureImage("/image/:name")
 	webSvc.PersonImage("/person/:name")
+	webSvc.Health("/health")
+	webSvc.Report("/report/:date")
 
 	// endregion
 	// region Менеджер управления всеми
 
+	// Сверка изображений замеров с логом температуры (0 в конфигурации отключает её)
+	reconcileInterval := time.Minute * time.Duration(cfg.Db.ReconcileInterval)
+	if reconcileInterval == 0 {
+		reconcileInterval = -1
+	}
 	managerCtl, err := manager.NewManager(ctx, &manager.ConfigManager{
 		Log:               log,
 		TermopadCtl:       termopadsAll,
+		PersonSyncCtl:     personSyncCtl,
 		SudosSvc:          sudosStore,
+		ThresholdsSvc:     thresholdsSvc,
 		WebSvc:            webSvc,
 		DbStore:           dbStore,
+		Supervisor:        supervisorCtl,
+		Queue:             queueCtl,
+		NotifySvc:         notifySvc,
+		WebhookSvc:        webhookSvc,
+		MQTTSvc:           mqttSvc,
+		Workers:           uint(cfg.Queue.Workers),
 		CleanBasePeriod:   time.Hour * 24 * time.Duration(cfg.Db.ArchiveDays),
 		CleanBaseInterval: time.Minute * time.Duration(cfg.Db.CleanArchiveInterval),
+		ReconcileInterval: reconcileInterval,
 	})
 	if err != nil {
 		return errors.Trace(err)
@@ -154,20 +613,93 @@ func run() error {
 		err := managerCtl.Serve()
 		if err != nil && err.Error() != context.Canceled.Error() {
 			done <- errors.Trace(err)
+			return
 		}
 		done <- nil
 	}()
 
+	// endregion
+	// region Перечитывание конфигурации на лету
+
+	configWatcher, err := config.NewWatcher(ctx, configFile, &config.ConfigWatcher{
+		Log: log,
+	})
+	if err != nil {
+		log.Warnf("изменения конфигурации не отслеживаются: %v", err)
+	} else {
+		configReloader := &reloader{
+			log:         log,
+			current:     cfg,
+			termopads:   termopads,
+			termopadCtl: termopadsAll,
+			sudosSvc:    sudosStore,
+			webSvc:      webSvc,
+			dbStore:     dbStore,
+			reportCtl:   reportCtl,
+		}
+		go func() {
+			for {
+				newCfg, err := configWatcher.EmmitConfig()
+				if err != nil {
+					return
+				}
+				configReloader.apply(newCfg)
+			}
+		}()
+	}
+
 	// endregion
 
 	// Процесс завершения работы
 	select {
 	case err := <-done:
 		return errors.Trace(err)
-	case <-chanInterrupt:
-		log.Info("получена по каналу interrupt команда на завершение работы программы")
-		cancel()
-		time.Sleep(time.Second)
-		return nil
+	case sig := <-chanInterrupt:
+		log.Infof("получен сигнал %s, завершение работы программы", sig)
+	}
+
+	// Упорядоченное завершение работы, ограниченное по времени
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Duration(cfg.Shutdown.Timeout)*time.Second)
+	defer shutdownCancel()
+
+	// Прекращаем приём замеров от термопадов и дожидаемся обработки (в т.ч. записи в БД) уже принятых
+	termopadCancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Error(errors.ErrorStack(err))
+		}
+	case <-shutdownCtx.Done():
+		log.Warn("истекло время ожидания обработки принятых замеров")
 	}
+
+	// Отправляем накопившиеся сообщения в СУДОС
+	if err := sudosStore.Close(shutdownCtx); err != nil {
+		log.Warn(err)
+	}
+
+	// Дожидаемся отправки оповещений о тревогах
+	if err := notifySvc.Close(shutdownCtx); err != nil {
+		log.Warn(err)
+	}
+
+	// Дожидаемся рассылки событий вебхукам; недоставленные сохраняются в БД
+	if err := webhookSvc.Close(shutdownCtx); err != nil {
+		log.Warn(err)
+	}
+
+	// Публикуем оставшиеся сообщения и отключаемся от брокера MQTT
+	if err := mqttSvc.Close(shutdownCtx); err != nil {
+		log.Warn(err)
+	}
+
+	// Закрываем подписки GraphQL и останавливаем WEB-сервер
+	if err := webSvc.Shutdown(shutdownCtx); err != nil {
+		log.Warn(err)
+	}
+
+	// Останавливаем остальные службы
+	cancel()
+	log.Info("работа программы завершена")
+	return nil
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kirsrus/termopad-server/controller"
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/config"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	"github.com/kirsrus/termopad-server/pkg/wiegand"
+	"github.com/kirsrus/termopad-server/service"
+	webSvcMod "github.com/kirsrus/termopad-server/service/web"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Рабочие смены из конфигурации (значения проверены при загрузке конфигурации)
+func shiftsFromConfig(cfg *config.Config) []model.Shift {
+	result := make([]model.Shift, 0, len(cfg.Report.Shifts))
+	for _, v := range cfg.Report.Shifts {
+		start, _ := tool.ParseClock(v.Start)
+		end, _ := tool.ParseClock(v.End)
+		result = append(result, model.Shift{Name: v.Name, Start: start, End: end})
+	}
+	return result
+}
+
+// Пользователи WEB-интерфейса из конфигурации
+func webUsersFromConfig(cfg *config.Config) []webSvcMod.User {
+	result := make([]webSvcMod.User, 0, len(cfg.Http.Users))
+	for _, v := range cfg.Http.Users {
+		role := v.Role
+		if role == "" {
+			role = model.UserRoleOperator
+		}
+		result = append(result, webSvcMod.User{Name: v.Name, PasswordHash: v.Password, Role: role})
+	}
+	return result
+}
+
+// Имена секций конфигурации, различающихся в oldCfg и newCfg
+func changedSections(oldCfg, newCfg *config.Config) []string {
+	result := make([]string, 0)
+	oldValue, newValue := reflect.ValueOf(*oldCfg), reflect.ValueOf(*newCfg)
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			result = append(result, strings.ToLower(oldValue.Type().Field(i).Name))
+		}
+	}
+	return result
+}
+
+// Создание службы термопада info с уведомлением onStatus об изменении его доступности
+type termopadFactory func(ctx context.Context, info model.TermopadInfo, onStatus func(online bool)) (service.TermopadSvc, error)
+
+// Запущенная служба термопада
+type termopadItem struct {
+	info   model.TermopadInfo
+	svc    service.TermopadSvc
+	cancel context.CancelFunc
+}
+
+// Набор запущенных служб термопадов. Позволяет изменять набор на лету, пересоздавая только
+// изменившиеся термопады
+type termopadSet struct {
+	ctx context.Context
+	log *logrus.Logger
+	// Создание службы термопада
+	newSvc termopadFactory
+	// Уведомление об изменении доступности термопада
+	onStatus func(termopadID uint, online bool)
+	items    map[uint]termopadItem
+	order    []uint
+}
+
+// Создание пустого набора служб термопадов
+func newTermopadSet(ctx context.Context, log *logrus.Logger, newSvc termopadFactory, onStatus func(termopadID uint, online bool)) *termopadSet {
+	return &termopadSet{
+		ctx:      ctx,
+		log:      log,
+		newSvc:   newSvc,
+		onStatus: onStatus,
+		items:    make(map[uint]termopadItem),
+		order:    make([]uint, 0),
+	}
+}
+
+// Приведение набора к списку infos. Новые и изменившиеся термопады запускаются, удалённые и заменённые
+// останавливаются. Если какой-либо термопад не удалось запустить, набор остаётся без изменений
+func (m *termopadSet) apply(infos []model.TermopadInfo) error {
+	started := make(map[uint]termopadItem)
+	for _, info := range infos {
+		if item, ok := m.items[info.ID]; ok && item.info == info {
+			continue
+		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		id := info.ID
+		svc, err := m.newSvc(ctx, info, func(online bool) {
+			if m.onStatus != nil {
+				m.onStatus(id, online)
+			}
+		})
+		if err != nil {
+			cancel()
+			for _, v := range started {
+				v.cancel()
+			}
+			return errors.Annotatef(err, "ошибка запуска термопада %d", info.ID)
+		}
+		started[info.ID] = termopadItem{info: info, svc: svc, cancel: cancel}
+	}
+
+	// Останавливаем удалённые и заменённые термопады
+	actual := make(map[uint]bool)
+	for _, info := range infos {
+		actual[info.ID] = true
+	}
+	for id, item := range m.items {
+		if _, replaced := started[id]; replaced || !actual[id] {
+			item.cancel()
+			delete(m.items, id)
+		}
+	}
+	for id, item := range started {
+		m.items[id] = item
+	}
+	m.order = m.order[:0]
+	for _, info := range infos {
+		m.order = append(m.order, info.ID)
+	}
+	return nil
+}
+
+// Службы термопадов в порядке их описания в конфигурации
+func (m *termopadSet) services() []service.TermopadSvc {
+	result := make([]service.TermopadSvc, 0, len(m.order))
+	for _, id := range m.order {
+		result = append(result, m.items[id].svc)
+	}
+	return result
+}
+
+// Применение изменений конфигурации к работающим компонентам
+type reloader struct {
+	log     *logrus.Logger
+	current *config.Config
+
+	termopads   *termopadSet
+	termopadCtl controller.TermopadCtl
+	sudosSvc    service.SudosSvc
+	webSvc      service.WebSvc
+	dbStore     store.DbStore
+	reportCtl   controller.ReportCtl
+}
+
+// Применение новой конфигурации newCfg. Если применить её не удалось, возвращаются прежние значения
+func (m *reloader) apply(newCfg *config.Config) {
+	if err := m.applyConfig(m.current, newCfg); err != nil {
+		m.log.Errorf("ошибка применения новой конфигурации, возврат к прежней: %v", err)
+		if err := m.applyConfig(newCfg, m.current); err != nil {
+			m.log.Errorf("ошибка возврата к прежней конфигурации: %v", err)
+		}
+		return
+	}
+	sections := changedSections(m.current, newCfg)
+	m.current = newCfg
+	m.log.Info("новая конфигурация применена")
+	err := m.dbStore.SetAudit(model.Audit{
+		CreateAt: time.Now(),
+		User:     model.AuditSystemUser,
+		Action:   model.AuditConfigReload,
+		Details:  "изменены секции: " + strings.Join(sections, ", "),
+	})
+	if err != nil {
+		m.log.Warnf("ошибка записи в журнал аудита: %v", err)
+	}
+}
+
+// Применение отличий newCfg от oldCfg
+func (m *reloader) applyConfig(oldCfg, newCfg *config.Config) error {
+	// Уровень логирования
+	if oldCfg.Log.Level != newCfg.Log.Level {
+		level, err := logrus.ParseLevel(newCfg.Log.Level)
+		if err != nil {
+			return errors.Annotate(err, "некорректный уровень логирования")
+		}
+		m.log.SetLevel(level)
+		m.log.Infof("уровень логирования изменён на %s", level)
+	}
+
+	// Подключение к СУДОС
+	if oldCfg.Sudos.Address != newCfg.Sudos.Address {
+		if err := m.sudosSvc.SetAddress(newCfg.Sudos.Address); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if oldCfg.Sudos.TemplateNormal != newCfg.Sudos.TemplateNormal ||
+		oldCfg.Sudos.TemplateAlarm != newCfg.Sudos.TemplateAlarm ||
+		oldCfg.Sudos.TemplateLower != newCfg.Sudos.TemplateLower {
+		if err := m.sudosSvc.SetTemplates(newCfg.Sudos.TemplateNormal, newCfg.Sudos.TemplateAlarm, newCfg.Sudos.TemplateLower); err != nil {
+			return errors.Trace(err)
+		}
+		m.log.Info("шаблоны сообщений СУДОС изменены")
+	}
+
+	// Формат карт объекта
+	if oldCfg.Termopad.CardFormat != newCfg.Termopad.CardFormat {
+		if err := wiegand.SetDefault(newCfg.Termopad.CardFormat); err != nil {
+			return errors.Trace(err)
+		}
+		m.log.Infof("формат карт по умолчанию изменён на %s", newCfg.Termopad.CardFormat)
+	}
+
+	// Набор термопадов и настройки WEB
+	oldInfos, newInfos := oldCfg.TermopadsInfo(), newCfg.TermopadsInfo()
+	if !reflect.DeepEqual(oldInfos, newInfos) {
+		if err := m.termopads.apply(newInfos); err != nil {
+			return errors.Trace(err)
+		}
+		m.termopadCtl.Restart(m.termopads.services())
+		m.dbStore.SetTermopads(newInfos)
+		m.reportCtl.SetTermopads(newInfos)
+		m.log.Infof("список термопадов изменён (%d термопадов)", len(newInfos))
+	}
+	if !reflect.DeepEqual(oldInfos, newInfos) || oldCfg.Http.TermopadsOnPage != newCfg.Http.TermopadsOnPage {
+		m.webSvc.SetTermopads(newInfos, uint(newCfg.Http.TermopadsOnPage))
+	}
+
+	// Параметры, изменение которых требует перезапуска
+	restart := make([]string, 0)
+	if oldCfg.Db != newCfg.Db {
+		restart = append(restart, "db")
+	}
+	if oldCfg.Images != newCfg.Images {
+		restart = append(restart, "images")
+	}
+	if oldCfg.Log.Path != newCfg.Log.Path || oldCfg.Log.Filename != newCfg.Log.Filename || oldCfg.Log.Console != newCfg.Log.Console {
+		restart = append(restart, "log")
+	}
+	if oldCfg.Http.Port != newCfg.Http.Port || oldCfg.Http.AssetsDir != newCfg.Http.AssetsDir ||
+		!reflect.DeepEqual(oldCfg.Http.Users, newCfg.Http.Users) ||
+		!reflect.DeepEqual(oldCfg.Http.PrivacyMode, newCfg.Http.PrivacyMode) ||
+		!reflect.DeepEqual(oldCfg.Http.TrustedProxies, newCfg.Http.TrustedProxies) {
+		restart = append(restart, "http")
+	}
+	if oldCfg.Queue != newCfg.Queue {
+		restart = append(restart, "queue")
+	}
+	if !reflect.DeepEqual(oldCfg.Notify, newCfg.Notify) {
+		restart = append(restart, "notify")
+	}
+	if !reflect.DeepEqual(oldCfg.Webhook, newCfg.Webhook) {
+		restart = append(restart, "webhook")
+	}
+	if oldCfg.Mqtt != newCfg.Mqtt {
+		restart = append(restart, "mqtt")
+	}
+	if !reflect.DeepEqual(oldCfg.Report, newCfg.Report) {
+		restart = append(restart, "report")
+	}
+	if oldCfg.Privacy != newCfg.Privacy {
+		restart = append(restart, "privacy")
+	}
+	if oldCfg.Shutdown != newCfg.Shutdown {
+		restart = append(restart, "shutdown")
+	}
+	if oldCfg.Recognize != newCfg.Recognize {
+		restart = append(restart, "recognize")
+	}
+	if oldCfg.Sudos.Path != newCfg.Sudos.Path || oldCfg.Sudos.SyncInterval != newCfg.Sudos.SyncInterval ||
+		oldCfg.Sudos.SyncRequestInterval != newCfg.Sudos.SyncRequestInterval {
+		restart = append(restart, "sudos")
+	}
+	if oldCfg.Termopad.Timeout != newCfg.Termopad.Timeout || oldCfg.Termopad.TimeoutAlive != newCfg.Termopad.TimeoutAlive ||
+		oldCfg.Termopad.DedupeWindow != newCfg.Termopad.DedupeWindow {
+		restart = append(restart, "termopad")
+	}
+	if len(restart) != 0 {
+		sort.Strings(restart)
+		m.log.Warnf("изменения в секциях %v вступят в силу только после перезапуска", restart)
+	}
+	if oldCfg.Termopad.MaxTemperature != newCfg.Termopad.MaxTemperature || oldCfg.Termopad.MinTemperature != newCfg.Termopad.MinTemperature {
+		m.log.Warn("общие пороги температуры хранятся в БД и изменяются через WEB-интерфейс")
+	}
+	return nil
+}
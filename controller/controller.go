@@ -1,7 +1,12 @@
 package controller
 
 import (
+	"context"
+	"io"
+	"time"
+
 	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/service"
 )
 
 // TermopadCtl контроллер управления термопадами
@@ -9,4 +14,69 @@ import (
 type TermopadCtl interface {
 	// Ожидает очередное сообщение от текромпада и возвращает в своём результате полученные данные.
 	EmmitTemperature() (*model.TermopadTemperatureEvent, error)
+	// Изменяет список опрашиваемых термопадов без перерыва в работе оставшихся.
+	Restart([]service.TermopadSvc)
+}
+
+// PersonSyncCtl контроллер массовой синхронизации справочника персон с СУДОС
+//go:generate mockery --dir . --name PersonSyncCtl --output ./mocks
+type PersonSyncCtl interface {
+	// Запускает синхронизацию в фоне и возвращает запись о ней. Ошибка, если синхронизация уже идёт.
+	Sync() (*model.PersonSync, error)
+	// Возвращает состояние выполняющейся синхронизации или nil.
+	Current() *model.PersonSync
+	// Возвращает не более limit последних записей истории синхронизации.
+	History(limit uint) ([]model.PersonSync, error)
+	// Ожидает очередное событие о ходе синхронизации.
+	EmmitProgress() (*model.PersonSync, error)
+}
+
+// SupervisorCtl наблюдение за фоновыми обработчиками с перезапуском после сбоев
+//go:generate mockery --dir . --name SupervisorCtl --output ./mocks
+type SupervisorCtl interface {
+	// Запускает обработчик fn под наблюдением. При ошибке или панике fn перезапускается с задержкой,
+	// пока не отменён ctx. Возврат nil завершает наблюдение.
+	Go(ctx context.Context, name string, fn func() error)
+	// Возвращает состояние всех наблюдаемых обработчиков.
+	Status() []model.WorkerStatus
+}
+
+// QueueCtl ограниченная очередь замеров температуры с равномерной выдачей по термопадам
+//go:generate mockery --dir . --name QueueCtl --output ./mocks
+type QueueCtl interface {
+	// Помещает замер в очередь. При переполнении поступает согласно политике очереди.
+	Push(*model.TermopadTemperatureEvent) error
+	// Ожидает и возвращает очередной замер, по очереди от каждого термопада. После Close возвращает
+	// оставшиеся замеры, а затем ошибку.
+	Pop() (*model.TermopadTemperatureEvent, error)
+	// Прекращает приём замеров.
+	Close()
+	// Возвращает глубину очереди по каждому термопаду.
+	Stats() []model.QueueStats
+}
+
+// ReportCtl сводные отчёты о замерах за сутки или смену
+//go:generate mockery --dir . --name ReportCtl --output ./mocks
+type ReportCtl interface {
+	// Возвращает сохранённый отчёт или формирует его. Пустая смена shift означает сутки целиком.
+	Report(date time.Time, shift string) (*model.Report, error)
+	// Формирует отчёт заново. Отчёт за завершившийся период сохраняется и выгружается в файлы.
+	Generate(date time.Time, shift string) (*model.Report, error)
+	// Выводит отчёт в формате model.ReportFormatHTML или model.ReportFormatCSV.
+	Render(w io.Writer, report *model.Report, format string) error
+	// Описание рабочих смен.
+	Shifts() []model.Shift
+	// Изменяет список термопадов, по которым формируются отчёты.
+	SetTermopads([]model.TermopadInfo)
+}
+
+// RetentionCtl хранение и стирание персональных данных
+//go:generate mockery --dir . --name RetentionCtl --output ./mocks
+type RetentionCtl interface {
+	// Стирает персональные данные персоны в настроенном режиме. Отсутствие данных проверяется через errors.IsNotFound.
+	ErasePerson(wigand uint) (*model.Erasure, error)
+	// Стирает персоны, не проходившие замеров дольше срока хранения, с записью в журнал аудита.
+	CleanInactive() ([]model.Erasure, error)
+	// Возвращает режим стирания: model.ErasureDelete или model.ErasurePseudonymize.
+	Mode() string
 }
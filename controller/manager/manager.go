@@ -2,11 +2,15 @@ package manager
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"math"
+	"sync"
 	"time"
 
 	"github.com/kirsrus/termopad-server/controller"
+	"github.com/kirsrus/termopad-server/controller/queue"
+	"github.com/kirsrus/termopad-server/controller/supervisor"
 	"github.com/kirsrus/termopad-server/model"
 	"github.com/kirsrus/termopad-server/pkg/validator"
 	"github.com/kirsrus/termopad-server/service"
@@ -23,6 +27,10 @@ const (
 	updatePersonInterval = 60 * time.Minute
 	cleanBasePeriod      = time.Hour * 24 * 30
 	cleanBaseInterval    = time.Minute * 30
+	workers              = 4
+	reconcileInterval    = 24 * time.Hour
+	// Замеры моложе этого времени при сверке изображений с логом не учитываются: они могут ещё обрабатываться
+	reconcileGrace = 10 * time.Minute
 )
 
 // ConfigManager конфигурация Manager
@@ -30,15 +38,32 @@ type ConfigManager struct {
 	Log *logrus.Logger
 
 	TermopadCtl controller.TermopadCtl
-
-	WebSvc   service.WebSvc
-	SudosSvc service.SudosSvc
-	DbStore  store.DbStore
+	// Контроллер синхронизации персон с СУДОС (может отсутствовать)
+	PersonSyncCtl controller.PersonSyncCtl
+
+	WebSvc        service.WebSvc
+	SudosSvc      service.SudosSvc
+	ThresholdsSvc service.ThresholdsSvc
+	DbStore       store.DbStore
+	// Супервизор фоновых обработчиков (если не задан, создаётся свой)
+	Supervisor controller.SupervisorCtl
+	// Очередь принятых замеров (если не задана, создаётся своя с политикой block)
+	Queue controller.QueueCtl
+	// Количество обработчиков замеров
+	Workers uint
+	// Служба оповещения о тревогах (может отсутствовать)
+	NotifySvc service.NotifySvc
+	// Рассылка событий внешним системам (может отсутствовать)
+	WebhookSvc service.WebhookSvc
+	// Публикация замеров в брокер MQTT (может отсутствовать)
+	MQTTSvc service.MQTTSvc
 
 	RequestTimeout       time.Duration
 	UpdatePersonInterval time.Duration
 	CleanBasePeriod      time.Duration
 	CleanBaseInterval    time.Duration
+	// Период сверки изображений замеров с логом температуры. Отрицательное значение отключает сверку
+	ReconcileInterval time.Duration
 
 	WebPort   uint
 	AssetsDir string
@@ -50,20 +75,30 @@ type Manager struct {
 	log       *logrus.Entry
 	validator *validator.Validator
 
-	termopadCtl controller.TermopadCtl
+	termopadCtl   controller.TermopadCtl
+	personSyncCtl controller.PersonSyncCtl
 
-	webSvc   service.WebSvc
-	sudosSvc service.SudosSvc
-	dbStore  store.DbStore
+	webSvc        service.WebSvc
+	sudosSvc      service.SudosSvc
+	thresholdsSvc service.ThresholdsSvc
+	dbStore       store.DbStore
+	supervisor    controller.SupervisorCtl
+	queue         controller.QueueCtl
 
+	workers              uint
 	requestTimeout       time.Duration
 	updatePersonInterval time.Duration
 	cleanBasePeriod      time.Duration
 	cleanBaseInterval    time.Duration
+	reconcileInterval    time.Duration
 
 	e         *echo.Echo
 	webPort   uint
 	assetsDir string
+
+	notifySvc  service.NotifySvc
+	webhookSvc service.WebhookSvc
+	mqttSvc    service.MQTTSvc
 }
 
 // NewManager конструктор Manage
@@ -87,6 +122,23 @@ func NewManager(ctx context.Context, config *ConfigManager) (*Manager, error) {
 	if config.DbStore == nil {
 		return nil, errors.New("не передан сервис базы данных")
 	}
+	if config.ThresholdsSvc == nil {
+		return nil, errors.New("не передан сервис порогов температуры")
+	}
+	if config.Supervisor == nil {
+		svc, err := supervisor.NewSupervisor(ctx, &supervisor.ConfigSupervisor{Log: config.Log})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		config.Supervisor = svc
+	}
+	if config.Queue == nil {
+		ctl, err := queue.NewQueue(ctx, &queue.ConfigQueue{Log: config.Log})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		config.Queue = ctl
+	}
 
 	manager := Manager{
 		ctx: ctx,
@@ -94,22 +146,34 @@ func NewManager(ctx context.Context, config *ConfigManager) (*Manager, error) {
 			"module": "manager",
 			"scope":  "controller",
 		}),
-		validator:   validator.Get(),
-		termopadCtl: config.TermopadCtl,
-		sudosSvc:    config.SudosSvc,
-
-		webSvc:  config.WebSvc,
-		dbStore: config.DbStore,
-
+		validator:     validator.Get(),
+		termopadCtl:   config.TermopadCtl,
+		personSyncCtl: config.PersonSyncCtl,
+		sudosSvc:      config.SudosSvc,
+
+		webSvc:        config.WebSvc,
+		thresholdsSvc: config.ThresholdsSvc,
+		dbStore:       config.DbStore,
+		supervisor:    config.Supervisor,
+		queue:         config.Queue,
+		notifySvc:     config.NotifySvc,
+		webhookSvc:    config.WebhookSvc,
+		mqttSvc:       config.MQTTSvc,
+
+		workers:              workers,
 		requestTimeout:       requestTimeout,
 		updatePersonInterval: updatePersonInterval,
 		cleanBasePeriod:      cleanBasePeriod,
 		cleanBaseInterval:    cleanBaseInterval,
+		reconcileInterval:    reconcileInterval,
 
 		e:         echo.New(),
 		webPort:   80,
 		assetsDir: "./assets/main",
 	}
+	if config.Workers != 0 {
+		manager.workers = config.Workers
+	}
 	if config.RequestTimeout != 0 {
 		manager.requestTimeout = config.RequestTimeout
 	}
@@ -122,6 +186,9 @@ func NewManager(ctx context.Context, config *ConfigManager) (*Manager, error) {
 	if config.CleanBaseInterval != 0 {
 		manager.cleanBaseInterval = config.CleanBaseInterval
 	}
+	if config.ReconcileInterval != 0 {
+		manager.reconcileInterval = config.ReconcileInterval
+	}
 	if config.WebPort != 0 {
 		manager.webPort = config.WebPort
 	}
@@ -136,70 +203,133 @@ func NewManager(ctx context.Context, config *ConfigManager) (*Manager, error) {
 
 // Вывести значения конфигурациии в лог
 func (m Manager) configToLog() {
+	m.log.Debugf("workers: %d", m.workers)
 	m.log.Debugf("requestTimeout: %s", m.requestTimeout)
 	m.log.Debugf("updatePersonInterval: %s", m.updatePersonInterval)
 	m.log.Debugf("cleanBasePeriod: %s", m.cleanBasePeriod)
 	m.log.Debugf("cleanBaseInterval: %s", m.cleanBaseInterval)
+	m.log.Debugf("reconcileInterval: %s", m.reconcileInterval)
 	m.log.Debugf("webPort: %d", m.webPort)
 	m.log.Debugf("assetsDir: %s", m.assetsDir)
 }
 
-// Serve начало процесса обработки поступающих данных
+// Serve начало процесса обработки поступающих данных. Принятые замеры помещаются в ограниченную очередь,
+// из которой их по очереди от каждого термопада разбирает фиксированное число обработчиков. Фоновые
+// обработчики работают под наблюдением супервизора и после сбоя перезапускаются. Когда контроллер
+// термопадов прекращает выдавать замеры (его контекст отменён), оставшиеся в очереди замеры
+// обрабатываются, и после завершения всех обработчиков Serve возвращает nil. При отмене контекста
+// менеджера работа прерывается сразу
 func (m Manager) Serve() error {
-	done := make(chan error)
-	termperature := make(chan *model.TermopadTemperatureEvent, 10)
-
-	g := new(errgroup.Group)
+	intakeDone := make(chan struct{})
+	closeIntake := new(sync.Once)
 
 	// Запуск контроллера обмена данных с термопадом
-	g.Go(func() error {
+	m.supervisor.Go(m.ctx, "manager.intake", func() error {
 		for {
 			term, err := m.termopadCtl.EmmitTemperature()
 			if err != nil {
-				return err
+				if err == context.Canceled {
+					m.log.Info("приём замеров от термопадов прекращён")
+					closeIntake.Do(func() { close(intakeDone) })
+					return nil
+				}
+				return errors.Trace(err)
 			}
-			select {
-			case <-m.ctx.Done():
-				return nil
-			case termperature <- term:
-			default:
-				m.log.Warn("очередь termperature переполнена")
+			if err := m.queue.Push(term); err != nil {
+				if m.ctx.Err() != nil {
+					return nil
+				}
+				m.log.Errorf("замер термопада %d не помещён в очередь: %v", term.Info.ID, err)
 			}
 		}
 	})
 
+	// Пул обработчиков замеров
+	workers := new(sync.WaitGroup)
+	for i := uint(0); i < m.workers; i++ {
+		workers.Add(1)
+		m.supervisor.Go(m.ctx, fmt.Sprintf("manager.worker.%d", i+1), func() error {
+			for {
+				temp, err := m.queue.Pop()
+				if err != nil {
+					workers.Done()
+					return nil
+				}
+				m.temperatureInWorker(temp)
+			}
+		})
+	}
+
+	// Пересылка хода синхронизации персон в WEB
+	if m.personSyncCtl != nil {
+		m.supervisor.Go(m.ctx, "manager.personsync", func() error {
+			for {
+				progress, err := m.personSyncCtl.EmmitProgress()
+				if err != nil {
+					if err == m.ctx.Err() {
+						return nil
+					}
+					return errors.Trace(err)
+				}
+				m.webSvc.PersonSyncChanged(*progress)
+			}
+		})
+	}
+
 	// Запуск хоускеппера для очистки базы данных от старых записей
-	g.Go(func() error {
+	m.supervisor.Go(m.ctx, "manager.clean", func() error {
+		days := int(math.Round(m.cleanBasePeriod.Hours() / 24))
 		for {
-			// todo: временное решение пока не доведу до ума очистку базы.
-			// Потом нужно будет перенести в нужно место с
-
-			days := int(math.Round(m.cleanBaseInterval.Hours() / 24))
-			err := m.dbStore.Clean(days)
-			if err != nil {
-				return errors.Trace(err)
+			if _, err := m.dbStore.Clean(days, false); err != nil {
+				return errors.Annotate(err, "ошибка очистки архива")
+			}
+			select {
+			case <-m.ctx.Done():
+				return nil
+			case <-time.After(m.cleanBaseInterval):
 			}
-			time.Sleep(m.cleanBaseInterval)
 		}
 	})
 
-	go func() {
-		err := g.Wait()
-		done <- errors.Trace(err)
-	}()
-
-	// Обработка полученных от термопадов данных
-	for {
-		select {
-		case err := <-done:
-			return errors.Trace(err)
-		case <-m.ctx.Done():
-			return nil
-		case temp := <-termperature:
-			go func() {
-				m.temperatureInWorker(temp)
-			}()
-		}
+	// Сверка изображений замеров с логом температуры. Сверка просматривает весь архив, поэтому выполняется
+	// редко и не сразу после старта
+	if m.reconcileInterval > 0 {
+		m.supervisor.Go(m.ctx, "manager.reconcile", func() error {
+			ticker := time.NewTicker(m.reconcileInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-m.ctx.Done():
+					return nil
+				case <-ticker.C:
+					m.reconcile()
+				}
+			}
+		})
+	}
+
+	select {
+	case <-m.ctx.Done():
+	case <-intakeDone:
+		// Обрабатываем оставшиеся в очереди замеры и дожидаемся всех обработчиков
+		m.queue.Close()
+		workers.Wait()
+		m.log.Info("все принятые замеры обработаны")
+	}
+	return nil
+}
+
+// Сверка изображений замеров с логом температуры. Изображение без записи остаётся, если замер не удалось
+// записать в БД. Расхождения только сообщаются в лог, исправляются они командой reconcile
+func (m Manager) reconcile() {
+	result, err := m.dbStore.Reconcile(time.Now().Add(-reconcileGrace), store.ReconcileOptions{})
+	if err != nil {
+		m.log.Warnf("ошибка сверки изображений с логом температуры: %v", err)
+		return
+	}
+	if len(result.OrphanImages) != 0 || len(result.OrphanTemperatures) != 0 {
+		m.log.Warnf("изображений без записей в логе температуры %d, записей без изображений %d (исправляется командой reconcile)",
+			len(result.OrphanImages), len(result.OrphanTemperatures))
 	}
 }
 
@@ -207,6 +337,30 @@ func (m Manager) Serve() error {
 func (m Manager) temperatureInWorker(temp *model.TermopadTemperatureEvent) {
 	g := new(errgroup.Group)
 	found := true
+
+	// Записываем температуру в локальную БД до обработки, чтобы повторный замер не был обработан
+	// дважды (повторно полученные замеры отсекаются контроллером термопадов ещё до сохранения
+	// изображения). Остальные ошибки не критичны, только в лог, чтобы не портить весь процесс
+	err := m.dbStore.SetTemperatureLog(temp.Info.ID, temp.Temperature.FileName, temp.Temperature.Wigand.ID, temp.Temperature.Temperature, temp.Image)
+	if err != nil {
+		if m.dbStore.IsDuplicate(err) {
+			m.log.Infof("замер %s уже обработан, пропускаем", temp.IngestionKey())
+			return
+		}
+		m.log.Error(err)
+	}
+
+	// Пороги берутся при каждом замере, чтобы их изменение вступало в силу немедленно. Недостоверный
+	// замер (например, температура окружающей среды) тревогой не считается
+	invalid := temp.Temperature.Invalid
+	alarm := !invalid && m.thresholdsSvc.TermopadThresholds(temp.Info).IsAlarm(temp.Temperature.Temperature)
+	if invalid {
+		m.log.Infof("недостоверный замер %0.1f у %s на термопаде %d", temp.Temperature.Temperature, temp.Temperature.Wigand, temp.Info.ID)
+	}
+	if alarm {
+		m.log.Warnf("повышенная температура %0.1f у %s на термопаде %d", temp.Temperature.Temperature, temp.Temperature.Wigand, temp.Info.ID)
+	}
+
 	// Пытаемся получить данные из локальной БД. Если информации о персоне нет или данные
 	// устарели, посылаем запрос в СУДОС для корректировки.
 	person, err := m.dbStore.GetPerson(temp.Temperature.Wigand.ID)
@@ -223,10 +377,12 @@ func (m Manager) temperatureInWorker(temp *model.TermopadTemperatureEvent) {
 	if found {
 		m.log.Debugf("данные о %d получены из БД", temp.Temperature.Wigand.ID)
 
-		m.webSvc.TemperatureChanged(model.TemperatureChange{
+		change := model.TemperatureChange{
 			ID:           temp.Info.ID,
 			CreateAt:     *temp.CreateAt,
 			Temperature:  math.Round(temp.Temperature.Temperature*10) / 10,
+			Alarm:        alarm,
+			Invalid:      invalid,
 			Image:        temp.Image,
 			Wigand:       temp.Temperature.Wigand,
 			NameFirst:    person.Name,
@@ -235,18 +391,18 @@ func (m Manager) temperatureInWorker(temp *model.TermopadTemperatureEvent) {
 			Organization: person.Organization,
 			Departament:  person.Department,
 			Postion:      person.Position,
-		})
+		}
+		m.webSvc.TemperatureChanged(change)
+		m.publishMeasurement(change)
 
-		go func() {
-			err := m.sudosSvc.SetPersonTemperature(*person, temp.Temperature, temp.Info)
-			if err != nil {
-				m.log.Warn(err)
-			}
-		}()
+		m.setPersonTemperature(*person, temp)
+		m.notifyAlarm(*person, temp, alarm)
 
-		// Если данные устарели, запрашиваем у СУДОС более новые данные
-		if time.Since(*person.UpdateAt) > m.updatePersonInterval {
+		// Если данные устарели, запрашиваем у СУДОС более новые данные. Внесённых вручную
+		// персон (посетители, подрядчики) в СУДОС нет, поэтому их не обновляем
+		if !person.Manual && time.Since(*person.UpdateAt) > m.updatePersonInterval {
 			m.log.Debugf("запрос у СУДОС о %d т.к. прошло много времени", temp.Temperature.Wigand.ID)
+			current := *person
 			g.Go(func() error {
 				person, err := m.sudosSvc.Person(temp.Temperature.Wigand)
 				if err != nil {
@@ -264,7 +420,7 @@ func (m Manager) temperatureInWorker(temp *model.TermopadTemperatureEvent) {
 				person.Image = make([]byte, 0)
 
 				// Сохраняем данные о полученной персоны в БД
-				_, update, err := m.dbStore.SetPerson(*person)
+				stored, update, err := m.dbStore.SetPerson(*person)
 				if err != nil {
 					m.log.Error(err)
 					return errors.Trace(err)
@@ -272,23 +428,34 @@ func (m Manager) temperatureInWorker(temp *model.TermopadTemperatureEvent) {
 				if update {
 					m.log.Debugf("в связи с истечением срока годности обновлена запись для wigand=%s (%s)", temp.Temperature.Wigand, person.Family)
 				}
+				if m.webhookSvc != nil && !stored.SameData(current) {
+					m.webhookSvc.PersonUpdated(*stored)
+				}
 				return nil
 			})
 		}
 	} else {
 		// Персона не обнаружена
 
-		m.webSvc.TemperatureChanged(model.TemperatureChange{
+		change := model.TemperatureChange{
 			ID:          temp.Info.ID,
 			CreateAt:    *temp.CreateAt,
 			Temperature: math.Round(temp.Temperature.Temperature*10) / 10,
+			Alarm:       alarm,
+			Invalid:     invalid,
 			Image:       temp.Image,
 			Wigand:      temp.Temperature.Wigand,
-		})
+		}
+		m.webSvc.TemperatureChanged(change)
+		// Внешним системам замер рассылается сразу, данные персоны, полученные от СУДОС, придут
+		// отдельным событием об изменении персоны
+		m.publishMeasurement(change)
 
 		g.Go(func() error {
 			person, err := m.sudosSvc.Person(temp.Temperature.Wigand)
 			if err != nil {
+				// О персоне известен только виганд, но тревога всё равно рассылается
+				m.notifyAlarm(model.Person{Wigand: temp.Temperature.Wigand}, temp, alarm)
 				return errors.Trace(err)
 			}
 
@@ -303,16 +470,21 @@ func (m Manager) temperatureInWorker(temp *model.TermopadTemperatureEvent) {
 			person.Image = make([]byte, 0)
 
 			// Сохраняем данные о персоне в локальную БД
-			_, _, err = m.dbStore.SetPerson(*person)
+			stored, _, err := m.dbStore.SetPerson(*person)
 			if err != nil {
 				m.log.Error(err)
 				return errors.Trace(err)
 			}
+			if m.webhookSvc != nil {
+				m.webhookSvc.PersonUpdated(*stored)
+			}
 
 			m.webSvc.TemperatureChanged(model.TemperatureChange{
 				ID:          temp.Info.ID,
 				CreateAt:    *temp.CreateAt,
 				Temperature: math.Round(temp.Temperature.Temperature*10) / 10,
+				Alarm:       alarm,
+				Invalid:     invalid,
 				Image:       temp.Image,
 				Wigand:      temp.Temperature.Wigand,
 				NameFirst:   person.Name,
@@ -322,23 +494,59 @@ func (m Manager) temperatureInWorker(temp *model.TermopadTemperatureEvent) {
 				Postion:     person.Position,
 			})
 
-			go func() {
-				err := m.sudosSvc.SetPersonTemperature(*person, temp.Temperature, temp.Info)
-				if err != nil {
-					m.log.Warn(err)
-				}
-			}()
+			m.setPersonTemperature(*person, temp)
+			m.notifyAlarm(*person, temp, alarm)
 
 			return nil
 		})
 	}
 
-	// Записываем температуру в локальную БД. Делаем секцию не критичной, только в лог, чтобы
-	// не портить весь процесс, если он не логируется.
-	err = m.dbStore.SetTemperatureLog(temp.Info.ID, temp.Temperature.Wigand.ID, temp.Temperature.Temperature, temp.Image)
-	if err != nil {
-		m.log.Error(err)
+	_ = g.Wait()
+}
+
+// Отправка температуры персоны в СУДОС. Недостоверные замеры температурой тела не являются и не отправляются
+func (m Manager) setPersonTemperature(person model.Person, temp *model.TermopadTemperatureEvent) {
+	if temp.Temperature.Invalid {
+		return
+	}
+	if err := m.sudosSvc.SetPersonTemperature(person, temp.Temperature, temp.Info); err != nil {
+		m.log.Warn(err)
 	}
+}
 
-	_ = g.Wait()
+// Рассылка принятого замера внешним системам
+func (m Manager) publishMeasurement(change model.TemperatureChange) {
+	if m.webhookSvc != nil {
+		m.webhookSvc.Measurement(change)
+	}
+	if m.mqttSvc != nil {
+		m.mqttSvc.Measurement(change)
+	}
+}
+
+// Оповещение ответственных лиц и внешних систем о повышенной температуре персоны
+func (m Manager) notifyAlarm(person model.Person, temp *model.TermopadTemperatureEvent, alarm bool) {
+	if !alarm {
+		return
+	}
+	event := model.Alarm{
+		CreateAt:    *temp.CreateAt,
+		Temperature: math.Round(temp.Temperature.Temperature*10) / 10,
+		Thresholds:  m.thresholdsSvc.TermopadThresholds(temp.Info),
+		Person:      person,
+		Termopad:    temp.Info,
+		ImageName:   temp.Image,
+	}
+	// Сохранённую тревогу ответственные лица могут отметить обработанной
+	id, err := m.dbStore.SetAlarm(event)
+	if err != nil {
+		m.log.Warnf("ошибка сохранения тревоги: %v", err)
+	}
+	event.ID = id
+	if m.notifySvc != nil {
+		m.notifySvc.Alarm(event)
+	}
+	if m.webhookSvc != nil {
+		m.webhookSvc.Alarm(event)
+	}
 }
@@ -0,0 +1,279 @@
+package personsync
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/service"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Период плановой синхронизации
+	syncInterval = 24 * time.Hour
+	// Минимальный интервал между запросами в СУДОС
+	requestInterval = 500 * time.Millisecond
+	// Через сколько обработанных персон сохранять промежуточное состояние в историю
+	saveEvery = 50
+	// Величина канала событий о ходе синхронизации
+	progressCapacity = 10
+)
+
+// PersonSync контроллер массовой синхронизации справочника персон с СУДОС. Инициализируется через
+// NewPersonSync. Периодически (и по запросу через Sync) обходит все известные номера вигандов,
+// запрашивая их в СУДОС не чаще requestInterval, и обновляет персоны и их фотографии в БД.
+type PersonSync struct {
+	ctx context.Context
+	log *logrus.Entry
+
+	sudosSvc service.SudosSvc
+	dbStore  store.DbStore
+
+	// Период плановой синхронизации (0 - только по запросу)
+	syncInterval time.Duration
+	// Минимальный интервал между запросами в СУДОС
+	requestInterval time.Duration
+
+	mu      sync.Mutex
+	current *model.PersonSync
+
+	progress chan *model.PersonSync
+
+	webhookSvc service.WebhookSvc
+}
+
+// ConfigPersonSync конфигурация PersonSync
+type ConfigPersonSync struct {
+	Log *logrus.Logger
+	// Период плановой синхронизации. Отрицательное значение отключает плановую синхронизацию
+	SyncInterval time.Duration
+	// Минимальный интервал между запросами в СУДОС
+	RequestInterval time.Duration
+	// Рассылка внешним системам событий об изменении персон (может отсутствовать)
+	WebhookSvc service.WebhookSvc
+}
+
+// NewPersonSync конструктор PersonSync
+func NewPersonSync(ctx context.Context, sudosSvc service.SudosSvc, dbStore store.DbStore, config *ConfigPersonSync) (*PersonSync, error) {
+	if config == nil {
+		return nil, errors.New("не установлен config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if sudosSvc == nil {
+		return nil, errors.New("не указана служба sudosSvc")
+	}
+	if dbStore == nil {
+		return nil, errors.New("не указана служба dbStore")
+	}
+
+	personSync := PersonSync{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "personsync",
+			"scope":  "controller",
+		}),
+		sudosSvc: sudosSvc,
+		dbStore:  dbStore,
+
+		syncInterval:    syncInterval,
+		requestInterval: requestInterval,
+
+		progress: make(chan *model.PersonSync, progressCapacity),
+
+		webhookSvc: config.WebhookSvc,
+	}
+	if config.SyncInterval != 0 {
+		personSync.syncInterval = config.SyncInterval
+	}
+	if config.RequestInterval != 0 {
+		personSync.requestInterval = config.RequestInterval
+	}
+	go personSync.loop()
+
+	return &personSync, nil
+}
+
+// Плановый запуск синхронизации
+func (m *PersonSync) loop() {
+	if m.syncInterval <= 0 {
+		m.log.Info("плановая синхронизация персон отключена")
+		return
+	}
+	m.log.Infof("старт работы модуля (период синхронизации %s)", m.syncInterval)
+	ticker := time.NewTicker(m.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			m.log.Info("завершение работы модуля")
+			return
+		case <-ticker.C:
+			if _, err := m.Sync(); err != nil {
+				m.log.Warnf("плановая синхронизация не запущена: %v", err)
+			}
+		}
+	}
+}
+
+// Sync запускает в фоне синхронизацию справочника персон с СУДОС и возвращает созданную запись истории.
+// Если синхронизация уже выполняется, возвращается ошибка
+func (m *PersonSync) Sync() (*model.PersonSync, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current != nil {
+		return nil, errors.Errorf("синхронизация персон уже выполняется (запущена %s)", m.current.StartAt.Format("2006.01.02 15:04:05"))
+	}
+
+	wigands, err := m.dbStore.KnownWigands()
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка получения списка вигандов")
+	}
+	current, err := m.dbStore.SetPersonSync(model.PersonSync{
+		StartAt: time.Now(),
+		Status:  model.PersonSyncRunning,
+		Total:   uint(len(wigands)),
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка сохранения истории синхронизации")
+	}
+	m.current = current
+	m.log.Infof("запуск синхронизации персон с СУДОС (%d персон)", len(wigands))
+
+	res := *current
+	go m.sync(res, wigands)
+	return &res, nil
+}
+
+// Current возвращает состояние выполняющейся синхронизации или nil, если синхронизация не выполняется
+func (m *PersonSync) Current() *model.PersonSync {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current == nil {
+		return nil
+	}
+	res := *m.current
+	return &res
+}
+
+// History возвращает не более limit последних записей истории синхронизации
+func (m *PersonSync) History(limit uint) ([]model.PersonSync, error) {
+	return m.dbStore.PersonSyncs(limit)
+}
+
+// EmmitProgress ожидает очередное событие о ходе синхронизации. Возвращает context.Canceled при
+// принудительном завершении работы
+func (m *PersonSync) EmmitProgress() (*model.PersonSync, error) {
+	select {
+	case <-m.ctx.Done():
+		return nil, m.ctx.Err()
+	case progress := <-m.progress:
+		return progress, nil
+	}
+}
+
+// Обход всех вигандов с ограничением частоты запросов в СУДОС
+func (m *PersonSync) sync(state model.PersonSync, wigands []uint) {
+	limiter := time.NewTicker(m.requestInterval)
+	defer limiter.Stop()
+
+loop:
+	for _, wigandID := range wigands {
+		select {
+		case <-m.ctx.Done():
+			state.Status = model.PersonSyncCanceled
+			break loop
+		case <-limiter.C:
+		}
+
+		if err := m.syncPerson(model.Wigand{ID: wigandID}); err != nil {
+			m.log.Debugf("персона %d не синхронизирована: %v", wigandID, err)
+			state.Failed++
+		} else {
+			state.Updated++
+		}
+		state.Processed++
+
+		if state.Processed%saveEvery == 0 {
+			m.save(state)
+		}
+		m.setProgress(state)
+	}
+
+	if state.Status == model.PersonSyncRunning {
+		state.Status = model.PersonSyncDone
+		if state.Total != 0 && state.Failed == state.Total {
+			state.Status = model.PersonSyncFailed
+			state.Error = "СУДОС не ответил ни на один запрос"
+		}
+	}
+	finish := time.Now()
+	state.FinishAt = &finish
+	m.save(state)
+	m.log.Infof("синхронизация персон завершена со статусом %s: обновлено %d, ошибок %d из %d",
+		state.Status, state.Updated, state.Failed, state.Total)
+
+	m.mu.Lock()
+	m.current = nil
+	m.mu.Unlock()
+	m.setProgress(state)
+}
+
+// Запрос данных одной персоны из СУДОС и сохранение их в БД
+func (m *PersonSync) syncPerson(wigand model.Wigand) error {
+	person, err := m.sudosSvc.Person(wigand)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(person.Image) != 0 {
+		if err := m.dbStore.SetPersonImage(person.Wigand.ID, person.Image); err != nil {
+			m.log.Warnf("ошибка сохранения изображения персоны %s в БД: %v", wigand, err)
+		}
+	}
+	// todo: кастыль от ошибки валидатора на BASE64 изображении (см. Manager.temperatureInWorker)
+	person.Image = make([]byte, 0)
+
+	// Внешним системам рассылаются только действительно изменившиеся персоны, а не весь справочник
+	previous, err := m.dbStore.GetPerson(person.Wigand.ID)
+	if err != nil && !m.dbStore.IsNotFound(err) {
+		return errors.Trace(err)
+	}
+	stored, _, err := m.dbStore.SetPerson(*person)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if m.webhookSvc != nil && (previous == nil || !stored.SameData(*previous)) {
+		m.webhookSvc.PersonUpdated(*stored)
+	}
+	return nil
+}
+
+// Сохранение текущего состояния синхронизации в историю
+func (m *PersonSync) save(state model.PersonSync) {
+	if _, err := m.dbStore.SetPersonSync(state); err != nil {
+		m.log.Warnf("ошибка сохранения истории синхронизации: %v", err)
+	}
+}
+
+// Фиксация текущего состояния синхронизации и отправка его подписчикам
+func (m *PersonSync) setProgress(state model.PersonSync) {
+	m.mu.Lock()
+	if m.current != nil {
+		*m.current = state
+	}
+	m.mu.Unlock()
+
+	select {
+	case m.progress <- &state:
+	default:
+		m.log.Debug("очередь progress переполнена")
+	}
+}
@@ -0,0 +1,354 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Ёмкость очереди в памяти для каждого термопада
+	capacity = 100
+	// Директория для сохранения замеров при политике spill
+	spillDir = "./spool"
+)
+
+// ErrClosed очередь закрыта и пуста
+var ErrClosed = errors.New("очередь замеров закрыта")
+
+// Queue ограниченная очередь замеров температуры. Инициализируется через NewQueue. Для каждого термопада
+// ведётся своя очередь ёмкостью capacity, а Pop выдаёт замеры по кругу от каждого термопада, чтобы
+// поток замеров с одного термопада не задерживал обработку остальных.
+type Queue struct {
+	ctx context.Context
+	log *logrus.Entry
+
+	capacity int
+	overflow string
+	spillDir string
+
+	mu       *sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	closed   bool
+
+	termopads map[uint]*termopadQueue
+	// Порядок обхода термопадов и позиция следующего
+	order []uint
+	next  int
+	// Счётчик для уникальности имён файлов при сбросе на диск
+	seq uint64
+}
+
+// Очередь замеров одного термопада
+type termopadQueue struct {
+	items []*model.TermopadTemperatureEvent
+	// Файлы сохранённых на диск замеров в порядке поступления
+	spilled []string
+	dropped uint
+}
+
+// ConfigQueue конфигурация Queue
+type ConfigQueue struct {
+	Log *logrus.Logger
+	// Ёмкость очереди в памяти для каждого термопада
+	Capacity int
+	// Политика переполнения: model.QueueOverflowBlock (по умолчанию), model.QueueOverflowDropOldest
+	// или model.QueueOverflowSpill
+	Overflow string
+	// Директория для сохранения замеров при политике spill
+	SpillDir string
+}
+
+// NewQueue конструктор Queue. При политике spill в очередь загружаются замеры, оставшиеся на диске
+// с прошлого запуска
+func NewQueue(ctx context.Context, config *ConfigQueue) (*Queue, error) {
+	if config == nil {
+		return nil, errors.New("не установлен config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+
+	queue := Queue{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "queue",
+			"scope":  "controller",
+		}),
+		capacity: capacity,
+		overflow: model.QueueOverflowBlock,
+		spillDir: spillDir,
+
+		mu:        new(sync.Mutex),
+		termopads: make(map[uint]*termopadQueue),
+		order:     make([]uint, 0),
+	}
+	queue.notEmpty = sync.NewCond(queue.mu)
+	queue.notFull = sync.NewCond(queue.mu)
+
+	if config.Capacity != 0 {
+		queue.capacity = config.Capacity
+	}
+	if config.Overflow != "" {
+		queue.overflow = config.Overflow
+	}
+	if config.SpillDir != "" {
+		queue.spillDir = config.SpillDir
+	}
+	switch queue.overflow {
+	case model.QueueOverflowBlock, model.QueueOverflowDropOldest:
+	case model.QueueOverflowSpill:
+		if err := queue.loadSpilled(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	default:
+		return nil, errors.Errorf("неизвестная политика переполнения очереди \"%s\"", queue.overflow)
+	}
+
+	// Будим ожидающих при отмене контекста
+	go func() {
+		<-ctx.Done()
+		queue.mu.Lock()
+		queue.notEmpty.Broadcast()
+		queue.notFull.Broadcast()
+		queue.mu.Unlock()
+	}()
+
+	queue.log.Debugf("capacity: %d", queue.capacity)
+	queue.log.Debugf("overflow: %s", queue.overflow)
+
+	return &queue, nil
+}
+
+// Push помещает замер в очередь его термопада. Если очередь заполнена, при политике block ожидает
+// освобождения места, при drop-oldest вытесняет самый старый замер, при spill сохраняет замер на диск
+func (m *Queue) Push(event *model.TermopadTemperatureEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	queue := m.termopad(event.Info.ID)
+
+	// Пока на диске есть замеры термопада, новые пишутся туда же, чтобы не нарушить порядок
+	if len(queue.items) < m.capacity && len(queue.spilled) == 0 {
+		m.push(queue, event)
+		return nil
+	}
+
+	switch m.overflow {
+	case model.QueueOverflowDropOldest:
+		queue.items = queue.items[1:]
+		queue.dropped++
+		m.log.Warnf("очередь термопада %d переполнена, самый старый замер вытеснен (всего %d)", event.Info.ID, queue.dropped)
+		m.push(queue, event)
+	case model.QueueOverflowSpill:
+		fileName, err := m.spill(event)
+		if err != nil {
+			return errors.Annotatef(err, "ошибка сохранения замера термопада %d на диск", event.Info.ID)
+		}
+		queue.spilled = append(queue.spilled, fileName)
+		m.log.Debugf("очередь термопада %d переполнена, замер сохранён в %s", event.Info.ID, fileName)
+	default:
+		m.log.Warnf("очередь термопада %d переполнена, приём замеров ожидает", event.Info.ID)
+		for len(queue.items) >= m.capacity {
+			if m.closed {
+				return ErrClosed
+			}
+			if m.ctx.Err() != nil {
+				return m.ctx.Err()
+			}
+			m.notFull.Wait()
+		}
+		m.push(queue, event)
+	}
+	return nil
+}
+
+// Pop ожидает и возвращает очередной замер. Термопады обходятся по кругу. После Close возвращает
+// оставшиеся замеры, а когда очередь опустеет - ErrClosed. При отмене контекста возвращает его ошибку
+func (m *Queue) Pop() (*model.TermopadTemperatureEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		if err := m.ctx.Err(); err != nil {
+			return nil, err
+		}
+		if event := m.take(); event != nil {
+			m.notFull.Broadcast()
+			return event, nil
+		}
+		if m.closed {
+			return nil, ErrClosed
+		}
+		m.notEmpty.Wait()
+	}
+}
+
+// Close прекращает приём замеров. Уже принятые замеры остаются доступны через Pop
+func (m *Queue) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.notEmpty.Broadcast()
+	m.notFull.Broadcast()
+}
+
+// Stats возвращает глубину очереди каждого термопада, упорядоченную по ID термопада
+func (m *Queue) Stats() []model.QueueStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]model.QueueStats, 0, len(m.termopads))
+	for id, v := range m.termopads {
+		result = append(result, model.QueueStats{
+			TermopadID: id,
+			Queued:     uint(len(v.items)),
+			Spilled:    uint(len(v.spilled)),
+			Dropped:    v.dropped,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].TermopadID < result[j].TermopadID })
+	return result
+}
+
+// Очередь термопада id (создаётся при первом обращении)
+func (m *Queue) termopad(id uint) *termopadQueue {
+	queue, ok := m.termopads[id]
+	if !ok {
+		queue = &termopadQueue{items: make([]*model.TermopadTemperatureEvent, 0)}
+		m.termopads[id] = queue
+		m.order = append(m.order, id)
+	}
+	return queue
+}
+
+func (m *Queue) push(queue *termopadQueue, event *model.TermopadTemperatureEvent) {
+	queue.items = append(queue.items, event)
+	m.notEmpty.Signal()
+}
+
+// Очередной замер по кругу термопадов или nil, если очередь пуста
+func (m *Queue) take() *model.TermopadTemperatureEvent {
+	for i := 0; i < len(m.order); i++ {
+		idx := (m.next + i) % len(m.order)
+		queue := m.termopads[m.order[idx]]
+		if len(queue.items) == 0 {
+			continue
+		}
+		m.next = (idx + 1) % len(m.order)
+
+		event := queue.items[0]
+		queue.items = queue.items[1:]
+		m.refill(queue)
+		return event
+	}
+	return nil
+}
+
+// Возврат сохранённых на диск замеров в освободившееся место очереди
+func (m *Queue) refill(queue *termopadQueue) {
+	for len(queue.spilled) != 0 && len(queue.items) < m.capacity {
+		fileName := queue.spilled[0]
+		queue.spilled = queue.spilled[1:]
+
+		event, err := readSpilled(fileName)
+		if err != nil {
+			m.log.Errorf("замер %s не восстановлен с диска: %v", fileName, err)
+			continue
+		}
+		if err := os.Remove(fileName); err != nil {
+			m.log.Warn(err)
+		}
+		queue.items = append(queue.items, event)
+	}
+}
+
+// Сохранение замера на диск в поддиректорию термопада. Само изображение уже сохранено
+// контроллером термопада, поэтому его содержимое не записывается
+func (m *Queue) spill(event *model.TermopadTemperatureEvent) (string, error) {
+	dir := filepath.Join(m.spillDir, strconv.Itoa(int(event.Info.ID)))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	copied := *event
+	copied.Temperature.Image = nil
+	content, err := json.Marshal(copied)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	m.seq++
+	fileName := filepath.Join(dir, fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), m.seq%1000000))
+	if err := ioutil.WriteFile(fileName, content, 0644); err != nil {
+		return "", errors.Trace(err)
+	}
+	return fileName, nil
+}
+
+// Загрузка оставшихся на диске с прошлого запуска замеров
+func (m *Queue) loadSpilled() error {
+	dirs, err := ioutil.ReadDir(m.spillDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Trace(err)
+	}
+	total := 0
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		id, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(m.spillDir, dir.Name(), "*.json"))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(files) == 0 {
+			continue
+		}
+		sort.Strings(files)
+		queue := m.termopad(uint(id))
+		queue.spilled = append(queue.spilled, files...)
+		m.refill(queue)
+		total += len(files)
+	}
+	if total != 0 {
+		m.log.Infof("с диска восстановлено замеров: %d", total)
+	}
+	return nil
+}
+
+// Чтение сохранённого на диск замера
+func readSpilled(fileName string) (*model.TermopadTemperatureEvent, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	event := new(model.TermopadTemperatureEvent)
+	if err := json.Unmarshal(content, event); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return event, nil
+}
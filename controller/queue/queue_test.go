@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+)
+
+// Замер термопада id с температурой temperature
+func event(id uint, temperature float64) *model.TermopadTemperatureEvent {
+	now := time.Now()
+	return &model.TermopadTemperatureEvent{
+		CreateAt:    &now,
+		Info:        model.TermopadInfo{ID: id},
+		Temperature: model.TemperatureEvent{Temperature: temperature},
+	}
+}
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name      string
+		overflow  string
+		push      []*model.TermopadTemperatureEvent
+		want      []float64
+		wantStats []model.QueueStats
+	}{
+		{
+			name:     "вытеснение самого старого замера",
+			overflow: model.QueueOverflowDropOldest,
+			push:     []*model.TermopadTemperatureEvent{event(1, 36.1), event(1, 36.2), event(1, 36.3), event(1, 36.4)},
+			want:     []float64{36.3, 36.4},
+			wantStats: []model.QueueStats{
+				{TermopadID: 1, Queued: 2, Dropped: 2},
+			},
+		},
+		{
+			name:     "сохранение на диск с сохранением порядка",
+			overflow: model.QueueOverflowSpill,
+			push:     []*model.TermopadTemperatureEvent{event(1, 36.1), event(1, 36.2), event(1, 36.3), event(1, 36.4)},
+			want:     []float64{36.1, 36.2, 36.3, 36.4},
+			wantStats: []model.QueueStats{
+				{TermopadID: 1, Queued: 2, Spilled: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "queue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			queue, err := NewQueue(context.Background(), &ConfigQueue{Capacity: 2, Overflow: tt.overflow, SpillDir: dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, v := range tt.push {
+				if err := queue.Push(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := queue.Stats(); !reflect.DeepEqual(got, tt.wantStats) {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.wantStats)
+			}
+
+			got := make([]float64, 0)
+			for range tt.want {
+				v, err := queue.Pop()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, v.Temperature.Temperature)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Pop() = %v, want %v", got, tt.want)
+			}
+
+			queue.Close()
+			if _, err := queue.Pop(); err != ErrClosed {
+				t.Errorf("Pop() после Close = %v, want %v", err, ErrClosed)
+			}
+		})
+	}
+}
+
+func TestQueue_Block(t *testing.T) {
+	queue, err := NewQueue(context.Background(), &ConfigQueue{Capacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Push(event(1, 36.1)); err != nil {
+		t.Fatal(err)
+	}
+
+	pushed := make(chan error, 1)
+	go func() { pushed <- queue.Push(event(1, 36.2)) }()
+	select {
+	case <-pushed:
+		t.Fatal("Push() в заполненную очередь не ожидает освобождения места")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := queue.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Push() не продолжился после освобождения места")
+	}
+	if got := queue.Stats(); !reflect.DeepEqual(got, []model.QueueStats{{TermopadID: 1, Queued: 1}}) {
+		t.Errorf("Stats() = %+v", got)
+	}
+}
+
+func TestQueue_Fairness(t *testing.T) {
+	queue, err := NewQueue(context.Background(), &ConfigQueue{Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []*model.TermopadTemperatureEvent{event(1, 36.1), event(1, 36.2), event(1, 36.3), event(2, 36.6), event(3, 36.7)} {
+		if err := queue.Push(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []uint{1, 2, 3, 1, 1}
+	got := make([]uint, 0)
+	for range want {
+		v, err := queue.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v.Info.ID)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Pop() термопады %v, want %v", got, want)
+	}
+}
+
+func TestQueue_SpillRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := ConfigQueue{Capacity: 1, Overflow: model.QueueOverflowSpill, SpillDir: dir}
+	queue, err := NewQueue(context.Background(), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []*model.TermopadTemperatureEvent{event(5, 36.1), event(5, 36.2), event(5, 36.3)} {
+		if err := queue.Push(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Замеры на диске переживают перезапуск
+	restored, err := NewQueue(context.Background(), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.QueueStats{{TermopadID: 5, Queued: 1, Spilled: 1}}
+	if got := restored.Stats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	restored.Close()
+	got := make([]float64, 0)
+	for {
+		v, err := restored.Pop()
+		if err != nil {
+			break
+		}
+		got = append(got, v.Temperature.Temperature)
+	}
+	if !reflect.DeepEqual(got, []float64{36.2, 36.3}) {
+		t.Errorf("Pop() = %v, want %v", got, []float64{36.2, 36.3})
+	}
+}
@@ -0,0 +1,118 @@
+package report
+
+import (
+	"encoding/csv"
+	"html/template"
+	"io"
+	"strconv"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+
+	"github.com/juju/errors"
+)
+
+// Шаблон HTML-представления отчёта
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(counts model.ReportCounts) string {
+		return strconv.FormatFloat(counts.UnknownRate()*100, 'f', 1, 64) + "%"
+	},
+	"temperature": func(t float64) string {
+		return strconv.FormatFloat(t, 'f', 1, 64)
+	},
+	"minutes": func(cabin model.ReportCabin) string {
+		return strconv.Itoa(int(cabin.Downtime.Minutes()))
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>Отчёт о замерах температуры: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #999; padding: 0.2em 0.6em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.fever { color: #c00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Отчёт о замерах температуры</h1>
+<p>{{.Title}}. Сформирован {{.CreatedAt}}</p>
+<h2>Кабины</h2>
+<table>
+<tr><th>Кабина</th><th>Замеров</th><th>Повышенная</th><th>Неизвестные карты</th><th>Доля неизвестных</th><th>Недостоверные</th><th>Мин.</th><th>Макс.</th><th>Простой, мин</th></tr>
+{{range .Report.Cabins}}<tr><td>{{.Name}}</td><td>{{.Total}}</td><td{{if .Fever}} class="fever"{{end}}>{{.Fever}}</td><td>{{.Unknown}}</td><td>{{percent .ReportCounts}}</td><td>{{.Invalid}}</td><td>{{temperature .TemperatureMin}}</td><td>{{temperature .TemperatureMax}}</td><td>{{minutes .}}</td></tr>
+{{end}}<tr><th>Итого</th><th>{{.Report.Total}}</th><th>{{.Report.Fever}}</th><th>{{.Report.Unknown}}</th><th>{{percent .Report.ReportCounts}}</th><th>{{.Report.Invalid}}</th><th></th><th></th><th></th></tr>
+</table>
+<h2>Организации</h2>
+<table>
+<tr><th>Организация</th><th>Замеров</th><th>Повышенная</th><th>Недостоверные</th></tr>
+{{range .Report.Organizations}}<tr><td>{{if .Organization}}{{.Organization}}{{else}}(не указана){{end}}</td><td>{{.Total}}</td><td{{if .Fever}} class="fever"{{end}}>{{.Fever}}</td><td>{{.Invalid}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Render выводит отчёт report в w в формате format (model.ReportFormatHTML или model.ReportFormatCSV)
+func (m *Report) Render(w io.Writer, report *model.Report, format string) error {
+	switch format {
+	case model.ReportFormatHTML:
+		return errors.Trace(htmlTemplate.Execute(w, struct {
+			Title     string
+			CreatedAt string
+			Report    *model.Report
+		}{
+			Title:     periodTitle(report),
+			CreatedAt: report.CreatedAt.Format(tool.DateTimeLayout),
+			Report:    report,
+		}))
+	case model.ReportFormatCSV:
+		return renderCSV(w, report)
+	}
+	return errors.NotSupportedf("формат отчёта \"%s\"", format)
+}
+
+// Вывод отчёта в CSV: строки по кабинам, итог и строки по организациям, различаемые колонкой section
+func renderCSV(w io.Writer, report *model.Report) error {
+	writer := csv.NewWriter(w)
+	row := func(section string, id string, name string, counts model.ReportCounts, extra ...string) error {
+		values := []string{
+			section, id, name,
+			strconv.Itoa(int(counts.Total)),
+			strconv.Itoa(int(counts.Fever)),
+			strconv.Itoa(int(counts.Unknown)),
+			strconv.FormatFloat(counts.UnknownRate(), 'f', 3, 64),
+			strconv.Itoa(int(counts.Invalid)),
+		}
+		for len(extra) < 3 {
+			extra = append(extra, "")
+		}
+		return writer.Write(append(values, extra...))
+	}
+
+	err := writer.Write([]string{"section", "id", "name", "total", "fever", "unknown", "unknown_rate", "invalid",
+		"temperature_min", "temperature_max", "downtime_minutes"})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, v := range report.Cabins {
+		err := row("cabin", strconv.Itoa(int(v.TermopadID)), v.Name, v.ReportCounts,
+			strconv.FormatFloat(v.TemperatureMin, 'f', 1, 64),
+			strconv.FormatFloat(v.TemperatureMax, 'f', 1, 64),
+			strconv.Itoa(int(v.Downtime.Minutes())))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := row("total", "", "", report.ReportCounts); err != nil {
+		return errors.Trace(err)
+	}
+	for _, v := range report.Organizations {
+		if err := row("organization", "", v.Organization, v.ReportCounts); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	writer.Flush()
+	return errors.Trace(writer.Error())
+}
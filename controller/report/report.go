@@ -0,0 +1,357 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kirsrus/termopad-server/controller"
+	"github.com/kirsrus/termopad-server/controller/supervisor"
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/cron"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	"github.com/kirsrus/termopad-server/service"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Report контроллер сводных отчётов о замерах за сутки или смену. Инициализируется через NewReport.
+// По расписанию формирует отчёты за прошедшие сутки и завершившиеся смены, сохраняет их в БД и,
+// если задана директория, выгружает в HTML и CSV
+type Report struct {
+	ctx context.Context
+	log *logrus.Entry
+
+	dbStore       store.DbStore
+	thresholdsSvc service.ThresholdsSvc
+	supervisor    controller.SupervisorCtl
+
+	// Расписание формирования отчётов (nil - только по запросу)
+	schedule *cron.Schedule
+	shifts   []model.Shift
+	// Директория выгрузки отчётов (пустая - отчёты только сохраняются в БД)
+	path string
+
+	mu        sync.RWMutex
+	termopads []model.TermopadInfo
+}
+
+// ConfigReport конфигурация Report
+type ConfigReport struct {
+	Log *logrus.Logger
+	// Термопады, по которым формируется отчёт (в порядке вывода кабин)
+	Termopads []model.TermopadInfo
+	// Расписание формирования отчётов в формате cron. Пустое - отчёты формируются только по запросу
+	Schedule string
+	// Рабочие смены. Отчёт без указания смены охватывает сутки целиком
+	Shifts []model.Shift
+	// Директория, в которую выгружаются сформированные по расписанию отчёты
+	Path string
+	// Супервизор, перезапускающий формирование отчётов по расписанию после сбоев (если не задан и задано
+	// расписание, создаётся свой)
+	Supervisor controller.SupervisorCtl
+}
+
+// NewReport конструктор Report
+func NewReport(ctx context.Context, dbStore store.DbStore, thresholdsSvc service.ThresholdsSvc, config *ConfigReport) (*Report, error) {
+	if config == nil {
+		return nil, errors.New("не установлен config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if dbStore == nil {
+		return nil, errors.New("не указана служба dbStore")
+	}
+	if thresholdsSvc == nil {
+		return nil, errors.New("не указана служба thresholdsSvc")
+	}
+
+	report := Report{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "report",
+			"scope":  "controller",
+		}),
+		dbStore:       dbStore,
+		thresholdsSvc: thresholdsSvc,
+		supervisor:    config.Supervisor,
+		shifts:        config.Shifts,
+		path:          config.Path,
+		termopads:     config.Termopads,
+	}
+	for _, v := range config.Shifts {
+		if v.Name == "" {
+			return nil, errors.New("не указано имя смены")
+		}
+	}
+	if config.Schedule != "" {
+		schedule, err := cron.Parse(config.Schedule)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		report.schedule = schedule
+		if report.supervisor == nil {
+			svc, err := supervisor.NewSupervisor(ctx, &supervisor.ConfigSupervisor{Log: config.Log})
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			report.supervisor = svc
+		}
+	}
+
+	m := &report
+	if m.schedule != nil {
+		m.supervisor.Go(ctx, "report.schedule", m.loop)
+	}
+	return m, nil
+}
+
+// Формирование отчётов по расписанию. После сбоя супервизор перезапускает цикл, и он продолжается
+// со следующего срабатывания расписания
+func (m *Report) loop() error {
+	m.log.Infof("старт работы модуля (расписание \"%s\")", m.schedule)
+	for {
+		next := m.schedule.Next(time.Now())
+		if next.IsZero() {
+			m.log.Warnf("расписание \"%s\" никогда не срабатывает", m.schedule)
+			return nil
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-m.ctx.Done():
+			timer.Stop()
+			m.log.Info("завершение работы модуля")
+			return nil
+		case <-timer.C:
+			m.scheduled(next)
+		}
+	}
+}
+
+// Формирование в момент now отчётов за прошедшие сутки и за последний завершившийся экземпляр каждой смены
+func (m *Report) scheduled(now time.Time) {
+	today := tool.RoundToDate(now)
+	if _, err := m.Generate(today.AddDate(0, 0, -1), ""); err != nil {
+		m.log.Warnf("ошибка формирования суточного отчёта: %v", err)
+	}
+	for _, shift := range m.shifts {
+		for day := today; day.After(today.AddDate(0, 0, -3)); day = day.AddDate(0, 0, -1) {
+			if _, to := shift.Period(day); to.After(now) {
+				continue
+			}
+			if _, err := m.Generate(day, shift.Name); err != nil {
+				m.log.Warnf("ошибка формирования отчёта по смене %s: %v", shift.Name, err)
+			}
+			break
+		}
+	}
+}
+
+// Shifts возвращает описание рабочих смен
+func (m *Report) Shifts() []model.Shift {
+	return m.shifts
+}
+
+// SetTermopads изменяет список термопадов, по которым формируются отчёты
+func (m *Report) SetTermopads(termopads []model.TermopadInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.termopads = termopads
+}
+
+// Report возвращает отчёт за сутки date по смене shift (пустая - сутки целиком). Сохранённый отчёт
+// возвращается из БД, иначе отчёт формируется через Generate
+func (m *Report) Report(date time.Time, shift string) (*model.Report, error) {
+	result, err := m.dbStore.Report(date, shift)
+	if err == nil {
+		return result, nil
+	}
+	if !m.dbStore.IsNotFound(err) {
+		return nil, errors.Trace(err)
+	}
+	return m.Generate(date, shift)
+}
+
+// Generate формирует отчёт за сутки date по смене shift (пустая - сутки целиком). Отчёт за завершившийся
+// период сохраняется в БД, заменяя прежний, и выгружается в директорию отчётов
+func (m *Report) Generate(date time.Time, shift string) (*model.Report, error) {
+	period, err := m.shift(shift)
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.compute(date, period)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if result.To.After(result.CreatedAt) {
+		// Период ещё не завершился - отчёт промежуточный
+		return result, nil
+	}
+	if err := m.dbStore.SetReport(*result); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := m.export(result); err != nil {
+		m.log.Warnf("ошибка выгрузки отчёта: %v", err)
+	}
+	m.log.Infof("сформирован отчёт %s: замеров %d, с повышенной температурой %d", fileName(result), result.Total, result.Fever)
+	return result, nil
+}
+
+// Смена с именем name (пустое - сутки целиком)
+func (m *Report) shift(name string) (model.Shift, error) {
+	if name == "" {
+		return model.WholeDay, nil
+	}
+	for _, v := range m.shifts {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return model.Shift{}, errors.NewNotFound(nil, fmt.Sprintf("смена \"%s\" не описана в конфигурации", name))
+}
+
+// Расчёт показателей отчёта за смену shift, начавшуюся в сутки date
+func (m *Report) compute(date time.Time, shift model.Shift) (*model.Report, error) {
+	now := time.Now()
+	from, to := shift.Period(date)
+	result := model.Report{
+		Date:          tool.RoundToDate(date),
+		Shift:         shift.Name,
+		From:          from,
+		To:            to,
+		CreatedAt:     now,
+		Cabins:        make([]model.ReportCabin, 0),
+		Organizations: make([]model.ReportOrganization, 0),
+	}
+
+	m.mu.RLock()
+	termopads := m.termopads
+	m.mu.RUnlock()
+
+	organizations := make(map[string]*model.ReportOrganization)
+	for _, info := range termopads {
+		cabin := model.ReportCabin{TermopadID: info.ID, Name: info.Name}
+		thresholds := m.thresholdsSvc.TermopadThresholds(info)
+
+		metrics, err := m.dbStore.TermopadPeriodLog(info.ID, from, to, false)
+		if err != nil {
+			return nil, errors.Annotatef(err, "лог термопада %d", info.ID)
+		}
+		for _, v := range metrics {
+			counts := model.ReportCounts{Total: 1}
+			if v.Invalid {
+				counts.Invalid = 1
+			} else if thresholds.IsAlarm(v.Temperature) {
+				counts.Fever = 1
+			}
+			if isUnknown(v.Person) {
+				counts.Unknown = 1
+			}
+			cabin.Add(counts)
+
+			organization, ok := organizations[v.Person.Organization]
+			if !ok {
+				organization = &model.ReportOrganization{Organization: v.Person.Organization}
+				organizations[v.Person.Organization] = organization
+			}
+			organization.Add(counts)
+		}
+
+		// Границы температуры берутся из сжатого по дням лога (недостоверные замеры в него не попадают)
+		days, err := m.dbStore.TermopadPeriodLog(info.ID, from, to, true)
+		if err != nil {
+			return nil, errors.Annotatef(err, "сжатый лог термопада %d", info.ID)
+		}
+		for i, v := range days {
+			if i == 0 || v.TemperatureMin < cabin.TemperatureMin {
+				cabin.TemperatureMin = v.TemperatureMin
+			}
+			if i == 0 || v.TemperatureMax > cabin.TemperatureMax {
+				cabin.TemperatureMax = v.TemperatureMax
+			}
+		}
+
+		downtimeTo := to
+		if now.Before(downtimeTo) {
+			downtimeTo = now
+		}
+		if from.Before(downtimeTo) {
+			if cabin.Downtime, err = m.dbStore.TermopadDowntime(info.ID, from, downtimeTo); err != nil {
+				return nil, errors.Annotatef(err, "время недоступности термопада %d", info.ID)
+			}
+		}
+
+		result.Add(cabin.ReportCounts)
+		result.Cabins = append(result.Cabins, cabin)
+	}
+
+	for _, v := range organizations {
+		result.Organizations = append(result.Organizations, *v)
+	}
+	sort.Slice(result.Organizations, func(i, j int) bool {
+		a, b := result.Organizations[i], result.Organizations[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Organization < b.Organization
+	})
+	return &result, nil
+}
+
+// Замер сделан по неизвестной карте: карта не распознана или персоны нет в справочнике (для таких
+// персон лог термопада заполняет только виганд)
+func isUnknown(person model.Person) bool {
+	return person.Wigand.ID == 0 || person.CreateAt == nil
+}
+
+// Выгрузка отчёта в HTML и CSV в директорию отчётов
+func (m *Report) export(report *model.Report) error {
+	if m.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.path, 0755); err != nil {
+		return errors.Trace(err)
+	}
+	for _, format := range []string{model.ReportFormatHTML, model.ReportFormatCSV} {
+		file, err := os.Create(filepath.Join(m.path, fileName(report)+"."+format))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = m.Render(file, report, format)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Имя файла отчёта без расширения
+func fileName(report *model.Report) string {
+	name := "report-" + report.Date.Format(tool.DateLayout)
+	if report.Shift != "" {
+		name += "-" + report.Shift
+	}
+	return name
+}
+
+// Описание периода отчёта для вывода
+func periodTitle(report *model.Report) string {
+	shift := "сутки"
+	if report.Shift != "" {
+		shift = fmt.Sprintf("смена %s", report.Shift)
+	}
+	return fmt.Sprintf("%s, %s (%s - %s)", report.Date.Format(tool.DateLayout), shift,
+		report.From.Format(tool.DateTimeLayout), report.To.Format(tool.DateTimeLayout))
+}
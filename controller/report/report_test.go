@@ -0,0 +1,169 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/config"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	thresholdsSvcMod "github.com/kirsrus/termopad-server/service/thresholds"
+	dbStoreMod "github.com/kirsrus/termopad-server/store/db"
+)
+
+func TestReport_Generate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	dbStore, err := dbStoreMod.NewDb(ctx, &dbStoreMod.ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	termopads := []model.TermopadInfo{{ID: 1, Name: "Кабина 1"}, {ID: 2, Name: "Кабина 2"}}
+	dbStore.SetTermopads(termopads)
+	thresholdsSvc, err := thresholdsSvcMod.NewThresholds(ctx, dbStore, &thresholdsSvcMod.ConfigThresholds{
+		MaxTemperature: 37.5,
+		MinTemperature: 35.0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []model.Person{
+		{Wigand: model.NewWigand(100), Family: "Иванов", Name: "Иван", Organization: "Альфа"},
+		{Wigand: model.NewWigand(200), Family: "Петров", Name: "Пётр", Organization: "Бета"},
+	} {
+		if _, _, err := dbStore.SetPerson(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readings := []struct {
+		termopad    uint
+		wigand      uint
+		temperature float64
+	}{
+		{1, 100, 36.6},
+		{1, 100, 38.5},
+		{1, 100, -5.0},
+		// Карта не найдена в справочнике и карта не распознана
+		{1, 999, 36.7},
+		{1, 0, 36.5},
+		{2, 200, 36.6},
+	}
+	for i, v := range readings {
+		if err := dbStore.SetTemperatureLog(v.termopad, strings.Repeat("f", i+1), v.wigand, v.temperature, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Термопад 2 был недоступен (в пределах текущих суток)
+	now := time.Now()
+	downtimeFrom := now.Add(-30 * time.Minute)
+	if today := tool.RoundToDate(now); downtimeFrom.Before(today) {
+		downtimeFrom = today
+	}
+	if err := dbStore.SetTermopadStatus(2, false, downtimeFrom); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbStore.SetTermopadStatus(2, true, now); err != nil {
+		t.Fatal(err)
+	}
+
+	reportCtl, err := NewReport(ctx, dbStore, thresholdsSvc, &ConfigReport{
+		Termopads: termopads,
+		Shifts:    []model.Shift{{Name: "day", Start: 8 * time.Hour, End: 20 * time.Hour}},
+		Path:      filepath.Join(dir, "reports"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := reportCtl.Generate(now, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (model.ReportCounts{Total: 6, Fever: 1, Unknown: 2, Invalid: 1}); got.ReportCounts != want {
+		t.Errorf("Generate() итоги = %+v, want %+v", got.ReportCounts, want)
+	}
+	wantCabins := []model.ReportCabin{
+		{TermopadID: 1, Name: "Кабина 1", ReportCounts: model.ReportCounts{Total: 5, Fever: 1, Unknown: 2, Invalid: 1},
+			TemperatureMin: 36.5, TemperatureMax: 38.5},
+		{TermopadID: 2, Name: "Кабина 2", ReportCounts: model.ReportCounts{Total: 1},
+			TemperatureMin: 36.6, TemperatureMax: 36.6, Downtime: now.Sub(downtimeFrom)},
+	}
+	if !reflect.DeepEqual(got.Cabins, wantCabins) {
+		t.Errorf("Generate() кабины = %+v, want %+v", got.Cabins, wantCabins)
+	}
+	wantOrganizations := []model.ReportOrganization{
+		{Organization: "Альфа", ReportCounts: model.ReportCounts{Total: 3, Fever: 1, Invalid: 1}},
+		{Organization: "", ReportCounts: model.ReportCounts{Total: 2, Unknown: 2}},
+		{Organization: "Бета", ReportCounts: model.ReportCounts{Total: 1}},
+	}
+	if !reflect.DeepEqual(got.Organizations, wantOrganizations) {
+		t.Errorf("Generate() организации = %+v, want %+v", got.Organizations, wantOrganizations)
+	}
+
+	// Текущие сутки не завершились - отчёт не сохраняется
+	if _, err := dbStore.Report(now, ""); !dbStore.IsNotFound(err) {
+		t.Errorf("Report() промежуточного отчёта: %v, want not found", err)
+	}
+	if _, err := reportCtl.Generate(now, "night"); err == nil {
+		t.Error("Generate() по неизвестной смене без ошибки")
+	}
+
+	// Отчёт за завершившиеся сутки сохраняется и выгружается в файлы
+	yesterday := now.AddDate(0, 0, -1)
+	if _, err := reportCtl.Generate(yesterday, ""); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := dbStore.Report(yesterday, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Total != 0 || len(stored.Cabins) != 2 {
+		t.Errorf("Report() сохранённый отчёт = %+v", stored)
+	}
+	name := filepath.Join(dir, "reports", "report-"+yesterday.Format(tool.DateLayout))
+	for _, ext := range []string{".html", ".csv"} {
+		if _, err := os.Stat(name + ext); err != nil {
+			t.Errorf("не выгружен файл отчёта: %v", err)
+		}
+	}
+
+	// Представление в CSV: заголовок, кабины, итог и организации
+	var buf bytes.Buffer
+	if err := reportCtl.Render(&buf, got, model.ReportFormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1+2+1+3 {
+		t.Fatalf("Render() csv строк = %d, want %d", len(rows), 7)
+	}
+	if want := []string{"cabin", "1", "Кабина 1", "5", "1", "2", "0.400", "1", "36.5", "38.5", "0"}; !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("Render() csv кабина = %q, want %q", rows[1], want)
+	}
+	buf.Reset()
+	if err := reportCtl.Render(&buf, got, model.ReportFormatHTML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<td>Кабина 2</td>") {
+		t.Error("Render() html не содержит кабину")
+	}
+}
@@ -0,0 +1,159 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Период проверки срока хранения персон
+	checkInterval = time.Hour
+)
+
+// Retention контроллер хранения персональных данных. Инициализируется через NewRetention. Стирает данные
+// персоны по запросу, а если задан срок хранения - периодически стирает персоны, не проходившие замеров
+// дольше этого срока. Каждое стирание по сроку хранения записывается в журнал аудита
+type Retention struct {
+	ctx context.Context
+	log *logrus.Entry
+
+	dbStore store.DbStore
+
+	// Срок хранения персон без замеров (0 - бессрочно)
+	period time.Duration
+	// Режим стирания (model.ErasureDelete или model.ErasurePseudonymize)
+	mode     string
+	interval time.Duration
+}
+
+// ConfigRetention конфигурация Retention
+type ConfigRetention struct {
+	Log *logrus.Logger
+	// Срок хранения персон, не проходивших замеров, в днях (0 - бессрочно)
+	PersonRetentionDays uint
+	// Режим стирания: model.ErasureDelete (по умолчанию) или model.ErasurePseudonymize
+	Mode string
+	// Период проверки срока хранения (по умолчанию час)
+	Interval time.Duration
+}
+
+// NewRetention конструктор Retention
+func NewRetention(ctx context.Context, dbStore store.DbStore, config *ConfigRetention) (*Retention, error) {
+	if config == nil {
+		return nil, errors.New("не установлен config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if dbStore == nil {
+		return nil, errors.New("не указана служба dbStore")
+	}
+
+	retention := Retention{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "retention",
+			"scope":  "controller",
+		}),
+		dbStore:  dbStore,
+		period:   time.Duration(config.PersonRetentionDays) * 24 * time.Hour,
+		mode:     model.ErasureDelete,
+		interval: checkInterval,
+	}
+	switch config.Mode {
+	case "":
+	case model.ErasureDelete, model.ErasurePseudonymize:
+		retention.mode = config.Mode
+	default:
+		return nil, errors.Errorf("неизвестный режим стирания \"%s\"", config.Mode)
+	}
+	if config.Interval != 0 {
+		retention.interval = config.Interval
+	}
+
+	if retention.period != 0 {
+		go retention.loop()
+	}
+	return &retention, nil
+}
+
+// Mode режим стирания персональных данных
+func (m *Retention) Mode() string {
+	return m.mode
+}
+
+// ErasePerson стирает персональные данные персоны wigand в настроенном режиме. Отсутствие данных о персоне
+// проверяется через errors.IsNotFound
+func (m *Retention) ErasePerson(wigand uint) (*model.Erasure, error) {
+	erasure, err := m.dbStore.ErasePerson(wigand, m.mode == model.ErasurePseudonymize)
+	if err != nil {
+		if m.dbStore.IsNotFound(err) {
+			return nil, errors.NotFoundf("данные персоны с вигандом %d", wigand)
+		}
+		return nil, errors.Trace(err)
+	}
+	return erasure, nil
+}
+
+// CleanInactive стирает персоны, не проходившие замеров дольше срока хранения, и записывает каждое стирание в
+// журнал аудита. Если срок хранения не задан, ничего не делает
+func (m *Retention) CleanInactive() ([]model.Erasure, error) {
+	result := make([]model.Erasure, 0)
+	if m.period == 0 {
+		return result, nil
+	}
+	wigands, err := m.dbStore.InactivePersons(time.Now().Add(-m.period))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, wigand := range wigands {
+		erasure, err := m.ErasePerson(wigand)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return result, errors.Trace(err)
+		}
+		result = append(result, *erasure)
+		err = m.dbStore.SetAudit(model.Audit{
+			CreateAt: time.Now(),
+			User:     model.AuditSystemUser,
+			Action:   model.AuditErasePerson,
+			Target:   strconv.Itoa(int(wigand)),
+			Details:  fmt.Sprintf("истёк срок хранения %d дней; %s", int(m.period.Hours()/24), erasure.Summary()),
+		})
+		if err != nil {
+			m.log.Warnf("ошибка записи в журнал аудита: %v", err)
+		}
+	}
+	if len(result) != 0 {
+		m.log.Infof("стёрто персон с истёкшим сроком хранения: %d", len(result))
+	}
+	return result, nil
+}
+
+// Периодическое стирание персон с истёкшим сроком хранения
+func (m *Retention) loop() {
+	m.log.Infof("старт работы модуля (срок хранения персон %d дней, режим %s)", int(m.period.Hours()/24), m.mode)
+	for {
+		if _, err := m.CleanInactive(); err != nil {
+			m.log.Warnf("ошибка стирания персон с истёкшим сроком хранения: %v", err)
+		}
+		select {
+		case <-m.ctx.Done():
+			m.log.Info("завершение работы модуля")
+			return
+		case <-time.After(m.interval):
+		}
+	}
+}
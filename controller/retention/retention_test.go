@@ -0,0 +1,125 @@
+package retention
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+)
+
+// Хранилище с персонами без замеров. Вызов остальных методов store.DbStore приводит к панике
+type inactiveStore struct {
+	store.DbStore
+	inactive []uint
+	// Виганды, данных о которых уже нет
+	missing map[uint]bool
+	before  time.Time
+	audit   []model.Audit
+}
+
+func (m *inactiveStore) InactivePersons(before time.Time) ([]uint, error) {
+	m.before = before
+	return m.inactive, nil
+}
+
+func (m *inactiveStore) ErasePerson(wigandID uint, pseudonymize bool) (*model.Erasure, error) {
+	if m.missing[wigandID] {
+		return nil, errors.NotFoundf("персона")
+	}
+	erasure := model.Erasure{Wigand: wigandID, Mode: model.ErasureDelete, Temperatures: 1}
+	if pseudonymize {
+		erasure.Mode, erasure.Pseudonym = model.ErasurePseudonymize, wigandID+1000
+	}
+	return &erasure, nil
+}
+
+func (m *inactiveStore) IsNotFound(err error) bool {
+	return errors.IsNotFound(err)
+}
+
+func (m *inactiveStore) SetAudit(audit model.Audit) error {
+	m.audit = append(m.audit, audit)
+	return nil
+}
+
+func TestRetention_CleanInactive(t *testing.T) {
+	tests := []struct {
+		name      string
+		days      uint
+		mode      string
+		inactive  []uint
+		missing   map[uint]bool
+		want      []model.Erasure
+		wantAudit []string
+	}{
+		{
+			name:     "бессрочное хранение",
+			inactive: []uint{100},
+			want:     []model.Erasure{},
+		},
+		{
+			name:      "удаление",
+			days:      30,
+			inactive:  []uint{100, 200},
+			missing:   map[uint]bool{200: true},
+			want:      []model.Erasure{{Wigand: 100, Mode: model.ErasureDelete, Temperatures: 1}},
+			wantAudit: []string{"100"},
+		},
+		{
+			name:     "псевдонимизация",
+			days:     30,
+			mode:     model.ErasurePseudonymize,
+			inactive: []uint{100, 200},
+			want: []model.Erasure{
+				{Wigand: 100, Mode: model.ErasurePseudonymize, Pseudonym: 1100, Temperatures: 1},
+				{Wigand: 200, Mode: model.ErasurePseudonymize, Pseudonym: 1200, Temperatures: 1},
+			},
+			wantAudit: []string{"100", "200"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbStore := &inactiveStore{inactive: tt.inactive, missing: tt.missing}
+			retention, err := NewRetention(context.Background(), dbStore, &ConfigRetention{Mode: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Срок задаётся без запуска периодической проверки
+			retention.period = time.Duration(tt.days) * 24 * time.Hour
+
+			got, err := retention.CleanInactive()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CleanInactive() = %+v, want %+v", got, tt.want)
+			}
+			if tt.days != 0 {
+				if since := time.Since(dbStore.before); since < retention.period || since > retention.period+time.Minute {
+					t.Errorf("InactivePersons() before = %v", dbStore.before)
+				}
+			}
+			var targets []string
+			for _, v := range dbStore.audit {
+				if v.User != model.AuditSystemUser || v.Action != model.AuditErasePerson {
+					t.Errorf("запись аудита = %+v", v)
+				}
+				targets = append(targets, v.Target)
+			}
+			if !reflect.DeepEqual(targets, tt.wantAudit) {
+				t.Errorf("записи аудита по %v, want %v", targets, tt.wantAudit)
+			}
+		})
+	}
+}
+
+func TestNewRetention(t *testing.T) {
+	if _, err := NewRetention(context.Background(), &inactiveStore{}, &ConfigRetention{Mode: "archive"}); err == nil {
+		t.Error("NewRetention() с неизвестным режимом: нет ошибки")
+	}
+}
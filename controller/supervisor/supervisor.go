@@ -0,0 +1,181 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Задержка перед первым перезапуском обработчика. Каждый следующий сбой удваивает её
+	minBackoff = time.Second
+	// Максимальная задержка перед перезапуском
+	maxBackoff = time.Minute
+	// Если обработчик проработал без сбоев дольше этого времени, задержка сбрасывается до minBackoff
+	resetAfter = time.Minute
+)
+
+// Supervisor наблюдение за фоновыми обработчиками. Инициализируется через NewSupervisor. Обработчики,
+// завершившиеся с ошибкой или паникой, перезапускаются с нарастающей задержкой, а количество сбоев
+// и последняя ошибка доступны через Status.
+type Supervisor struct {
+	ctx context.Context
+	log *logrus.Entry
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	resetAfter time.Duration
+
+	mu      *sync.Mutex
+	workers map[*worker]struct{}
+}
+
+// Обработчик под наблюдением
+type worker struct {
+	status model.WorkerStatus
+}
+
+// ConfigSupervisor конфигурация Supervisor
+type ConfigSupervisor struct {
+	Log *logrus.Logger
+	// Задержка перед первым перезапуском обработчика
+	MinBackoff time.Duration
+	// Максимальная задержка перед перезапуском
+	MaxBackoff time.Duration
+	// Время работы без сбоев, после которого задержка сбрасывается
+	ResetAfter time.Duration
+}
+
+// NewSupervisor конструктор Supervisor
+func NewSupervisor(ctx context.Context, config *ConfigSupervisor) (*Supervisor, error) {
+	if config == nil {
+		return nil, errors.New("не установлен config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+
+	supervisor := Supervisor{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "supervisor",
+			"scope":  "controller",
+		}),
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		resetAfter: resetAfter,
+
+		mu:      new(sync.Mutex),
+		workers: make(map[*worker]struct{}),
+	}
+	if config.MinBackoff != 0 {
+		supervisor.minBackoff = config.MinBackoff
+	}
+	if config.MaxBackoff != 0 {
+		supervisor.maxBackoff = config.MaxBackoff
+	}
+	if config.ResetAfter != 0 {
+		supervisor.resetAfter = config.ResetAfter
+	}
+	if supervisor.maxBackoff < supervisor.minBackoff {
+		return nil, errors.Errorf("максимальная задержка %s меньше минимальной %s", supervisor.maxBackoff, supervisor.minBackoff)
+	}
+
+	return &supervisor, nil
+}
+
+// Go запускает обработчик fn с именем name. При ошибке или панике fn перезапускается с нарастающей
+// задержкой. Обработчик перестаёт наблюдаться, когда fn возвращает nil или отменяется ctx либо
+// контекст супервизора
+func (m *Supervisor) Go(ctx context.Context, name string, fn func() error) {
+	w := &worker{status: model.WorkerStatus{Name: name}}
+	m.mu.Lock()
+	m.workers[w] = struct{}{}
+	m.mu.Unlock()
+
+	go m.run(ctx, w, fn)
+}
+
+// Status возвращает состояние всех наблюдаемых обработчиков, упорядоченное по имени
+func (m *Supervisor) Status() []model.WorkerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]model.WorkerStatus, 0, len(m.workers))
+	for w := range m.workers {
+		result = append(result, w.status)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Цикл запуска обработчика с перезапуском после сбоев
+func (m *Supervisor) run(ctx context.Context, w *worker, fn func() error) {
+	defer func() {
+		m.mu.Lock()
+		delete(m.workers, w)
+		m.mu.Unlock()
+	}()
+	name := w.status.Name
+	backoff := m.minBackoff
+
+	for {
+		startedAt := time.Now()
+		m.mu.Lock()
+		w.status.Running = true
+		w.status.StartedAt = startedAt
+		m.mu.Unlock()
+
+		err := call(fn)
+		if ctx.Err() != nil || m.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			m.log.Debugf("обработчик %s завершил работу", name)
+			return
+		}
+
+		if time.Since(startedAt) >= m.resetAfter {
+			backoff = m.minBackoff
+		}
+		crashedAt := time.Now()
+		m.mu.Lock()
+		w.status.Running = false
+		w.status.Crashes++
+		w.status.LastError = err.Error()
+		w.status.LastErrorAt = &crashedAt
+		crashes := w.status.Crashes
+		m.mu.Unlock()
+		m.log.Errorf("обработчик %s аварийно завершился (сбоев: %d): %v; перезапуск через %s", name, crashes, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > m.maxBackoff {
+			backoff = m.maxBackoff
+		}
+	}
+}
+
+// Вызов обработчика с перехватом паники
+func call(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("паника: %v", r)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,102 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSupervisor_Go(t *testing.T) {
+	tests := []struct {
+		name        string
+		fn          func(calls int32) error
+		wantCalls   int32
+		wantCrashes uint
+		wantError   string
+	}{
+		{
+			name: "перезапуск после ошибок",
+			fn: func(calls int32) error {
+				if calls < 3 {
+					return errors.New("сбой")
+				}
+				return nil
+			},
+			wantCalls:   3,
+			wantCrashes: 2,
+			wantError:   "сбой",
+		},
+		{
+			name: "перезапуск после паники",
+			fn: func(calls int32) error {
+				if calls == 1 {
+					panic("авария")
+				}
+				return nil
+			},
+			wantCalls:   2,
+			wantCrashes: 1,
+			wantError:   "паника: авария",
+		},
+		{
+			name:      "штатное завершение",
+			fn:        func(int32) error { return nil },
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			supervisor, err := NewSupervisor(ctx, &ConfigSupervisor{
+				MinBackoff: time.Millisecond,
+				MaxBackoff: 5 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var calls int32
+			done := make(chan struct{})
+			// Перед последним вызовом сохраняем состояние обработчика, пока он ещё наблюдается
+			var crashes uint
+			var lastError string
+			supervisor.Go(ctx, "worker", func() error {
+				n := atomic.AddInt32(&calls, 1)
+				if n == tt.wantCalls {
+					status := supervisor.Status()
+					if len(status) != 1 || !status[0].Running {
+						t.Errorf("Status() = %+v, want one running worker", status)
+					} else {
+						crashes, lastError = status[0].Crashes, status[0].LastError
+					}
+					defer close(done)
+				}
+				return tt.fn(n)
+			})
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("обработчик вызван %d раз, want %d", atomic.LoadInt32(&calls), tt.wantCalls)
+			}
+			if crashes != tt.wantCrashes {
+				t.Errorf("Crashes = %d, want %d", crashes, tt.wantCrashes)
+			}
+			if lastError != tt.wantError {
+				t.Errorf("LastError = %q, want %q", lastError, tt.wantError)
+			}
+
+			// Завершившийся обработчик перестаёт наблюдаться
+			deadline := time.Now().Add(time.Second)
+			for len(supervisor.Status()) != 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if status := supervisor.Status(); len(status) != 0 {
+				t.Errorf("Status() = %+v, want empty", status)
+			}
+		})
+	}
+}
@@ -2,20 +2,27 @@ package termopad
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
+	"sync"
+	"time"
 
+	"github.com/kirsrus/termopad-server/controller"
+	"github.com/kirsrus/termopad-server/controller/supervisor"
 	"github.com/kirsrus/termopad-server/model"
 	"github.com/kirsrus/termopad-server/service"
 	"github.com/kirsrus/termopad-server/store"
 
 	"github.com/juju/errors"
+	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/errgroup"
 )
 
 const (
 	// Величина канала информации от термопадов
 	eventCapacity = 10
+	// Время, в течение которого повторно полученный замер отбрасывается без обращения к БД
+	dedupeWindow = 10 * time.Minute
 )
 
 // Termopad контроллер упдавления группой термопадов. Инициализируестя через NewTermopad. Держит постоянное
@@ -25,12 +32,19 @@ type Termopad struct {
 	ctx context.Context
 	log *logrus.Entry
 
-	termopadsSvc []service.TermopadSvc
-	dbStore      store.DbStore
+	dbStore    store.DbStore
+	supervisor controller.SupervisorCtl
+
+	// Запущенные обработчики термопадов и функции их остановки
+	mu      *sync.Mutex
+	readers map[service.TermopadSvc]context.CancelFunc
 
 	event chan *model.TermopadTemperatureEvent
 	stop  chan error
 
+	// Ключи приёма недавно полученных замеров
+	seen *cache.Cache
+
 	// Величина канала информации от термопадов
 	eventCapacity uint
 }
@@ -40,6 +54,10 @@ type ConfigTermopad struct {
 	Log *logrus.Logger
 	// Величина канала информации от термопадов
 	EventCapacity uint
+	// Супервизор, перезапускающий обработчики термопадов после сбоев (если не задан, создаётся свой)
+	Supervisor controller.SupervisorCtl
+	// Время, в течение которого повторно полученный замер отбрасывается без обращения к БД
+	DedupeWindow time.Duration
 }
 
 // NewTermopad конструтор Termopad
@@ -57,6 +75,13 @@ func NewTermopad(ctx context.Context, termopadsSvc []service.TermopadSvc, dbStor
 	if dbStore == nil {
 		return nil, errors.New("не указана служба dbStore")
 	}
+	if config.Supervisor == nil {
+		svc, err := supervisor.NewSupervisor(ctx, &supervisor.ConfigSupervisor{Log: config.Log})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		config.Supervisor = svc
+	}
 
 	termopad := Termopad{
 		ctx: ctx,
@@ -64,66 +89,139 @@ func NewTermopad(ctx context.Context, termopadsSvc []service.TermopadSvc, dbStor
 			"module": "termopad",
 			"scope":  "controller",
 		}),
-		termopadsSvc: termopadsSvc,
-		dbStore:      dbStore,
+		dbStore:    dbStore,
+		supervisor: config.Supervisor,
+
+		mu:      new(sync.Mutex),
+		readers: make(map[service.TermopadSvc]context.CancelFunc),
 
-		event: make(chan *model.TermopadTemperatureEvent, eventCapacity),
-		stop:  make(chan error),
+		stop: make(chan error),
 
 		eventCapacity: eventCapacity,
 	}
 	if config.EventCapacity != 0 {
 		termopad.eventCapacity = config.EventCapacity
 	}
-	go termopad.loop()
+	termopad.event = make(chan *model.TermopadTemperatureEvent, termopad.eventCapacity)
+	window := dedupeWindow
+	if config.DedupeWindow != 0 {
+		window = config.DedupeWindow
+	}
+	termopad.seen = cache.New(window, window)
+
+	m := &termopad
+	m.log.Info("старт работы модуля")
+	m.Restart(termopadsSvc)
+	go func() {
+		<-ctx.Done()
+		m.log.Info("завершение работы модуля")
+	}()
 
-	return &termopad, nil
+	return m, nil
 }
 
-// Бесконечное получение данных со всех термопадов
-func (m Termopad) loop() {
-	m.log.Info("старт работы модуля")
-	for {
-		g := new(errgroup.Group)
-
-		for _, v := range m.termopadsSvc {
-			v := v
-			g.Go(func() error {
-				for {
-					event, err := v.EmmitTemperature()
-					if err != nil {
-						return errors.Trace(err)
-					}
-					m.event <- event
-				}
-			})
+// Restart изменяет список термопадов, с которых получаются данные. Обработчики термопадов, которых нет
+// в termopadsSvc, останавливаются (сами службы термопадов при этом должны быть остановлены владельцем),
+// для новых термопадов запускаются обработчики, а уже работающие продолжают работу без перерыва
+func (m *Termopad) Restart(termopadsSvc []service.TermopadSvc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	actual := make(map[service.TermopadSvc]bool)
+	for _, v := range termopadsSvc {
+		actual[v] = true
+	}
+	for svc, cancel := range m.readers {
+		if !actual[svc] {
+			cancel()
+			delete(m.readers, svc)
 		}
+	}
+	for _, v := range termopadsSvc {
+		if _, ok := m.readers[v]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.readers[v] = cancel
+		svc := v
+		m.supervisor.Go(ctx, fmt.Sprintf("termopad.%d", svc.Info().ID), func() error {
+			return m.read(ctx, svc)
+		})
+	}
+	m.log.Debugf("опрашивается термопадов: %d", len(m.readers))
+}
 
-		err := g.Wait()
-		if err != nil && err.Error() != context.Canceled.Error() {
-			m.log.Error(err)
+// Бесконечное получение данных с термопада svc, пока не будет отменён ctx. Ошибка службы термопада
+// возвращается супервизору для перезапуска
+func (m *Termopad) read(ctx context.Context, svc service.TermopadSvc) error {
+	for {
+		event, err := svc.EmmitTemperature()
+		if err != nil {
+			if ctx.Err() != nil || err.Error() == context.Canceled.Error() {
+				return nil
+			}
+			return errors.Trace(err)
 		}
 		select {
-		case <-m.ctx.Done():
-			m.log.Info("завершение работы модуля")
-			return
+		case <-ctx.Done():
+			return nil
+		case m.event <- event:
 		}
 	}
 }
 
 // EmmitTemperature ожидает события поступление на любой из термопадов события
-// о текущей термпературе. Возвращает context.Cacnel при принудиельно завершении работы
-func (m Termopad) EmmitTemperature() (*model.TermopadTemperatureEvent, error) {
-	select {
-	case <-m.ctx.Done():
-		return nil, m.ctx.Err()
-	case temp := <-m.event:
+// о текущей термпературе. Повторно полученные замеры отбрасываются до сохранения изображения.
+// Возвращает context.Cacnel при принудиельно завершении работы, предварительно отдав все уже
+// принятые от термопадов события
+func (m *Termopad) EmmitTemperature() (*model.TermopadTemperatureEvent, error) {
+	for {
+		var temp *model.TermopadTemperatureEvent
+		select {
+		case temp = <-m.event:
+		case <-m.ctx.Done():
+			select {
+			case temp = <-m.event:
+			default:
+				return nil, m.ctx.Err()
+			}
+		}
+		duplicate, err := m.duplicate(temp)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if duplicate {
+			continue
+		}
 		// Сохраняем поученное изображение в БД
 		imgName, err := m.dbStore.SetTempImage(*temp.CreateAt, temp.Temperature.Wigand, temp.Temperature.Image)
 		if err != nil {
+			m.seen.Delete(temp.IngestionKey())
 			return nil, errors.Trace(err)
 		}
 		temp.Image = *imgName
 		return temp, nil
 	}
 }
+
+// Проверка, что замер temp уже получен: недавний по кэшу, более старый (в т.ч. принятый до перезапуска)
+// по логу температур. Замеры без имени файла на термопаде не проверяются
+func (m *Termopad) duplicate(temp *model.TermopadTemperatureEvent) (bool, error) {
+	key := temp.IngestionKey()
+	if key == "" {
+		return false, nil
+	}
+	if err := m.seen.Add(key, nil, cache.DefaultExpiration); err != nil {
+		m.log.Debugf("повторный замер %s отброшен", key)
+		return true, nil
+	}
+	found, err := m.dbStore.HasTemperatureLog(temp.Info.ID, temp.Temperature.FileName)
+	if err != nil {
+		m.seen.Delete(key)
+		return false, errors.Trace(err)
+	}
+	if found {
+		m.log.Infof("замер %s уже обработан, пропускаем", key)
+	}
+	return found, nil
+}
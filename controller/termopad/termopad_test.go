@@ -0,0 +1,119 @@
+package termopad
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/config"
+	"github.com/kirsrus/termopad-server/service"
+	"github.com/kirsrus/termopad-server/store/db"
+
+	"github.com/juju/errors"
+)
+
+// Термопад, отдающий заранее заданные замеры
+type fakeTermopad struct {
+	ctx    context.Context
+	info   model.TermopadInfo
+	events chan model.TermopadTemperatureEvent
+}
+
+func (m fakeTermopad) EmmitTemperature() (*model.TermopadTemperatureEvent, error) {
+	select {
+	case event := <-m.events:
+		return &event, nil
+	case <-m.ctx.Done():
+		return nil, m.ctx.Err()
+	}
+}
+
+func (m fakeTermopad) Info() model.TermopadInfo {
+	return m.info
+}
+
+func TestTermopad_EmmitTemperature(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{}
+	cfg.Images.Path = filepath.Join(dir, "temperature")
+	dbStore, err := db.NewDb(context.Background(), &db.ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: cfg,
+	})
+	if err != nil {
+		t.Fatal(errors.ErrorStack(err))
+	}
+	// Замер, принятый до перезапуска
+	if err := dbStore.SetTemperatureLog(1, "processed.jpg", 400, 36.6, "2026.03.02_09.00.00_400.jpeg"); err != nil {
+		t.Fatal(errors.ErrorStack(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	info := model.TermopadInfo{ID: 1, URL: "ws://127.0.0.1:8000/feed", Name: "T1"}
+	svc := fakeTermopad{ctx: ctx, info: info, events: make(chan model.TermopadTemperatureEvent, 5)}
+	begin := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	for i, v := range []struct {
+		fileName string
+		wigand   int
+	}{
+		{fileName: "first.jpg", wigand: 100},
+		// Повтор того же сообщения после переподключения
+		{fileName: "first.jpg", wigand: 100},
+		{fileName: "second.jpg", wigand: 200},
+		{fileName: "processed.jpg", wigand: 400},
+		// Замер без имени файла не отсекается
+		{wigand: 300},
+	} {
+		at := begin.Add(time.Duration(i) * time.Minute)
+		svc.events <- model.TermopadTemperatureEvent{
+			CreateAt: &at,
+			Info:     info,
+			Temperature: model.TemperatureEvent{
+				FileName:    v.fileName,
+				Temperature: 36.6,
+				Wigand:      model.NewWigand(v.wigand),
+				Image:       []byte("jpeg"),
+			},
+		}
+	}
+
+	termopad, err := NewTermopad(ctx, []service.TermopadSvc{svc}, dbStore, &ConfigTermopad{})
+	if err != nil {
+		t.Fatal(errors.ErrorStack(err))
+	}
+	want := []string{
+		"2026.03.02_10.00.00_100.jpeg",
+		"2026.03.02_10.02.00_200.jpeg",
+		"2026.03.02_10.04.00_300.jpeg",
+	}
+	got := make([]string, 0)
+	for range want {
+		event, err := termopad.EmmitTemperature()
+		if err != nil {
+			t.Fatal(errors.ErrorStack(err))
+		}
+		got = append(got, event.Image)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EmmitTemperature() = %v, want %v", got, want)
+	}
+
+	stored := make([]string, 0)
+	err = filepath.Walk(cfg.Images.Path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			stored = append(stored, info.Name())
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("сохранены изображения %v, want %v", stored, want)
+	}
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// Режимы шифрования подключения к почтовому серверу оповещений
+const (
+	// Без шифрования
+	SMTPTLSNone = "none"
+	// Переход на TLS командой STARTTLS (обычно порт 587 или 25)
+	SMTPTLSStartTLS = "starttls"
+	// Подключение сразу по TLS (обычно порт 465)
+	SMTPTLSImplicit = "tls"
+)
+
+// Alarm тревога о повышенной температуре, передаваемая в службы оповещения
+type Alarm struct {
+	CreateAt    time.Time
+	Temperature float64
+	// Пороги нормальной температуры, с которыми сравнивался замер
+	Thresholds Thresholds
+	// Для персон, не найденных в справочнике и в СУДОС, заполняется только виганд
+	Person   Person
+	Termopad TermopadInfo
+	// Имя файла изображения замера в БД (пустое, если изображения нет)
+	ImageName string
+	// Идентификатор сохранённой в БД тревоги (0, если тревога не сохранена)
+	ID uint
+	// Время и автор подтверждения обработки тревоги (nil, если тревога не обработана)
+	HandledAt *time.Time
+	HandledBy string
+}
+
+// ThrottleKey ключ отсечения повторных оповещений: персона, а для нераспознанной карты - термопад
+func (m Alarm) ThrottleKey() string {
+	if m.Person.Wigand.ID == 0 {
+		return "termopad:" + strconv.Itoa(int(m.Termopad.ID))
+	}
+	return "wigand:" + strconv.Itoa(int(m.Person.Wigand.ID))
+}
@@ -0,0 +1,96 @@
+package model
+
+import "time"
+
+// Роли пользователей WEB-интерфейса
+const (
+	// Администратор: все операции, включая просмотр журнала аудита
+	UserRoleAdmin = "admin"
+	// Оператор: все операции, кроме просмотра журнала аудита
+	UserRoleOperator = "operator"
+	// Табло (например, в холле): только просмотр в режиме приватности
+	UserRoleViewer = "viewer"
+)
+
+// UserRoles все роли пользователей
+var UserRoles = []string{UserRoleAdmin, UserRoleOperator, UserRoleViewer}
+
+// Действия, записываемые в журнал аудита
+const (
+	AuditCreatePerson      = "create-person"
+	AuditUpdatePerson      = "update-person"
+	AuditUploadPersonImage = "upload-person-image"
+	AuditRefreshPerson     = "refresh-person"
+	AuditSyncPersons       = "sync-persons"
+	AuditSetThresholds     = "set-thresholds"
+	AuditAcknowledgeAlarm  = "acknowledge-alarm"
+	// Стирание персональных данных персоны (по запросу или по истечении срока хранения)
+	AuditErasePerson = "erase-person"
+	// Просмотр истории замеров персоны
+	AuditPersonLog = "person-log"
+	// Просмотр фотографии персоны
+	AuditPersonImage = "person-image"
+	// Поиск контактов персоны
+	AuditContacts = "contacts"
+	// Получение (выгрузка) сводного отчёта
+	AuditReport = "report"
+	// Выгрузка лога замеров командой export
+	AuditExport = "export"
+	// Просмотр журнала аудита
+	AuditLog = "audit-log"
+	// Применение изменённого файла конфигурации
+	AuditConfigReload = "config-reload"
+)
+
+// AuditSystemUser автор действий, выполненных самим сервером (например, перечитывание конфигурации)
+const AuditSystemUser = "system"
+
+// Requester пользователь, выполняющий запрос к WEB-серверу
+type Requester struct {
+	// Имя пользователя (при отключённой авторизации - пустое)
+	User string
+	// Роль пользователя (пустая - запрос к открытому пути без авторизации)
+	Role string
+	IP   string
+	// Режим приватности: ФИО возвращаются инициалами, лица на изображениях размываются
+	Privacy bool
+}
+
+// Anonymous выполняется ли запрос к открытому пути без авторизации
+func (m Requester) Anonymous() bool {
+	return m.Role == ""
+}
+
+// IsAdmin имеет ли пользователь права администратора
+func (m Requester) IsAdmin() bool {
+	return m.Role == UserRoleAdmin
+}
+
+// ReadOnly доступен ли пользователю только просмотр
+func (m Requester) ReadOnly() bool {
+	return m.Role == UserRoleViewer
+}
+
+// Audit запись журнала аудита
+type Audit struct {
+	ID       uint
+	CreateAt time.Time
+	User     string
+	// Действие (константы Audit*)
+	Action string
+	// Объект действия: виганд персоны, идентификатор тревоги, дата отчёта и т.п. (может быть пустым)
+	Target string
+	IP     string
+	// Дополнительные сведения о действии в свободной форме
+	Details string
+}
+
+// AuditFilter отбор записей журнала аудита. Пустые поля не ограничивают выборку
+type AuditFilter struct {
+	User   string
+	Action string
+	Target string
+	// Период [From, To)
+	From time.Time
+	To   time.Time
+}
@@ -0,0 +1,38 @@
+package model
+
+import "fmt"
+
+// Режимы стирания персональных данных персоны
+const (
+	// Персона удаляется вместе со всеми замерами, тревогами и изображениями
+	ErasureDelete = "delete"
+	// Замеры и тревоги сохраняются для статистики под псевдонимом без ФИО, номера карты и изображений.
+	// Организация и подразделение сохраняются для отчётов
+	ErasurePseudonymize = "pseudonymize"
+)
+
+// ErasureModes все режимы стирания персональных данных
+var ErasureModes = []string{ErasureDelete, ErasurePseudonymize}
+
+// Erasure результат стирания персональных данных персоны
+type Erasure struct {
+	Wigand uint
+	// Режим стирания (константы Erasure*)
+	Mode string
+	// Псевдоним, под которым сохранены замеры (0 при удалении)
+	Pseudonym uint
+	// Количество удалённых или обезличенных записей лога температуры и тревог
+	Temperatures int64
+	Alarms       int64
+	// Количество удалённых файлов изображений замеров и фотографий персоны
+	Images int
+}
+
+// Summary описание результата стирания для журнала аудита
+func (m Erasure) Summary() string {
+	summary := fmt.Sprintf("режим %s, замеров %d, тревог %d, изображений %d", m.Mode, m.Temperatures, m.Alarms, m.Images)
+	if m.Pseudonym != 0 {
+		summary += fmt.Sprintf(", псевдоним %d", m.Pseudonym)
+	}
+	return summary
+}
@@ -15,6 +15,21 @@ type Person struct {
 	Organization string `conform:"trim"`
 	Department   string `conform:"trim"`
 	Position     string `conform:"trim"`
+	// Персона внесена вручную, а не получена из СУДОС
+	Manual bool
 	// Изображение из базы данных СУДОС
 	Image []byte
 }
+
+// SameData проверяет, что описание персоны (виганд и реквизиты) совпадает с other. Время записи
+// и изображение не сравниваются
+func (m Person) SameData(other Person) bool {
+	return m.Wigand.ID == other.Wigand.ID &&
+		m.Family == other.Family &&
+		m.Name == other.Name &&
+		m.MiddleName == other.MiddleName &&
+		m.Organization == other.Organization &&
+		m.Department == other.Department &&
+		m.Position == other.Position &&
+		m.Manual == other.Manual
+}
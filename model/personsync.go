@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+const (
+	PersonSyncRunning  = "running"
+	PersonSyncDone     = "done"
+	PersonSyncCanceled = "canceled"
+	PersonSyncFailed   = "failed"
+)
+
+// PersonSync описывает процесс (и историю) массовой синхронизации персон с СУДОС
+type PersonSync struct {
+	// Идентификатор записи в истории синхронизаций
+	ID       uint
+	StartAt  time.Time
+	FinishAt *time.Time
+	// Текущее состояние: PersonSyncRunning, PersonSyncDone, PersonSyncCanceled или PersonSyncFailed
+	Status string
+	// Всего персон для синхронизации
+	Total uint
+	// Обработано персон
+	Processed uint
+	// Успешно обновлено персон
+	Updated uint
+	// Персон, данные которых не удалось получить
+	Failed uint
+	// Описание ошибки, прервавшей синхронизацию
+	Error string
+}
+
+// IsRunning синхронизация ещё выполняется
+func (m PersonSync) IsRunning() bool {
+	return m.Status == PersonSyncRunning
+}
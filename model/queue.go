@@ -0,0 +1,22 @@
+package model
+
+// Политики переполнения очереди замеров
+const (
+	// Приём замера ожидает освобождения места в очереди
+	QueueOverflowBlock = "block"
+	// Из очереди вытесняется самый старый замер того же термопада
+	QueueOverflowDropOldest = "drop-oldest"
+	// Замер сохраняется на диск и возвращается в очередь по мере её освобождения
+	QueueOverflowSpill = "spill"
+)
+
+// QueueStats глубина очереди замеров одного термопада
+type QueueStats struct {
+	TermopadID uint
+	// Замеров в памяти
+	Queued uint
+	// Замеров, сохранённых на диск
+	Spilled uint
+	// Вытеснено замеров с момента запуска
+	Dropped uint
+}
@@ -0,0 +1,96 @@
+package model
+
+import "time"
+
+// Shift рабочая смена. Start и End - смещение начала и окончания смены от начала суток. Если End
+// не больше Start, смена заканчивается в следующие сутки
+type Shift struct {
+	Name  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Period границы смены, начавшейся в сутки date, в виде полуинтервала [from, to)
+func (m Shift) Period(date time.Time) (from time.Time, to time.Time) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	from = day.Add(m.Start)
+	to = day.Add(m.End)
+	if m.End <= m.Start {
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to
+}
+
+// WholeDay смена, охватывающая сутки целиком (отчёт без указания смены)
+var WholeDay = Shift{Start: 0, End: 0}
+
+// Report сводный отчёт о замерах за сутки или смену
+type Report struct {
+	// Сутки отчёта и имя смены (пустое - сутки целиком)
+	Date  time.Time
+	Shift string
+	// Границы периода отчёта [From, To)
+	From      time.Time
+	To        time.Time
+	CreatedAt time.Time
+
+	// Итоги по всем кабинам
+	ReportCounts
+	// Показатели по кабинам в порядке описания термопадов
+	Cabins []ReportCabin
+	// Показатели по организациям, упорядоченные по убыванию количества замеров
+	Organizations []ReportOrganization
+}
+
+// ReportCounts количество замеров в отчёте
+type ReportCounts struct {
+	// Всего замеров
+	Total uint
+	// Замеров с повышенной температурой
+	Fever uint
+	// Замеров по картам, не найденным в справочнике персон
+	Unknown uint
+	// Недостоверных замеров
+	Invalid uint
+}
+
+// UnknownRate доля замеров по неизвестным картам (от 0 до 1)
+func (m ReportCounts) UnknownRate() float64 {
+	if m.Total == 0 {
+		return 0
+	}
+	return float64(m.Unknown) / float64(m.Total)
+}
+
+// ReportCabin показатели кабины (термопада) за период отчёта
+type ReportCabin struct {
+	TermopadID uint
+	Name       string
+	ReportCounts
+	// Минимальная и максимальная достоверная температура за период
+	TemperatureMin float64
+	TemperatureMax float64
+	// Время недоступности термопада в пределах периода
+	Downtime time.Duration
+}
+
+// ReportOrganization показатели организации за период отчёта. Замеры неизвестных персон
+// относятся к организации с пустым именем
+type ReportOrganization struct {
+	Organization string
+	ReportCounts
+}
+
+// Add прибавляет количество замеров other
+func (m *ReportCounts) Add(other ReportCounts) {
+	m.Total += other.Total
+	m.Fever += other.Fever
+	m.Unknown += other.Unknown
+	m.Invalid += other.Invalid
+}
+
+// Форматы выгрузки отчёта
+const (
+	ReportFormatHTML = "html"
+	ReportFormatCSV  = "csv"
+)
@@ -2,20 +2,38 @@ package model
 
 import "time"
 
+// Границы правдоподобной температуры тела. Замеры вне их (в т.ч. отрицательные при измерении
+// температуры окружающей среды) сохраняются как есть, но помечаются недостоверными
+const (
+	MinBodyTemperature = 30.0
+	MaxBodyTemperature = 45.0
+)
+
+// IsInvalidReading замер temperature не может быть температурой тела
+func IsInvalidReading(temperature float64) bool {
+	return temperature < MinBodyTemperature || temperature > MaxBodyTemperature
+}
+
 // Temperature информация о температуре
 type Temperature struct {
 	TermopadID  uint `validate:"required"`
 	Wigand      Wigand
-	Temperature float64 `validate:"required"`
-	ImagePath   string  `conform:"trim"`
+	Temperature float64
+	ImagePath   string `conform:"trim"`
 	Image       []byte
+	// Замер вне границ температуры тела, см. IsInvalidReading
+	Invalid bool
 }
 
 // TemperatureInfo описывает событие о температуре
 type TemperatureEvent struct {
-	Temperature float64 `validate:"required"`
+	Temperature float64
 	Wigand      Wigand
 	Image       []byte
+	// Имя файла изображения на термопаде. Вместе с ID термопада однозначно определяет замер
+	FileName string
+	// Недостоверный замер (вне границ температуры тела)
+	Invalid bool
 }
 
 // TemperatureMetric элемент метрики температуры (для отображения в графиках)
@@ -27,4 +45,6 @@ type TemperatureMetric struct {
 	Image          string
 	Person         Person
 	Termopad       TermopadInfo
+	// Недостоверный замер (в графиках не учитывается при сжатии)
+	Invalid bool
 }
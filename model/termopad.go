@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"github.com/juju/errors"
 	"regexp"
 	"strconv"
@@ -16,6 +17,13 @@ type TermopadInfo struct {
 	Name         string `conform:"trim" validate:"required"`
 	SerialNumber uint
 	Description  string `conform:"trim"`
+	// Переопределение порогов нормальной температуры для этого термопада (0 - используются общие)
+	MaxTemperature float64
+	MinTemperature float64
+	// Формат карт Wiegand (пустой - формат объекта по умолчанию) и признак передачи номера карты
+	// полным кадром с битами чётности
+	CardFormat string
+	CardFrame  bool
 }
 
 // TermopadAction событие в WebSocket канале термопада
@@ -62,7 +70,7 @@ func (m *TermopadFileName) Parse(fileName string) error {
 	*m = TermopadFileName{}
 	var err error
 
-	re := regexp.MustCompile(`(\d+-\d+-\d+--\d+-\d+-\d+)--([\w\d]+)--(-?)(\d+\.\d+)\.jpg`)
+	re := regexp.MustCompile(`(\d+-\d+-\d+--\d+-\d+-\d+)--([\w\d]+)--(-?\d+\.\d+)\.jpg`)
 	match := re.FindStringSubmatch(fileName)
 	if len(match) == 0 {
 		return errors.Errorf("формат имени файла \"%s\" не распознан", fileName)
@@ -73,14 +81,10 @@ func (m *TermopadFileName) Parse(fileName string) error {
 		return errors.Errorf("некорректный формат записи времени \"%s\" в имени файла: %s", match[1], fileName)
 	}
 
-	if match[3] != "" { // Минусовое значение температры
-		m.Temperature = 0.0
-	} else {
-		temp, err := strconv.ParseFloat(match[4], 32)
-		if err != nil {
-			return errors.Errorf("в имени файла \"%s\" не удалось расознать температуту \"%s\"", fileName, match[3])
-		}
-		m.Temperature = temp
+	// Температура сохраняется со знаком: термопады используются и для замера температуры окружающей среды
+	m.Temperature, err = strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		return errors.Errorf("в имени файла \"%s\" не удалось расознать температуту \"%s\"", fileName, match[3])
 	}
 
 	number, err := strconv.Atoi(match[2])
@@ -101,3 +105,12 @@ type TermopadTemperatureEvent struct {
 	Info        TermopadInfo
 	Temperature TemperatureEvent
 }
+
+// IngestionKey ключ приёма замера (ID термопада и имя файла на термопаде), по которому отсекаются
+// повторно полученные замеры. Если имя файла неизвестно, возвращается пустая строка
+func (m TermopadTemperatureEvent) IngestionKey() string {
+	if m.Temperature.FileName == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s", m.Info.ID, m.Temperature.FileName)
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestTermopadFileName_Parse(t *testing.T) {
+	tests := []struct {
+		name            string
+		fileName        string
+		wantTemperature float64
+		wantWigand      uint
+		wantInvalid     bool
+		wantErr         bool
+	}{
+		{
+			name:            "температура тела",
+			fileName:        "27-11-2020--12-37-54--123456--36.6.jpg",
+			wantTemperature: 36.6,
+			wantWigand:      123456,
+		},
+		{
+			name:            "отрицательная температура сохраняется со знаком",
+			fileName:        "27-11-2020--12-37-54--Unknown---12.3.jpg",
+			wantTemperature: -12.3,
+			wantInvalid:     true,
+		},
+		{
+			name:            "нулевая температура",
+			fileName:        "27-11-2020--12-37-54--Unknown--0.0.jpg",
+			wantTemperature: 0,
+			wantInvalid:     true,
+		},
+		{
+			name:            "точность без потерь float32",
+			fileName:        "27-11-2020--12-37-54--Unknown--36.7.jpg",
+			wantTemperature: 36.7,
+		},
+		{
+			name:     "нераспознаваемое имя",
+			fileName: "image.jpg",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got TermopadFileName
+			err := got.Parse(tt.fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Temperature != tt.wantTemperature {
+				t.Errorf("Parse() Temperature = %v, want %v", got.Temperature, tt.wantTemperature)
+			}
+			if got.Wigand.ID != tt.wantWigand {
+				t.Errorf("Parse() Wigand = %v, want %v", got.Wigand.ID, tt.wantWigand)
+			}
+			if invalid := IsInvalidReading(got.Temperature); invalid != tt.wantInvalid {
+				t.Errorf("IsInvalidReading(%v) = %v, want %v", got.Temperature, invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package model
+
+import "github.com/juju/errors"
+
+// Thresholds пороги нормальной температуры
+type Thresholds struct {
+	MaxTemperature float64 `validate:"required"`
+	MinTemperature float64 `validate:"required"`
+}
+
+// Validate проверка корректности порогов
+func (m Thresholds) Validate() error {
+	if m.MaxTemperature <= 0 || m.MinTemperature <= 0 {
+		return errors.New("пороги температуры должны быть больше нуля")
+	}
+	if m.MinTemperature >= m.MaxTemperature {
+		return errors.Errorf("минимальная температура %0.1f должна быть меньше максимальной %0.1f",
+			m.MinTemperature, m.MaxTemperature)
+	}
+	return nil
+}
+
+// IsAlarm температура temperature превышает норму
+func (m Thresholds) IsAlarm(temperature float64) bool {
+	return temperature >= m.MaxTemperature
+}
+
+// IsLower температура temperature ниже нормы
+func (m Thresholds) IsLower(temperature float64) bool {
+	return temperature < m.MinTemperature
+}
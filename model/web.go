@@ -7,9 +7,11 @@ import (
 // TemperatureChange событие замера термпературы у новой персоны
 type TemperatureChange struct {
 	// ID терминала
-	ID           uint
-	CreateAt     time.Time
-	Temperature  float64
+	ID          uint
+	CreateAt    time.Time
+	Temperature float64
+	// Температура превышает норму
+	Alarm        bool
 	Image        string
 	Wigand       Wigand
 	NameFirst    string
@@ -18,6 +20,8 @@ type TemperatureChange struct {
 	Organization string
 	Departament  string
 	Postion      string
+	// Замер не является температурой тела (тревога не выставляется)
+	Invalid bool
 }
 
 // TemperatureUpdate событие обновления данных о зафиксированной TemperatureChange температуры
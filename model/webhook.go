@@ -0,0 +1,68 @@
+package model
+
+import "time"
+
+// Типы событий, рассылаемых внешним системам через вебхуки
+const (
+	// Принят замер температуры
+	WebhookEventMeasurement = "measurement"
+	// Повышенная температура
+	WebhookEventAlarm = "alarm"
+	// Потеряна связь с термопадом
+	WebhookEventTermopadDown = "termopad-down"
+	// Добавлены или изменены данные персоны
+	WebhookEventPersonUpdated = "person-updated"
+)
+
+// WebhookEvents все типы событий вебхуков
+var WebhookEvents = []string{
+	WebhookEventMeasurement,
+	WebhookEventAlarm,
+	WebhookEventTermopadDown,
+	WebhookEventPersonUpdated,
+}
+
+// Результаты попытки доставки события вебхука
+const (
+	// Событие доставлено
+	WebhookDelivered = "delivered"
+	// Попытка неудачна, будет повтор
+	WebhookFailed = "failed"
+	// Попытки исчерпаны или ошибка неустранима, событие перенесено в недоставленные
+	WebhookDead = "dead"
+)
+
+// WebhookDelivery запись журнала доставки: одна попытка отправки события вебхуку
+type WebhookDelivery struct {
+	ID       uint
+	CreateAt time.Time
+	// Имя вебхука из конфигурации
+	Webhook string
+	EventID string
+	Event   string
+	// Номер попытки, начиная с 1
+	Attempt uint
+	// Результат: WebhookDelivered, WebhookFailed или WebhookDead
+	Status string
+	// HTTP-код ответа (0, если ответ не получен)
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
+
+// WebhookDeadLetter событие, которое не удалось доставить вебхуку. Хранится вместе с телом запроса
+// для разбора и ручной повторной отправки
+type WebhookDeadLetter struct {
+	ID       uint
+	CreateAt time.Time
+	Webhook  string
+	URL      string
+	EventID  string
+	Event    string
+	// Тело запроса (JSON)
+	Payload string
+	// Сделано попыток доставки
+	Attempts uint
+	// Причина последней неудачи
+	Error string
+}
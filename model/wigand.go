@@ -2,13 +2,16 @@ package model
 
 import (
 	"fmt"
-	"strconv"
+
+	"github.com/kirsrus/termopad-server/pkg/wiegand"
 )
 
 // Wigand описание карты виганд. Создаётся через конструктор NewWigand
 type Wigand struct {
-	// Общий номер карты виганд (собранный из фасалити и номера)
+	// Общий номер карты виганд (собранный из фасалити и номера, без битов чётности)
 	ID uint `validate:"required"`
+	// Формат карты из реестра wiegand (пустой - формат по умолчанию)
+	Format string `json:",omitempty"`
 }
 
 // NewWigand конструктор структуры Wigand.
@@ -16,14 +19,18 @@ func NewWigand(ID int) Wigand {
 	return Wigand{ID: uint(ID)}
 }
 
-// Парсинго номера вигадна на фасалити и номер
+// CardFormat формат карты из реестра wiegand
+func (m Wigand) CardFormat() wiegand.Format {
+	return wiegand.Get(m.Format)
+}
+
+// Парсинго номера вигадна на фасалити и номер согласно формату карты
 func (m Wigand) parse() (uint, uint) {
 	if m.ID == 0 {
 		return 0, 0
 	}
-	fasality := m.ID >> 16
-	num := m.ID>>16<<16 ^ m.ID
-	return fasality, num
+	fasality, num := m.CardFormat().Split(uint64(m.ID))
+	return uint(fasality), uint(num)
 }
 
 // Fasality фасалити виганда
@@ -38,14 +45,18 @@ func (m Wigand) Number() uint {
 	return number
 }
 
-// Parse парсит в общий номер (ID) из фасалити и номера
-func (m *Wigand) Parse(fasality uint, number uint) {
+// Parse собирает общий номер (ID) из фасалити и номера согласно формату карты
+func (m *Wigand) Parse(fasality uint, number uint) error {
 	if fasality == 0 && number == 0 {
 		m.ID = 0
-		return
+		return nil
 	}
-	s, _ := strconv.ParseUint(fmt.Sprintf("%b%016b", fasality, number), 2, 32)
-	m.ID = uint(s)
+	id, err := m.CardFormat().Join(uint64(fasality), uint64(number))
+	if err != nil {
+		return err
+	}
+	m.ID = uint(id)
+	return nil
 }
 
 // IsEmpty данные виганда не заполнены
@@ -53,10 +64,15 @@ func (m Wigand) IsEmpty() bool {
 	return m.ID == 0
 }
 
+// Display номер карты для отображения в формате карты
+func (m Wigand) Display() string {
+	return m.CardFormat().Display(uint64(m.ID))
+}
+
 // String краткое описание
 func (m Wigand) String() string {
 	if m.ID == 0 {
 		return "0-0 (0)"
 	}
-	return fmt.Sprintf("%d-%d (%d)", m.Fasality(), m.Number(), m.ID)
+	return fmt.Sprintf("%s (%d)", m.Display(), m.ID)
 }
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// WorkerStatus состояние фонового обработчика под наблюдением супервизора
+type WorkerStatus struct {
+	Name string
+	// Обработчик работает (false - ожидает перезапуска после сбоя)
+	Running bool
+	// Время последнего запуска
+	StartedAt time.Time
+	// Количество аварийных завершений (ошибок и паник)
+	Crashes uint
+	// Последняя ошибка и время её возникновения
+	LastError   string
+	LastErrorAt *time.Time
+}
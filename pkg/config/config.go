@@ -1,38 +1,88 @@
 package config
 
 import (
-	"log"
 	"os"
-	"sync"
+	"strings"
 	"time"
 
-	"github.com/jinzhu/configor"
-)
+	"github.com/kirsrus/termopad-server/model"
 
-var (
-	config Config
-	once   sync.Once
+	"github.com/jinzhu/configor"
+	"github.com/juju/errors"
 )
 
 const FileName = "config.yaml"
 
-// Get единажды читает и возвращает конфигурацию
-func Get() *Config {
-	return GetWithPath(FileName)
+// EnvPrefix префикс переменных окружения, переопределяющих значения конфигурации. Имя переменной
+// составляется из пути к параметру, например TERMOPAD_SUDOS_ADDRESS или TERMOPAD_TERMOPAD_INFO_0_ADDRESS
+const EnvPrefix = "TERMOPAD"
+
+// Load читает конфигурацию из файла filepath с учётом переменных окружения EnvPrefix (без проверки
+// значений через Validate). Если файла нет, конфигурация формируется из значений по умолчанию и
+// переменных окружения
+func Load(filepath string) (*Config, error) {
+	cfg, err := load(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Check читает конфигурацию из файла filepath и проверяет её, возвращая ValidationError со всеми
+// найденными проблемами
+func Check(filepath string) (*Config, error) {
+	cfg, err := load(filepath)
+	if err != nil && !isRequiredError(err) {
+		return nil, ValidationError{err.Error()}
+	}
+	// Незаполненные обязательные поля (в т.ч. вызвавшее ошибку чтения) выявит Validate
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Чтение конфигурации. При ошибке незаполненного обязательного поля возвращает и конфигурацию,
+// прочитанную до момента ошибки
+func load(filepath string) (*Config, error) {
+	files := make([]string, 0, 1)
+	if _, err := os.Stat(filepath); err == nil {
+		files = append(files, filepath)
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Errorf("файл конфигурации недоступен: %s", err)
+	}
+
+	var cfg Config
+	err := configor.New(&configor.Config{ENVPrefix: EnvPrefix, Silent: true}).Load(&cfg, files...)
+	// Корректировки значений
+	cfg.Recognize.TimeOut = cfg.Recognize.TimeOut * time.Millisecond
+	if err != nil {
+		return &cfg, errors.Errorf("ошибка чтения файла конфигурации %s: %s", filepath, err)
+	}
+	return &cfg, nil
+}
+
+// Ошибка configor о незаполненном обязательном поле
+func isRequiredError(err error) bool {
+	return strings.HasSuffix(err.Error(), "is required, but blank")
 }
 
-// GetWithPath единожды читает и возвращает конфигурацию
-func GetWithPath(filepath string) *Config {
-	once.Do(func() {
-		if _, err := os.Stat(filepath); err != nil {
-			log.Fatalf("файл конфигурации недоступен: %s", err)
-		}
-		err := configor.Load(&config, filepath)
-		if err != nil {
-			log.Fatalf("ошибка чтения файла конфигурации %s: %s", filepath, err)
-		}
-		// Корректировки значений
-		config.Recognize.TimeOut = config.Recognize.TimeOut * time.Millisecond
-	})
-	return &config
+// TermopadsInfo описания термопадов из конфигурации
+func (m *Config) TermopadsInfo() []model.TermopadInfo {
+	result := make([]model.TermopadInfo, 0, len(m.Termopad.Info))
+	for _, i := range m.Termopad.Info {
+		result = append(result, model.TermopadInfo{
+			ID:             i.ID,
+			URL:            i.Address,
+			SudosID:        i.Cabina,
+			Name:           i.Name,
+			SerialNumber:   0,
+			Description:    i.Description,
+			MaxTemperature: i.MaxTemperature,
+			MinTemperature: i.MinTemperature,
+			CardFormat:     i.CardFormat,
+			CardFrame:      i.CardFrame,
+		})
+	}
+	return result
 }
@@ -40,6 +40,9 @@ type (
 
 			// Период очистки архива до ArchiveDays в минутах
 			CleanArchiveInterval int `default:"30"`
+
+			// Период сверки изображений замеров с логом температуры в минутах (0 - сверка отключена)
+			ReconcileInterval int `default:"1440"`
 		}
 
 		// Описание места хранения изображений с термопада
@@ -47,6 +50,15 @@ type (
 
 			// Путь к корневой директории с изображениями
 			Path string `default:"./imagedb/temperature"`
+
+			// Шифрование изображений замеров и фотографий персон (AES-256-GCM). Ключ длиной 32 байта в hex или
+			// base64 задаётся файлом или переменной окружения. Без ключа изображения хранятся открыто
+			Encryption struct {
+				// Файл с ключом шифрования
+				KeyFile string
+				// Имя переменной окружения с ключом шифрования
+				KeyEnv string
+			}
 		}
 
 		// Описание термопатодов
@@ -58,12 +70,20 @@ type (
 			// Таймаут потокогого опроса термопада (когда ожидаем изменения данных)
 			Timeout uint `default:"1"`
 
-			// Максималная нормальная температура
+			// Максималная нормальная температура. Используется как начальное значение, после
+			// первого запуска пороги хранятся в БД и изменяются через WEB-интерфейс
 			MaxTemperature float64 `required:"true"`
 
-			// Минимальная нормальная температура
+			// Минимальная нормальная температура (начальное значение, как и MaxTemperature)
 			MinTemperature float64 `required:"true"`
 
+			// Время, в течение которого повторно полученные от термопада замеры отбрасываются
+			// без обращения к БД (в минутах). Более старые повторы отсекаются по логу температур
+			DedupeWindow int `default:"10"`
+
+			// Формат карт Wiegand на объекте: H10301, W34, H10304 или CSN32
+			CardFormat string `default:"H10301"`
+
 			// Адреса термопадов
 			Info []struct {
 
@@ -81,6 +101,18 @@ type (
 
 				// Описание термопада
 				Description string
+
+				// Максимальная нормальная температура для этого термопада (0 - используется общая)
+				MaxTemperature float64
+
+				// Минимальная нормальная температура для этого термопада (0 - используется общая)
+				MinTemperature float64
+
+				// Формат карт Wiegand этого термопада (пустой - используется общий)
+				CardFormat string
+
+				// Термопад передаёт номер карты полным кадром Wiegand с битами чётности
+				CardFrame bool
 			}
 		}
 
@@ -100,26 +132,237 @@ type (
 			// чем указано здесь - в конце их выведутся заглушки
 			TermopadsOnPage int `default:"0"`
 
-			// Максималная нормальная температура
-			MaxTemperature float64 `required:"true"`
+			// Максимальный размер загружаемой фотографии персоны в килобайтах
+			MaxImageSize int `default:"2048"`
 
-			// Минимальная нормальная температура
-			MinTemperature float64 `required:"true"`
+			// Адреса или сети (CIDR) обратных прокси перед сервером. Адрес клиента из заголовков
+			// X-Forwarded-For и X-Real-IP берётся только для запросов от них, иначе - адрес соединения
+			TrustedProxies []string
+
+			// Пользователи WEB-интерфейса (HTTP Basic). Пустой список отключает авторизацию: все запросы
+			// выполняются с правами администратора
+			Users []struct {
+				Name string `required:"true"`
+
+				// Хеш пароля bcrypt (формируется командой hash-password)
+				Password string `required:"true"`
+
+				// Роль: admin, operator (пустая - operator) или viewer. Журнал аудита доступен только admin,
+				// viewer только просматривает табло в режиме приватности
+				Role string
+			}
+
+			// Режим приватности табло: ФИО возвращаются инициалами, лица на изображениях замеров и фотографиях
+			// персон размываются. Включается для пользователей с ролью viewer, для клиентов из Clients и по
+			// запросу клиента (параметр запроса privacy=1 или заголовок X-Privacy: 1)
+			PrivacyMode struct {
+				// Адреса или сети (CIDR) клиентов, например табло в холле: 192.168.10.0/24
+				Clients []string
+
+				// Размытие изображений: frame - всего кадра, face - только области лица (если лицо не найдено -
+				// всего кадра). Детектор лица простой и может ошибиться, поэтому face включается только явно
+				Blur string `default:"frame"`
+			}
 		}
 
 		// Описание СУДОС
 		Sudos struct {
 			// Адрес WebSocket канала, например 127.0.0.1:8000/sudos
-			Address string `requires:"true"`
+			Address string `required:"true"`
 
 			// Путь к папке и изображениями персон
 			Path string `default:"./imagedb/persons"`
+
+			// Период плановой синхронизации справочника персон с СУДОС (в минутах, 0 - отключена)
+			SyncInterval int `default:"1440"`
+
+			// Минимальный интервал между запросами в СУДОС при синхронизации (в миллисекундах)
+			SyncRequestInterval int `default:"500"`
+
+			// Шаблоны (text/template) сообщений в СУДОС о нормальной, повышенной и пониженной температуре.
+			// Пустой шаблон заменяется встроенным
+			TemplateNormal string
+			TemplateAlarm  string
+			TemplateLower  string
+		}
+
+		// Очередь принятых замеров
+		Queue struct {
+			// Количество обработчиков замеров
+			Workers int `default:"4"`
+
+			// Ёмкость очереди в памяти для каждого термопада
+			Capacity int `default:"100"`
+
+			// Поведение при переполнении: block - приём ожидает освобождения места,
+			// drop-oldest - вытесняется самый старый замер, spill - замер сохраняется на диск
+			Overflow string `default:"block"`
+
+			// Директория для замеров, сохранённых при переполнении (политика spill)
+			SpillDir string `default:"./spool"`
+		}
+
+		// Сводные отчёты о замерах
+		Report struct {
+			// Расписание формирования отчётов за прошедшие сутки и завершившиеся смены в формате cron
+			// (минуты, часы, день месяца, месяц, день недели)
+			Schedule string `default:"0 7 * * *"`
+
+			// Директория выгрузки сформированных отчётов в HTML и CSV (пустая - отчёты хранятся только в БД)
+			Path string `default:"./reports"`
+
+			// Рабочие смены. Время начала и окончания в формате ЧЧ:ММ; смена, окончание которой не позже
+			// начала, заканчивается на следующие сутки
+			Shifts []struct {
+				Name  string `required:"true"`
+				Start string `required:"true"`
+				End   string `required:"true"`
+			}
+		}
+
+		// Оповещение ответственных лиц о повышенной температуре
+		Notify struct {
+			// Время, в течение которого повторные тревоги по той же персоне не рассылаются (в минутах)
+			Throttle int `default:"30"`
+
+			// Почтовый сервер. Если адрес не указан, оповещения по почте не отправляются
+			Smtp struct {
+				Host string
+				Port uint `default:"25"`
+
+				// Учётные данные (пустое имя - без авторизации)
+				Username string
+				Password string
+
+				// Адрес отправителя, например "Termopad <termopad@example.com>"
+				From string
+
+				// Шифрование: none, starttls или tls (подключение сразу по TLS, обычно порт 465)
+				TLS string `default:"starttls"`
+
+				// Не проверять сертификат сервера
+				InsecureSkipVerify bool
+
+				// Шаблоны темы (text/template) и содержимого (html/template) письма. Пустой шаблон
+				// заменяется встроенным
+				Subject  string
+				Template string
+			}
+
+			// Бот Telegram. Если токен не указан, оповещения в чаты не отправляются
+			Telegram struct {
+				Token string
+
+				// Адрес Bot API
+				APIURL string `default:"https://api.telegram.org"`
+
+				// Время ожидания нажатий кнопок одним запросом (в секундах)
+				PollTimeout int `default:"30"`
+			}
+
+			// Получатели по организациям и подразделениям. Пустая организация или подразделение
+			// подходят для любых персон
+			Recipients []struct {
+				Organization string
+				Department   string
+				Emails       []string
+				// Идентификаторы чатов Telegram
+				Chats []int64
+			}
+		}
+
+		// Рассылка событий внешним системам HTTP-запросами POST
+		Webhook struct {
+			// Повторных попыток доставки после первой неудачной
+			Retries int `default:"5"`
+
+			// Задержка перед первой повторной попыткой (в секундах). Каждая следующая вдвое дольше,
+			// но не больше MaxBackoff
+			Backoff int `default:"1"`
+
+			// Наибольшая задержка между попытками (в секундах)
+			MaxBackoff int `default:"300"`
+
+			// Ограничение времени одного запроса (в секундах)
+			Timeout int `default:"10"`
+
+			// Вебхуки
+			Hooks []struct {
+				// Имя вебхука в журнале доставки
+				Name string `required:"true"`
+
+				// Адрес HTTP(S), на который отправляются события
+				URL string `required:"true"`
+
+				// Ключ подписи запросов HMAC-SHA256 (пустой - запросы не подписываются)
+				Secret string
+
+				// Типы событий: measurement, alarm, termopad-down, person-updated (пустой список - все)
+				Events []string
+			}
+		}
+
+		// Публикация замеров и состояния термопадов в брокер MQTT
+		Mqtt struct {
+			// Адрес брокера: mqtt://host:port или mqtts://host:port для подключения по TLS (также
+			// tcp:// и ssl://). Пустой адрес отключает публикацию
+			Address string
+
+			ClientID string `default:"termopad-server"`
+
+			// Учётные данные (пустое имя - без авторизации)
+			Username string
+			Password string
+
+			// Сертификат удостоверяющего центра брокера в формате PEM (пустой - системные сертификаты)
+			CAFile string
+
+			// Не проверять сертификат брокера
+			InsecureSkipVerify bool
+
+			// Уровень гарантии доставки: 0 или 1
+			QoS int `default:"1"`
+
+			// Период проверки связи с брокером (в секундах)
+			KeepAlive int `default:"30"`
+
+			// Пауза перед повторным подключением (в секундах)
+			Reconnect int `default:"10"`
+
+			// Топики замеров и состояния термопадов, {id} заменяется идентификатором термопада
+			TopicMeasurement string `default:"termopad/{id}/measurement"`
+			TopicStatus      string `default:"termopad/{id}/status"`
+
+			// Топик состояния сервера: online или offline
+			TopicServer string `default:"termopad/server/status"`
+
+			// Топик команд управления (пустой - команды не принимаются). Ответы публикуются в
+			// <топик>/reply
+			TopicControl string
+		}
+
+		// Завершение работы
+		Shutdown struct {
+			// Максимальное время завершения работы (в секундах): обработка принятых замеров,
+			// отправка сообщений в СУДОС и остановка WEB-сервера
+			Timeout int `default:"10"`
+		}
+
+		// Хранение персональных данных
+		Privacy struct {
+			// Срок хранения персон, не проходивших замеров (в днях, 0 - бессрочно). По истечении срока
+			// персона стирается так же, как мутацией erasePerson
+			PersonRetentionDays int `default:"0"`
+
+			// Режим стирания: delete - персона удаляется вместе с замерами и тревогами, pseudonymize - замеры
+			// и тревоги сохраняются для статистики под псевдонимом без ФИО, номера карты и изображений
+			Erasure string `default:"delete"`
 		}
 
 		// Распознавание лица
 		Recognize struct {
 			// URL сервера распознавания
-			URL string `requires:"true"`
+			URL string `required:"true"`
 			// Таймаут ожидания ответа (в милисекундах)
 			TimeOut time.Duration `default:"1000"`
 		}
@@ -0,0 +1,423 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/cron"
+	"github.com/kirsrus/termopad-server/pkg/faceblur"
+	"github.com/kirsrus/termopad-server/pkg/imagecrypt"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	"github.com/kirsrus/termopad-server/pkg/wiegand"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Допустимое имя смены (используется в именах файлов отчётов)
+var shiftName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidationError список всех найденных в конфигурации проблем
+type ValidationError []string
+
+// Error описание всех проблем одной строкой
+func (m ValidationError) Error() string {
+	return "некорректная конфигурация: " + strings.Join(m, "; ")
+}
+
+// Validate проверяет значения конфигурации и возвращает ValidationError со всеми найденными проблемами
+func Validate(cfg *Config) error {
+	problems := make(ValidationError, 0)
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, path := range blankRequired(reflect.ValueOf(*cfg), "") {
+		add("%s: обязательное значение не задано (переменная окружения %s)", path, envName(path))
+	}
+
+	if _, err := logrus.ParseLevel(cfg.Log.Level); err != nil {
+		add("log.level: неизвестный уровень логирования \"%s\"", cfg.Log.Level)
+	}
+
+	if cfg.Db.ReconcileInterval < 0 {
+		add("db.reconcileinterval: период сверки не может быть отрицательным")
+	}
+
+	if _, err := imagecrypt.LoadKey(cfg.Images.Encryption.KeyFile, cfg.Images.Encryption.KeyEnv); err != nil {
+		add("images.encryption: %s", err)
+	}
+
+	if cfg.Termopad.MaxTemperature != 0 && cfg.Termopad.MinTemperature != 0 &&
+		cfg.Termopad.MinTemperature >= cfg.Termopad.MaxTemperature {
+		add("termopad: минимальная температура %0.1f должна быть меньше максимальной %0.1f",
+			cfg.Termopad.MinTemperature, cfg.Termopad.MaxTemperature)
+	}
+	if cfg.Termopad.DedupeWindow < 0 {
+		add("termopad.dedupewindow: время отсечения повторов не может быть отрицательным")
+	}
+	if _, err := wiegand.Lookup(cfg.Termopad.CardFormat); err != nil {
+		add("termopad.cardformat: %s (%s)", err, strings.Join(wiegand.Names(), ", "))
+	}
+	ids := make(map[uint]bool)
+	cabins := make(map[uint]bool)
+	for idx, v := range cfg.Termopad.Info {
+		if v.ID != 0 && ids[v.ID] {
+			add("termopad.info[%d]: повторяющийся id %d", idx, v.ID)
+		}
+		ids[v.ID] = true
+		if cabins[v.Cabina] {
+			add("termopad.info[%d]: повторяющаяся кабина %d", idx, v.Cabina)
+		}
+		cabins[v.Cabina] = true
+		if v.Address != "" && !isWebsocketURL(v.Address) {
+			add("termopad.info[%d]: некорректный адрес WebSocket \"%s\"", idx, v.Address)
+		}
+		if v.CardFormat != "" {
+			if _, err := wiegand.Lookup(v.CardFormat); err != nil {
+				add("termopad.info[%d].cardformat: %s (%s)", idx, err, strings.Join(wiegand.Names(), ", "))
+			}
+		}
+		if v.MaxTemperature != 0 && v.MinTemperature != 0 && v.MinTemperature >= v.MaxTemperature {
+			add("termopad.info[%d]: минимальная температура %0.1f должна быть меньше максимальной %0.1f",
+				idx, v.MinTemperature, v.MaxTemperature)
+		}
+	}
+
+	if cfg.Http.TermopadsOnPage < 0 {
+		add("http.termopadsonpage: количество термопадов на странице не может быть отрицательным")
+	}
+	if cfg.Http.MaxImageSize <= 0 {
+		add("http.maximagesize: размер фотографии должен быть положительным")
+	}
+	for idx, v := range cfg.Http.TrustedProxies {
+		if _, err := tool.ParseNetwork(v); err != nil {
+			add("http.trustedproxies[%d]: некорректный адрес или сеть \"%s\"", idx, v)
+		}
+	}
+	users := make(map[string]bool)
+	for idx, v := range cfg.Http.Users {
+		if v.Name != "" && users[strings.ToLower(v.Name)] {
+			add("http.users[%d]: повторяющееся имя пользователя \"%s\"", idx, v.Name)
+		}
+		users[strings.ToLower(v.Name)] = true
+		if strings.Contains(v.Name, ":") {
+			add("http.users[%d].name: имя пользователя не может содержать \":\"", idx)
+		}
+		if v.Password != "" {
+			if _, err := bcrypt.Cost([]byte(v.Password)); err != nil {
+				add("http.users[%d].password: ожидается хеш bcrypt (команда hash-password)", idx)
+			}
+		}
+		if v.Role != "" && !contains(model.UserRoles, v.Role) {
+			add("http.users[%d].role: неизвестная роль \"%s\" (%s)", idx, v.Role, strings.Join(model.UserRoles, ", "))
+		}
+	}
+	for idx, v := range cfg.Http.PrivacyMode.Clients {
+		if _, err := tool.ParseNetwork(v); err != nil {
+			add("http.privacymode.clients[%d]: некорректный адрес или сеть \"%s\"", idx, v)
+		}
+	}
+	if !contains(faceblur.Modes, cfg.Http.PrivacyMode.Blur) {
+		add("http.privacymode.blur: неизвестный режим размытия \"%s\" (%s)", cfg.Http.PrivacyMode.Blur,
+			strings.Join(faceblur.Modes, ", "))
+	}
+
+	if cfg.Sudos.Address != "" && !isWebsocketURL(cfg.Sudos.Address) {
+		add("sudos.address: некорректный адрес WebSocket \"%s\"", cfg.Sudos.Address)
+	}
+
+	if cfg.Queue.Workers <= 0 {
+		add("queue.workers: количество обработчиков должно быть положительным")
+	}
+	if cfg.Queue.Capacity <= 0 {
+		add("queue.capacity: ёмкость очереди должна быть положительной")
+	}
+	switch cfg.Queue.Overflow {
+	case model.QueueOverflowBlock, model.QueueOverflowDropOldest, model.QueueOverflowSpill:
+	default:
+		add("queue.overflow: неизвестная политика переполнения \"%s\" (block, drop-oldest, spill)", cfg.Queue.Overflow)
+	}
+
+	if _, err := cron.Parse(cfg.Report.Schedule); err != nil {
+		add("report.schedule: %s", err)
+	}
+	shifts := make(map[string]bool)
+	for idx, v := range cfg.Report.Shifts {
+		if v.Name != "" && !shiftName.MatchString(v.Name) {
+			add("report.shifts[%d].name: имя смены \"%s\" может содержать только латинские буквы, цифры, \"-\" и \"_\"", idx, v.Name)
+		}
+		if shifts[v.Name] {
+			add("report.shifts[%d]: повторяющееся имя смены \"%s\"", idx, v.Name)
+		}
+		shifts[v.Name] = true
+		for _, clock := range []struct {
+			name  string
+			value string
+		}{{"start", v.Start}, {"end", v.End}} {
+			if _, err := tool.ParseClock(clock.value); clock.value != "" && err != nil {
+				add("report.shifts[%d].%s: %s", idx, clock.name, err)
+			}
+		}
+	}
+
+	if cfg.Notify.Throttle < 0 {
+		add("notify.throttle: время отсечения повторных тревог не может быть отрицательным")
+	}
+	switch cfg.Notify.Smtp.TLS {
+	case model.SMTPTLSNone, model.SMTPTLSStartTLS, model.SMTPTLSImplicit:
+	default:
+		add("notify.smtp.tls: неизвестный режим шифрования \"%s\" (%s, %s, %s)", cfg.Notify.Smtp.TLS,
+			model.SMTPTLSNone, model.SMTPTLSStartTLS, model.SMTPTLSImplicit)
+	}
+	if cfg.Notify.Smtp.Host != "" {
+		if _, err := mail.ParseAddress(cfg.Notify.Smtp.From); err != nil {
+			add("notify.smtp.from: некорректный адрес отправителя \"%s\"", cfg.Notify.Smtp.From)
+		}
+	}
+	chats := false
+	for idx, v := range cfg.Notify.Recipients {
+		if len(v.Emails) == 0 && len(v.Chats) == 0 {
+			add("notify.recipients[%d]: не указаны ни адреса, ни чаты", idx)
+		}
+		chats = chats || len(v.Chats) != 0
+		for _, email := range v.Emails {
+			if _, err := mail.ParseAddress(email); err != nil {
+				add("notify.recipients[%d].emails: некорректный адрес \"%s\"", idx, email)
+			}
+		}
+	}
+
+	if chats && cfg.Notify.Telegram.Token == "" {
+		add("notify.telegram.token: не указан токен бота для оповещения в чаты")
+	}
+	if cfg.Notify.Telegram.Token != "" {
+		if !isHTTPURL(cfg.Notify.Telegram.APIURL) {
+			add("notify.telegram.apiurl: некорректный адрес HTTP \"%s\"", cfg.Notify.Telegram.APIURL)
+		}
+		if cfg.Notify.Telegram.PollTimeout <= 0 {
+			add("notify.telegram.polltimeout: время ожидания должно быть положительным")
+		}
+	}
+
+	if cfg.Webhook.Retries < 0 {
+		add("webhook.retries: количество повторных попыток не может быть отрицательным")
+	}
+	if cfg.Webhook.Backoff <= 0 || cfg.Webhook.MaxBackoff < cfg.Webhook.Backoff {
+		add("webhook: задержка между попытками должна быть положительной и не больше maxbackoff")
+	}
+	if cfg.Webhook.Timeout <= 0 {
+		add("webhook.timeout: время ожидания ответа должно быть положительным")
+	}
+	hooks := make(map[string]bool)
+	for idx, v := range cfg.Webhook.Hooks {
+		if v.Name != "" && hooks[v.Name] {
+			add("webhook.hooks[%d]: повторяющееся имя вебхука \"%s\"", idx, v.Name)
+		}
+		hooks[v.Name] = true
+		if v.URL != "" && !isHTTPURL(v.URL) {
+			add("webhook.hooks[%d].url: некорректный адрес HTTP \"%s\"", idx, v.URL)
+		}
+		for _, event := range v.Events {
+			if !contains(model.WebhookEvents, event) {
+				add("webhook.hooks[%d].events: неизвестное событие \"%s\" (%s)", idx, event, strings.Join(model.WebhookEvents, ", "))
+			}
+		}
+	}
+
+	if cfg.Mqtt.Address != "" {
+		if !isMQTTURL(cfg.Mqtt.Address) {
+			add("mqtt.address: некорректный адрес брокера \"%s\" (mqtt://, mqtts://, tcp:// или ssl://)", cfg.Mqtt.Address)
+		}
+		if cfg.Mqtt.CAFile != "" {
+			if _, err := ioutil.ReadFile(cfg.Mqtt.CAFile); err != nil {
+				add("mqtt.cafile: сертификат недоступен для чтения: %v", err)
+			}
+		}
+	}
+	if cfg.Mqtt.QoS != 0 && cfg.Mqtt.QoS != 1 {
+		add("mqtt.qos: поддерживается уровень гарантии доставки 0 или 1, задан %d", cfg.Mqtt.QoS)
+	}
+	if cfg.Mqtt.KeepAlive <= 0 || cfg.Mqtt.Reconnect <= 0 {
+		add("mqtt: период проверки связи и пауза перед переподключением должны быть положительными")
+	}
+	topics := []struct {
+		name     string
+		topic    string
+		withID   bool
+		optional bool
+	}{
+		{"topicmeasurement", cfg.Mqtt.TopicMeasurement, true, false},
+		{"topicstatus", cfg.Mqtt.TopicStatus, true, false},
+		{"topicserver", cfg.Mqtt.TopicServer, false, false},
+		{"topiccontrol", cfg.Mqtt.TopicControl, false, true},
+	}
+	for _, v := range topics {
+		switch {
+		case v.topic == "" && v.optional:
+		case v.topic == "" || strings.ContainsAny(v.topic, "+#"):
+			add("mqtt.%s: некорректный топик \"%s\"", v.name, v.topic)
+		case v.withID && !strings.Contains(v.topic, "{id}"):
+			add("mqtt.%s: в топике \"%s\" нет подстановки {id}", v.name, v.topic)
+		}
+	}
+
+	if cfg.Shutdown.Timeout <= 0 {
+		add("shutdown.timeout: время завершения работы должно быть положительным")
+	}
+
+	if cfg.Privacy.PersonRetentionDays < 0 {
+		add("privacy.personretentiondays: срок хранения персон не может быть отрицательным")
+	}
+	if !contains(model.ErasureModes, cfg.Privacy.Erasure) {
+		add("privacy.erasure: неизвестный режим стирания \"%s\" (%s)", cfg.Privacy.Erasure, strings.Join(model.ErasureModes, ", "))
+	}
+
+	if cfg.Recognize.URL != "" && !isHTTPURL(cfg.Recognize.URL) {
+		add("recognize.url: некорректный адрес HTTP \"%s\"", cfg.Recognize.URL)
+	}
+
+	// Директории, в которые программа пишет данные
+	dirs := []struct {
+		name string
+		path string
+	}{
+		{"log.filename", filepath.Dir(cfg.Log.Filename)},
+		{"db.filename", filepath.Dir(cfg.Db.Filename)},
+		{"images.path", cfg.Images.Path},
+		{"sudos.path", cfg.Sudos.Path},
+	}
+	if cfg.Report.Path != "" {
+		dirs = append(dirs, struct {
+			name string
+			path string
+		}{"report.path", cfg.Report.Path})
+	}
+	if cfg.Queue.Overflow == model.QueueOverflowSpill {
+		dirs = append(dirs, struct {
+			name string
+			path string
+		}{"queue.spilldir", cfg.Queue.SpillDir})
+	}
+	for _, v := range dirs {
+		if err := checkWritableDir(v.path); err != nil {
+			add("%s: директория \"%s\" недоступна для записи: %s", v.name, v.path, err)
+		}
+	}
+
+	if len(problems) != 0 {
+		return problems
+	}
+	return nil
+}
+
+// Пути (в нотации config.yaml) к незаполненным полям с тегом required:"true"
+func blankRequired(value reflect.Value, prefix string) []string {
+	result := make([]string, 0)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		fieldType := value.Type().Field(i)
+		path := strings.ToLower(fieldType.Name)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		if fieldType.Tag.Get("required") == "true" && field.IsZero() {
+			result = append(result, path)
+		}
+		switch field.Kind() {
+		case reflect.Struct:
+			result = append(result, blankRequired(field, path)...)
+		case reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				if field.Index(j).Kind() == reflect.Struct {
+					result = append(result, blankRequired(field.Index(j), fmt.Sprintf("%s[%d]", path, j))...)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Имя переменной окружения для пути к параметру (например termopad.info[0].id)
+func envName(path string) string {
+	replacer := strings.NewReplacer(".", "_", "[", "_", "]", "")
+	return EnvPrefix + "_" + strings.ToUpper(replacer.Replace(path))
+}
+
+// Проверка возможности записи в директорию dir. Если её ещё нет, проверяется ближайшая
+// существующая родительская директория, в которой она будет создана
+func checkWritableDir(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s не является директорией", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+
+	file, err := ioutil.TempFile(dir, ".termopad-check-")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+// Проверка корректности адреса WebSocket
+func isWebsocketURL(address string) bool {
+	addr, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+	return addr.Scheme == "ws" && addr.Host != ""
+}
+
+// Проверка корректности адреса HTTP
+func isHTTPURL(address string) bool {
+	addr, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+	return (addr.Scheme == "http" || addr.Scheme == "https") && addr.Host != ""
+}
+
+// Корректен ли адрес брокера MQTT
+func isMQTTURL(address string) bool {
+	addr, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+	return contains([]string{"mqtt", "mqtts", "tcp", "ssl"}, addr.Scheme) && addr.Host != ""
+}
+
+// Есть ли значение value в списке values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
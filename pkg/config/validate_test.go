@@ -0,0 +1,208 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := `
+log:
+  filename: ` + filepath.Join(dir, "termopad.log") + `
+  level: info
+db:
+  filename: ` + filepath.Join(dir, "termopad.sqlite") + `
+images:
+  path: ` + filepath.Join(dir, "temperature") + `
+termopad:
+  maxtemperature: 37.7
+  mintemperature: 35.0
+  info:
+    - id: 1
+      cabina: 4
+      address: ws://127.0.0.1:11000/feed
+      name: Кабина 4
+http:
+  users:
+    - name: operator
+      password: $2a$04$6cgL.HNFRKCM41dQt8dXyOBjRaXJLTWkYPlNssq96pBk2Y6a5QAlW
+sudos:
+  address: ws://127.0.0.1:34888
+  path: ` + filepath.Join(dir, "persons") + `
+recognize:
+  url: http://127.0.0.1:2222/msg
+`
+
+	tests := []struct {
+		name         string
+		config       string
+		env          map[string]string
+		wantProblems ValidationError
+	}{
+		{
+			name:   "корректная конфигурация",
+			config: valid,
+		},
+		{
+			name:   "переопределение переменной окружения",
+			config: valid,
+			env:    map[string]string{"TERMOPAD_SUDOS_ADDRESS": "127.0.0.1:34888"},
+			wantProblems: ValidationError{
+				`sudos.address: некорректный адрес WebSocket "127.0.0.1:34888"`,
+			},
+		},
+		{
+			name: "все проблемы сразу",
+			config: `
+log:
+  level: verbose
+db:
+  reconcileinterval: -60
+images:
+  encryption:
+    keyenv: TERMOPAD_TEST_IMAGES_KEY
+termopad:
+  maxtemperature: 35.0
+  mintemperature: 37.7
+  info:
+    - id: 1
+      cabina: 4
+      address: 127.0.0.1:11000
+      name: Кабина 4
+    - id: 1
+      cabina: 4
+      name: Кабина 5
+      cardformat: H99999
+http:
+  trustedproxies: [10.0.0.0/8, nginx]
+  users:
+    - name: admin
+      password: $2a$04$6cgL.HNFRKCM41dQt8dXyOBjRaXJLTWkYPlNssq96pBk2Y6a5QAlW
+      role: admin
+    - name: Admin
+      password: secret
+      role: root
+  privacymode:
+    clients: [192.168.10.0/24, lobby]
+    blur: gauss
+sudos:
+  address: ws://127.0.0.1:34888
+queue:
+  workers: 0
+  overflow: drop-newest
+report:
+  schedule: "0 25 * * *"
+  shifts:
+    - name: день
+      start: "08:00"
+      end: "20:00"
+    - name: night
+      start: "20:00"
+      end: "8"
+notify:
+  smtp:
+    host: smtp.example.com
+    from: termopad
+    tls: ssl
+  recipients:
+    - organization: Альфа
+      emails: [ohrana@example.com, "ohrana at example.com"]
+    - organization: Бета
+      chats: [-100123]
+    - department: Цех 1
+webhook:
+  maxbackoff: 0
+  hooks:
+    - name: hr
+      url: https://hr.example.com/termopad
+      events: [measurement, termopad-up]
+    - name: hr
+      url: turnstile:8080
+mqtt:
+  address: http://broker:1883
+  qos: 2
+  topicstatus: termopad/status
+  topiccontrol: termopad/#
+privacy:
+  personretentiondays: -1
+  erasure: anonymize
+`,
+			wantProblems: ValidationError{
+				"termopad.info[1].address: обязательное значение не задано (переменная окружения TERMOPAD_TERMOPAD_INFO_1_ADDRESS)",
+				"recognize.url: обязательное значение не задано (переменная окружения TERMOPAD_RECOGNIZE_URL)",
+				`log.level: неизвестный уровень логирования "verbose"`,
+				"db.reconcileinterval: период сверки не может быть отрицательным",
+				"images.encryption: переменная окружения TERMOPAD_TEST_IMAGES_KEY с ключом шифрования не задана",
+				"termopad: минимальная температура 37.7 должна быть меньше максимальной 35.0",
+				`termopad.info[0]: некорректный адрес WebSocket "127.0.0.1:11000"`,
+				"termopad.info[1]: повторяющийся id 1",
+				"termopad.info[1]: повторяющаяся кабина 4",
+				`termopad.info[1].cardformat: неизвестный формат карты "H99999" (CSN32, H10301, H10304, W34)`,
+				`http.trustedproxies[1]: некорректный адрес или сеть "nginx"`,
+				`http.users[1]: повторяющееся имя пользователя "Admin"`,
+				"http.users[1].password: ожидается хеш bcrypt (команда hash-password)",
+				`http.users[1].role: неизвестная роль "root" (admin, operator, viewer)`,
+				`http.privacymode.clients[1]: некорректный адрес или сеть "lobby"`,
+				`http.privacymode.blur: неизвестный режим размытия "gauss" (face, frame)`,
+				"queue.workers: количество обработчиков должно быть положительным",
+				`queue.overflow: неизвестная политика переполнения "drop-newest" (block, drop-oldest, spill)`,
+				`report.schedule: расписание "0 25 * * *", поле "часы": значение 25 вне диапазона 0-23`,
+				`report.shifts[0].name: имя смены "день" может содержать только латинские буквы, цифры, "-" и "_"`,
+				`report.shifts[1].end: некорректное время "8", ожидается ЧЧ:ММ`,
+				`notify.smtp.tls: неизвестный режим шифрования "ssl" (none, starttls, tls)`,
+				`notify.smtp.from: некорректный адрес отправителя "termopad"`,
+				`notify.recipients[0].emails: некорректный адрес "ohrana at example.com"`,
+				"notify.recipients[2]: не указаны ни адреса, ни чаты",
+				"notify.telegram.token: не указан токен бота для оповещения в чаты",
+				"webhook: задержка между попытками должна быть положительной и не больше maxbackoff",
+				`webhook.hooks[0].events: неизвестное событие "termopad-up" (measurement, alarm, termopad-down, person-updated)`,
+				`webhook.hooks[1]: повторяющееся имя вебхука "hr"`,
+				`webhook.hooks[1].url: некорректный адрес HTTP "turnstile:8080"`,
+				`mqtt.address: некорректный адрес брокера "http://broker:1883" (mqtt://, mqtts://, tcp:// или ssl://)`,
+				"mqtt.qos: поддерживается уровень гарантии доставки 0 или 1, задан 2",
+				`mqtt.topicstatus: в топике "termopad/status" нет подстановки {id}`,
+				`mqtt.topiccontrol: некорректный топик "termopad/#"`,
+				"privacy.personretentiondays: срок хранения персон не может быть отрицательным",
+				`privacy.erasure: неизвестный режим стирания "anonymize" (delete, pseudonymize)`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				if err := os.Setenv(k, v); err != nil {
+					t.Fatal(err)
+				}
+				defer os.Unsetenv(k)
+			}
+			file := filepath.Join(dir, "config.yaml")
+			if err := ioutil.WriteFile(file, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Check(file)
+			if tt.wantProblems == nil {
+				if err != nil {
+					t.Fatalf("Check() error = %v", err)
+				}
+				return
+			}
+			problems, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("Check() error = %v, want ValidationError", err)
+			}
+			if !reflect.DeepEqual(problems, tt.wantProblems) {
+				t.Errorf("Check() problems:\n%q\nwant:\n%q", problems, tt.wantProblems)
+			}
+		})
+	}
+}
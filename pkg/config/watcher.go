@@ -0,0 +1,126 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Период проверки изменения файла конфигурации
+	watchInterval = 5 * time.Second
+)
+
+// Watcher отслеживает изменения файла конфигурации (и сигнал SIGHUP) и возвращает через EmmitConfig
+// новую, прошедшую проверку Check, конфигурацию. Инициализируется через NewWatcher
+type Watcher struct {
+	ctx      context.Context
+	log      *logrus.Entry
+	filepath string
+	interval time.Duration
+	modTime  time.Time
+	changes  chan *Config
+}
+
+// ConfigWatcher конфигурация Watcher
+type ConfigWatcher struct {
+	Log *logrus.Logger
+	// Период проверки изменения файла конфигурации
+	Interval time.Duration
+}
+
+// NewWatcher конструктор Watcher
+func NewWatcher(ctx context.Context, filepath string, config *ConfigWatcher) (*Watcher, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	info, err := os.Stat(filepath)
+	if err != nil {
+		return nil, errors.Annotate(err, "файл конфигурации недоступен")
+	}
+
+	watcher := Watcher{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "config",
+			"scope":  "pkg",
+			"file":   filepath,
+		}),
+		filepath: filepath,
+		interval: watchInterval,
+		modTime:  info.ModTime(),
+		changes:  make(chan *Config),
+	}
+	if config.Interval != 0 {
+		watcher.interval = config.Interval
+	}
+	go watcher.loop()
+
+	return &watcher, nil
+}
+
+// Отслеживание изменений файла и сигнала SIGHUP
+func (m *Watcher) loop() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-hangup:
+			m.log.Info("получен сигнал SIGHUP, перечитываем конфигурацию")
+			m.reload()
+		case <-ticker.C:
+			info, err := os.Stat(m.filepath)
+			if err != nil {
+				m.log.Warnf("файл конфигурации недоступен: %v", err)
+				continue
+			}
+			if info.ModTime().Equal(m.modTime) {
+				continue
+			}
+			m.modTime = info.ModTime()
+			m.log.Info("файл конфигурации изменён, перечитываем конфигурацию")
+			m.reload()
+		}
+	}
+}
+
+// Чтение и проверка конфигурации. Некорректная конфигурация не передаётся дальше
+func (m *Watcher) reload() {
+	cfg, err := Check(m.filepath)
+	if err != nil {
+		m.log.Errorf("новая конфигурация не применена: %v", err)
+		return
+	}
+	select {
+	case <-m.ctx.Done():
+	case m.changes <- cfg:
+	}
+}
+
+// EmmitConfig ожидает изменения конфигурации и возвращает новую, проверенную конфигурацию.
+// Возвращает context.Canceled при принудительном завершении работы
+func (m *Watcher) EmmitConfig() (*Config, error) {
+	select {
+	case <-m.ctx.Done():
+		return nil, m.ctx.Err()
+	case cfg := <-m.changes:
+		return cfg, nil
+	}
+}
@@ -0,0 +1,159 @@
+// Package cron разбор расписаний в формате cron из пяти полей: минуты, часы, день месяца, месяц и день недели.
+// Поля поддерживают "*", списки через запятую, диапазоны "a-b" и шаг "/n"
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ограничение поиска следующего срабатывания (расписание вроде "0 0 30 2 *" не срабатывает никогда)
+const searchLimit = 5 * 366 * 24 * 60
+
+// Границы значений полей расписания
+var fields = []struct {
+	name     string
+	min, max int
+}{
+	{"минуты", 0, 59},
+	{"часы", 0, 23},
+	{"день месяца", 1, 31},
+	{"месяц", 1, 12},
+	{"день недели", 0, 7},
+}
+
+// Schedule разобранное расписание. Создаётся через Parse
+type Schedule struct {
+	spec   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// День месяца или день недели ограничены (не "*"). Если ограничены оба, достаточно совпадения любого
+	domRestricted bool
+	dowRestricted bool
+}
+
+// Parse разбирает расписание spec
+func Parse(spec string) (*Schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("расписание \"%s\" должно состоять из %d полей", spec, len(fields))
+	}
+	masks := make([]uint64, len(fields))
+	for i, part := range parts {
+		mask, err := parseField(part, fields[i].min, fields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("расписание \"%s\", поле \"%s\": %s", spec, fields[i].name, err)
+		}
+		masks[i] = mask
+	}
+	// Воскресенье допускается и как 0, и как 7
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1
+	}
+	return &Schedule{
+		spec:          spec,
+		minute:        masks[0],
+		hour:          masks[1],
+		dom:           masks[2],
+		month:         masks[3],
+		dow:           masks[4],
+		domRestricted: parts[2] != "*",
+		dowRestricted: parts[4] != "*",
+	}, nil
+}
+
+// String исходная запись расписания
+func (m Schedule) String() string {
+	return m.spec
+}
+
+// Next время первого срабатывания расписания строго после t (с точностью до минуты). Если расписание
+// никогда не срабатывает, возвращается нулевое время
+func (m Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < searchLimit; i++ {
+		if m.match(t) {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// Совпадение времени t с расписанием
+func (m Schedule) match(t time.Time) bool {
+	if !has(m.minute, t.Minute()) || !has(m.hour, t.Hour()) || !has(m.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(m.dom, t.Day()), has(m.dow, int(t.Weekday()))
+	if m.domRestricted && m.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Разбор поля расписания в битовую маску допустимых значений
+func parseField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(item, "/"); idx >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[idx+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("некорректный шаг \"%s\"", item[idx+1:])
+			}
+			item = item[:idx]
+		}
+		from, to := min, max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if from, err = parseValue(bounds[0], min, max); err != nil {
+				return 0, err
+			}
+			if to, err = parseValue(bounds[1], min, max); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("некорректный диапазон \"%s\"", item)
+			}
+		default:
+			value, err := parseValue(item, min, max)
+			if err != nil {
+				return 0, err
+			}
+			from = value
+			if step == 1 {
+				to = value
+			}
+		}
+		for v := from; v <= to; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// Разбор значения поля с проверкой границ
+func parseValue(value string, min, max int) (int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("некорректное значение \"%s\"", value)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("значение %d вне диапазона %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// Наличие значения v в маске
+func has(mask uint64, v int) bool {
+	return mask&(1<<uint(v)) != 0
+}
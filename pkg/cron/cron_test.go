@@ -0,0 +1,45 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	// Понедельник
+	base := time.Date(2026, 3, 2, 10, 30, 15, 0, time.Local)
+	tests := []struct {
+		name string
+		spec string
+		want time.Time
+	}{
+		{"каждую минуту", "* * * * *", time.Date(2026, 3, 2, 10, 31, 0, 0, time.Local)},
+		{"ежедневно в 07:00", "0 7 * * *", time.Date(2026, 3, 3, 7, 0, 0, 0, time.Local)},
+		{"каждые 15 минут", "*/15 * * * *", time.Date(2026, 3, 2, 10, 45, 0, 0, time.Local)},
+		{"список часов", "0 8,20 * * *", time.Date(2026, 3, 2, 20, 0, 0, 0, time.Local)},
+		{"рабочие дни", "0 9 * * 1-5", time.Date(2026, 3, 3, 9, 0, 0, 0, time.Local)},
+		{"воскресенье как 7", "0 0 * * 7", time.Date(2026, 3, 8, 0, 0, 0, 0, time.Local)},
+		{"первое число месяца", "30 6 1 * *", time.Date(2026, 4, 1, 6, 30, 0, 0, time.Local)},
+		{"день месяца или недели", "0 0 15 * 3", time.Date(2026, 3, 4, 0, 0, 0, 0, time.Local)},
+		{"не срабатывает никогда", "0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(\"%s\") без ошибки", spec)
+		}
+	}
+}
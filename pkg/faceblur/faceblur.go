@@ -0,0 +1,168 @@
+package faceblur
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+
+	"github.com/juju/errors"
+)
+
+// Режимы размытия изображений
+const (
+	// Размытие области лица. Если лицо не найдено, размывается весь кадр
+	ModeFace = "face"
+	// Размытие всего кадра
+	ModeFrame = "frame"
+)
+
+// Modes все режимы размытия
+var Modes = []string{ModeFace, ModeFrame}
+
+const (
+	// Размер ячейки детектора в пикселях
+	cellSize = 8
+	// Минимальная доля пикселей цвета кожи в ячейке, чтобы считать её ячейкой лица
+	cellSkinRatio = 0.5
+	// Минимальная площадь найденной области лица относительно кадра. Меньшие области считаются шумом
+	minFaceRatio = 0.02
+	// Расширение найденной области лица с каждой стороны (волосы, уши, шея) относительно её размера
+	faceMargin = 0.25
+	// Количество блоков пикселизации по большей стороне области
+	pixelBlocks = 8
+	// Качество JPEG результата
+	jpegQuality = 85
+)
+
+// Blur размывает лицо (mode=ModeFace) или весь кадр (mode=ModeFrame) на изображении JPEG content. Область
+// размывается пикселизацией, восстановить по которой лицо нельзя. При ошибке декодирования исходное
+// изображение не возвращается
+func Blur(content []byte, mode string) ([]byte, error) {
+	if mode != ModeFace && mode != ModeFrame {
+		return nil, errors.Errorf("неизвестный режим размытия \"%s\"", mode)
+	}
+	src, err := jpeg.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка декодирования JPEG")
+	}
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	area := img.Bounds()
+	if mode == ModeFace {
+		if face, ok := DetectFace(img); ok {
+			area = face
+		}
+	}
+	pixelate(img, area)
+
+	var result bytes.Buffer
+	if err := jpeg.Encode(&result, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, errors.Annotate(err, "ошибка кодирования JPEG")
+	}
+	return result.Bytes(), nil
+}
+
+// DetectFace простой детектор лица: ищет наибольшую связную область цвета кожи и возвращает её, расширенную
+// на faceMargin. Если область не найдена или слишком мала, возвращает false
+func DetectFace(img image.Image) (image.Rectangle, bool) {
+	bounds := img.Bounds()
+	cols, rows := bounds.Dx()/cellSize, bounds.Dy()/cellSize
+	if cols == 0 || rows == 0 {
+		return image.Rectangle{}, false
+	}
+
+	// Ячейки цвета кожи
+	skin := make([]bool, cols*rows)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			count := 0
+			for y := 0; y < cellSize; y++ {
+				for x := 0; x < cellSize; x++ {
+					if isSkin(img.At(bounds.Min.X+col*cellSize+x, bounds.Min.Y+row*cellSize+y)) {
+						count++
+					}
+				}
+			}
+			skin[row*cols+col] = float64(count) >= cellSkinRatio*cellSize*cellSize
+		}
+	}
+
+	// Наибольшая связная (по сторонам ячеек) область
+	visited := make([]bool, len(skin))
+	best, bestSize := image.Rectangle{}, 0
+	for start := range skin {
+		if !skin[start] || visited[start] {
+			continue
+		}
+		size := 0
+		area := image.Rect(start%cols, start/cols, start%cols+1, start/cols+1)
+		queue := []int{start}
+		visited[start] = true
+		for len(queue) != 0 {
+			cell := queue[0]
+			queue = queue[1:]
+			size++
+			col, row := cell%cols, cell/cols
+			area = area.Union(image.Rect(col, row, col+1, row+1))
+			for _, next := range [][2]int{{col - 1, row}, {col + 1, row}, {col, row - 1}, {col, row + 1}} {
+				if next[0] < 0 || next[0] >= cols || next[1] < 0 || next[1] >= rows {
+					continue
+				}
+				idx := next[1]*cols + next[0]
+				if skin[idx] && !visited[idx] {
+					visited[idx] = true
+					queue = append(queue, idx)
+				}
+			}
+		}
+		if size > bestSize {
+			best, bestSize = area, size
+		}
+	}
+	if float64(bestSize) < minFaceRatio*float64(cols*rows) {
+		return image.Rectangle{}, false
+	}
+
+	face := image.Rect(best.Min.X*cellSize, best.Min.Y*cellSize, best.Max.X*cellSize, best.Max.Y*cellSize).Add(bounds.Min)
+	dx, dy := int(float64(face.Dx())*faceMargin), int(float64(face.Dy())*faceMargin)
+	face = image.Rect(face.Min.X-dx, face.Min.Y-dy, face.Max.X+dx, face.Max.Y+dy)
+	return face.Intersect(bounds), true
+}
+
+// Проверка цвета кожи по классическим границам цветоразностных составляющих YCbCr
+func isSkin(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	y, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	return y > 40 && cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173
+}
+
+// Пикселизация области area изображения: область делится на pixelBlocks блоков по большей стороне, каждый блок
+// заливается средним цветом
+func pixelate(img *image.RGBA, area image.Rectangle) {
+	block := area.Dx()
+	if area.Dy() > block {
+		block = area.Dy()
+	}
+	block = (block + pixelBlocks - 1) / pixelBlocks
+	if block < 1 {
+		return
+	}
+	for y := area.Min.Y; y < area.Max.Y; y += block {
+		for x := area.Min.X; x < area.Max.X; x += block {
+			rect := image.Rect(x, y, x+block, y+block).Intersect(area)
+			var r, g, b, count uint64
+			for py := rect.Min.Y; py < rect.Max.Y; py++ {
+				for px := rect.Min.X; px < rect.Max.X; px++ {
+					c := img.RGBAAt(px, py)
+					r, g, b = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B)
+					count++
+				}
+			}
+			avg := color.RGBA{R: uint8(r / count), G: uint8(g / count), B: uint8(b / count), A: 0xff}
+			draw.Draw(img, rect, &image.Uniform{C: avg}, image.Point{}, draw.Src)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package faceblur
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// Кадр 160x120 на синем фоне с прямоугольником цвета кожи face (пустой - без лица)
+func frame(face image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 160, 120))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 30, G: 60, B: 160, A: 0xff}}, image.Point{}, draw.Src)
+	draw.Draw(img, face, &image.Uniform{C: color.RGBA{R: 220, G: 170, B: 140, A: 0xff}}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestDetectFace(t *testing.T) {
+	tests := []struct {
+		name   string
+		face   image.Rectangle
+		wantOK bool
+	}{
+		{"лицо в центре", image.Rect(48, 24, 112, 96), true},
+		{"лицо у края кадра", image.Rect(0, 0, 40, 48), true},
+		{"нет лица", image.Rectangle{}, false},
+		{"слишком малая область", image.Rect(80, 80, 88, 88), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DetectFace(frame(tt.face))
+			if ok != tt.wantOK {
+				t.Fatalf("DetectFace() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && (!tt.face.In(got) || !got.In(image.Rect(0, 0, 160, 120))) {
+				t.Errorf("DetectFace() = %v, должна включать %v и не выходить за кадр", got, tt.face)
+			}
+		})
+	}
+}
+
+func TestBlur(t *testing.T) {
+	face := image.Rect(48, 24, 112, 96)
+	img := frame(face)
+	// Детали лица, которые должны исчезнуть после размытия
+	for x := face.Min.X; x < face.Max.X; x += 4 {
+		draw.Draw(img, image.Rect(x, 50, x+2, 52), &image.Uniform{C: color.Black}, image.Point{}, draw.Src)
+	}
+	var content bytes.Buffer
+	if err := jpeg.Encode(&content, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		mode    string
+		// Точка фона, которая должна остаться синей
+		background image.Point
+		wantErr    bool
+	}{
+		{name: "размытие лица", content: content.Bytes(), mode: ModeFace, background: image.Pt(2, 115)},
+		{name: "размытие кадра", content: content.Bytes(), mode: ModeFrame, background: image.Point{X: -1}},
+		{name: "не JPEG", content: []byte("not an image"), mode: ModeFace, wantErr: true},
+		{name: "неизвестный режим", content: content.Bytes(), mode: "gauss", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Blur(tt.content, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Blur() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if got != nil {
+					t.Error("Blur() при ошибке вернул изображение")
+				}
+				return
+			}
+			result, err := jpeg.Decode(bytes.NewReader(got))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Bounds() != img.Bounds() {
+				t.Fatalf("размер = %v, want %v", result.Bounds(), img.Bounds())
+			}
+			// Полосы на лице должны быть сглажены: соседние пиксели почти одинаковы
+			for x := face.Min.X + 8; x < face.Max.X-8; x += 4 {
+				a, b := gray(result.At(x, 51)), gray(result.At(x+2, 51))
+				if a-b > 40 || b-a > 40 {
+					t.Fatalf("детали лица не размыты в точке (%d, 51): %d и %d", x, a, b)
+				}
+			}
+			if tt.background.X >= 0 {
+				if _, _, b, _ := result.At(tt.background.X, tt.background.Y).RGBA(); b>>8 < 120 {
+					t.Errorf("фон вне лица изменён")
+				}
+			}
+		})
+	}
+}
+
+func gray(c color.Color) int {
+	return int(color.GrayModel.Convert(c).(color.Gray).Y)
+}
@@ -0,0 +1,113 @@
+package imagecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// KeySize длина ключа шифрования в байтах (AES-256)
+const KeySize = 32
+
+// Заголовок зашифрованного файла: сигнатура и версия формата. За ним следуют nonce и шифротекст GCM
+var header = []byte("TPENC\x01")
+
+// Cipher шифрование содержимого файлов изображений AES-GCM. Инициализируется через NewCipher
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher конструктор Cipher с ключом key длиной KeySize
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, errors.Errorf("длина ключа шифрования должна быть %d байт, задано %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal шифрует содержимое plain
+func (m *Cipher) Seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Annotate(err, "ошибка генерации nonce")
+	}
+	result := make([]byte, 0, len(header)+len(nonce)+len(plain)+m.aead.Overhead())
+	result = append(result, header...)
+	result = append(result, nonce...)
+	return m.aead.Seal(result, nonce, plain, header), nil
+}
+
+// Open расшифровывает содержимое, зашифрованное Seal. Ошибка возвращается и при неверном ключе
+func (m *Cipher) Open(content []byte) ([]byte, error) {
+	if !IsEncrypted(content) {
+		return nil, errors.New("содержимое не зашифровано")
+	}
+	content = content[len(header):]
+	if len(content) < m.aead.NonceSize() {
+		return nil, errors.New("зашифрованное содержимое повреждено")
+	}
+	nonce, sealed := content[:m.aead.NonceSize()], content[m.aead.NonceSize():]
+	plain, err := m.aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return nil, errors.New("неверный ключ шифрования или содержимое повреждено")
+	}
+	return plain, nil
+}
+
+// IsEncrypted зашифровано ли содержимое content
+func IsEncrypted(content []byte) bool {
+	return bytes.HasPrefix(content, header)
+}
+
+// ParseKey разбирает ключ, записанный в hex (64 символа) или base64. Пробельные символы по краям игнорируются
+func ParseKey(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, errors.Errorf("ключ шифрования должен содержать %d байт в hex или base64", KeySize)
+}
+
+// LoadKey читает ключ из файла file или из переменной окружения env (задаётся что-то одно). Если не задано
+// ни то, ни другое, возвращается nil: шифрование отключено
+func LoadKey(file string, env string) ([]byte, error) {
+	switch {
+	case file != "" && env != "":
+		return nil, errors.New("ключ шифрования задаётся либо файлом, либо переменной окружения")
+	case file != "":
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Annotate(err, "ошибка чтения файла ключа шифрования")
+		}
+		key, err := ParseKey(string(content))
+		return key, errors.Annotatef(err, "файл %s", file)
+	case env != "":
+		value, ok := os.LookupEnv(env)
+		if !ok || value == "" {
+			return nil, errors.Errorf("переменная окружения %s с ключом шифрования не задана", env)
+		}
+		key, err := ParseKey(value)
+		return key, errors.Annotatef(err, "переменная окружения %s", env)
+	}
+	return nil, nil
+}
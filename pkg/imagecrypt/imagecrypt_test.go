@@ -0,0 +1,79 @@
+package imagecrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, KeySize)
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{"hex", hex.EncodeToString(key), false},
+		{"hex с переводом строки", hex.EncodeToString(key) + "\n", false},
+		{"base64", base64.StdEncoding.EncodeToString(key), false},
+		{"короткий ключ", hex.EncodeToString(key[:16]), true},
+		{"не ключ", "secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, key) {
+				t.Errorf("ParseKey() = %x", got)
+			}
+		})
+	}
+}
+
+func TestCipher_Open(t *testing.T) {
+	first, err := NewCipher(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewCipher(bytes.Repeat([]byte{2}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := []byte("\xff\xd8\xff\xe0 jpeg")
+	sealed, err := first.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed) || IsEncrypted(plain) || bytes.Contains(sealed, plain) {
+		t.Fatalf("Seal() = %q", sealed)
+	}
+	damaged := append([]byte{}, sealed...)
+	damaged[len(damaged)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		cipher  *Cipher
+		content []byte
+		wantErr bool
+	}{
+		{"тот же ключ", first, sealed, false},
+		{"другой ключ", second, sealed, true},
+		{"повреждённое содержимое", first, damaged, true},
+		{"открытое содержимое", first, plain, true},
+		{"только заголовок", first, header, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cipher.Open(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, plain) {
+				t.Errorf("Open() = %q, want %q", got, plain)
+			}
+		})
+	}
+}
@@ -74,7 +74,7 @@ func GetWithConfig(config Config) *logrus.Logger {
 		//log.Level = logrus.InfoLevel
 		//log.Printf("----------===== начало записи в лог %s =====----------", time.Now())
 		//log.Level = prevLogLevel
-		log.Println("----------===== начало записи в лог %s =====----------", time.Now())
+		log.Printf("----------===== начало записи в лог %s =====----------", time.Now())
 		logger = log
 	})
 	return logger
@@ -0,0 +1,261 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Broker встраиваемый брокер MQTT: принимает подключения, хранит сохраняемые сообщения, рассылает
+// публикации подписчикам и публикует завещания при обрыве связи. Сессии не сохраняются, QoS
+// подписчикам не выше 1. Предназначен для тестов и отладки без внешнего брокера
+type Broker struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	clients  map[*brokerClient]bool
+	retained map[string]Message
+}
+
+// Подключение клиента к брокеру
+type brokerClient struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	// Фильтры подписок и их QoS (защищены Broker.mu)
+	subscriptions map[string]byte
+	nextID        uint16
+	will          *Message
+}
+
+// NewBroker запускает брокер на listener
+func NewBroker(listener net.Listener) *Broker {
+	broker := &Broker{
+		listener: listener,
+		clients:  make(map[*brokerClient]bool),
+		retained: make(map[string]Message),
+	}
+	go broker.serve()
+	return broker
+}
+
+// Addr адрес, на котором брокер принимает подключения
+func (m *Broker) Addr() string {
+	return m.listener.Addr().String()
+}
+
+// Retained возвращает сохранённое сообщение топика topic
+func (m *Broker) Retained(topic string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.retained[topic]
+	return msg, ok
+}
+
+// Close останавливает брокер и разрывает все подключения (без публикации завещаний)
+func (m *Broker) Close() error {
+	err := m.listener.Close()
+	m.mu.Lock()
+	for client := range m.clients {
+		client.will = nil
+		_ = client.conn.Close()
+	}
+	m.mu.Unlock()
+	return errors.Trace(err)
+}
+
+// Приём подключений
+func (m *Broker) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.handle(conn)
+	}
+}
+
+// Обслуживание подключения клиента
+func (m *Broker) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	client := &brokerClient{conn: conn, subscriptions: make(map[string]byte)}
+
+	p, err := readPacket(reader)
+	if err != nil || p.kind != packetConnect {
+		return
+	}
+	if client.will, err = decodeConnect(p); err != nil {
+		return
+	}
+	if err := client.write(packetConnack, 0, []byte{0, 0}); err != nil {
+		return
+	}
+	m.mu.Lock()
+	m.clients[client] = true
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.clients, client)
+		will := client.will
+		m.mu.Unlock()
+		if will != nil {
+			m.publish(*will)
+		}
+	}()
+
+	for {
+		p, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		switch p.kind {
+		case packetPublish:
+			msg, id, err := decodePublish(p)
+			if err != nil {
+				return
+			}
+			if msg.QoS > 0 {
+				_ = client.write(packetPuback, 0, encodeID(id))
+			}
+			m.publish(msg)
+		case packetSubscribe:
+			if err := m.subscribe(client, p); err != nil {
+				return
+			}
+		case packetPingreq:
+			_ = client.write(packetPingresp, 0, nil)
+		case packetDisconnect:
+			m.mu.Lock()
+			client.will = nil
+			m.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Подписка клиента по пакету SUBSCRIBE и выдача ему подходящих сохранённых сообщений
+func (m *Broker) subscribe(client *brokerClient, p *packet) error {
+	d := decoder{body: p.body}
+	id := d.uint16()
+	filters := make(map[string]byte)
+	var codes bytes.Buffer
+	for len(d.body) > 0 && d.err == nil {
+		filter := d.string()
+		qos := d.byte()
+		if qos > 1 {
+			qos = 1
+		}
+		filters[filter] = qos
+		codes.WriteByte(qos)
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	m.mu.Lock()
+	retained := make([]Message, 0)
+	for filter, qos := range filters {
+		client.subscriptions[filter] = qos
+		for _, msg := range m.retained {
+			if Match(filter, msg.Topic) {
+				retained = append(retained, msg)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	if err := client.write(packetSuback, 0, append(encodeID(id), codes.Bytes()...)); err != nil {
+		return err
+	}
+	for _, msg := range retained {
+		client.deliver(msg, msg.QoS)
+	}
+	return nil
+}
+
+// Сохранение и рассылка сообщения подписчикам
+func (m *Broker) publish(msg Message) {
+	m.mu.Lock()
+	if msg.Retain {
+		if len(msg.Payload) == 0 {
+			delete(m.retained, msg.Topic)
+		} else {
+			m.retained[msg.Topic] = msg
+		}
+	}
+	type target struct {
+		client *brokerClient
+		qos    byte
+	}
+	targets := make([]target, 0)
+	for client := range m.clients {
+		granted, matched := byte(0), false
+		for filter, qos := range client.subscriptions {
+			if Match(filter, msg.Topic) {
+				matched = true
+				if qos > granted {
+					granted = qos
+				}
+			}
+		}
+		if matched {
+			targets = append(targets, target{client: client, qos: granted})
+		}
+	}
+	m.mu.Unlock()
+
+	// Подписчикам, получающим сообщение при публикации, флаг сохранения не передаётся
+	msg.Retain = false
+	for _, v := range targets {
+		v.client.deliver(msg, v.qos)
+	}
+}
+
+// Отправка сообщения подписчику с QoS не выше qos. Подтверждения подписчика не ожидаются
+func (m *brokerClient) deliver(msg Message, qos byte) {
+	if msg.QoS < qos {
+		qos = msg.QoS
+	}
+	msg.QoS = qos
+	m.writeMu.Lock()
+	m.nextID++
+	if m.nextID == 0 {
+		m.nextID++
+	}
+	id := m.nextID
+	m.writeMu.Unlock()
+	flags, body := encodePublish(msg, id)
+	_ = m.write(packetPublish, flags, body)
+}
+
+func (m *brokerClient) write(kind byte, flags byte, body []byte) error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	return writePacket(m.conn, kind, flags, body)
+}
+
+// Разбор пакета CONNECT: возвращается завещание клиента
+func decodeConnect(p *packet) (*Message, error) {
+	d := decoder{body: p.body}
+	if name := d.string(); name != "MQTT" && d.err == nil {
+		return nil, errors.Errorf("неизвестный протокол %s", name)
+	}
+	_ = d.byte()
+	flags := d.byte()
+	_ = d.uint16()
+	_ = d.string()
+	var will *Message
+	if flags&connectWill != 0 {
+		will = &Message{
+			Topic:  d.string(),
+			QoS:    (flags >> 3) & 0x03,
+			Retain: flags&connectWillRetain != 0,
+		}
+		will.Payload = append([]byte(nil), d.bytes()...)
+	}
+	return will, d.err
+}
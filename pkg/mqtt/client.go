@@ -0,0 +1,362 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	keepAlive = 30 * time.Second
+	timeout   = 10 * time.Second
+)
+
+// Причины отказа брокера в подключении (код ответа CONNACK)
+var connackErrors = map[byte]string{
+	1: "неподдерживаемая версия протокола",
+	2: "идентификатор клиента отклонён",
+	3: "брокер недоступен",
+	4: "неверное имя пользователя или пароль",
+	5: "подключение не разрешено",
+}
+
+// Options параметры подключения к брокеру
+type Options struct {
+	// Адрес брокера host:port
+	Address string
+	// Параметры TLS (nil - подключение без шифрования)
+	TLS      *tls.Config
+	ClientID string
+	Username string
+	Password string
+	// Период проверки связи
+	KeepAlive time.Duration
+	// Завещание: сообщение, которое брокер опубликует при обрыве связи с клиентом
+	Will *Message
+	// Ограничение времени подключения и ожидания подтверждений
+	Timeout time.Duration
+}
+
+// Подписка клиента
+type subscription struct {
+	filter  string
+	handler func(Message)
+}
+
+// Client подключение к брокеру MQTT. Создаётся через Connect. После обрыва связи клиент не переподключается:
+// закрывается канал Done, а причину возвращает Err
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	options Options
+
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	nextID        uint16
+	acks          map[uint16]chan byte
+	subscriptions []subscription
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+// Connect подключается к брокеру
+func Connect(ctx context.Context, options Options) (*Client, error) {
+	if options.KeepAlive == 0 {
+		options.KeepAlive = keepAlive
+	}
+	if options.Timeout == 0 {
+		options.Timeout = timeout
+	}
+	if options.Will != nil && options.Will.QoS > 1 {
+		return nil, errors.Errorf("уровень QoS %d не поддерживается", options.Will.QoS)
+	}
+
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	var conn net.Conn
+	var err error
+	if options.TLS != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: options.TLS}).DialContext(ctx, "tcp", options.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", options.Address)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "ошибка подключения к брокеру MQTT %s", options.Address)
+	}
+
+	client := &Client{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		options: options,
+		acks:    make(map[uint16]chan byte),
+		done:    make(chan struct{}),
+	}
+	if err := client.handshake(); err != nil {
+		_ = conn.Close()
+		return nil, errors.Trace(err)
+	}
+	go client.readLoop()
+	go client.pingLoop()
+	return client, nil
+}
+
+// Отправка CONNECT и ожидание CONNACK
+func (m *Client) handshake() error {
+	var body bytes.Buffer
+	writeString(&body, []byte("MQTT"))
+	body.WriteByte(4)
+	flags := byte(connectCleanSession)
+	if will := m.options.Will; will != nil {
+		flags |= connectWill | will.QoS<<3
+		if will.Retain {
+			flags |= connectWillRetain
+		}
+	}
+	if m.options.Username != "" {
+		flags |= connectUsername
+		if m.options.Password != "" {
+			flags |= connectPassword
+		}
+	}
+	body.WriteByte(flags)
+	_ = binary.Write(&body, binary.BigEndian, uint16(m.options.KeepAlive/time.Second))
+	writeString(&body, []byte(m.options.ClientID))
+	if will := m.options.Will; will != nil {
+		writeString(&body, []byte(will.Topic))
+		writeString(&body, will.Payload)
+	}
+	if m.options.Username != "" {
+		writeString(&body, []byte(m.options.Username))
+		if m.options.Password != "" {
+			writeString(&body, []byte(m.options.Password))
+		}
+	}
+
+	_ = m.conn.SetDeadline(time.Now().Add(m.options.Timeout))
+	defer func() { _ = m.conn.SetDeadline(time.Time{}) }()
+	if err := writePacket(m.conn, packetConnect, 0, body.Bytes()); err != nil {
+		return errors.Annotate(err, "ошибка отправки CONNECT")
+	}
+	p, err := readPacket(m.reader)
+	if err != nil {
+		return errors.Annotate(err, "брокер не ответил на CONNECT")
+	}
+	if p.kind != packetConnack || len(p.body) != 2 {
+		return errors.Errorf("вместо CONNACK получен пакет типа %d", p.kind)
+	}
+	if code := p.body[1]; code != 0 {
+		reason, ok := connackErrors[code]
+		if !ok {
+			reason = fmt.Sprintf("код %d", code)
+		}
+		return errors.Errorf("брокер отказал в подключении: %s", reason)
+	}
+	return nil
+}
+
+// Publish публикует сообщение. При QoS 1 дожидается подтверждения брокера
+func (m *Client) Publish(msg Message) error {
+	if msg.QoS > 1 {
+		return errors.Errorf("уровень QoS %d не поддерживается", msg.QoS)
+	}
+	var id uint16
+	var ack chan byte
+	if msg.QoS > 0 {
+		id, ack = m.expect()
+		defer m.forget(id)
+	}
+	flags, body := encodePublish(msg, id)
+	if err := m.write(packetPublish, flags, body); err != nil {
+		return errors.Trace(err)
+	}
+	if ack == nil {
+		return nil
+	}
+	_, err := m.wait(ack)
+	return errors.Trace(err)
+}
+
+// Subscribe подписывается на топики по фильтру filter. Сообщения передаются в handler в отдельной горутине
+func (m *Client) Subscribe(filter string, qos byte, handler func(Message)) error {
+	if qos > 1 {
+		return errors.Errorf("уровень QoS %d не поддерживается", qos)
+	}
+	m.mu.Lock()
+	m.subscriptions = append(m.subscriptions, subscription{filter: filter, handler: handler})
+	m.mu.Unlock()
+
+	id, ack := m.expect()
+	defer m.forget(id)
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, id)
+	writeString(&body, []byte(filter))
+	body.WriteByte(qos)
+	if err := m.write(packetSubscribe, 0x02, body.Bytes()); err != nil {
+		return errors.Trace(err)
+	}
+	code, err := m.wait(ack)
+	if err != nil {
+		return errors.Annotatef(err, "подписка на %s", filter)
+	}
+	if code == 0x80 {
+		return errors.Errorf("брокер отказал в подписке на %s", filter)
+	}
+	return nil
+}
+
+// Disconnect корректно отключается от брокера (завещание не публикуется)
+func (m *Client) Disconnect() error {
+	err := m.write(packetDisconnect, 0, nil)
+	m.close(errors.New("отключено клиентом"))
+	return errors.Trace(err)
+}
+
+// RemoteAddr адрес брокера, с которым установлено соединение
+func (m *Client) RemoteAddr() net.Addr {
+	return m.conn.RemoteAddr()
+}
+
+// Done закрывается при разрыве связи с брокером
+func (m *Client) Done() <-chan struct{} {
+	return m.done
+}
+
+// Err причина разрыва связи с брокером
+func (m *Client) Err() error {
+	select {
+	case <-m.done:
+		return m.err
+	default:
+		return nil
+	}
+}
+
+// Регистрация ожидания подтверждения (PUBACK или SUBACK) нового пакета. В канал передаётся код ответа
+func (m *Client) expect() (uint16, chan byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		m.nextID++
+		if _, busy := m.acks[m.nextID]; m.nextID != 0 && !busy {
+			break
+		}
+	}
+	ack := make(chan byte, 1)
+	m.acks[m.nextID] = ack
+	return m.nextID, ack
+}
+
+// Снятие ожидания подтверждения
+func (m *Client) forget(id uint16) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.acks, id)
+}
+
+// Ожидание подтверждения, возвращает код ответа
+func (m *Client) wait(ack chan byte) (byte, error) {
+	timer := time.NewTimer(m.options.Timeout)
+	defer timer.Stop()
+	select {
+	case code := <-ack:
+		return code, nil
+	case <-m.done:
+		return 0, errors.Annotate(m.err, "нет связи с брокером")
+	case <-timer.C:
+		return 0, errors.New("брокер не подтвердил получение")
+	}
+}
+
+// Запись пакета в подключение
+func (m *Client) write(kind byte, flags byte, body []byte) error {
+	select {
+	case <-m.done:
+		return errors.Annotate(m.err, "нет связи с брокером")
+	default:
+	}
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	_ = m.conn.SetWriteDeadline(time.Now().Add(m.options.Timeout))
+	if err := writePacket(m.conn, kind, flags, body); err != nil {
+		m.close(err)
+		return err
+	}
+	return nil
+}
+
+// Чтение пакетов от брокера. Если за полтора периода проверки связи ничего не пришло (даже PINGRESP),
+// связь считается потерянной
+func (m *Client) readLoop() {
+	for {
+		_ = m.conn.SetReadDeadline(time.Now().Add(m.options.KeepAlive * 3 / 2))
+		p, err := readPacket(m.reader)
+		if err != nil {
+			m.close(err)
+			return
+		}
+		switch p.kind {
+		case packetPuback, packetSuback:
+			d := decoder{body: p.body}
+			id := d.uint16()
+			var code byte
+			if p.kind == packetSuback {
+				code = d.byte()
+			}
+			m.mu.Lock()
+			if ack, ok := m.acks[id]; ok {
+				ack <- code
+				delete(m.acks, id)
+			}
+			m.mu.Unlock()
+		case packetPublish:
+			msg, id, err := decodePublish(p)
+			if err != nil {
+				m.close(err)
+				return
+			}
+			if msg.QoS > 0 {
+				_ = m.write(packetPuback, 0, encodeID(id))
+			}
+			m.mu.Lock()
+			for _, v := range m.subscriptions {
+				if Match(v.filter, msg.Topic) {
+					go v.handler(msg)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// Периодическая отправка PINGREQ
+func (m *Client) pingLoop() {
+	ticker := time.NewTicker(m.options.KeepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			_ = m.write(packetPingreq, 0, nil)
+		}
+	}
+}
+
+// Закрытие подключения с причиной err
+func (m *Client) close(err error) {
+	m.closeOnce.Do(func() {
+		m.err = err
+		close(m.done)
+		_ = m.conn.Close()
+	})
+}
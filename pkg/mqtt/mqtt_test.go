@@ -0,0 +1,95 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"termopad/1/status", "termopad/1/status", true},
+		{"termopad/1/status", "termopad/2/status", false},
+		{"termopad/+/status", "termopad/2/status", true},
+		{"termopad/+/status", "termopad/2/measurement", false},
+		{"termopad/+", "termopad/2/status", false},
+		{"termopad/#", "termopad/2/status", true},
+		{"termopad/#", "termopad", true},
+		{"#", "termopad/2/status", true},
+		{"termopad/1/status/extra", "termopad/1/status", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestClient_Broker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := NewBroker(listener)
+	defer broker.Close()
+
+	ctx := context.Background()
+	publisher, err := Connect(ctx, Options{
+		Address:  broker.Addr(),
+		ClientID: "publisher",
+		Timeout:  5 * time.Second,
+		Will:     &Message{Topic: "server/status", Payload: []byte("offline"), QoS: 1, Retain: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Publish(Message{Topic: "server/status", Payload: []byte("online"), QoS: 1, Retain: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber, err := Connect(ctx, Options{Address: broker.Addr(), ClientID: "subscriber", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Disconnect()
+	received := make(chan Message, 10)
+	if err := subscriber.Subscribe("#", 1, func(msg Message) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	receive := func(want string) {
+		t.Helper()
+		select {
+		case msg := <-received:
+			if string(msg.Payload) != want {
+				t.Errorf("получено %s: %q, want %q", msg.Topic, msg.Payload, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("не получено сообщение %q", want)
+		}
+	}
+
+	// Сохранённое сообщение выдаётся при подписке
+	receive("online")
+
+	if err := publisher.Publish(Message{Topic: "termopad/1/measurement", Payload: []byte("36.6")}); err != nil {
+		t.Fatal(err)
+	}
+	receive("36.6")
+
+	// При обрыве связи брокер публикует завещание
+	_ = publisher.conn.Close()
+	receive("offline")
+	if msg, ok := broker.Retained("server/status"); !ok || string(msg.Payload) != "offline" {
+		t.Errorf("сохранённое сообщение = %q, %v, want offline", msg.Payload, ok)
+	}
+	select {
+	case <-publisher.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("разрыв связи не обнаружен клиентом")
+	}
+}
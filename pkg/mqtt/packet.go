@@ -0,0 +1,215 @@
+// Package mqtt минимальная реализация протокола MQTT 3.1.1: клиент (QoS 0 и 1, сохраняемые сообщения,
+// завещание, подписки) и встраиваемый брокер для тестов и отладки без внешнего сервера
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Типы пакетов MQTT
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+	maxRemainingBytes = 4
+)
+
+// Флаги пакета CONNECT
+const (
+	connectCleanSession = 0x02
+	connectWill         = 0x04
+	connectWillRetain   = 0x20
+	connectPassword     = 0x40
+	connectUsername     = 0x80
+)
+
+// Message сообщение MQTT
+type Message struct {
+	Topic   string
+	Payload []byte
+	// Уровень гарантии доставки: 0 или 1
+	QoS byte
+	// Сообщение сохраняется брокером и выдаётся новым подписчикам
+	Retain bool
+}
+
+// Пакет MQTT: тип, флаги из фиксированного заголовка и тело
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// Чтение пакета
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == maxRemainingBytes {
+			return nil, errors.New("некорректная длина пакета MQTT")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+// Запись пакета
+func writePacket(w io.Writer, kind byte, flags byte, body []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(kind<<4 | flags&0x0f)
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if length == 0 {
+			break
+		}
+	}
+	buf.Write(body)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Запись строки или двоичных данных с длиной
+func writeString(buf *bytes.Buffer, value []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.Write(value)
+}
+
+// Разбор тела пакета. Первая ошибка сохраняется, последующие чтения возвращают нулевые значения
+type decoder struct {
+	body []byte
+	err  error
+}
+
+func (m *decoder) uint16() uint16 {
+	if m.err != nil {
+		return 0
+	}
+	if len(m.body) < 2 {
+		m.err = errors.New("неожиданный конец пакета MQTT")
+		return 0
+	}
+	v := binary.BigEndian.Uint16(m.body)
+	m.body = m.body[2:]
+	return v
+}
+
+func (m *decoder) byte() byte {
+	if m.err != nil {
+		return 0
+	}
+	if len(m.body) < 1 {
+		m.err = errors.New("неожиданный конец пакета MQTT")
+		return 0
+	}
+	v := m.body[0]
+	m.body = m.body[1:]
+	return v
+}
+
+func (m *decoder) bytes() []byte {
+	length := int(m.uint16())
+	if m.err != nil {
+		return nil
+	}
+	if len(m.body) < length {
+		m.err = errors.New("неожиданный конец пакета MQTT")
+		return nil
+	}
+	v := m.body[:length]
+	m.body = m.body[length:]
+	return v
+}
+
+func (m *decoder) string() string {
+	return string(m.bytes())
+}
+
+// Формирование тела пакета PUBLISH и флагов фиксированного заголовка
+func encodePublish(msg Message, id uint16) (byte, []byte) {
+	var buf bytes.Buffer
+	writeString(&buf, []byte(msg.Topic))
+	if msg.QoS > 0 {
+		_ = binary.Write(&buf, binary.BigEndian, id)
+	}
+	buf.Write(msg.Payload)
+	flags := msg.QoS << 1
+	if msg.Retain {
+		flags |= 0x01
+	}
+	return flags, buf.Bytes()
+}
+
+// Разбор пакета PUBLISH
+func decodePublish(p *packet) (Message, uint16, error) {
+	msg := Message{QoS: (p.flags >> 1) & 0x03, Retain: p.flags&0x01 != 0}
+	d := decoder{body: p.body}
+	msg.Topic = d.string()
+	var id uint16
+	if msg.QoS > 0 {
+		id = d.uint16()
+	}
+	if d.err != nil {
+		return msg, 0, d.err
+	}
+	msg.Payload = append([]byte(nil), d.body...)
+	return msg, id, nil
+}
+
+// Тело пакета, состоящее только из идентификатора пакета (PUBACK)
+func encodeID(id uint16) []byte {
+	body := make([]byte, 2)
+	binary.BigEndian.PutUint16(body, id)
+	return body
+}
+
+// Match проверяет, что топик topic подходит под фильтр подписки filter с шаблонами "+" (один уровень)
+// и "#" (все оставшиеся уровни)
+func Match(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
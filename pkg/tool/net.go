@@ -0,0 +1,28 @@
+package tool
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseNetwork разбирает сеть в нотации CIDR ("192.168.10.0/24") или одиночный адрес ("192.168.10.5"),
+// который считается сетью из одного адреса
+func ParseNetwork(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("некорректная сеть \"%s\"", value)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("некорректный адрес \"%s\"", value)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
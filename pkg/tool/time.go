@@ -1,8 +1,59 @@
 package tool
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 // RoundToDate округляет дату в t до круглого дня
 func RoundToDate(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
 }
+
+// Форматы дат в параметрах периода
+const (
+	DateLayout     = "2006-01-02"
+	DateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// ParsePeriod разбирает границы периода from и to в формате DateLayout или DateTimeLayout (местное время) и
+// возвращает полуинтервал [from, to). Если to задан датой, период включает этот день целиком
+func ParsePeriod(from string, to string) (time.Time, time.Time, error) {
+	fromTime, _, err := parseDateTime(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	toTime, dateOnly, err := parseDateTime(to)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if dateOnly {
+		toTime = toTime.AddDate(0, 0, 1)
+	}
+	if !fromTime.Before(toTime) {
+		return time.Time{}, time.Time{}, fmt.Errorf("начало периода %s не раньше его окончания %s", from, to)
+	}
+	return fromTime, toTime, nil
+}
+
+// Разбор даты или даты со временем. dateOnly - значение задано только датой
+func parseDateTime(value string) (t time.Time, dateOnly bool, err error) {
+	value = strings.TrimSpace(value)
+	if t, err = time.ParseInLocation(DateLayout, value, time.Local); err == nil {
+		return t, true, nil
+	}
+	if t, err = time.ParseInLocation(DateTimeLayout, value, time.Local); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("некорректная дата \"%s\", ожидается %s или %s", value, DateLayout, DateTimeLayout)
+}
+
+// ParseClock разбирает время суток value в формате ЧЧ:ММ и возвращает смещение от начала суток
+func ParseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("некорректное время \"%s\", ожидается ЧЧ:ММ", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -47,6 +47,11 @@ func (m *Validator) ValidateWithConform(i interface{}) error {
 	return m.validator.Struct(i)
 }
 
+// Var валидация отдельного значения field по правилам tag (например "required,websocket")
+func (m *Validator) Var(field interface{}, tag string) error {
+	return m.validator.Var(field, tag)
+}
+
 // Get единожды инициализирует и возвращает валидатор
 func Get() *Validator {
 	once.Do(func() {
@@ -0,0 +1,223 @@
+package wiegand
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// Имена встроенных форматов карт
+const (
+	// HID H10301: 26 бит, 8 бит фасалити, 16 бит номера
+	H10301 = "H10301"
+	// 34 бита: 16 бит фасалити, 16 бит номера
+	W34 = "W34"
+	// HID H10304: 37 бит, 16 бит фасалити, 19 бит номера
+	H10304 = "H10304"
+	// Серийный номер карты (CSN) 32 бита без фасалити и битов чётности
+	CSN32 = "CSN32"
+)
+
+// Format формат карты Wiegand.
+//
+// Номер карты (id) - это биты данных кадра без битов чётности, в таком виде его передают термопады
+// и хранит БД. Кадр (frame) - полная посылка Wiegand вместе с битами чётности
+type Format struct {
+	// Имя формата в конфигурации
+	Name string
+	// Описание для интерфейса
+	Description string
+	// Длина кадра в битах
+	Bits int
+	// Разрядность фасалити и номера. Сумма равна количеству бит данных
+	FacilityBits int
+	NumberBits   int
+	// Диапазоны битов кадра (нумерация с 1 от старшего бита), покрываемые битами чётности. Бит
+	// чётной чётности - первый бит кадра, нечётной - последний. Нулевые значения - без чётности
+	EvenFrom, EvenTo int
+	OddFrom, OddTo   int
+}
+
+var (
+	mu       = new(sync.RWMutex)
+	formats  = make(map[string]Format)
+	fallback = H10301
+)
+
+func init() {
+	for _, v := range []Format{
+		{Name: H10301, Description: "HID H10301 26 бит", Bits: 26, FacilityBits: 8, NumberBits: 16, EvenFrom: 2, EvenTo: 13, OddFrom: 14, OddTo: 25},
+		{Name: W34, Description: "Wiegand 34 бита", Bits: 34, FacilityBits: 16, NumberBits: 16, EvenFrom: 2, EvenTo: 17, OddFrom: 18, OddTo: 33},
+		{Name: H10304, Description: "HID H10304 37 бит", Bits: 37, FacilityBits: 16, NumberBits: 19, EvenFrom: 2, EvenTo: 19, OddFrom: 19, OddTo: 36},
+		{Name: CSN32, Description: "Серийный номер карты 32 бита", Bits: 32, NumberBits: 32},
+	} {
+		if err := Register(v); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register добавляет формат карты в реестр или заменяет формат с тем же именем
+func Register(format Format) error {
+	if format.Name == "" {
+		return errors.New("не задано имя формата карты")
+	}
+	dataBits := format.Bits
+	if format.EvenTo != 0 {
+		dataBits--
+	}
+	if format.OddTo != 0 {
+		dataBits--
+	}
+	if format.Bits <= 0 || format.Bits > 64 || format.FacilityBits+format.NumberBits != dataBits {
+		return errors.Errorf("формат карты %s: разрядность фасалити и номера не соответствует длине кадра", format.Name)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	formats[strings.ToUpper(format.Name)] = format
+	return nil
+}
+
+// Lookup возвращает формат по имени (без учёта регистра). Пустое имя - формат по умолчанию
+func Lookup(name string) (Format, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if name == "" {
+		name = fallback
+	}
+	format, ok := formats[strings.ToUpper(name)]
+	if !ok {
+		return Format{}, errors.Errorf("неизвестный формат карты \"%s\"", name)
+	}
+	return format, nil
+}
+
+// Get возвращает формат по имени, а если он неизвестен - формат по умолчанию
+func Get(name string) Format {
+	if format, err := Lookup(name); err == nil {
+		return format
+	}
+	format, _ := Lookup("")
+	return format
+}
+
+// SetDefault устанавливает формат, используемый для карт без явно указанного формата
+func SetDefault(name string) error {
+	format, err := Lookup(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	fallback = format.Name
+	return nil
+}
+
+// Default имя формата по умолчанию
+func Default() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return fallback
+}
+
+// Names имена всех зарегистрированных форматов
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make([]string, 0, len(formats))
+	for _, v := range formats {
+		result = append(result, v.Name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Split разбирает номер карты на фасалити и номер. Старшие биты, не помещающиеся в формат,
+// остаются в фасалити, чтобы номер карты не терялся
+func (m Format) Split(id uint64) (facility uint64, number uint64) {
+	return id >> uint(m.NumberBits), id & mask(m.NumberBits)
+}
+
+// Join собирает номер карты из фасалити и номера
+func (m Format) Join(facility uint64, number uint64) (uint64, error) {
+	if facility > mask(m.FacilityBits) {
+		return 0, errors.Errorf("фасалити %d не помещается в формат %s", facility, m.Name)
+	}
+	if number > mask(m.NumberBits) {
+		return 0, errors.Errorf("номер %d не помещается в формат %s", number, m.Name)
+	}
+	return facility<<uint(m.NumberBits) | number, nil
+}
+
+// Check проверяет, что номер карты помещается в формат
+func (m Format) Check(id uint64) error {
+	if id > mask(m.FacilityBits+m.NumberBits) {
+		return errors.Errorf("номер карты %d не помещается в формат %s", id, m.Name)
+	}
+	return nil
+}
+
+// Encode формирует кадр из номера карты, вычисляя биты чётности
+func (m Format) Encode(id uint64) (uint64, error) {
+	if err := m.Check(id); err != nil {
+		return 0, errors.Trace(err)
+	}
+	frame := id
+	if m.OddTo != 0 {
+		frame <<= 1
+	}
+	if m.EvenTo != 0 && bits.OnesCount64(m.rangeBits(frame, m.EvenFrom, m.EvenTo))%2 == 1 {
+		frame |= 1 << uint(m.Bits-1)
+	}
+	if m.OddTo != 0 && bits.OnesCount64(m.rangeBits(frame, m.OddFrom, m.OddTo))%2 == 0 {
+		frame |= 1
+	}
+	return frame, nil
+}
+
+// Decode проверяет биты чётности кадра и возвращает номер карты
+func (m Format) Decode(frame uint64) (uint64, error) {
+	if frame > mask(m.Bits) {
+		return 0, errors.Errorf("кадр 0x%X длиннее %d бит формата %s", frame, m.Bits, m.Name)
+	}
+	if m.EvenTo != 0 {
+		ones := bits.OnesCount64(m.rangeBits(frame, m.EvenFrom, m.EvenTo)) + int(frame>>uint(m.Bits-1))
+		if ones%2 != 0 {
+			return 0, errors.Errorf("ошибка чётной чётности кадра 0x%X формата %s", frame, m.Name)
+		}
+	}
+	if m.OddTo != 0 {
+		ones := bits.OnesCount64(m.rangeBits(frame, m.OddFrom, m.OddTo)) + int(frame&1)
+		if ones%2 != 1 {
+			return 0, errors.Errorf("ошибка нечётной чётности кадра 0x%X формата %s", frame, m.Name)
+		}
+		frame >>= 1
+	}
+	return frame & mask(m.FacilityBits+m.NumberBits), nil
+}
+
+// Display строка для отображения номера карты: фасалити и номер через дефис, для форматов
+// без фасалити - шестнадцатеричный номер
+func (m Format) Display(id uint64) string {
+	if m.FacilityBits == 0 {
+		return fmt.Sprintf("%0*X", (m.NumberBits+3)/4, id)
+	}
+	facility, number := m.Split(id)
+	return fmt.Sprintf("%d-%d", facility, number)
+}
+
+// Биты кадра с from по to включительно (нумерация с 1 от старшего бита)
+func (m Format) rangeBits(frame uint64, from, to int) uint64 {
+	return frame >> uint(m.Bits-to) & mask(to-from+1)
+}
+
+func mask(bits int) uint64 {
+	if bits >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<uint(bits) - 1
+}
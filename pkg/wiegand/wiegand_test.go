@@ -0,0 +1,126 @@
+package wiegand
+
+import (
+	"testing"
+)
+
+func TestFormat_EncodeDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		facility  uint64
+		number    uint64
+		wantFrame uint64
+	}{
+		{
+			name:      "H10301 нулевая карта",
+			format:    H10301,
+			wantFrame: 0x1,
+		},
+		{
+			name:      "H10301 фасалити 1 номер 1",
+			format:    H10301,
+			facility:  1,
+			number:    1,
+			wantFrame: 0x2020002,
+		},
+		{
+			name:      "H10301 максимальные значения",
+			format:    H10301,
+			facility:  255,
+			number:    65535,
+			wantFrame: 0x1FFFFFF,
+		},
+		{
+			name:     "W34",
+			format:   W34,
+			facility: 4660,
+			number:   22136,
+		},
+		{
+			name:     "H10304 19-битный номер",
+			format:   H10304,
+			facility: 65535,
+			number:   524287,
+		},
+		{
+			name:      "CSN32 без битов чётности",
+			format:    CSN32,
+			number:    0xDEADBEEF,
+			wantFrame: 0xDEADBEEF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := Lookup(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := format.Join(tt.facility, tt.number)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame, err := format.Encode(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantFrame != 0 && frame != tt.wantFrame {
+				t.Errorf("Encode() = 0x%X, want 0x%X", frame, tt.wantFrame)
+			}
+			if frame >= 1<<uint(format.Bits) {
+				t.Errorf("Encode() = 0x%X длиннее %d бит", frame, format.Bits)
+			}
+
+			got, err := format.Decode(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			facility, number := format.Split(got)
+			if facility != tt.facility || number != tt.number {
+				t.Errorf("Decode() = %d-%d, want %d-%d", facility, number, tt.facility, tt.number)
+			}
+
+			// Любой искажённый бит данных должен обнаруживаться проверкой чётности
+			if format.EvenTo != 0 {
+				for bit := 1; bit < format.Bits-1; bit++ {
+					if _, err := format.Decode(frame ^ 1<<uint(bit)); err == nil {
+						t.Errorf("Decode() не обнаружил искажение бита %d", bit)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFormat_Display(t *testing.T) {
+	tests := []struct {
+		format string
+		id     uint64
+		want   string
+	}{
+		{H10301, 1<<16 | 1234, "1-1234"},
+		{H10304, 3<<19 | 500000, "3-500000"},
+		{CSN32, 0xABC, "00000ABC"},
+		// Номер, не помещающийся в формат, отображается без потери старших битов
+		{W34, 1 << 40, "16777216-0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := Get(tt.format).Display(tt.id); got != tt.want {
+				t.Errorf("Display() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if _, err := Lookup("h10301"); err != nil {
+		t.Errorf("Lookup() без учёта регистра: %v", err)
+	}
+	if _, err := Lookup("H99999"); err == nil {
+		t.Error("Lookup() неизвестного формата без ошибки")
+	}
+	if got := Get("H99999").Name; got != Default() {
+		t.Errorf("Get() неизвестного формата = %s, want %s", got, Default())
+	}
+}
@@ -0,0 +1,504 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirsrus/termopad-server/controller"
+	"github.com/kirsrus/termopad-server/model"
+	mqttClient "github.com/kirsrus/termopad-server/pkg/mqtt"
+	"github.com/kirsrus/termopad-server/service"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Ёмкость очереди публикаций
+	queueCapacity = 100
+	// Период проверки связи с брокером
+	keepAlive = 30 * time.Second
+	// Пауза перед повторным подключением к брокеру
+	reconnect = 10 * time.Second
+	// Ограничение времени подключения и ожидания подтверждений
+	timeout = 10 * time.Second
+
+	clientID = "termopad-server"
+	// Шаблоны топиков; {id} заменяется идентификатором термопада
+	topicMeasurement = "termopad/{id}/measurement"
+	topicStatus      = "termopad/{id}/status"
+	topicServer      = "termopad/server/status"
+	// Топик ответов на команды: топик управления с этим суффиксом
+	replySuffix = "/reply"
+
+	// Состояние сервера в топике topicServer
+	serverOnline  = "online"
+	serverOffline = "offline"
+
+	// Команды, принимаемые в топике управления
+	commandSyncPersons   = "sync-persons"
+	commandSetThresholds = "set-thresholds"
+	commandStatus        = "status"
+)
+
+// MQTT публикация замеров и состояния термопадов в брокер MQTT. Имплементирует интерфейс MQTTSvc.
+// Инициализируется через NewMQTT. Связь с брокером поддерживается в фоне с переподключением; пока
+// связи нет, замеры не публикуются, а состояние термопадов публикуется после подключения. Состояние
+// термопадов и самого сервера публикуется сохраняемыми сообщениями, при обрыве связи брокер
+// публикует состояние сервера "offline" (завещание)
+type MQTT struct {
+	ctx    context.Context
+	log    *logrus.Entry
+	cancel context.CancelFunc
+
+	options   mqttClient.Options
+	qos       byte
+	reconnect time.Duration
+
+	topicMeasurement string
+	topicStatus      string
+	topicServer      string
+	topicControl     string
+
+	thresholdsSvc service.ThresholdsSvc
+	personSyncCtl controller.PersonSyncCtl
+	dbStore       store.DbStore
+
+	queue chan mqttClient.Message
+
+	mu     sync.Mutex
+	client *mqttClient.Client
+	// Последнее известное состояние термопадов: публикуется заново после переподключения
+	status map[uint]statusPayload
+	// Завершение фоновых обработчиков
+	done chan struct{}
+}
+
+// ConfigMQTT конфигурация MQTT
+type ConfigMQTT struct {
+	Log *logrus.Logger
+	// Адрес брокера: mqtt://host:port или mqtts://host:port (tcp:// и ssl:// соответственно). Пустой
+	// адрес отключает публикацию
+	Address  string
+	ClientID string
+	Username string
+	Password string
+	// Сертификат удостоверяющего центра брокера в формате PEM (пустой - системные сертификаты)
+	CAFile string
+	// Не проверять сертификат брокера
+	InsecureSkipVerify bool
+	// Уровень гарантии доставки публикаций: 0 или 1
+	QoS byte
+	// Период проверки связи с брокером
+	KeepAlive time.Duration
+	// Пауза перед повторным подключением
+	Reconnect time.Duration
+	// Шаблоны топиков замеров и состояния термопадов, в них {id} заменяется идентификатором термопада
+	TopicMeasurement string
+	TopicStatus      string
+	// Топик состояния самого сервера
+	TopicServer string
+	// Топик команд управления (пустой - команды не принимаются). Ответы публикуются в <топик>/reply
+	TopicControl string
+	// Сервис порогов температуры для команды set-thresholds
+	ThresholdsSvc service.ThresholdsSvc
+	// Контроллер синхронизации персон для команды sync-persons (может отсутствовать)
+	PersonSyncCtl controller.PersonSyncCtl
+	// БД для записи выполненных команд управления в журнал аудита (обязательна при заданном TopicControl)
+	DbStore store.DbStore
+}
+
+// NewMQTT конструктор MQTT
+func NewMQTT(ctx context.Context, config *ConfigMQTT) (service.MQTTSvc, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if config.QoS > 1 {
+		return nil, errors.Errorf("уровень QoS %d не поддерживается", config.QoS)
+	}
+	if config.TopicControl != "" && config.DbStore == nil {
+		return nil, errors.New("не указана служба dbStore для журнала аудита команд управления")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	mqtt := &MQTT{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "mqtt",
+			"scope":  "service",
+		}),
+		cancel:           cancel,
+		qos:              config.QoS,
+		reconnect:        reconnect,
+		topicMeasurement: topicMeasurement,
+		topicStatus:      topicStatus,
+		topicServer:      topicServer,
+		topicControl:     config.TopicControl,
+		thresholdsSvc:    config.ThresholdsSvc,
+		personSyncCtl:    config.PersonSyncCtl,
+		dbStore:          config.DbStore,
+		queue:            make(chan mqttClient.Message, queueCapacity),
+		status:           make(map[uint]statusPayload),
+		done:             make(chan struct{}),
+	}
+	if config.Reconnect != 0 {
+		mqtt.reconnect = config.Reconnect
+	}
+	if config.TopicMeasurement != "" {
+		mqtt.topicMeasurement = config.TopicMeasurement
+	}
+	if config.TopicStatus != "" {
+		mqtt.topicStatus = config.TopicStatus
+	}
+	if config.TopicServer != "" {
+		mqtt.topicServer = config.TopicServer
+	}
+
+	// Публикация отключена
+	if config.Address == "" {
+		close(mqtt.done)
+		return mqtt, nil
+	}
+
+	address, tlsConfig, err := parseAddress(config.Address, config.CAFile, config.InsecureSkipVerify)
+	if err != nil {
+		cancel()
+		return nil, errors.Trace(err)
+	}
+	mqtt.options = mqttClient.Options{
+		Address:   address,
+		TLS:       tlsConfig,
+		ClientID:  clientID,
+		Username:  config.Username,
+		Password:  config.Password,
+		KeepAlive: keepAlive,
+		Timeout:   timeout,
+		Will: &mqttClient.Message{
+			Topic:   mqtt.topicServer,
+			Payload: []byte(serverOffline),
+			QoS:     mqtt.qos,
+			Retain:  true,
+		},
+	}
+	if config.ClientID != "" {
+		mqtt.options.ClientID = config.ClientID
+	}
+	if config.KeepAlive != 0 {
+		mqtt.options.KeepAlive = config.KeepAlive
+	}
+
+	go mqtt.loop()
+
+	return mqtt, nil
+}
+
+// Разбор адреса брокера: адрес host:port и параметры TLS для защищённого подключения
+func parseAddress(address string, caFile string, insecureSkipVerify bool) (string, *tls.Config, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", nil, errors.Annotatef(err, "некорректный адрес брокера MQTT %s", address)
+	}
+	var port string
+	var secure bool
+	switch strings.ToLower(u.Scheme) {
+	case "mqtt", "tcp":
+		port = "1883"
+	case "mqtts", "ssl":
+		port, secure = "8883", true
+	default:
+		return "", nil, errors.Errorf("неизвестная схема адреса брокера MQTT %s", address)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	host := net.JoinHostPort(u.Hostname(), port)
+	if !secure {
+		return host, nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return "", nil, errors.Annotate(err, "ошибка чтения сертификата удостоверяющего центра MQTT")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return "", nil, errors.Errorf("в файле %s нет сертификатов", caFile)
+		}
+	}
+	return host, tlsConfig, nil
+}
+
+// Measurement публикует принятый замер температуры без ожидания. Если связи с брокером нет или очередь
+// переполнена, замер не публикуется
+func (m *MQTT) Measurement(change model.TemperatureChange) {
+	if m.options.Address == "" {
+		return
+	}
+	payload, err := json.Marshal(newMeasurementPayload(change))
+	if err != nil {
+		m.log.Error(errors.Annotate(err, "ошибка формирования замера"))
+		return
+	}
+	m.mu.Lock()
+	connected := m.client != nil
+	m.mu.Unlock()
+	if !connected {
+		return
+	}
+	m.enqueue(mqttClient.Message{
+		Topic:   m.topic(m.topicMeasurement, change.ID),
+		Payload: payload,
+		QoS:     m.qos,
+	})
+}
+
+// TermopadStatus публикует состояние связи с термопадом сохраняемым сообщением. Последнее состояние
+// каждого термопада публикуется заново после переподключения к брокеру
+func (m *MQTT) TermopadStatus(termopadID uint, online bool) {
+	if m.options.Address == "" {
+		return
+	}
+	status := statusPayload{TermopadID: termopadID, Online: online, At: time.Now()}
+	m.mu.Lock()
+	m.status[termopadID] = status
+	connected := m.client != nil
+	m.mu.Unlock()
+	if connected {
+		m.enqueue(m.statusMessage(status))
+	}
+}
+
+// Close публикует состояние сервера "offline" и отключается от брокера, но не дольше ctx
+func (m *MQTT) Close(ctx context.Context) error {
+	m.cancel()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("истекло время отключения от брокера MQTT")
+	}
+}
+
+// Постановка сообщения в очередь публикации без ожидания
+func (m *MQTT) enqueue(msg mqttClient.Message) {
+	select {
+	case m.queue <- msg:
+	default:
+		m.log.Warnf("очередь публикации MQTT переполнена, сообщение в %s отброшено", msg.Topic)
+	}
+}
+
+// Топик по шаблону pattern для термопада termopadID
+func (m *MQTT) topic(pattern string, termopadID uint) string {
+	return strings.ReplaceAll(pattern, "{id}", strconv.FormatUint(uint64(termopadID), 10))
+}
+
+// Сообщение о состоянии термопада
+func (m *MQTT) statusMessage(status statusPayload) mqttClient.Message {
+	payload, _ := json.Marshal(status)
+	return mqttClient.Message{
+		Topic:   m.topic(m.topicStatus, status.TermopadID),
+		Payload: payload,
+		QoS:     m.qos,
+		Retain:  true,
+	}
+}
+
+// Поддержание связи с брокером: подключение, публикация из очереди и переподключение после обрыва
+func (m *MQTT) loop() {
+	defer close(m.done)
+	for {
+		client, err := m.connect()
+		if err != nil {
+			m.log.Warnf("нет связи с брокером MQTT: %v", err)
+		} else {
+			m.log.Infof("установлена связь с брокером MQTT %s", m.options.Address)
+			m.serve(client)
+			m.mu.Lock()
+			m.client = nil
+			m.mu.Unlock()
+			if m.ctx.Err() != nil {
+				m.disconnect(client)
+				return
+			}
+			m.log.Warnf("потеряна связь с брокером MQTT: %v", client.Err())
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(m.reconnect):
+		}
+	}
+}
+
+// Подключение к брокеру: публикация состояния сервера и термопадов, подписка на команды
+func (m *MQTT) connect() (*mqttClient.Client, error) {
+	client, err := mqttClient.Connect(m.ctx, m.options)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := client.Publish(mqttClient.Message{
+		Topic:   m.topicServer,
+		Payload: []byte(serverOnline),
+		QoS:     m.qos,
+		Retain:  true,
+	}); err != nil {
+		_ = client.Disconnect()
+		return nil, errors.Trace(err)
+	}
+	if m.topicControl != "" {
+		handler := func(msg mqttClient.Message) { m.command(client, msg) }
+		if err := client.Subscribe(m.topicControl, m.qos, handler); err != nil {
+			_ = client.Disconnect()
+			return nil, errors.Trace(err)
+		}
+	}
+
+	m.mu.Lock()
+	m.client = client
+	for _, status := range m.status {
+		m.enqueue(m.statusMessage(status))
+	}
+	m.mu.Unlock()
+	return client, nil
+}
+
+// Публикация сообщений из очереди до обрыва связи или завершения работы
+func (m *MQTT) serve(client *mqttClient.Client) {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-client.Done():
+			return
+		case msg := <-m.queue:
+			if err := client.Publish(msg); err != nil {
+				m.log.Warnf("ошибка публикации в %s: %v", msg.Topic, err)
+			}
+		}
+	}
+}
+
+// Отключение при завершении работы: оставшиеся в очереди сообщения публикуются, состояние сервера
+// меняется на "offline"
+func (m *MQTT) disconnect(client *mqttClient.Client) {
+	for len(m.queue) > 0 {
+		msg := <-m.queue
+		if err := client.Publish(msg); err != nil {
+			m.log.Warnf("ошибка публикации в %s: %v", msg.Topic, err)
+		}
+	}
+	if err := client.Publish(mqttClient.Message{
+		Topic:   m.topicServer,
+		Payload: []byte(serverOffline),
+		QoS:     m.qos,
+		Retain:  true,
+	}); err != nil {
+		m.log.Warnf("ошибка публикации состояния сервера: %v", err)
+	}
+	if err := client.Disconnect(); err != nil {
+		m.log.Warnf("ошибка отключения от брокера MQTT: %v", err)
+	}
+}
+
+// Обработка команды, полученной через подключение client из топика управления. Ответ публикуется в топик ответов
+func (m *MQTT) command(client *mqttClient.Client, msg mqttClient.Message) {
+	var request commandRequest
+	reply := commandReply{}
+	if err := json.Unmarshal(msg.Payload, &request); err != nil {
+		reply.Error = "некорректная команда: " + err.Error()
+	} else {
+		reply.ID, reply.Command = request.ID, request.Command
+		m.log.Infof("получена команда MQTT %s", request.Command)
+		reply.Result, err = m.execute(client, request)
+		if err != nil {
+			m.log.Warnf("ошибка выполнения команды MQTT %s: %v", request.Command, err)
+			reply.Error = err.Error()
+		}
+	}
+	reply.OK = reply.Error == ""
+
+	payload, _ := json.Marshal(reply)
+	m.enqueue(mqttClient.Message{Topic: m.topicControl + replySuffix, Payload: payload, QoS: m.qos})
+}
+
+// Запись в журнал аудита выполненной команды управления. Изменение уже произошло, поэтому ошибка записи
+// только логируется
+func (m *MQTT) audit(client *mqttClient.Client, action string, target string, details string) {
+	ip := client.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	err := m.dbStore.SetAudit(model.Audit{
+		CreateAt: time.Now(),
+		User:     "mqtt:" + m.options.ClientID,
+		Action:   action,
+		Target:   target,
+		IP:       ip,
+		Details:  details,
+	})
+	if err != nil {
+		m.log.Warnf("ошибка записи в журнал аудита: %v", err)
+	}
+}
+
+// Выполнение команды. Изменения записываются в журнал аудита от имени клиента MQTT сервера с адресом брокера,
+// через который получена команда (автор сообщения протоколом MQTT не передаётся)
+func (m *MQTT) execute(client *mqttClient.Client, request commandRequest) (interface{}, error) {
+	switch request.Command {
+	case commandSyncPersons:
+		if m.personSyncCtl == nil {
+			return nil, errors.New("синхронизация персон недоступна")
+		}
+		record, err := m.personSyncCtl.Sync()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		m.audit(client, model.AuditSyncPersons, strconv.Itoa(int(record.ID)), "")
+		return syncPayload{ID: record.ID, Status: record.Status}, nil
+	case commandSetThresholds:
+		if m.thresholdsSvc == nil {
+			return nil, errors.New("изменение порогов температуры недоступно")
+		}
+		if request.Max == nil || request.Min == nil {
+			return nil, errors.New("не заданы пороги max и min")
+		}
+		thresholds := model.Thresholds{MaxTemperature: *request.Max, MinTemperature: *request.Min}
+		if err := m.thresholdsSvc.SetThresholds(thresholds); err != nil {
+			return nil, errors.Trace(err)
+		}
+		m.audit(client, model.AuditSetThresholds, "",
+			"max="+strconv.FormatFloat(*request.Max, 'f', 1, 64)+" min="+strconv.FormatFloat(*request.Min, 'f', 1, 64))
+		return thresholdsPayload{Max: thresholds.MaxTemperature, Min: thresholds.MinTemperature}, nil
+	case commandStatus:
+		m.mu.Lock()
+		result := make([]statusPayload, 0, len(m.status))
+		for _, v := range m.status {
+			result = append(result, v)
+		}
+		m.mu.Unlock()
+		return result, nil
+	default:
+		return nil, errors.Errorf("неизвестная команда \"%s\"", request.Command)
+	}
+}
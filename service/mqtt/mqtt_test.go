@@ -0,0 +1,300 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	mqttClient "github.com/kirsrus/termopad-server/pkg/mqtt"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Пороги температуры без хранения в БД
+type thresholdsStub struct {
+	mu         sync.Mutex
+	thresholds model.Thresholds
+}
+
+func (m *thresholdsStub) Thresholds() model.Thresholds {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.thresholds
+}
+
+func (m *thresholdsStub) TermopadThresholds(model.TermopadInfo) model.Thresholds {
+	return m.Thresholds()
+}
+
+func (m *thresholdsStub) SetThresholds(thresholds model.Thresholds) error {
+	if err := thresholds.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.thresholds = thresholds
+	return nil
+}
+
+// Журнал аудита в памяти. Остальные методы БД не используются
+type auditStub struct {
+	store.DbStore
+
+	mu     sync.Mutex
+	audits []model.Audit
+}
+
+func (m *auditStub) SetAudit(audit model.Audit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audits = append(m.audits, audit)
+	return nil
+}
+
+func (m *auditStub) Audits() []model.Audit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]model.Audit{}, m.audits...)
+}
+
+// Синхронизация персон, которая всегда запускается
+type personSyncStub struct{}
+
+func (personSyncStub) Sync() (*model.PersonSync, error) {
+	return &model.PersonSync{ID: 7, Status: model.PersonSyncRunning}, nil
+}
+
+func (personSyncStub) Current() *model.PersonSync { return nil }
+
+func (personSyncStub) History(uint) ([]model.PersonSync, error) { return nil, nil }
+
+func (personSyncStub) EmmitProgress() (*model.PersonSync, error) { return nil, nil }
+
+func TestMQTT(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := mqttClient.NewBroker(listener)
+	defer broker.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscriber, err := mqttClient.Connect(ctx, mqttClient.Options{Address: broker.Addr(), ClientID: "subscriber"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscriber.Disconnect()
+	received := make(chan mqttClient.Message, 10)
+	if err := subscriber.Subscribe("termopad/+/measurement", 1, func(msg mqttClient.Message) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	if err := subscriber.Subscribe("termopad/control/reply", 1, func(msg mqttClient.Message) { received <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	receive := func() mqttClient.Message {
+		t.Helper()
+		select {
+		case msg := <-received:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("сообщение не получено")
+		}
+		return mqttClient.Message{}
+	}
+
+	thresholds := &thresholdsStub{}
+	audit := &auditStub{}
+	mqttSvc, err := NewMQTT(ctx, &ConfigMQTT{
+		Address:       "mqtt://" + broker.Addr(),
+		QoS:           1,
+		Reconnect:     10 * time.Millisecond,
+		TopicControl:  "termopad/control",
+		ThresholdsSvc: thresholds,
+		DbStore:       audit,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Состояние термопада публикуется после подключения к брокеру
+	mqttSvc.TermopadStatus(1, true)
+	var status statusPayload
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if msg, ok := broker.Retained("termopad/1/status"); ok {
+			if err := json.Unmarshal(msg.Payload, &status); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("состояние термопада не опубликовано")
+		}
+	}
+	if status.TermopadID != 1 || !status.Online {
+		t.Errorf("состояние термопада = %+v", status)
+	}
+	if msg, ok := broker.Retained("termopad/server/status"); !ok || string(msg.Payload) != serverOnline {
+		t.Errorf("состояние сервера = %q, want %s", msg.Payload, serverOnline)
+	}
+
+	mqttSvc.Measurement(model.TemperatureChange{
+		ID:          2,
+		Temperature: 36.6,
+		Wigand:      model.NewWigand(100),
+		NameLast:    "Иванов",
+	})
+	msg := receive()
+	var measurement measurementPayload
+	if err := json.Unmarshal(msg.Payload, &measurement); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Topic != "termopad/2/measurement" || measurement.Temperature != 36.6 || measurement.Wigand != 100 ||
+		measurement.Family != "Иванов" {
+		t.Errorf("замер в %s = %s", msg.Topic, msg.Payload)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		wantOK  bool
+	}{
+		{
+			name:    "изменение порогов",
+			command: `{"id":"1","command":"set-thresholds","max":37.2,"min":35.1}`,
+			wantOK:  true,
+		},
+		{
+			name:    "синхронизация персон недоступна",
+			command: `{"id":"2","command":"sync-persons"}`,
+		},
+		{
+			name:    "неизвестная команда",
+			command: `{"id":"3","command":"reboot"}`,
+		},
+		{
+			name:    "некорректная команда",
+			command: `reboot`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := subscriber.Publish(mqttClient.Message{Topic: "termopad/control", Payload: []byte(tt.command), QoS: 1}); err != nil {
+				t.Fatal(err)
+			}
+			msg := receive()
+			var reply commandReply
+			if err := json.Unmarshal(msg.Payload, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if reply.OK != tt.wantOK || (!tt.wantOK && reply.Error == "") {
+				t.Errorf("ответ = %s", msg.Payload)
+			}
+		})
+	}
+	if got := thresholds.Thresholds(); got.MaxTemperature != 37.2 || got.MinTemperature != 35.1 {
+		t.Errorf("пороги = %+v", got)
+	}
+	// В журнал аудита попадает только выполненная команда
+	if audits := audit.Audits(); len(audits) != 1 || audits[0].Action != model.AuditSetThresholds ||
+		audits[0].User != "mqtt:"+clientID || audits[0].IP != "127.0.0.1" || audits[0].Details != "max=37.2 min=35.1" {
+		t.Errorf("журнал аудита = %+v", audits)
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer closeCancel()
+	if err := mqttSvc.Close(closeCtx); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := broker.Retained("termopad/server/status"); !ok || string(msg.Payload) != serverOffline {
+		t.Errorf("состояние сервера после отключения = %q, want %s", msg.Payload, serverOffline)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address    string
+		wantHost   string
+		wantSecure bool
+		wantErr    bool
+	}{
+		{address: "mqtt://broker", wantHost: "broker:1883"},
+		{address: "tcp://broker:1884", wantHost: "broker:1884"},
+		{address: "mqtts://broker", wantHost: "broker:8883", wantSecure: true},
+		{address: "ssl://broker:443", wantHost: "broker:443", wantSecure: true},
+		{address: "http://broker", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, tlsConfig, err := parseAddress(tt.address, "", false)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAddress(%s) error = %v, wantErr %v", tt.address, err, tt.wantErr)
+			continue
+		}
+		if host != tt.wantHost || (tlsConfig != nil) != tt.wantSecure {
+			t.Errorf("parseAddress(%s) = %s, %v", tt.address, host, tlsConfig != nil)
+		}
+	}
+}
+
+func TestMQTT_execute(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := mqttClient.NewBroker(listener)
+	defer broker.Close()
+	client, err := mqttClient.Connect(context.Background(), mqttClient.Options{Address: broker.Addr(), ClientID: "control"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect()
+
+	max, min := 37.4, 35.2
+	tests := []struct {
+		name    string
+		request commandRequest
+		want    model.Audit
+	}{
+		{
+			name:    "синхронизация персон",
+			request: commandRequest{Command: commandSyncPersons},
+			want:    model.Audit{User: "mqtt:lobby", Action: model.AuditSyncPersons, Target: "7", IP: "127.0.0.1"},
+		},
+		{
+			name:    "изменение порогов",
+			request: commandRequest{Command: commandSetThresholds, Max: &max, Min: &min},
+			want: model.Audit{User: "mqtt:lobby", Action: model.AuditSetThresholds, IP: "127.0.0.1",
+				Details: "max=37.4 min=35.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audit := &auditStub{}
+			m := &MQTT{
+				log:           logrus.NewEntry(logrus.New()),
+				options:       mqttClient.Options{ClientID: "lobby"},
+				thresholdsSvc: &thresholdsStub{},
+				personSyncCtl: personSyncStub{},
+				dbStore:       audit,
+			}
+			if _, err := m.execute(client, tt.request); err != nil {
+				t.Fatal(err)
+			}
+			audits := audit.Audits()
+			if len(audits) != 1 {
+				t.Fatalf("записей в журнале аудита %d, want 1", len(audits))
+			}
+			got := audits[0]
+			got.CreateAt = time.Time{}
+			if got != tt.want {
+				t.Errorf("журнал аудита = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
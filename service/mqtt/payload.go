@@ -0,0 +1,80 @@
+package mqtt
+
+import (
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+)
+
+// Замер температуры в топике замеров. Для неизвестных карт данные персоны не заполняются
+type measurementPayload struct {
+	TermopadID   uint      `json:"termopad_id"`
+	CreateAt     time.Time `json:"created_at"`
+	Temperature  float64   `json:"temperature"`
+	Alarm        bool      `json:"alarm"`
+	Invalid      bool      `json:"invalid"`
+	Image        string    `json:"image,omitempty"`
+	Wigand       uint      `json:"wigand"`
+	Family       string    `json:"family,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	MiddleName   string    `json:"middle_name,omitempty"`
+	Organization string    `json:"organization,omitempty"`
+	Department   string    `json:"department,omitempty"`
+	Position     string    `json:"position,omitempty"`
+}
+
+func newMeasurementPayload(change model.TemperatureChange) measurementPayload {
+	return measurementPayload{
+		TermopadID:   change.ID,
+		CreateAt:     change.CreateAt,
+		Temperature:  change.Temperature,
+		Alarm:        change.Alarm,
+		Invalid:      change.Invalid,
+		Image:        change.Image,
+		Wigand:       change.Wigand.ID,
+		Family:       change.NameLast,
+		Name:         change.NameFirst,
+		MiddleName:   change.NameMiddle,
+		Organization: change.Organization,
+		Department:   change.Departament,
+		Position:     change.Postion,
+	}
+}
+
+// Состояние связи с термопадом в топике состояния
+type statusPayload struct {
+	TermopadID uint      `json:"termopad_id"`
+	Online     bool      `json:"online"`
+	At         time.Time `json:"at"`
+}
+
+// Команда в топике управления
+type commandRequest struct {
+	// Идентификатор команды, возвращается в ответе
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	// Пороги температуры для команды set-thresholds
+	Max *float64 `json:"max"`
+	Min *float64 `json:"min"`
+}
+
+// Ответ на команду
+type commandReply struct {
+	ID      string      `json:"id,omitempty"`
+	Command string      `json:"command,omitempty"`
+	OK      bool        `json:"ok"`
+	Error   string      `json:"error,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// Результат команды sync-persons
+type syncPayload struct {
+	ID     uint   `json:"sync_id"`
+	Status string `json:"status"`
+}
+
+// Результат команды set-thresholds
+type thresholdsPayload struct {
+	Max float64 `json:"max"`
+	Min float64 `json:"min"`
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	htmlTemplate "html/template"
+	"io/ioutil"
+	"net/mail"
+	"sort"
+	"strings"
+	textTemplate "text/template"
+
+	"github.com/kirsrus/termopad-server/model"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Имя вложения с изображением замера (на него ссылается HTML письма через cid:)
+	imageContentID  = "measurement"
+	templateSubject = `Повышенная температура {{printf "%0.1f" .Temperature}}° - {{.Termopad.Name}}`
+	templateBody    = `<html><body>
+<h2 style="color:#c00">Повышенная температура {{printf "%0.1f" .Temperature}}°</h2>
+<table>
+<tr><td>Время</td><td>{{.CreateAt.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><td>Кабина</td><td>{{.Termopad.Name}}</td></tr>
+<tr><td>Норма</td><td>{{printf "%0.1f" .Thresholds.MinTemperature}}° - {{printf "%0.1f" .Thresholds.MaxTemperature}}°</td></tr>
+{{if .Person.Family}}<tr><td>ФИО</td><td>{{.Person.Family}} {{.Person.Name}} {{.Person.MiddleName}}</td></tr>
+<tr><td>Организация</td><td>{{.Person.Organization}}</td></tr>
+<tr><td>Подразделение</td><td>{{.Person.Department}}</td></tr>
+<tr><td>Должность</td><td>{{.Person.Position}}</td></tr>
+{{end}}<tr><td>Карта</td><td>{{.Person.Wigand.Display}}</td></tr>
+</table>
+{{if .ImageName}}<p><img src="cid:` + imageContentID + `" alt="изображение замера"></p>{{end}}
+</body></html>`
+)
+
+// Email оповещение о тревогах по электронной почте. Имплементирует интерфейс Notifier. Инициализируется
+// через NewEmail
+type Email struct {
+	ctx context.Context
+	log *logrus.Entry
+
+	smtp       *smtpSender
+	recipients []Recipients
+	subject    *textTemplate.Template
+	body       *htmlTemplate.Template
+}
+
+// ConfigEmail конфигурация Email
+type ConfigEmail struct {
+	Log  *logrus.Logger
+	SMTP ConfigSMTP
+	// Получатели оповещений по организациям и подразделениям (используются адреса Emails)
+	Recipients []Recipients
+	// Шаблоны темы (text/template) и содержимого (html/template) письма. В шаблон передаётся
+	// model.Alarm. Пустой шаблон заменяется встроенным
+	TemplateSubject string
+	TemplateBody    string
+}
+
+// NewEmail конструктор Email
+func NewEmail(ctx context.Context, config *ConfigEmail) (Notifier, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+
+	email := &Email{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "notify-email",
+			"scope":  "service",
+		}),
+		recipients: config.Recipients,
+	}
+	for _, r := range config.Recipients {
+		for _, v := range r.Emails {
+			if _, err := mail.ParseAddress(v); err != nil {
+				return nil, errors.Annotatef(err, "некорректный адрес получателя \"%s\"", v)
+			}
+		}
+	}
+	sender, err := newSMTPSender(config.SMTP)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	email.smtp = sender
+
+	subject, body := config.TemplateSubject, config.TemplateBody
+	if strings.TrimSpace(subject) == "" {
+		subject = templateSubject
+	}
+	if strings.TrimSpace(body) == "" {
+		body = templateBody
+	}
+	if email.subject, err = textTemplate.New("subject").Option("missingkey=error").Parse(subject); err != nil {
+		return nil, errors.Annotate(err, "ошибка в шаблоне темы письма")
+	}
+	if email.body, err = htmlTemplate.New("body").Option("missingkey=error").Parse(body); err != nil {
+		return nil, errors.Annotate(err, "ошибка в шаблоне письма")
+	}
+
+	return email, nil
+}
+
+// Name имя канала
+func (m *Email) Name() string {
+	return "email"
+}
+
+// Notify отправляет письмо о тревоге подходящим получателям
+func (m *Email) Notify(alarm model.Alarm, image []byte) error {
+	to := m.emails(alarm.Person)
+	if len(to) == 0 {
+		m.log.Debugf("нет получателей письма о тревоге %s", alarm.ThrottleKey())
+		return nil
+	}
+
+	var subject, body bytes.Buffer
+	if err := m.subject.Execute(&subject, alarm); err != nil {
+		return errors.Annotate(err, "ошибка шаблона темы письма")
+	}
+	if err := m.body.Execute(&body, alarm); err != nil {
+		return errors.Annotate(err, "ошибка шаблона письма")
+	}
+
+	attachments := make([]attachment, 0, 1)
+	if image != nil {
+		attachments = append(attachments, attachment{
+			Name:        alarm.ImageName,
+			ContentType: "image/jpeg",
+			ContentID:   imageContentID,
+			Content:     image,
+		})
+	}
+
+	if err := m.smtp.send(to, strings.TrimSpace(subject.String()), body.String(), attachments); err != nil {
+		return errors.Trace(err)
+	}
+	m.log.Infof("письмо о тревоге %s отправлено: %s", alarm.ThrottleKey(), strings.Join(to, ", "))
+	return nil
+}
+
+// Адреса получателей оповещений о персоне person без повторов
+func (m *Email) emails(person model.Person) []string {
+	unique := make(map[string]bool)
+	for _, r := range m.recipients {
+		if !r.match(person) {
+			continue
+		}
+		for _, v := range r.Emails {
+			unique[strings.ToLower(strings.TrimSpace(v))] = true
+		}
+	}
+	result := make([]string, 0, len(unique))
+	for v := range unique {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
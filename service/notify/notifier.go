@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"strings"
+
+	"github.com/kirsrus/termopad-server/model"
+)
+
+// Notifier канал оповещения о тревогах (почта, мессенджер). Каналы подключаются к Notify через
+// ConfigNotify.Notifiers; очередь, отсечение повторных тревог и загрузку изображения замера берёт на себя Notify
+type Notifier interface {
+	// Name имя канала для журнала
+	Name() string
+	// Notify отправляет оповещение о тревоге подходящим получателям канала. image - изображение замера в JPEG
+	// (nil, если изображения нет)
+	Notify(alarm model.Alarm, image []byte) error
+}
+
+// Recipients получатели оповещений о персонах организации и подразделения. Пустые Organization или
+// Department подходят для любых значений; правило с обоими пустыми получает все оповещения
+type Recipients struct {
+	Organization string
+	Department   string
+	// Адреса электронной почты
+	Emails []string
+	// Идентификаторы чатов Telegram
+	Chats []int64
+}
+
+// Подходит ли правило для персоны person
+func (m Recipients) match(person model.Person) bool {
+	if m.Organization != "" && !strings.EqualFold(strings.TrimSpace(m.Organization), strings.TrimSpace(person.Organization)) {
+		return false
+	}
+	if m.Department != "" && !strings.EqualFold(strings.TrimSpace(m.Department), strings.TrimSpace(person.Department)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/service"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Ёмкость очереди оповещений
+	queueCapacity = 100
+	// Время, в течение которого повторные тревоги по той же персоне не рассылаются
+	throttle = 30 * time.Minute
+)
+
+// Notify оповещение ответственных лиц о тревогах. Имплементирует интерфейс NotifySvc. Инициируется через
+// NewNotify. Оповещения отправляются в фоне по очереди во все каналы (Notifier); повторные тревоги по той же
+// персоне в течение throttle отбрасываются
+type Notify struct {
+	ctx     context.Context
+	log     *logrus.Entry
+	dbStore store.DbStore
+
+	notifiers []Notifier
+
+	throttle *cache.Cache
+	queue    chan model.Alarm
+	// Оповещения, поставленные в очередь, но ещё не отправленные
+	pending sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+}
+
+// ConfigNotify конфигурация Notify
+type ConfigNotify struct {
+	Log *logrus.Logger
+	// Каналы оповещения. Если каналов нет, оповещения не рассылаются
+	Notifiers []Notifier
+	// Время отсечения повторных тревог по той же персоне
+	Throttle time.Duration
+}
+
+// NewNotify конструктор Notify
+func NewNotify(ctx context.Context, dbStore store.DbStore, config *ConfigNotify) (service.NotifySvc, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if dbStore == nil {
+		return nil, errors.New("не указана служба dbStore")
+	}
+
+	notify := &Notify{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "notify",
+			"scope":  "service",
+		}),
+		dbStore:   dbStore,
+		notifiers: config.Notifiers,
+		queue:     make(chan model.Alarm, queueCapacity),
+	}
+
+	window := throttle
+	if config.Throttle != 0 {
+		window = config.Throttle
+	}
+	notify.throttle = cache.New(window, window)
+
+	go notify.loop()
+
+	return notify, nil
+}
+
+// Alarm ставит оповещение о тревоге в очередь отправки без ожидания. Повторная тревога по той же
+// персоне в течение времени отсечения отбрасывается
+func (m *Notify) Alarm(alarm model.Alarm) {
+	if len(m.notifiers) == 0 {
+		return
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return
+	}
+	if err := m.throttle.Add(alarm.ThrottleKey(), nil, cache.DefaultExpiration); err != nil {
+		m.log.Debugf("повторная тревога %s не рассылается", alarm.ThrottleKey())
+		return
+	}
+	m.pending.Add(1)
+	select {
+	case m.queue <- alarm:
+	default:
+		m.pending.Done()
+		m.log.Warnf("очередь оповещений переполнена, тревога %s не разослана", alarm.ThrottleKey())
+	}
+}
+
+// Close прекращает приём оповещений и дожидается отправки поставленных в очередь, но не дольше ctx
+func (m *Notify) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Annotatef(ctx.Err(), "не отправлено оповещений: %d", len(m.queue))
+	}
+}
+
+// Отправка оповещений из очереди
+func (m *Notify) loop() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case alarm := <-m.queue:
+			m.send(alarm)
+			m.pending.Done()
+		}
+	}
+}
+
+// Отправка оповещения о тревоге во все каналы. Ошибка одного канала не мешает отправке в остальные
+func (m *Notify) send(alarm model.Alarm) {
+	var image []byte
+	if alarm.ImageName != "" {
+		content, err := m.dbStore.TempImage(alarm.ImageName)
+		if err != nil {
+			m.log.Warnf("изображение замера %s не приложено к оповещению: %v", alarm.ImageName, err)
+		} else {
+			image = content
+		}
+	}
+
+	for _, v := range m.notifiers {
+		if err := v.Notify(alarm, image); err != nil {
+			m.log.Warnf("ошибка оповещения о тревоге %s через %s: %v", alarm.ThrottleKey(), v.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/config"
+	dbStoreMod "github.com/kirsrus/termopad-server/store/db"
+)
+
+// Письмо, принятое тестовым SMTP-сервером
+type sinkMessage struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// Локальный SMTP-сервер, принимающий письма без отправки
+type smtpSink struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []sinkMessage
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (m *smtpSink) port() uint {
+	return uint(m.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (m *smtpSink) received() []sinkMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sinkMessage(nil), m.messages...)
+}
+
+func (m *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 sink ESMTP")
+	var msg sinkMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			reply("250-sink")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			msg.auth = line
+			reply("235 OK")
+		case "MAIL":
+			msg.from = line
+			reply("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.data = data.String()
+			m.mu.Lock()
+			m.messages = append(m.messages, msg)
+			m.mu.Unlock()
+			msg = sinkMessage{}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestNotify_Alarm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dbStore, err := dbStoreMod.NewDb(ctx, &dbStoreMod.ConfigDb{
+		DbFile:             filepath.Join(dir, "test.sqlite"),
+		RootTemperatureDir: filepath.Join(dir, "temperature"),
+		GlobalConfig:       &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createAt := time.Date(2020, 12, 13, 13, 27, 28, 0, time.Local)
+	image := []byte("jpeg-image")
+	imageName, err := dbStore.SetTempImage(createAt, model.NewWigand(100), image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newSMTPSink(t)
+	defer sink.listener.Close()
+	email, err := NewEmail(ctx, &ConfigEmail{
+		SMTP: ConfigSMTP{
+			Host:     "127.0.0.1",
+			Port:     sink.port(),
+			Username: "termopad",
+			Password: "secret",
+			From:     "Термопад <termopad@example.com>",
+			TLS:      model.SMTPTLSNone,
+		},
+		Recipients: []Recipients{
+			{Emails: []string{"ohrana@example.com"}},
+			{Organization: "альфа ", Emails: []string{"alpha@example.com", "OHRANA@example.com"}},
+			{Organization: "Альфа", Department: "Цех 2", Emails: []string{"master2@example.com"}},
+			{Organization: "Бета", Emails: []string{"beta@example.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifySvc, err := NewNotify(ctx, dbStore, &ConfigNotify{Notifiers: []Notifier{email}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alarm := model.Alarm{
+		CreateAt:    createAt,
+		Temperature: 38.2,
+		Thresholds:  model.Thresholds{MaxTemperature: 37.5, MinTemperature: 35.0},
+		Person: model.Person{Wigand: model.NewWigand(100), Family: "Иванов", Name: "Иван",
+			Organization: "Альфа", Department: "Цех 1"},
+		Termopad:  model.TermopadInfo{ID: 1, Name: "Кабина 1"},
+		ImageName: *imageName,
+	}
+	notifySvc.Alarm(alarm)
+	// Повторная тревога по той же персоне отсекается
+	notifySvc.Alarm(alarm)
+	// Нераспознанная карта: только общие получатели, без изображения
+	notifySvc.Alarm(model.Alarm{
+		CreateAt:    createAt,
+		Temperature: 39.0,
+		Thresholds:  model.Thresholds{MaxTemperature: 37.5, MinTemperature: 35.0},
+		Termopad:    model.TermopadInfo{ID: 2, Name: "Кабина 2"},
+	})
+
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer closeCancel()
+	if err := notifySvc.Close(closeCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := sink.received()
+	if len(messages) != 2 {
+		t.Fatalf("принято писем %d, want 2", len(messages))
+	}
+	sort.Slice(messages, func(i, j int) bool { return len(messages[i].to) > len(messages[j].to) })
+
+	tests := []struct {
+		name        string
+		msg         sinkMessage
+		wantTo      []string
+		wantSubject string
+		wantBody    []string
+		wantImage   bool
+	}{
+		{
+			name:        "известная персона",
+			msg:         messages[0],
+			wantTo:      []string{"alpha@example.com", "ohrana@example.com"},
+			wantSubject: "Повышенная температура 38.2° - Кабина 1",
+			wantBody:    []string{"Иванов Иван", "Цех 1", "35.0° - 37.5°", "cid:measurement"},
+			wantImage:   true,
+		},
+		{
+			name:        "нераспознанная карта",
+			msg:         messages[1],
+			wantTo:      []string{"ohrana@example.com"},
+			wantSubject: "Повышенная температура 39.0° - Кабина 2",
+			wantBody:    []string{"Кабина 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.HasPrefix(tt.msg.auth, "AUTH PLAIN") {
+				t.Errorf("авторизация = %q", tt.msg.auth)
+			}
+			if tt.msg.from != "MAIL FROM:<termopad@example.com>" {
+				t.Errorf("отправитель = %q", tt.msg.from)
+			}
+			if !reflect.DeepEqual(tt.msg.to, tt.wantTo) {
+				t.Errorf("получатели = %v, want %v", tt.msg.to, tt.wantTo)
+			}
+
+			parsed, err := mail.ReadMessage(strings.NewReader(tt.msg.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != tt.wantSubject {
+				t.Errorf("тема = %q, want %q", subject, tt.wantSubject)
+			}
+			_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := multipart.NewReader(parsed.Body, params["boundary"])
+			parts := make(map[string][]byte)
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					break
+				}
+				content, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+				if err != nil {
+					t.Fatal(err)
+				}
+				parts[part.Header.Get("Content-ID")] = content
+			}
+			for _, v := range tt.wantBody {
+				if !strings.Contains(string(parts[""]), v) {
+					t.Errorf("письмо не содержит %q", v)
+				}
+			}
+			if got, ok := parts["<measurement>"]; ok != tt.wantImage || (ok && string(got) != string(image)) {
+				t.Errorf("изображение замера = %q, want %v", got, tt.wantImage)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+
+	"github.com/juju/errors"
+)
+
+const (
+	smtpPort    = 25
+	smtpTimeout = 30 * time.Second
+	// Длина строки в base64-частях письма
+	base64LineLength = 76
+)
+
+// ConfigSMTP параметры отправки писем через SMTP-сервер
+type ConfigSMTP struct {
+	Host string
+	Port uint
+	// Учётные данные (пустое имя - без авторизации)
+	Username string
+	Password string
+	// Адрес отправителя
+	From string
+	// Режим шифрования: model.SMTPTLSNone, model.SMTPTLSStartTLS или model.SMTPTLSImplicit
+	TLS string
+	// Не проверять сертификат сервера
+	InsecureSkipVerify bool
+	// Ограничение времени отправки одного письма
+	Timeout time.Duration
+}
+
+// Вложение письма
+type attachment struct {
+	Name        string
+	ContentType string
+	// Идентификатор для ссылки из HTML (cid:ContentID)
+	ContentID string
+	Content   []byte
+}
+
+// Отправка писем через SMTP-сервер
+type smtpSender struct {
+	config ConfigSMTP
+	from   *mail.Address
+}
+
+// Создание отправителя писем с проверкой параметров
+func newSMTPSender(config ConfigSMTP) (*smtpSender, error) {
+	if config.Host == "" {
+		return nil, errors.New("не указан адрес SMTP-сервера")
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, errors.Annotatef(err, "некорректный адрес отправителя \"%s\"", config.From)
+	}
+	switch config.TLS {
+	case "":
+		config.TLS = model.SMTPTLSStartTLS
+	case model.SMTPTLSNone, model.SMTPTLSStartTLS, model.SMTPTLSImplicit:
+	default:
+		return nil, errors.Errorf("неизвестный режим шифрования SMTP \"%s\"", config.TLS)
+	}
+	if config.Port == 0 {
+		config.Port = smtpPort
+	}
+	if config.Timeout == 0 {
+		config.Timeout = smtpTimeout
+	}
+	return &smtpSender{config: config, from: from}, nil
+}
+
+// Отправка письма с HTML-содержимым body и вложениями получателям to
+func (m *smtpSender) send(to []string, subject string, body string, attachments []attachment) error {
+	message, err := m.message(to, subject, body, attachments)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(int(m.config.Port)))
+	tlsConfig := &tls.Config{ServerName: m.config.Host, InsecureSkipVerify: m.config.InsecureSkipVerify}
+	dialer := &net.Dialer{Timeout: m.config.Timeout}
+	var conn net.Conn
+	if m.config.TLS == model.SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return errors.Annotatef(err, "ошибка подключения к SMTP-серверу %s", addr)
+	}
+	_ = conn.SetDeadline(time.Now().Add(m.config.Timeout))
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return errors.Annotate(err, "ошибка приветствия SMTP-сервера")
+	}
+	defer func() { _ = client.Close() }()
+
+	if m.config.TLS == model.SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP-сервер не поддерживает STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return errors.Annotate(err, "ошибка перехода на TLS")
+		}
+	}
+	if m.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return errors.Annotate(err, "ошибка авторизации на SMTP-сервере")
+		}
+	}
+	if err := client.Mail(m.from.Address); err != nil {
+		return errors.Annotate(err, "отправитель отклонён SMTP-сервером")
+	}
+	for _, v := range to {
+		if err := client.Rcpt(v); err != nil {
+			return errors.Annotatef(err, "получатель %s отклонён SMTP-сервером", v)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return errors.Trace(err)
+	}
+	if err := w.Close(); err != nil {
+		return errors.Annotate(err, "письмо отклонено SMTP-сервером")
+	}
+	return errors.Trace(client.Quit())
+}
+
+// Формирование письма в формате MIME: HTML-содержимое и вложения
+func (m *smtpSender) message(to []string, subject string, body string, attachments []attachment) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	headers := []string{
+		"From: " + m.from.String(),
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.BEncoding.Encode("UTF-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"", writer.Boundary()),
+	}
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	writeBase64(part, []byte(body))
+
+	for _, v := range attachments {
+		header := textproto.MIMEHeader{
+			"Content-Type":              {v.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": v.Name})},
+		}
+		if v.ContentID != "" {
+			header.Set("Content-ID", "<"+v.ContentID+">")
+		}
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		writeBase64(part, v.Content)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Запись содержимого в base64 с разбивкой на строки
+func writeBase64(w io.Writer, content []byte) {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > base64LineLength {
+		_, _ = w.Write([]byte(encoded[:base64LineLength] + "\r\n"))
+		encoded = encoded[base64LineLength:]
+	}
+	_, _ = w.Write([]byte(encoded + "\r\n"))
+}
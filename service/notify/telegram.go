@@ -0,0 +1,464 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kirsrus/termopad-server/controller"
+	"github.com/kirsrus/termopad-server/controller/supervisor"
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	telegramAPIURL = "https://api.telegram.org"
+	// Время ожидания обновлений одним запросом getUpdates (long polling)
+	telegramPollTimeout = 30 * time.Second
+	// Пауза перед повторным запросом обновлений после ошибки
+	telegramRetryInterval = 5 * time.Second
+	// Ограничение времени отправки сообщения
+	telegramTimeout = 30 * time.Second
+	// Префикс данных кнопки подтверждения тревоги; за ним следует идентификатор тревоги
+	telegramAckPrefix = "ack:"
+)
+
+// Telegram оповещение о тревогах в чаты Telegram через Bot API. Имплементирует интерфейс Notifier.
+// Инициализируется через NewTelegram. К оповещению о сохранённой тревоге прикладывается кнопка
+// подтверждения: нажатие в чате получателя отмечает тревогу обработанной в БД. Нажатия принимаются
+// в фоне через getUpdates обработчиком под наблюдением супервизора
+type Telegram struct {
+	ctx        context.Context
+	log        *logrus.Entry
+	dbStore    store.DbStore
+	supervisor controller.SupervisorCtl
+	client     *http.Client
+
+	// Адрес методов бота: <APIURL>/bot<token>/
+	endpoint   string
+	recipients []Recipients
+
+	pollTimeout   time.Duration
+	retryInterval time.Duration
+	// Идентификатор следующего ожидаемого обновления (сохраняется при перезапуске приёма)
+	offset int64
+}
+
+// ConfigTelegram конфигурация Telegram
+type ConfigTelegram struct {
+	Log *logrus.Logger
+	// Токен бота
+	Token string
+	// Адрес Bot API (по умолчанию https://api.telegram.org)
+	APIURL string
+	// Получатели оповещений по организациям и подразделениям (используются чаты Chats)
+	Recipients []Recipients
+	// Время ожидания обновлений одним запросом
+	PollTimeout time.Duration
+	// Пауза перед повторным запросом обновлений после ошибки
+	RetryInterval time.Duration
+	// Супервизор, перезапускающий приём нажатий после сбоев (если не задан, создаётся свой)
+	Supervisor controller.SupervisorCtl
+}
+
+// NewTelegram конструктор Telegram
+func NewTelegram(ctx context.Context, dbStore store.DbStore, config *ConfigTelegram) (Notifier, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if dbStore == nil {
+		return nil, errors.New("не указана служба dbStore")
+	}
+	if config.Token == "" {
+		return nil, errors.New("не указан токен бота Telegram")
+	}
+	if config.Supervisor == nil {
+		svc, err := supervisor.NewSupervisor(ctx, &supervisor.ConfigSupervisor{Log: config.Log})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		config.Supervisor = svc
+	}
+
+	apiURL := telegramAPIURL
+	if config.APIURL != "" {
+		apiURL = strings.TrimRight(config.APIURL, "/")
+	}
+	telegram := &Telegram{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "notify-telegram",
+			"scope":  "service",
+		}),
+		dbStore:       dbStore,
+		supervisor:    config.Supervisor,
+		endpoint:      apiURL + "/bot" + config.Token + "/",
+		recipients:    config.Recipients,
+		pollTimeout:   telegramPollTimeout,
+		retryInterval: telegramRetryInterval,
+	}
+	if config.PollTimeout != 0 {
+		telegram.pollTimeout = config.PollTimeout
+	}
+	if config.RetryInterval != 0 {
+		telegram.retryInterval = config.RetryInterval
+	}
+	telegram.client = &http.Client{Timeout: telegram.pollTimeout + telegramTimeout}
+
+	telegram.supervisor.Go(ctx, "notify.telegram", telegram.poll)
+
+	return telegram, nil
+}
+
+// Name имя канала
+func (m *Telegram) Name() string {
+	return "telegram"
+}
+
+// Notify отправляет в подходящие чаты изображение замера с подписью (без изображения - текстовое сообщение)
+func (m *Telegram) Notify(alarm model.Alarm, image []byte) error {
+	chats := m.chats(alarm.Person)
+	if len(chats) == 0 {
+		m.log.Debugf("нет чатов для оповещения о тревоге %s", alarm.ThrottleKey())
+		return nil
+	}
+
+	caption := telegramCaption(alarm)
+	var markup *inlineKeyboard
+	if alarm.ID != 0 {
+		markup = &inlineKeyboard{Buttons: [][]inlineButton{{{
+			Text: "Принято",
+			Data: telegramAckPrefix + strconv.Itoa(int(alarm.ID)),
+		}}}}
+	}
+
+	var result error
+	for _, chat := range chats {
+		var err error
+		if image != nil {
+			err = m.sendPhoto(chat, caption, markup, alarm.ImageName, image)
+		} else {
+			params := map[string]interface{}{"chat_id": chat, "text": caption}
+			if markup != nil {
+				params["reply_markup"] = markup
+			}
+			err = m.call("sendMessage", params, nil)
+		}
+		if err != nil {
+			result = errors.Annotatef(err, "чат %d", chat)
+			m.log.Warnf("ошибка отправки тревоги %s в чат %d: %v", alarm.ThrottleKey(), chat, err)
+			continue
+		}
+		m.log.Infof("тревога %s отправлена в чат %d", alarm.ThrottleKey(), chat)
+	}
+	return result
+}
+
+// Подпись оповещения: температура, персона, кабина и время
+func telegramCaption(alarm model.Alarm) string {
+	lines := []string{fmt.Sprintf("Повышенная температура %0.1f°", alarm.Temperature)}
+	if alarm.Person.Family != "" {
+		name := strings.Join(strings.Fields(alarm.Person.Family+" "+alarm.Person.Name+" "+alarm.Person.MiddleName), " ")
+		if alarm.Person.Organization != "" {
+			name += " (" + alarm.Person.Organization + ")"
+		}
+		lines = append(lines, name)
+	} else {
+		lines = append(lines, "Карта "+alarm.Person.Wigand.Display())
+	}
+	lines = append(lines,
+		"Кабина: "+alarm.Termopad.Name,
+		fmt.Sprintf("Норма: %0.1f° - %0.1f°", alarm.Thresholds.MinTemperature, alarm.Thresholds.MaxTemperature),
+		alarm.CreateAt.Format("2006.01.02 15:04:05"),
+	)
+	return strings.Join(lines, "\n")
+}
+
+// Чаты получателей оповещений о персоне person без повторов
+func (m *Telegram) chats(person model.Person) []int64 {
+	unique := make(map[int64]bool)
+	result := make([]int64, 0)
+	for _, r := range m.recipients {
+		if !r.match(person) {
+			continue
+		}
+		for _, v := range r.Chats {
+			if !unique[v] {
+				unique[v] = true
+				result = append(result, v)
+			}
+		}
+	}
+	return result
+}
+
+// Является ли chat чатом одного из получателей
+func (m *Telegram) known(chat int64) bool {
+	for _, r := range m.recipients {
+		for _, v := range r.Chats {
+			if v == chat {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Приём нажатий кнопок подтверждения тревог. Ошибки запросов повторяются здесь же, сбои (паника при
+// обработке нажатия) перезапускаются супервизором
+func (m *Telegram) poll() error {
+	for {
+		updates := make([]telegramUpdate, 0)
+		err := m.call("getUpdates", map[string]interface{}{
+			"offset":          m.offset,
+			"timeout":         int(m.pollTimeout / time.Second),
+			"allowed_updates": []string{"callback_query"},
+		}, &updates)
+		if m.ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			m.log.Warnf("ошибка получения обновлений Telegram: %v", err)
+			select {
+			case <-m.ctx.Done():
+				return nil
+			case <-time.After(m.retryInterval):
+			}
+			continue
+		}
+		for _, v := range updates {
+			if v.ID >= m.offset {
+				m.offset = v.ID + 1
+			}
+			if v.Callback != nil {
+				m.acknowledge(*v.Callback)
+			}
+		}
+	}
+}
+
+// Обработка нажатия кнопки подтверждения тревоги
+func (m *Telegram) acknowledge(callback telegramCallback) {
+	answer := func(text string) {
+		if err := m.call("answerCallbackQuery", map[string]interface{}{
+			"callback_query_id": callback.ID,
+			"text":              text,
+		}, nil); err != nil {
+			m.log.Warnf("ошибка ответа на нажатие кнопки: %v", err)
+		}
+	}
+
+	if callback.Message == nil || !m.known(callback.Message.Chat.ID) {
+		answer("Чат не подписан на оповещения")
+		return
+	}
+	if !strings.HasPrefix(callback.Data, telegramAckPrefix) {
+		answer("Неизвестная команда")
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(callback.Data, telegramAckPrefix), 10, 64)
+	if err != nil {
+		answer("Неизвестная команда")
+		return
+	}
+
+	by := callback.From.display()
+	alarm, err := m.dbStore.HandleAlarm(uint(id), by, time.Now())
+	if err != nil {
+		if m.dbStore.IsNotFound(err) {
+			answer("Тревога не найдена (возможно, удалена из архива)")
+			return
+		}
+		m.log.Warnf("ошибка подтверждения тревоги %d: %v", id, err)
+		answer("Ошибка сервера, повторите позже")
+		return
+	}
+	if alarm.HandledBy == by {
+		m.log.Infof("тревога %d подтверждена в Telegram: %s", id, by)
+		if err := m.dbStore.SetAudit(model.Audit{
+			CreateAt: time.Now(),
+			User:     callback.From.auditName(),
+			Action:   model.AuditAcknowledgeAlarm,
+			Target:   strconv.FormatUint(id, 10),
+		}); err != nil {
+			m.log.Warnf("ошибка записи в журнал аудита подтверждения тревоги %d: %v", id, err)
+		}
+		answer("Тревога отмечена обработанной")
+	} else {
+		answer("Тревога уже обработана: " + alarm.HandledBy)
+	}
+
+	// Отметка об обработке добавляется к сообщению, кнопка убирается
+	text := callback.Message.Caption
+	method, field := "editMessageCaption", "caption"
+	if text == "" {
+		text = callback.Message.Text
+		method, field = "editMessageText", "text"
+	}
+	text += fmt.Sprintf("\n\nОбработано: %s, %s", alarm.HandledBy, alarm.HandledAt.Format("2006.01.02 15:04:05"))
+	if err := m.call(method, map[string]interface{}{
+		"chat_id":    callback.Message.Chat.ID,
+		"message_id": callback.Message.ID,
+		field:        text,
+	}, nil); err != nil {
+		m.log.Warnf("ошибка изменения сообщения о тревоге %d: %v", id, err)
+	}
+}
+
+// Вызов метода Bot API с параметрами в JSON. Результат метода разбирается в result (если не nil)
+func (m *Telegram) call(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return m.do(method, "application/json", body, result)
+}
+
+// Отправка изображения с подписью и кнопкой (multipart/form-data)
+func (m *Telegram) sendPhoto(chat int64, caption string, markup *inlineKeyboard, name string, image []byte) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	_ = writer.WriteField("chat_id", strconv.FormatInt(chat, 10))
+	_ = writer.WriteField("caption", caption)
+	if markup != nil {
+		encoded, err := json.Marshal(markup)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_ = writer.WriteField("reply_markup", string(encoded))
+	}
+	if name == "" {
+		name = "measurement.jpg"
+	}
+	part, err := writer.CreateFormFile("photo", name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := part.Write(image); err != nil {
+		return errors.Trace(err)
+	}
+	if err := writer.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return m.do("sendPhoto", writer.FormDataContentType(), body.Bytes(), nil)
+}
+
+// Запрос к методу Bot API
+func (m *Telegram) do(method string, contentType string, body []byte, result interface{}) error {
+	request, err := http.NewRequestWithContext(m.ctx, http.MethodPost, m.endpoint+method, bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	response, err := m.client.Do(request)
+	if err != nil {
+		// Адрес запроса содержит токен бота и в журнал не выводится
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return errors.Annotatef(err, "ошибка запроса %s к Bot API", method)
+	}
+	defer response.Body.Close()
+
+	var reply telegramResponse
+	if err := json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		return errors.Annotatef(err, "некорректный ответ Bot API на %s (HTTP %d)", method, response.StatusCode)
+	}
+	if !reply.OK {
+		return errors.Errorf("Bot API отклонил %s: %d %s", method, reply.ErrorCode, reply.Description)
+	}
+	if result != nil {
+		if err := json.Unmarshal(reply.Result, result); err != nil {
+			return errors.Annotatef(err, "некорректный результат %s", method)
+		}
+	}
+	return nil
+}
+
+// Ответ Bot API
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+}
+
+// Встроенная клавиатура сообщения
+type inlineKeyboard struct {
+	Buttons [][]inlineButton `json:"inline_keyboard"`
+}
+
+type inlineButton struct {
+	Text string `json:"text"`
+	Data string `json:"callback_data"`
+}
+
+// Обновление, полученное через getUpdates (используются только нажатия кнопок)
+type telegramUpdate struct {
+	ID       int64             `json:"update_id"`
+	Callback *telegramCallback `json:"callback_query"`
+}
+
+// Нажатие кнопки встроенной клавиатуры
+type telegramCallback struct {
+	ID      string           `json:"id"`
+	From    telegramUser     `json:"from"`
+	Message *telegramMessage `json:"message"`
+	Data    string           `json:"data"`
+}
+
+type telegramUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// Имя пользователя для отметки об обработке тревоги
+func (m telegramUser) display() string {
+	name := strings.TrimSpace(m.FirstName + " " + m.LastName)
+	switch {
+	case m.Username != "" && name != "":
+		return name + " (@" + m.Username + ")"
+	case m.Username != "":
+		return "@" + m.Username
+	case name != "":
+		return name
+	default:
+		return "telegram:" + strconv.FormatInt(m.ID, 10)
+	}
+}
+
+// Пользователь для журнала аудита: имя пользователя Telegram, а без него идентификатор
+func (m telegramUser) auditName() string {
+	if m.Username != "" {
+		return "telegram:@" + m.Username
+	}
+	return "telegram:" + strconv.FormatInt(m.ID, 10)
+}
+
+type telegramMessage struct {
+	ID      int64        `json:"message_id"`
+	Chat    telegramChat `json:"chat"`
+	Text    string       `json:"text"`
+	Caption string       `json:"caption"`
+}
+
+type telegramChat struct {
+	ID int64 `json:"id"`
+}
@@ -0,0 +1,242 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/config"
+	dbStoreMod "github.com/kirsrus/termopad-server/store/db"
+)
+
+// Запрос к тестовому Bot API
+type botCall struct {
+	method string
+	params map[string]string
+	photo  []byte
+}
+
+// Тестовый Bot API: запоминает вызовы методов и выдаёт поставленные в очередь обновления
+type botStub struct {
+	server  *httptest.Server
+	updates chan telegramUpdate
+	mu      sync.Mutex
+	calls   []botCall
+}
+
+func newBotStub(t *testing.T, token string) *botStub {
+	stub := &botStub{updates: make(chan telegramUpdate, 10)}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := "/bot" + token + "/"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`))
+			return
+		}
+		call := botCall{method: strings.TrimPrefix(r.URL.Path, prefix), params: make(map[string]string)}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Error(err)
+			}
+			for k, v := range r.MultipartForm.Value {
+				call.params[k] = v[0]
+			}
+			if file, _, err := r.FormFile("photo"); err == nil {
+				call.photo, _ = ioutil.ReadAll(file)
+			}
+		} else {
+			params := make(map[string]interface{})
+			_ = json.NewDecoder(r.Body).Decode(&params)
+			for k, v := range params {
+				encoded, _ := json.Marshal(v)
+				call.params[k] = strings.Trim(string(encoded), `"`)
+			}
+		}
+
+		result := `true`
+		switch call.method {
+		case "getUpdates":
+			updates := make([]telegramUpdate, 0)
+			select {
+			case update := <-stub.updates:
+				updates = append(updates, update)
+			case <-time.After(20 * time.Millisecond):
+			}
+			encoded, _ := json.Marshal(updates)
+			result = string(encoded)
+		case "sendPhoto", "sendMessage":
+			result = `{"message_id":1,"chat":{"id":` + call.params["chat_id"] + `}}`
+		}
+		if call.method != "getUpdates" {
+			stub.mu.Lock()
+			stub.calls = append(stub.calls, call)
+			stub.mu.Unlock()
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":` + result + `}`))
+	}))
+	return stub
+}
+
+// Вызовы метода method
+func (m *botStub) called(method string) []botCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]botCall, 0)
+	for _, v := range m.calls {
+		if v.method == method {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Ожидание count вызовов метода method
+func (m *botStub) wait(t *testing.T, method string, count int) []botCall {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if calls := m.called(method); len(calls) >= count {
+			return calls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались вызова %s", method)
+		}
+	}
+}
+
+func TestTelegram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-telegram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dbStore, err := dbStoreMod.NewDb(ctx, &dbStoreMod.ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := newBotStub(t, "123:secret")
+	defer stub.server.Close()
+	telegram, err := NewTelegram(ctx, dbStore, &ConfigTelegram{
+		Token:  "123:secret",
+		APIURL: stub.server.URL,
+		Recipients: []Recipients{
+			{Chats: []int64{100}},
+			{Organization: "Бета", Chats: []int64{200}},
+		},
+		RetryInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alarm := model.Alarm{
+		CreateAt:    time.Date(2020, 12, 13, 13, 27, 28, 0, time.Local),
+		Temperature: 38.2,
+		Thresholds:  model.Thresholds{MaxTemperature: 37.5, MinTemperature: 35.0},
+		Person: model.Person{Wigand: model.NewWigand(100), Family: "Иванов", Name: "Иван",
+			Organization: "Альфа"},
+		Termopad:  model.TermopadInfo{ID: 1, Name: "Кабина 1"},
+		ImageName: "2020.12.13/13/132728_100.jpg",
+	}
+	id, err := dbStore.SetAlarm(alarm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alarm.ID = id
+	image := []byte("jpeg-image")
+	if err := telegram.Notify(alarm, image); err != nil {
+		t.Fatal(err)
+	}
+	// Без изображения и без сохранения в БД: текстовое сообщение без кнопки
+	if err := telegram.Notify(model.Alarm{Temperature: 39.0, Termopad: model.TermopadInfo{ID: 2, Name: "Кабина 2"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	photos := stub.called("sendPhoto")
+	if len(photos) != 1 {
+		t.Fatalf("отправлено изображений %d, want 1", len(photos))
+	}
+	photo := photos[0]
+	if photo.params["chat_id"] != "100" || string(photo.photo) != string(image) {
+		t.Errorf("изображение отправлено в чат %s: %q", photo.params["chat_id"], photo.photo)
+	}
+	for _, v := range []string{"38.2°", "Иванов Иван (Альфа)", "Кабина 1", "2020.12.13 13:27:28"} {
+		if !strings.Contains(photo.params["caption"], v) {
+			t.Errorf("подпись не содержит %q: %s", v, photo.params["caption"])
+		}
+	}
+	if !strings.Contains(photo.params["reply_markup"], `"callback_data":"ack:1"`) {
+		t.Errorf("кнопка подтверждения = %s", photo.params["reply_markup"])
+	}
+	messages := stub.called("sendMessage")
+	if len(messages) != 1 || !strings.Contains(messages[0].params["text"], "Кабина 2") ||
+		messages[0].params["reply_markup"] != "" {
+		t.Errorf("текстовые сообщения = %+v", messages)
+	}
+
+	// Нажатие из чужого чата не подтверждает тревогу
+	stub.updates <- telegramUpdate{ID: 1, Callback: &telegramCallback{
+		ID:      "cb1",
+		From:    telegramUser{ID: 7, Username: "stranger"},
+		Message: &telegramMessage{ID: 1, Chat: telegramChat{ID: 999}, Caption: photo.params["caption"]},
+		Data:    "ack:1",
+	}}
+	answers := stub.wait(t, "answerCallbackQuery", 1)
+	if answers[0].params["text"] != "Чат не подписан на оповещения" {
+		t.Errorf("ответ чужому чату = %q", answers[0].params["text"])
+	}
+
+	stub.updates <- telegramUpdate{ID: 2, Callback: &telegramCallback{
+		ID:      "cb2",
+		From:    telegramUser{ID: 8, Username: "shift_lead", FirstName: "Пётр"},
+		Message: &telegramMessage{ID: 1, Chat: telegramChat{ID: 100}, Caption: photo.params["caption"]},
+		Data:    "ack:1",
+	}}
+	answers = stub.wait(t, "answerCallbackQuery", 2)
+	if answers[1].params["text"] != "Тревога отмечена обработанной" {
+		t.Errorf("ответ на подтверждение = %q", answers[1].params["text"])
+	}
+	edits := stub.wait(t, "editMessageCaption", 1)
+	if !strings.Contains(edits[0].params["caption"], "Обработано: Пётр (@shift_lead)") {
+		t.Errorf("изменённая подпись = %s", edits[0].params["caption"])
+	}
+
+	alarms, err := dbStore.Alarms(true, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alarms) != 0 {
+		t.Errorf("необработанные тревоги = %+v, want нет", alarms)
+	}
+	alarms, err = dbStore.Alarms(false, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alarms) != 1 || alarms[0].HandledAt == nil || alarms[0].HandledBy != "Пётр (@shift_lead)" {
+		t.Errorf("тревоги = %+v", alarms)
+	}
+
+	audits, err := dbStore.AuditLog(model.AuditFilter{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 1 || audits[0].Action != model.AuditAcknowledgeAlarm || audits[0].User != "telegram:@shift_lead" ||
+		audits[0].Target != "1" {
+		t.Errorf("журнал аудита = %+v", audits)
+	}
+}
@@ -1,6 +1,9 @@
 package service
 
 import (
+	"context"
+	"time"
+
 	"github.com/kirsrus/termopad-server/model"
 )
 
@@ -17,8 +20,18 @@ type WebSvc interface {
 	TemperatureImage(string)
 	// Показать изображение персоны
 	PersonImage(string)
+	// Хэндлер состояния фоновых обработчиков (количество сбоев, последние ошибки - только после авторизации)
+	Health(string)
+	// Хэндлер сводного отчёта за сутки из параметра :date в формате HTML или CSV
+	Report(string)
 	// Отсылка события измерения температуры
 	TemperatureChanged(model.TemperatureChange)
+	// Отсылка события о ходе синхронизации персон с СУДОС
+	PersonSyncChanged(model.PersonSync)
+	// Изменение списка термопадов и их количества на странице
+	SetTermopads(termopads []model.TermopadInfo, termopadsOnPage uint)
+	// Закрытие подписок и остановка WEB-сервера с ожиданием текущих запросов, но не дольше ctx
+	Shutdown(ctx context.Context) error
 }
 
 // SudosSvc репозиторий общения с СУДОС
@@ -28,6 +41,58 @@ type SudosSvc interface {
 	Person(model.Wigand) (*model.Person, error)
 	// Устанавливает температуру персоны.
 	SetPersonTemperature(model.Person, model.TemperatureEvent, model.TermopadInfo) error
+	// Изменяет адрес подключения к СУДОС с переподключением.
+	SetAddress(address string) error
+	// Изменяет шаблоны сообщений о нормальной, повышенной и пониженной температуре.
+	SetTemplates(normal, alarm, lower string) error
+	// Дожидается отправки сообщений из очереди, но не дольше ctx, и закрывает подключение.
+	Close(ctx context.Context) error
+}
+
+// ThresholdsSvc единые пороги нормальной температуры для всех компонентов
+//go:generate mockery --dir . --name ThresholdsSvc --output ./mocks
+type ThresholdsSvc interface {
+	// Текущие общие пороги температуры.
+	Thresholds() model.Thresholds
+	// Пороги температуры для термопада с учётом его собственных переопределений.
+	TermopadThresholds(model.TermopadInfo) model.Thresholds
+	// Изменяет общие пороги температуры. Изменения вступают в силу немедленно.
+	SetThresholds(model.Thresholds) error
+}
+
+// NotifySvc оповещение ответственных лиц о тревогах (почта, мессенджеры)
+//go:generate mockery --dir . --name NotifySvc --output ./mocks
+type NotifySvc interface {
+	// Ставит оповещение о тревоге в очередь отправки. Повторные тревоги по той же персоне отсекаются.
+	Alarm(model.Alarm)
+	// Дожидается отправки оповещений из очереди, но не дольше ctx.
+	Close(ctx context.Context) error
+}
+
+// WebhookSvc рассылка событий внешним системам через вебхуки
+//go:generate mockery --dir . --name WebhookSvc --output ./mocks
+type WebhookSvc interface {
+	// Рассылает событие о принятом замере температуры.
+	Measurement(model.TemperatureChange)
+	// Рассылает событие о повышенной температуре.
+	Alarm(model.Alarm)
+	// Рассылает событие о потере связи с термопадом.
+	TermopadDown(termopadID uint, at time.Time)
+	// Рассылает событие о добавлении или изменении данных персоны.
+	PersonUpdated(model.Person)
+	// Прекращает повторные попытки и дожидается обработки событий из очередей, но не дольше ctx.
+	Close(ctx context.Context) error
+}
+
+// MQTTSvc публикация замеров и состояния термопадов в брокер MQTT
+//go:generate mockery --dir . --name MQTTSvc --output ./mocks
+type MQTTSvc interface {
+	// Публикует принятый замер температуры.
+	Measurement(model.TemperatureChange)
+	// Публикует состояние связи с термопадом (сохраняемым сообщением).
+	TermopadStatus(termopadID uint, online bool)
+	// Публикует состояние сервера "offline" и отключается от брокера, но не дольше ctx.
+	Close(ctx context.Context) error
 }
 
 // TermopadSvc репозиторий работы с термопадом. Держит постоянно подключение к термопаду.
@@ -35,4 +100,6 @@ type SudosSvc interface {
 type TermopadSvc interface {
 	// Ожидает очередное сообщение от текромпада и возвращает в своём результате полученные данные.
 	EmmitTemperature() (*model.TermopadTemperatureEvent, error)
+	// Описание термопада.
+	Info() model.TermopadInfo
 }
\ No newline at end of file
@@ -1,13 +1,15 @@
 package sudos
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/kirsrus/termopad-server/model"
@@ -28,11 +30,10 @@ const (
 	cacheCleanupInterval = 10 * time.Minute // Интервал очистки мёртвых записях (сборщик мусора)
 	reconnectTimeout     = 10 * time.Second
 	requestTimeout       = 3 * time.Second
-	templateNormal       = "температура в норме (%0.1f°)"
-	templateAlarm        = "температура повышенная (%0.1f°)"
-	templateLower        = "низкая температура (%0.1f°)"
-	maxTemperature       = 37.5
-	minTemperature       = 34.0
+	closePollInterval    = 50 * time.Millisecond // Период проверки опустошения очереди отправки при закрытии
+	templateNormal       = `температура в норме ({{printf "%0.1f" .Temperature}}°)`
+	templateAlarm        = `температура повышенная ({{printf "%0.1f" .Temperature}}°)`
+	templateLower        = `низкая температура ({{printf "%0.1f" .Temperature}}°)`
 )
 
 // Тип текущего состояния подключения к термопаду
@@ -48,18 +49,21 @@ const (
 type Sudos struct {
 	ctx              context.Context
 	log              *logrus.Entry
+	mu               *sync.RWMutex // Защищает sudosUrl, conn, closed и шаблоны при изменении конфигурации на лету
 	sudosUrl         string
+	conn             *websocket.Conn
+	closed           bool
+	writeMu          *sync.Mutex // Удерживается на время отправки сообщения в WebSocket
 	reconnectTimeout time.Duration
 	requestTimeout   time.Duration
 	connectedFlag    conectType
 	readChan         chan []byte // Канал получения данных от СУДОС
 	writeChan        chan []byte // Канал отправки данных в СУДОС
 	cache            *cache.Cache
-	templateNormal   string
-	templateAlarm    string
-	templateLower    string
-	maxTemperature   float64
-	minTemperature   float64
+	templateNormal   *template.Template
+	templateAlarm    *template.Template
+	templateLower    *template.Template
+	thresholdsSvc    service.ThresholdsSvc
 	validator        *validator.Validator
 }
 
@@ -69,11 +73,25 @@ type ConfigSudos struct {
 	SudosUrl         string `conform:"trim" validate:"required,websocket"`
 	ReconnectTimeout time.Duration
 	RequestTimeout   time.Duration
-	TemplateNormal   string
-	TemplateAlarm    string
-	TemplateLower    string
-	MaxTemperature   float64
-	MinTemperature   float64
+	// Шаблоны text/template сообщений о нормальной, повышенной и пониженной температуре. В шаблон
+	// передаётся MessageData
+	TemplateNormal string
+	TemplateAlarm  string
+	TemplateLower  string
+	// Единые пороги нормальной температуры
+	ThresholdsSvc service.ThresholdsSvc
+}
+
+// MessageData данные, доступные в шаблонах сообщений в СУДОС
+type MessageData struct {
+	Person      model.Person
+	Temperature float64
+	// Пороги нормальной температуры, с которыми сравнивалась Temperature
+	MaxTemperature float64
+	MinTemperature float64
+	Termopad       model.TermopadInfo
+	// Номер кабины в СУДОС
+	Cabina uint
 }
 
 // NewSudos констурктор Sudos
@@ -87,6 +105,9 @@ func NewSudos(ctx context.Context, config *ConfigSudos) (service.SudosSvc, error
 		config.Log = logrus.New()
 		config.Log.Out = ioutil.Discard
 	}
+	if config.ThresholdsSvc == nil {
+		return nil, errors.New("не передана служба порогов температуры")
+	}
 
 	sudos := &Sudos{
 		ctx: ctx,
@@ -95,6 +116,8 @@ func NewSudos(ctx context.Context, config *ConfigSudos) (service.SudosSvc, error
 			"scope":   "store",
 			"address": config.SudosUrl,
 		}),
+		mu:               new(sync.RWMutex),
+		writeMu:          new(sync.Mutex),
 		sudosUrl:         config.SudosUrl,
 		reconnectTimeout: reconnectTimeout,
 		requestTimeout:   requestTimeout,
@@ -102,11 +125,7 @@ func NewSudos(ctx context.Context, config *ConfigSudos) (service.SudosSvc, error
 		readChan:         make(chan []byte, readChanCapacity),
 		writeChan:        make(chan []byte, writeChanCapacity),
 		cache:            cache.New(cacheExpiration, cacheCleanupInterval),
-		templateNormal:   templateNormal,
-		templateAlarm:    templateAlarm,
-		templateLower:    templateLower,
-		maxTemperature:   maxTemperature,
-		minTemperature:   minTemperature,
+		thresholdsSvc:    config.ThresholdsSvc,
 		validator:        validator.Get(),
 	}
 	if config.ReconnectTimeout != 0 {
@@ -116,11 +135,67 @@ func NewSudos(ctx context.Context, config *ConfigSudos) (service.SudosSvc, error
 		sudos.requestTimeout = config.RequestTimeout
 	}
 
+	if err := sudos.SetTemplates(config.TemplateNormal, config.TemplateAlarm, config.TemplateLower); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	go sudos.loop()
 
 	return sudos, nil
 }
 
+// SetAddress изменяет адрес WebSocket канала СУДОС. Текущее подключение закрывается и устанавливается
+// новое по изменённому адресу
+func (m *Sudos) SetAddress(address string) error {
+	if err := m.validator.Var(address, "required,websocket"); err != nil {
+		return errors.Annotatef(err, "некорректный адрес СУДОС \"%s\"", address)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sudosUrl == address {
+		return nil
+	}
+	m.log.Infof("адрес СУДОС изменён на %s", address)
+	m.sudosUrl = address
+	if m.conn != nil {
+		_ = m.conn.Close()
+	}
+	return nil
+}
+
+// SetTemplates изменяет шаблоны сообщений о нормальной, повышенной и пониженной температуре. Пустой
+// шаблон заменяется встроенным. При ошибке в любом из шаблонов текущие шаблоны не изменяются
+func (m *Sudos) SetTemplates(normal, alarm, lower string) error {
+	tmplNormal, err := parseTemplate("normal", normal, templateNormal)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmplAlarm, err := parseTemplate("alarm", alarm, templateAlarm)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmplLower, err := parseTemplate("lower", lower, templateLower)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	m.mu.Lock()
+	m.templateNormal, m.templateAlarm, m.templateLower = tmplNormal, tmplAlarm, tmplLower
+	m.mu.Unlock()
+	return nil
+}
+
+// Разбор шаблона сообщения text. Если text пустой, используется встроенный шаблон def
+func parseTemplate(name, text, def string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		text = def
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Annotatef(err, "ошибка в шаблоне сообщения %s", name)
+	}
+	return tmpl, nil
+}
+
 // Кольцевое обращение к СУДОС
 func (m *Sudos) loop() {
 	m.log.Info("старт работы модуля")
@@ -131,6 +206,13 @@ func (m *Sudos) loop() {
 			return
 		default:
 		}
+		m.mu.RLock()
+		closed := m.closed
+		m.mu.RUnlock()
+		if closed {
+			m.log.Info("завершение работы модуля")
+			return
+		}
 
 		err := m.connect()
 		if err != nil && err.Error() != context.Canceled.Error() {
@@ -141,7 +223,11 @@ func (m *Sudos) loop() {
 
 // Подключение по WebSocket к СУДОС
 func (m *Sudos) connect() error {
-	conn, _, err := websocket.DefaultDialer.Dial(m.sudosUrl, nil)
+	m.mu.RLock()
+	sudosUrl := m.sudosUrl
+	m.mu.RUnlock()
+
+	conn, _, err := websocket.DefaultDialer.Dial(sudosUrl, nil)
 	if err != nil {
 		if m.connectedFlag == connectUnknown || m.connectedFlag == connectSuccess {
 			m.log.Warnf("ошибка подключения: %v", err)
@@ -149,16 +235,32 @@ func (m *Sudos) connect() error {
 		m.connectedFlag = connectFailed
 		return errors.Trace(err)
 	}
-	defer func() { _ = conn.Close() }()
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		_ = conn.Close()
+		return nil
+	}
+	m.conn = conn
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.conn = nil
+		m.mu.Unlock()
+		_ = conn.Close()
+	}()
 	if m.connectedFlag == connectUnknown || m.connectedFlag == connectFailed {
-		m.log.Infof("подключение установлено")
+		m.log.Infof("подключение к %s установлено", sudosUrl)
 		m.connectedFlag = connectSuccess
 	}
 
 	g := new(errgroup.Group)
+	// Закрывается при завершении чтения, чтобы остановить запись и перейти к переподключению
+	readDone := make(chan struct{})
 
 	// Чтение из канала
 	g.Go(func() error {
+		defer close(readDone)
 		for {
 			tpe, message, err := conn.ReadMessage()
 			if err != nil {
@@ -229,20 +331,56 @@ func (m *Sudos) connect() error {
 
 	// Запись в канал
 	g.Go(func() error {
-		for write := range m.writeChan {
-			err = conn.WriteMessage(websocket.TextMessage, write)
-			if err != nil {
-				m.log.Warnf("ошибка записи в WebSocket: %v", err)
-				return errors.Trace(err)
+		for {
+			select {
+			case <-readDone:
+				return nil
+			case write := <-m.writeChan:
+				m.writeMu.Lock()
+				err := conn.WriteMessage(websocket.TextMessage, write)
+				m.writeMu.Unlock()
+				if err != nil {
+					m.log.Warnf("ошибка записи в WebSocket: %v", err)
+					_ = conn.Close() // Прерываем чтение
+					return errors.Trace(err)
+				}
 			}
 		}
-		return nil
 	})
 
 	err = g.Wait()
 	return errors.Trace(err)
 }
 
+// Close дожидается отправки накопившихся в очереди сообщений, но не дольше ctx, и закрывает подключение
+// к СУДОС. Переподключение после этого не выполняется
+func (m *Sudos) Close(ctx context.Context) error {
+	ticker := time.NewTicker(closePollInterval)
+	defer ticker.Stop()
+	var err error
+	for len(m.writeChan) != 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = errors.Annotatef(ctx.Err(), "не отправлено сообщений в СУДОС: %d", len(m.writeChan))
+		case <-ticker.C:
+		}
+	}
+	// Дожидаемся окончания отправки последнего сообщения
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.conn != nil {
+		deadline := time.Now().Add(closePollInterval)
+		_ = m.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), deadline)
+		_ = m.conn.Close()
+	}
+	m.log.Info("подключение к СУДОС закрыто")
+	return err
+}
+
 // Person запрашивает даныне персоны по номеру Wigand.
 func (m Sudos) Person(wigand model.Wigand) (*model.Person, error) {
 	if err := validator.Get().ValidateWithConform(&wigand); err != nil {
@@ -318,6 +456,42 @@ func (m Sudos) Person(wigand model.Wigand) (*model.Person, error) {
 	}
 }
 
+// Формирует по шаблону сообщение о температуре персоны для СУДОС. Пороги температуры берутся из
+// описания термопада, а если там они не заданы - общие. Возвращает признак тревожной температуры
+func (m Sudos) message(person model.Person, temperature model.TemperatureEvent, termopad model.TermopadInfo) (string, bool, error) {
+	thresholds := m.thresholdsSvc.TermopadThresholds(termopad)
+	data := MessageData{
+		Person:         person,
+		Temperature:    temperature.Temperature,
+		MaxTemperature: thresholds.MaxTemperature,
+		MinTemperature: thresholds.MinTemperature,
+		Termopad:       termopad,
+		Cabina:         termopad.SudosID,
+	}
+
+	m.mu.RLock()
+	templNormal, templAlarm, templLower := m.templateNormal, m.templateAlarm, m.templateLower
+	m.mu.RUnlock()
+
+	var tmpl *template.Template
+	var alarm bool
+	switch true {
+	case thresholds.IsAlarm(temperature.Temperature):
+		tmpl = templAlarm
+		alarm = true
+	case thresholds.IsLower(temperature.Temperature):
+		tmpl = templLower
+	default:
+		tmpl = templNormal
+	}
+
+	var message bytes.Buffer
+	if err := tmpl.Execute(&message, data); err != nil {
+		return "", false, errors.Annotatef(err, "ошибка формирования сообщения по шаблону %s", tmpl.Name())
+	}
+	return message.String(), alarm, nil
+}
+
 // SetPersonTemperature устанавливает температуру персоны.
 func (m Sudos) SetPersonTemperature(person model.Person, temperature model.TemperatureEvent, termopad model.TermopadInfo) error {
 	if err := m.validator.Validate(&person.Wigand); err != nil {
@@ -325,16 +499,9 @@ func (m Sudos) SetPersonTemperature(person model.Person, temperature model.Tempe
 	}
 
 	// Опредеяем результирующую строку
-	var message string
-	var alarm bool
-	switch true {
-	case temperature.Temperature >= m.maxTemperature:
-		message = fmt.Sprintf(m.templateAlarm, temperature.Temperature)
-		alarm = true
-	case temperature.Temperature < m.minTemperature:
-		message = fmt.Sprintf(m.templateLower, temperature.Temperature)
-	default:
-		message = fmt.Sprintf(m.templateNormal, temperature.Temperature)
+	message, alarm, err := m.message(person, temperature, termopad)
+	if err != nil {
+		return errors.Trace(err)
 	}
 
 	// Отправляем результат
@@ -0,0 +1,125 @@
+package sudos
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kirsrus/termopad-server/model"
+)
+
+// Постоянные пороги температуры для тестов
+type staticThresholds struct {
+	thresholds model.Thresholds
+}
+
+func (m staticThresholds) Thresholds() model.Thresholds { return m.thresholds }
+
+func (m staticThresholds) TermopadThresholds(termopad model.TermopadInfo) model.Thresholds {
+	result := m.thresholds
+	if termopad.MaxTemperature != 0 {
+		result.MaxTemperature = termopad.MaxTemperature
+	}
+	if termopad.MinTemperature != 0 {
+		result.MinTemperature = termopad.MinTemperature
+	}
+	return result
+}
+
+func (m staticThresholds) SetThresholds(model.Thresholds) error { return nil }
+
+func TestSudos_message(t *testing.T) {
+	// Отменённый контекст, чтобы не запускать подключение к СУДОС
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	person := model.Person{
+		Wigand: model.NewWigand(525935),
+		Family: "Иванов",
+		Name:   "Иван",
+	}
+	cabina := model.TermopadInfo{ID: 1, SudosID: 4, Name: "Кабина 4"}
+	cabinaStrict := model.TermopadInfo{ID: 2, SudosID: 5, Name: "Кабина 5", MaxTemperature: 37.0}
+
+	thresholds := model.Thresholds{MaxTemperature: 37.5, MinTemperature: 34.0}
+
+	tests := []struct {
+		name        string
+		config      ConfigSudos
+		thresholds  *model.Thresholds
+		temperature float64
+		termopad    model.TermopadInfo
+		wantMessage string
+		wantAlarm   bool
+		wantErr     bool
+	}{
+		{
+			name:        "встроенный шаблон нормальной температуры",
+			temperature: 36.6,
+			termopad:    cabina,
+			wantMessage: "температура в норме (36.6°)",
+		},
+		{
+			name:        "встроенный шаблон повышенной температуры",
+			temperature: 37.5,
+			termopad:    cabina,
+			wantMessage: "температура повышенная (37.5°)",
+			wantAlarm:   true,
+		},
+		{
+			name:        "изменённые общие пороги",
+			thresholds:  &model.Thresholds{MaxTemperature: 37.8, MinTemperature: 36.0},
+			temperature: 35.9,
+			termopad:    cabina,
+			wantMessage: "низкая температура (35.9°)",
+		},
+		{
+			name:        "порог термопада",
+			temperature: 37.1,
+			termopad:    cabinaStrict,
+			wantMessage: "температура повышенная (37.1°)",
+			wantAlarm:   true,
+		},
+		{
+			name: "пользовательский шаблон",
+			config: ConfigSudos{
+				TemplateAlarm: `{{.Person.Family}} {{.Person.Name}}: {{printf "%0.1f" .Temperature}} > {{.MaxTemperature}} ({{.Termopad.Name}}, кабина {{.Cabina}})`,
+			},
+			temperature: 38.2,
+			termopad:    cabinaStrict,
+			wantMessage: "Иванов Иван: 38.2 > 37 (Кабина 5, кабина 5)",
+			wantAlarm:   true,
+		},
+		{
+			name:    "ошибка в шаблоне",
+			config:  ConfigSudos{TemplateNormal: "{{.Temperature"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.SudosUrl = "ws://127.0.0.1:34888"
+			config.ThresholdsSvc = staticThresholds{thresholds}
+			if tt.thresholds != nil {
+				config.ThresholdsSvc = staticThresholds{*tt.thresholds}
+			}
+			svc, err := NewSudos(ctx, &config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSudos() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			message, alarm, err := svc.(*Sudos).message(person, model.TemperatureEvent{Temperature: tt.temperature}, tt.termopad)
+			if err != nil {
+				t.Fatalf("message() error = %v", err)
+			}
+			if message != tt.wantMessage {
+				t.Errorf("message() = %q, want %q", message, tt.wantMessage)
+			}
+			if alarm != tt.wantAlarm {
+				t.Errorf("message() alarm = %v, want %v", alarm, tt.wantAlarm)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package termopad
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Версия формата файла захвата
+const captureVersion = 1
+
+// Виды записей файла захвата
+const (
+	// Начало захвата (первая запись файла)
+	captureStart = "start"
+	// Сообщение, полученное от термопада по WebSocket
+	captureFrame = "frame"
+	// Результат скачивания изображения замера
+	captureImage = "image"
+	// Изменение доступности термопада
+	captureStatus = "status"
+)
+
+// Запись файла захвата. Файл захвата состоит из записей в формате JSON, по одной на строку
+type captureRecord struct {
+	At       time.Time `json:"at"`
+	Kind     string    `json:"kind"`
+	Termopad uint      `json:"termopad,omitempty"`
+	// Версия формата (только для captureStart)
+	Version int `json:"version,omitempty"`
+	// Сообщение термопада как есть (только для captureFrame)
+	Frame string `json:"frame,omitempty"`
+	// Имя файла изображения, его содержимое и ошибка скачивания (только для captureImage)
+	FileName string `json:"fileName,omitempty"`
+	Image    []byte `json:"image,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Доступность термопада (только для captureStatus)
+	Online bool `json:"online,omitempty"`
+}
+
+// Recorder запись сообщений термопадов, скачанных изображений и изменений доступности термопадов в файл
+// захвата для последующего воспроизведения через Replay. Инициализируется через NewRecorder. Один Recorder
+// используется всеми термопадами, записи различаются идентификатором термопада. Методы записи безопасны
+// для nil Recorder: в этом случае ничего не записывается
+type Recorder struct {
+	log *logrus.Entry
+
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// ConfigRecorder конфигурация Recorder
+type ConfigRecorder struct {
+	Log *logrus.Logger
+	// Файл захвата. Существующий файл дописывается
+	FileName string
+}
+
+// NewRecorder конструктор Recorder. После использования файл захвата закрывается через Close
+func NewRecorder(config *ConfigRecorder) (*Recorder, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if config.FileName == "" {
+		return nil, errors.New("не задан файл захвата")
+	}
+
+	file, err := os.OpenFile(config.FileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка открытия файла захвата")
+	}
+	res := &Recorder{
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "recorder",
+			"scope":  "store",
+			"file":   config.FileName,
+		}),
+		file: file,
+		enc:  json.NewEncoder(file),
+	}
+	if err := res.enc.Encode(captureRecord{At: time.Now(), Kind: captureStart, Version: captureVersion}); err != nil {
+		_ = file.Close()
+		return nil, errors.Annotate(err, "ошибка записи в файл захвата")
+	}
+	res.log.Info("запись захвата сообщений термопадов")
+	return res, nil
+}
+
+// Close закрывает файл захвата
+func (m *Recorder) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return errors.Trace(m.file.Close())
+}
+
+// Запись сообщения message термопада termopad, полученного в момент at
+func (m *Recorder) frame(termopad uint, at time.Time, message []byte) {
+	m.write(captureRecord{At: at, Kind: captureFrame, Termopad: termopad, Frame: string(message)})
+}
+
+// Запись результата скачивания изображения fileName с термопада termopad
+func (m *Recorder) image(termopad uint, at time.Time, fileName string, content []byte, err error) {
+	record := captureRecord{At: at, Kind: captureImage, Termopad: termopad, FileName: fileName, Image: content}
+	if err != nil {
+		record.Image, record.Error = nil, err.Error()
+	}
+	m.write(record)
+}
+
+// Запись изменения доступности термопада termopad
+func (m *Recorder) status(termopad uint, at time.Time, online bool) {
+	m.write(captureRecord{At: at, Kind: captureStatus, Termopad: termopad, Online: online})
+}
+
+func (m *Recorder) write(record captureRecord) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.enc.Encode(record); err != nil {
+		m.log.Warnf("ошибка записи в файл захвата: %v", err)
+	}
+}
+
+// Capture загруженный файл захвата. Загружается через LoadCapture
+type Capture struct {
+	// Момент начала захвата (первого захвата, если файл дописывался несколько раз)
+	start time.Time
+	// Записи по термопадам в порядке их записи
+	records map[uint][]captureRecord
+}
+
+// LoadCapture загружает файл захвата fileName, записанный Recorder
+func LoadCapture(fileName string) (*Capture, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка открытия файла захвата")
+	}
+	defer func() { _ = file.Close() }()
+
+	res := &Capture{records: make(map[uint][]captureRecord)}
+	dec := json.NewDecoder(file)
+	for line := 1; ; line++ {
+		var record captureRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Annotatef(err, "запись %d файла захвата повреждена", line)
+		}
+		switch record.Kind {
+		case captureStart:
+			if record.Version != captureVersion {
+				return nil, errors.Errorf("неподдерживаемая версия файла захвата %d", record.Version)
+			}
+			if res.start.IsZero() {
+				res.start = record.At
+			}
+		case captureFrame, captureImage, captureStatus:
+			if res.start.IsZero() {
+				return nil, errors.New("файл захвата не начинается с записи начала захвата")
+			}
+			res.records[record.Termopad] = append(res.records[record.Termopad], record)
+		default:
+			return nil, errors.Errorf("запись %d файла захвата неизвестного вида \"%s\"", line, record.Kind)
+		}
+	}
+	if res.start.IsZero() {
+		return nil, errors.New("файл захвата пуст")
+	}
+	return res, nil
+}
+
+// Termopads идентификаторы термопадов, сообщения которых есть в захвате
+func (m *Capture) Termopads() []uint {
+	result := make([]uint, 0, len(m.records))
+	for k := range m.records {
+		result = append(result, k)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// Start момент начала захвата
+func (m *Capture) Start() time.Time {
+	return m.start
+}
@@ -0,0 +1,161 @@
+package termopad
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+
+	"github.com/juju/errors"
+)
+
+// Сообщение термопада о новом изображении fileName
+func testFrame(fileName string) []byte {
+	return []byte(fmt.Sprintf(`{"action":"newImage","timestamp":"2020-11-27T12:37:54.838079","filename":"%s"}`, fileName))
+}
+
+func TestReplay(t *testing.T) {
+	const (
+		first   = "27-11-2020--12-37-54--123--36.6.jpg"
+		second  = "27-11-2020--12-38-10--Unknown--37.5.jpg"
+		failed  = "27-11-2020--12-38-20--124--36.4.jpg"
+		foreign = "27-11-2020--12-38-30--125--36.5.jpg"
+	)
+	fileName := filepath.Join(t.TempDir(), "capture.jsonl")
+	recorder, err := NewRecorder(&ConfigRecorder{FileName: fileName})
+	if err != nil {
+		t.Fatal(errors.ErrorStack(err))
+	}
+	begin := time.Date(2020, 11, 27, 12, 37, 50, 0, time.UTC)
+	recorder.status(1, begin, true)
+	recorder.frame(1, begin.Add(time.Second), testFrame(first))
+	recorder.image(1, begin.Add(time.Second), first, []byte("first"), nil)
+	// Повтор того же сообщения после переподключения
+	recorder.frame(1, begin.Add(2*time.Second), testFrame(first))
+	recorder.frame(1, begin.Add(3*time.Second), []byte("{некорректный json"))
+	recorder.frame(1, begin.Add(4*time.Second), testFrame(failed))
+	recorder.image(1, begin.Add(4*time.Second), failed, nil, errors.New("таймаут"))
+	// Повтор после неудачного скачивания даёт ещё одну попытку
+	recorder.frame(1, begin.Add(5*time.Second), testFrame(failed))
+	recorder.image(1, begin.Add(5*time.Second), failed, []byte("failed"), nil)
+	recorder.frame(2, begin.Add(6*time.Second), testFrame(foreign))
+	recorder.image(2, begin.Add(6*time.Second), foreign, []byte("foreign"), nil)
+	recorder.frame(1, begin.Add(7*time.Second), testFrame(second))
+	recorder.image(1, begin.Add(7*time.Second), second, []byte("second"), nil)
+	recorder.status(1, begin.Add(8*time.Second), false)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(errors.ErrorStack(err))
+	}
+
+	capture, err := LoadCapture(fileName)
+	if err != nil {
+		t.Fatal(errors.ErrorStack(err))
+	}
+	if got := capture.Termopads(); !reflect.DeepEqual(got, []uint{1, 2}) {
+		t.Errorf("Termopads() = %v, want [1 2]", got)
+	}
+
+	type event struct {
+		CreateAt    time.Time
+		FileName    string
+		Temperature float64
+		Wigand      uint
+		Image       string
+	}
+	want := []event{
+		{CreateAt: begin.Add(time.Second), FileName: first, Temperature: 36.6, Wigand: 123, Image: "first"},
+		{CreateAt: begin.Add(5 * time.Second), FileName: failed, Temperature: 36.4, Wigand: 124, Image: "failed"},
+		{CreateAt: begin.Add(7 * time.Second), FileName: second, Temperature: 37.5, Wigand: 0, Image: "second"},
+	}
+
+	tests := []struct {
+		name  string
+		speed float64
+	}{
+		{name: "без пауз", speed: 0},
+		{name: "ускоренное", speed: 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			statuses := make(chan bool, 2)
+			replay, err := NewReplay(ctx, &ConfigReplay{
+				TermopadInfo: model.TermopadInfo{ID: 1, URL: "ws://127.0.0.1:8000/feed", Name: "T1"},
+				Capture:      capture,
+				Speed:        tt.speed,
+				OnStatus:     func(online bool) { statuses <- online },
+			})
+			if err != nil {
+				t.Fatal(errors.ErrorStack(err))
+			}
+
+			got := make([]event, 0)
+			for range want {
+				res, err := replay.EmmitTemperature()
+				if err != nil {
+					t.Fatal(errors.ErrorStack(err))
+				}
+				got = append(got, event{
+					CreateAt:    res.CreateAt.UTC(),
+					FileName:    res.Temperature.FileName,
+					Temperature: res.Temperature.Temperature,
+					Wigand:      res.Temperature.Wigand.ID,
+					Image:       string(res.Temperature.Image),
+				})
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("EmmitTemperature() = %v, want %v", got, want)
+			}
+			for _, want := range []bool{true, false} {
+				select {
+				case online := <-statuses:
+					if online != want {
+						t.Errorf("OnStatus(%v), want %v", online, want)
+					}
+				case <-ctx.Done():
+					t.Fatal("не получено изменение доступности термопада")
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCapture(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "корректный",
+			content: `{"at":"2020-11-27T12:37:50Z","kind":"start","version":1}` + "\n" + `{"at":"2020-11-27T12:37:51Z","kind":"status","termopad":1,"online":true}`,
+		},
+		{name: "пустой", content: "", wantErr: true},
+		{name: "без записи начала", content: `{"at":"2020-11-27T12:37:51Z","kind":"status","termopad":1}`, wantErr: true},
+		{name: "неизвестная версия", content: `{"at":"2020-11-27T12:37:50Z","kind":"start","version":2}`, wantErr: true},
+		{name: "повреждённый", content: `{"at":"2020-11-27T12:37:50Z","kind":"start","version":1}` + "\n{", wantErr: true},
+		{
+			name:    "неизвестный вид записи",
+			content: `{"at":"2020-11-27T12:37:50Z","kind":"start","version":1}` + "\n" + `{"at":"2020-11-27T12:37:51Z","kind":"other"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "capture.jsonl")
+			if err := ioutil.WriteFile(fileName, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadCapture(fileName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadCapture() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
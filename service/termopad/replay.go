@@ -0,0 +1,196 @@
+package termopad
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/validator"
+	"github.com/kirsrus/termopad-server/service"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Replay имплементация термопада, воспроизводящая его сообщения из файла захвата, записанного Recorder.
+// Инициализируется через NewReplay. Сообщения обрабатываются так же, как в Websocket (проверка, отбрасывание
+// повторов, разбор имени файла), изображения берутся из захвата. Время замера - записанное время сообщения,
+// поэтому повторное воспроизведение захвата даёт те же замеры независимо от скорости
+type Replay struct {
+	termopadInfo model.TermopadInfo
+	ctx          context.Context
+	log          *logrus.Entry
+
+	// Записи захвата термопада и результаты скачивания изображений по именам файлов
+	records []captureRecord
+	images  map[string][]captureRecord
+	// Начало захвата, момент начала воспроизведения и его скорость
+	start time.Time
+	begin time.Time
+	speed float64
+	// Обработчик сообщений термопада
+	processor *Websocket
+	// Канал передачи результата. Не буферизирован: воспроизведение ждёт, пока замер заберут
+	resultChan chan model.TermopadTemperatureEvent
+	onStatus   func(online bool)
+}
+
+// ConfigReplay конфигурация Replay
+type ConfigReplay struct {
+	Log          *logrus.Logger
+	TermopadInfo model.TermopadInfo
+	// Загруженный файл захвата. Воспроизводятся записи термопада с идентификатором TermopadInfo.ID
+	Capture *Capture
+	// Скорость воспроизведения: 1 - реальное время, 10 - в 10 раз быстрее, 0 - без пауз
+	Speed float64
+	// Момент, соответствующий началу захвата (по умолчанию - момент создания Replay). Задаётся один для
+	// всех термопадов, чтобы их сообщения воспроизводились в записанном порядке
+	Begin time.Time
+	// Время, в течение которого повторные сообщения о том же файле отбрасываются
+	DedupeWindow time.Duration
+	// Вызывается при записанных потере (online=false) и восстановлении связи с термопадом
+	OnStatus func(online bool)
+}
+
+// NewReplay конструктор Replay
+func NewReplay(ctx context.Context, config *ConfigReplay) (service.TermopadSvc, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if err := validator.Get().Validate(&config.TermopadInfo); err != nil {
+		return nil, errors.Annotate(err, "некорректное описание термопада")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if config.Capture == nil {
+		return nil, errors.New("не задан файл захвата")
+	}
+	if config.Speed < 0 {
+		return nil, errors.New("скорость воспроизведения не может быть отрицательной")
+	}
+
+	log := config.Log.WithFields(map[string]interface{}{
+		"module":  "replay",
+		"scope":   "store",
+		"id":      config.TermopadInfo.ID,
+		"address": config.TermopadInfo.URL,
+	})
+	dedupeWindow := DedupeWindow
+	if config.DedupeWindow != 0 {
+		dedupeWindow = config.DedupeWindow
+	}
+	res := &Replay{
+		termopadInfo: config.TermopadInfo,
+		ctx:          ctx,
+		log:          log,
+		images:       make(map[string][]captureRecord),
+		start:        config.Capture.start,
+		begin:        config.Begin,
+		speed:        config.Speed,
+		processor: &Websocket{
+			termopadInfo: config.TermopadInfo,
+			ctx:          ctx,
+			log:          log,
+			seen:         newDedupe(dedupeWindow),
+		},
+		resultChan: make(chan model.TermopadTemperatureEvent),
+		onStatus:   config.OnStatus,
+	}
+	if res.begin.IsZero() {
+		res.begin = time.Now()
+	}
+	for _, v := range config.Capture.records[config.TermopadInfo.ID] {
+		if v.Kind == captureImage {
+			res.images[v.FileName] = append(res.images[v.FileName], v)
+			continue
+		}
+		res.records = append(res.records, v)
+	}
+
+	go res.loop()
+
+	return res, nil
+}
+
+// Воспроизведение записей захвата с соблюдением интервалов между ними
+func (m *Replay) loop() {
+	m.log.Infof("старт воспроизведения захвата (записей %d, скорость %g)", len(m.records), m.speed)
+
+	for _, record := range m.records {
+		if !m.wait(record.At) {
+			m.log.Info("завершение работы модуля")
+			return
+		}
+		switch record.Kind {
+		case captureStatus:
+			if m.onStatus != nil {
+				m.onStatus(record.Online)
+			}
+		case captureFrame:
+			res := m.processor.handle([]byte(record.Frame), record.At, m.fetch)
+			if res == nil {
+				continue
+			}
+			at := record.At
+			select {
+			case m.resultChan <- model.TermopadTemperatureEvent{CreateAt: &at, Info: m.termopadInfo, Temperature: *res}:
+			case <-m.ctx.Done():
+				m.log.Info("завершение работы модуля")
+				return
+			}
+		}
+	}
+
+	m.log.Info("воспроизведение захвата завершено")
+}
+
+// Ожидание момента воспроизведения записи, сделанной в момент at. Возвращает false при завершении работы
+func (m *Replay) wait(at time.Time) bool {
+	var delay time.Duration
+	if m.speed != 0 {
+		delay = time.Until(m.begin.Add(time.Duration(float64(at.Sub(m.start)) / m.speed)))
+	}
+	if delay <= 0 {
+		return m.ctx.Err() == nil
+	}
+	select {
+	case <-m.ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// Изображение fileName из захвата. Повторные скачивания одного файла берутся из захвата по порядку
+func (m *Replay) fetch(fileName string) ([]byte, error) {
+	queue := m.images[fileName]
+	if len(queue) == 0 {
+		m.log.Warnf("изображение %s отсутствует в захвате", fileName)
+		return nil, errors.NotFoundf("изображение %s в захвате", fileName)
+	}
+	m.images[fileName] = queue[1:]
+	if queue[0].Error != "" {
+		m.log.Warnf("изображение %s не скачано: %s", fileName, queue[0].Error)
+		return nil, errors.New(queue[0].Error)
+	}
+	return queue[0].Image, nil
+}
+
+// Info описание термопада
+func (m *Replay) Info() model.TermopadInfo {
+	return m.termopadInfo
+}
+
+// EmmitTemperature ожидает очередной воспроизведённый замер. После окончания захвата ожидает завершения работы.
+// В случае штатного завершения работы, возвращаетя ошибка context.Canceled
+func (m *Replay) EmmitTemperature() (*model.TermopadTemperatureEvent, error) {
+	select {
+	case result := <-m.resultChan:
+		return &result, nil
+	case <-m.ctx.Done():
+		return nil, m.ctx.Err()
+	}
+}
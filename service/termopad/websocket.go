@@ -25,6 +25,8 @@ const (
 	MaximumResultChan = 20
 	ReconnectTimeout  = 5 * time.Second
 	DownloadTimeout   = 2 * time.Second
+	// Время, в течение которого повторные сообщения о том же файле отбрасываются
+	DedupeWindow = 10 * time.Minute
 )
 
 // Тип текущего состояния подключения к термопаду
@@ -47,6 +49,13 @@ type Websocket struct {
 	// Канал передачи результата
 	resultChan    chan model.TemperatureEvent
 	connectedFlag connectType
+	// Имена недавно принятых файлов. Сохраняются между переподключениями, т.к. после
+	// переподключения термопад может повторить уже отправленные сообщения
+	seen *dedupe
+	// Уведомление об изменении доступности термопада
+	onStatus func(online bool)
+	// Запись сообщений термопада в файл захвата (может отсутствовать)
+	recorder *Recorder
 }
 
 // ConfigWebsocket конфигурация Websocket
@@ -55,6 +64,12 @@ type ConfigWebsocket struct {
 	TermopadInfo     model.TermopadInfo
 	ReconnectTimeout time.Duration
 	DownloadTimeout  time.Duration
+	// Время, в течение которого повторные сообщения о том же файле отбрасываются
+	DedupeWindow time.Duration
+	// Вызывается при потере (online=false) и восстановлении связи с термопадом
+	OnStatus func(online bool)
+	// Запись сообщений термопада и скачанных изображений в файл захвата (если не задана - не ведётся)
+	Recorder *Recorder
 }
 
 // NewWebsocket конструктор структуры Websocket
@@ -85,7 +100,14 @@ func NewWebsocket(ctx context.Context, config *ConfigWebsocket) (service.Termopa
 		downloadTimeout:  DownloadTimeout,
 		resultChan:       make(chan model.TemperatureEvent, MaximumResultChan),
 		connectedFlag:    connectUnknown,
+		onStatus:         config.OnStatus,
+		recorder:         config.Recorder,
 	}
+	dedupeWindow := DedupeWindow
+	if config.DedupeWindow != 0 {
+		dedupeWindow = config.DedupeWindow
+	}
+	res.seen = newDedupe(dedupeWindow)
 	if config.ReconnectTimeout != 0 {
 		res.reconnectTimeout = config.ReconnectTimeout
 	}
@@ -130,6 +152,9 @@ func (m *Websocket) connect() error {
 		if m.connectedFlag == connectUnknown || m.connectedFlag == connectSuccess {
 			m.log.Warnf("ошибка подключения: %v", err)
 		}
+		if m.connectedFlag != connectFailed {
+			m.status(false)
+		}
 		m.connectedFlag = connectFailed
 		return errors.Trace(err)
 	}
@@ -137,6 +162,7 @@ func (m *Websocket) connect() error {
 	if m.connectedFlag == connectUnknown || m.connectedFlag == connectFailed {
 		m.log.Infof("подключение установлено")
 		m.connectedFlag = connectSuccess
+		m.status(true)
 	}
 
 	// Бесконечно читаем из канала WebSocket
@@ -167,10 +193,6 @@ func (m *Websocket) connect() error {
 		}
 	}()
 
-	// Имя предыдущего скачанного изображения, чтобы не попадать на
-	// ошибку термопада с одновременно несколькими одинаковыми сообщениями о скачивании в течении
-	// нескльких миллисекунд с одними и темиже данными, но разным временем посыла
-	var prevousFileName string
 	// Обрабатываем результат чтения
 	for {
 		select {
@@ -179,48 +201,81 @@ func (m *Websocket) connect() error {
 		case err := <-done:
 			return err
 		case message := <-read:
-			msg := model.TermopadAction{}
-			if err = json.Unmarshal(message, &msg); err != nil {
-				m.log.Warnf("пршиёл некорректный json \"%s\" с ошибкой: %s", string(message), err.Error())
+			at := time.Now()
+			m.recorder.frame(m.termopadInfo.ID, at, message)
+			res := m.handle(message, at, m.download)
+			if res == nil {
 				continue
 			}
-			if err = msg.Validate(); err != nil {
-				m.log.Warnf("ошибка валидации полученного json: %v", err)
-				continue
+			select {
+			case m.resultChan <- *res:
+			default:
+				m.log.Warnf("канал resultChan переполнен")
 			}
+		}
+	}
+}
 
-			if msg.Action == "newImage" && msg.FileName != prevousFileName {
-				prevousFileName = msg.FileName
+// Обработка сообщения термопада message, полученного в момент at. Возвращает замер температуры или nil, если
+// сообщение не содержит нового замера. Изображение замера получается через fetch по имени файла
+func (m *Websocket) handle(message []byte, at time.Time, fetch func(fileName string) ([]byte, error)) *model.TemperatureEvent {
+	msg := model.TermopadAction{}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		m.log.Warnf("пршиёл некорректный json \"%s\" с ошибкой: %s", string(message), err.Error())
+		return nil
+	}
+	if err := msg.Validate(); err != nil {
+		m.log.Warnf("ошибка валидации полученного json: %v", err)
+		return nil
+	}
+	if msg.Action != "newImage" {
+		return nil
+	}
 
-				// Распарсиваем имя файла (там все данные)
-				termopadFileName := model.TermopadFileName{}
-				if err = termopadFileName.Parse(msg.FileName); err != nil {
-					m.log.Warnf("нераспознаваемое имя файла '%s': %v", msg.FileName, err)
-					continue
-				}
+	// Термопад может прислать несколько одинаковых сообщений о скачивании с разным временем
+	// посыла, в том числе не подряд и после переподключения
+	if !m.seen.add(msg.FileName, at) {
+		m.log.Debugf("повторное сообщение о файле '%s' отброшено", msg.FileName)
+		return nil
+	}
 
-				// Скачиваем изображение
-				addr, _ := url.Parse(m.termopadInfo.URL)
-				URL := fmt.Sprintf(ImageUrlTemplate, addr.Host, msg.FileName)
-				immageContent, err := m.downloadContent(URL)
-				if err != nil {
-					continue
-				}
+	// Распарсиваем имя файла (там все данные)
+	termopadFileName := model.TermopadFileName{}
+	if err := termopadFileName.Parse(msg.FileName); err != nil {
+		m.log.Warnf("нераспознаваемое имя файла '%s': %v", msg.FileName, err)
+		return nil
+	}
 
-				res := model.TemperatureEvent{
-					Temperature: termopadFileName.Temperature,
-					Wigand:      termopadFileName.Wigand,
-					Image:       immageContent,
-				}
+	// Скачиваем изображение
+	immageContent, err := fetch(msg.FileName)
+	if err != nil {
+		// Повторное сообщение о файле даст ещё одну попытку скачивания
+		m.seen.delete(msg.FileName)
+		return nil
+	}
 
-				select {
-				case m.resultChan <- res:
-				default:
-					m.log.Warnf("канал resultChan переполнен")
-					continue
-				}
-			}
-		}
+	return &model.TemperatureEvent{
+		Temperature: termopadFileName.Temperature,
+		Invalid:     model.IsInvalidReading(termopadFileName.Temperature),
+		Wigand:      m.cardWigand(termopadFileName.Wigand),
+		Image:       immageContent,
+		FileName:    msg.FileName,
+	}
+}
+
+// Скачивание с термопада изображения замера fileName с записью результата в файл захвата
+func (m *Websocket) download(fileName string) ([]byte, error) {
+	addr, _ := url.Parse(m.termopadInfo.URL)
+	content, err := m.downloadContent(fmt.Sprintf(ImageUrlTemplate, addr.Host, fileName))
+	m.recorder.image(m.termopadInfo.ID, time.Now(), fileName, content, err)
+	return content, err
+}
+
+// Уведомление об изменении доступности термопада с записью в файл захвата
+func (m *Websocket) status(online bool) {
+	m.recorder.status(m.termopadInfo.ID, time.Now(), online)
+	if m.onStatus != nil {
+		m.onStatus(online)
 	}
 }
 
@@ -255,6 +310,33 @@ func (m Websocket) downloadContent(URL string) ([]byte, error) {
 	return data, nil
 }
 
+// Номер карты в формате термопада. Если термопад передаёт полный кадр Wiegand, проверяется его
+// чётность; при ошибке карта считается нераспознанной, а замер температуры сохраняется
+func (m Websocket) cardWigand(wigand model.Wigand) model.Wigand {
+	wigand.Format = m.termopadInfo.CardFormat
+	if wigand.IsEmpty() {
+		return wigand
+	}
+	format := wigand.CardFormat()
+	if m.termopadInfo.CardFrame {
+		id, err := format.Decode(uint64(wigand.ID))
+		if err != nil {
+			m.log.Warnf("карта не распознана: %v", err)
+			wigand.ID = 0
+			return wigand
+		}
+		wigand.ID = uint(id)
+	} else if err := format.Check(uint64(wigand.ID)); err != nil {
+		m.log.Warn(err)
+	}
+	return wigand
+}
+
+// Info описание термопада
+func (m Websocket) Info() model.TermopadInfo {
+	return m.termopadInfo
+}
+
 // EmmitTemperature ожидает данные от термопада и возвращает в свойм результате полученные данные.
 // В случае штатного завершения работы, возвращаетя ошибка context.Canceled
 func (m Websocket) EmmitTemperature() (*model.TermopadTemperatureEvent, error) {
@@ -271,3 +353,33 @@ func (m Websocket) EmmitTemperature() (*model.TermopadTemperatureEvent, error) {
 		return nil, m.ctx.Err()
 	}
 }
+
+// Имена недавно принятых файлов с моментом их приёма. Время передаётся явно, чтобы при воспроизведении
+// захвата повторы отбрасывались по записанному времени сообщений, а не по времени воспроизведения
+type dedupe struct {
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+func newDedupe(window time.Duration) *dedupe {
+	return &dedupe{window: window, seen: make(map[string]time.Time)}
+}
+
+// Запоминает файл name, принятый в момент at. Возвращает false, если файл уже принимался в пределах окна
+func (m *dedupe) add(name string, at time.Time) bool {
+	for k, v := range m.seen {
+		if at.Sub(v) >= m.window {
+			delete(m.seen, k)
+		}
+	}
+	if _, ok := m.seen[name]; ok {
+		return false
+	}
+	m.seen[name] = at
+	return true
+}
+
+// Забывает файл name
+func (m *dedupe) delete(name string) {
+	delete(m.seen, name)
+}
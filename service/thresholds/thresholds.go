@@ -0,0 +1,116 @@
+package thresholds
+
+import (
+	"context"
+	"io/ioutil"
+	"sync"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/service"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Thresholds единые пороги нормальной температуры. Имплементирует интерфейс ThresholdsSvc.
+// Инициализируется через NewThresholds. Значения хранятся в таблице config БД, а при её отсутствии
+// заполняются из конфигурационного файла.
+type Thresholds struct {
+	ctx     context.Context
+	log     *logrus.Entry
+	dbStore store.DbStore
+
+	mu      sync.RWMutex
+	current model.Thresholds
+}
+
+// ConfigThresholds конфигурация Thresholds
+type ConfigThresholds struct {
+	Log *logrus.Logger
+	// Начальные пороги температуры, если в БД они ещё не сохранены
+	MaxTemperature float64
+	MinTemperature float64
+}
+
+// NewThresholds конструктор Thresholds
+func NewThresholds(ctx context.Context, dbStore store.DbStore, config *ConfigThresholds) (service.ThresholdsSvc, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if dbStore == nil {
+		return nil, errors.New("не указана служба dbStore")
+	}
+
+	thresholds := Thresholds{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "thresholds",
+			"scope":  "service",
+		}),
+		dbStore: dbStore,
+	}
+
+	current, err := dbStore.Thresholds()
+	if err != nil {
+		if !dbStore.IsNotFound(err) {
+			return nil, errors.Annotate(err, "ошибка чтения порогов температуры из БД")
+		}
+		// Первый запуск: переносим пороги из конфигурационного файла в БД
+		current = &model.Thresholds{
+			MaxTemperature: config.MaxTemperature,
+			MinTemperature: config.MinTemperature,
+		}
+		if err := dbStore.SetThresholds(*current); err != nil {
+			return nil, errors.Annotate(err, "ошибка сохранения начальных порогов температуры")
+		}
+		thresholds.log.Infof("пороги температуры %0.1f..%0.1f перенесены из конфигурации в БД",
+			current.MinTemperature, current.MaxTemperature)
+	}
+	if err := current.Validate(); err != nil {
+		return nil, errors.Annotate(err, "некорректные пороги температуры")
+	}
+	thresholds.current = *current
+	thresholds.log.Debugf("пороги температуры: %0.1f..%0.1f", current.MinTemperature, current.MaxTemperature)
+
+	return &thresholds, nil
+}
+
+// Thresholds текущие общие пороги температуры
+func (m *Thresholds) Thresholds() model.Thresholds {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// TermopadThresholds пороги температуры для термопада termopad с учётом его собственных переопределений
+func (m *Thresholds) TermopadThresholds(termopad model.TermopadInfo) model.Thresholds {
+	result := m.Thresholds()
+	if termopad.MaxTemperature != 0 {
+		result.MaxTemperature = termopad.MaxTemperature
+	}
+	if termopad.MinTemperature != 0 {
+		result.MinTemperature = termopad.MinTemperature
+	}
+	return result
+}
+
+// SetThresholds изменяет и сохраняет в БД общие пороги температуры
+func (m *Thresholds) SetThresholds(thresholds model.Thresholds) error {
+	if err := thresholds.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.dbStore.SetThresholds(thresholds); err != nil {
+		return errors.Trace(err)
+	}
+	m.log.Infof("пороги температуры изменены: %0.1f..%0.1f -> %0.1f..%0.1f",
+		m.current.MinTemperature, m.current.MaxTemperature, thresholds.MinTemperature, thresholds.MaxTemperature)
+	m.current = thresholds
+	return nil
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/service/web/graph"
+
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User пользователь WEB-интерфейса
+type User struct {
+	Name string
+	// Хеш пароля bcrypt
+	PasswordHash string
+	// Роль: model.UserRoleAdmin или model.UserRoleOperator
+	Role string
+}
+
+// Проверка учётных данных пользователей. Проверка bcrypt медленная, поэтому успешно проверенные пары
+// имя-пароль запоминаются (в виде хеша) до перезапуска
+type authenticator struct {
+	users    map[string]User
+	verified *sync.Map
+}
+
+func newAuthenticator(users []User) *authenticator {
+	result := &authenticator{
+		users:    make(map[string]User, len(users)),
+		verified: new(sync.Map),
+	}
+	for _, v := range users {
+		result.users[v.Name] = v
+	}
+	return result
+}
+
+// Включена ли авторизация
+func (m *authenticator) enabled() bool {
+	return len(m.users) != 0
+}
+
+// Пользователь с именем name и паролем password (false, если учётные данные неверны)
+func (m *authenticator) check(name string, password string) (User, bool) {
+	user, ok := m.users[name]
+	if !ok {
+		return User{}, false
+	}
+	sum := sha256.Sum256([]byte(name + "\x00" + password))
+	key := hex.EncodeToString(sum[:])
+	if _, ok := m.verified.Load(key); ok {
+		return user, true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return User{}, false
+	}
+	m.verified.Store(key, true)
+	return user, true
+}
+
+// Middleware авторизации по HTTP Basic. Пользователь запроса передаётся резолверам GraphQL и обработчикам через
+// контекст запроса (graph.RequesterFromContext). При отключённой авторизации запросы выполняются с правами
+// администратора без имени пользователя. Пути из public доступны без авторизации: без учётных данных запрос к ним
+// выполняется без роли (model.Requester.Anonymous). Здесь же определяется режим приватности запроса
+func (m Web) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		requester := model.Requester{Role: model.UserRoleAdmin, IP: m.clientIP(c)}
+		if m.auth.enabled() {
+			name, password, ok := c.Request().BasicAuth()
+			if ok {
+				var user User
+				if user, ok = m.auth.check(name, password); ok {
+					requester.User, requester.Role = user.Name, user.Role
+				}
+			}
+			if !ok {
+				if !m.public[c.Path()] {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="termopad", charset="UTF-8"`)
+					return echo.ErrUnauthorized
+				}
+				requester.Role = ""
+			}
+		}
+		requester.Privacy = m.privacy(c, requester.Role)
+		c.SetRequest(c.Request().WithContext(graph.WithRequester(c.Request().Context(), requester)))
+		return next(c)
+	}
+}
@@ -0,0 +1,257 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/service/web/graph"
+
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestWeb_authenticate(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []User{
+		{Name: "admin", PasswordHash: string(hash), Role: model.UserRoleAdmin},
+		{Name: "operator", PasswordHash: string(hash), Role: model.UserRoleOperator},
+		{Name: "lobby", PasswordHash: string(hash), Role: model.UserRoleViewer},
+	}
+
+	tests := []struct {
+		name     string
+		users    []User
+		path     string
+		user     string
+		password string
+		// Заголовок X-Privacy
+		privacy string
+		// Сети клиентов с режимом приватности
+		privacyClients []string
+		// Заголовки с адресом клиента и сети доверенных прокси
+		headers        map[string]string
+		trustedProxies []string
+		wantCode       int
+		want           model.Requester
+	}{
+		{
+			name:     "авторизация отключена",
+			path:     "/api",
+			wantCode: http.StatusOK,
+			want:     model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1"},
+		},
+		{
+			name:     "без учётных данных",
+			users:    users,
+			path:     "/api",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "неверный пароль",
+			users:    users,
+			path:     "/api",
+			user:     "operator",
+			password: "guess",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "неизвестный пользователь",
+			users:    users,
+			path:     "/api",
+			user:     "guest",
+			password: "secret",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "оператор",
+			users:    users,
+			path:     "/api",
+			user:     "operator",
+			password: "secret",
+			wantCode: http.StatusOK,
+			want:     model.Requester{User: "operator", Role: model.UserRoleOperator, IP: "192.0.2.1"},
+		},
+		{
+			name:     "открытый путь без учётных данных",
+			users:    users,
+			path:     "/health",
+			wantCode: http.StatusOK,
+			want:     model.Requester{IP: "192.0.2.1"},
+		},
+		{
+			name:     "открытый путь с неверным паролем",
+			users:    users,
+			path:     "/health",
+			user:     "operator",
+			password: "guess",
+			wantCode: http.StatusOK,
+			want:     model.Requester{IP: "192.0.2.1"},
+		},
+		{
+			name:     "открытый путь с учётными данными",
+			users:    users,
+			path:     "/health",
+			user:     "operator",
+			password: "secret",
+			wantCode: http.StatusOK,
+			want:     model.Requester{User: "operator", Role: model.UserRoleOperator, IP: "192.0.2.1"},
+		},
+		{
+			name:     "табло",
+			users:    users,
+			path:     "/api",
+			user:     "lobby",
+			password: "secret",
+			wantCode: http.StatusOK,
+			want:     model.Requester{User: "lobby", Role: model.UserRoleViewer, IP: "192.0.2.1", Privacy: true},
+		},
+		{
+			name:           "клиент из сети с режимом приватности",
+			path:           "/api",
+			privacyClients: []string{"198.51.100.7", "192.0.2.0/24"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1", Privacy: true},
+		},
+		{
+			name:           "клиент вне сетей с режимом приватности",
+			path:           "/api",
+			privacyClients: []string{"198.51.100.0/24"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1"},
+		},
+		{
+			name:     "подставленный адрес без доверенных прокси",
+			path:     "/api",
+			headers:  map[string]string{echo.HeaderXForwardedFor: "203.0.113.9", echo.HeaderXRealIP: "203.0.113.9"},
+			wantCode: http.StatusOK,
+			want:     model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1"},
+		},
+		{
+			name:           "подставленный адрес не от доверенного прокси",
+			path:           "/api",
+			headers:        map[string]string{echo.HeaderXForwardedFor: "203.0.113.9"},
+			trustedProxies: []string{"198.51.100.0/24"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1"},
+		},
+		{
+			name:           "адрес от доверенного прокси",
+			path:           "/api",
+			headers:        map[string]string{echo.HeaderXForwardedFor: "203.0.113.9"},
+			trustedProxies: []string{"192.0.2.0/24"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "203.0.113.9"},
+		},
+		{
+			name:           "цепочка прокси с адресом, подставленным клиентом",
+			path:           "/api",
+			headers:        map[string]string{echo.HeaderXForwardedFor: "198.51.100.1, 203.0.113.9, 192.0.2.10"},
+			trustedProxies: []string{"192.0.2.0/24"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "203.0.113.9"},
+		},
+		{
+			name:           "X-Real-IP от доверенного прокси",
+			path:           "/api",
+			headers:        map[string]string{echo.HeaderXRealIP: "203.0.113.9"},
+			trustedProxies: []string{"192.0.2.1"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "203.0.113.9"},
+		},
+		{
+			name:           "подставленный адрес клиента с режимом приватности",
+			path:           "/api",
+			privacyClients: []string{"198.51.100.0/24"},
+			headers:        map[string]string{echo.HeaderXForwardedFor: "198.51.100.7", echo.HeaderXRealIP: "198.51.100.7"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1"},
+		},
+		{
+			name:           "клиент с режимом приватности подставляет другой адрес",
+			path:           "/api",
+			privacyClients: []string{"192.0.2.0/24"},
+			headers:        map[string]string{echo.HeaderXForwardedFor: "203.0.113.9"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1", Privacy: true},
+		},
+		{
+			name:           "клиент с режимом приватности за доверенным прокси",
+			path:           "/api",
+			privacyClients: []string{"198.51.100.0/24"},
+			headers:        map[string]string{echo.HeaderXForwardedFor: "198.51.100.7"},
+			trustedProxies: []string{"192.0.2.1"},
+			wantCode:       http.StatusOK,
+			want:           model.Requester{Role: model.UserRoleAdmin, IP: "198.51.100.7", Privacy: true},
+		},
+		{
+			name:     "режим приватности по заголовку",
+			path:     "/api",
+			privacy:  "1",
+			wantCode: http.StatusOK,
+			want:     model.Requester{Role: model.UserRoleAdmin, IP: "192.0.2.1", Privacy: true},
+		},
+		{
+			name:     "режим приватности по параметру запроса",
+			users:    users,
+			path:     "/api?privacy=true",
+			user:     "operator",
+			password: "secret",
+			wantCode: http.StatusOK,
+			want:     model.Requester{User: "operator", Role: model.UserRoleOperator, IP: "192.0.2.1", Privacy: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			privacyClients, err := parseNetworks(tt.privacyClients)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trustedProxies, err := parseNetworks(tt.trustedProxies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			web := Web{
+				e:              echo.New(),
+				auth:           newAuthenticator(tt.users),
+				public:         map[string]bool{"/health": true},
+				privacyClients: privacyClients,
+				trustedProxies: trustedProxies,
+			}
+			var got model.Requester
+			handler := func(c echo.Context) error {
+				got = graph.RequesterFromContext(c.Request().Context())
+				return c.NoContent(http.StatusOK)
+			}
+			web.e.Use(web.authenticate)
+			web.e.GET("/api", handler)
+			web.e.GET("/health", handler)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+			if tt.privacy != "" {
+				req.Header.Set(privacyHeader, tt.privacy)
+			}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			web.e.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("код ответа = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get(echo.HeaderWWWAuthenticate) == "" {
+				t.Error("нет заголовка WWW-Authenticate")
+			}
+			if got != tt.want {
+				t.Errorf("пользователь запроса = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
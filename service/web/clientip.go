@@ -0,0 +1,66 @@
+package web
+
+import (
+	"net"
+	"strings"
+
+	"github.com/kirsrus/termopad-server/pkg/tool"
+
+	"github.com/juju/errors"
+	"github.com/labstack/echo"
+)
+
+// Разбор списка адресов и сетей (CIDR)
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		network, err := tool.ParseNetwork(v)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// Входит ли адрес ip в одну из сетей networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, v := range networks {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Адрес клиента запроса. Берётся адрес соединения; заголовкам X-Forwarded-For и X-Real-IP, которые клиент может
+// подставить сам, доверяется только при соединении с доверенного прокси из trustedProxies. В X-Forwarded-For
+// адресом клиента считается последний адрес, не принадлежащий доверенным прокси
+func (m Web) clientIP(c echo.Context) string {
+	remote := c.Request().RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil || !containsIP(m.trustedProxies, ip) {
+		return remote
+	}
+	if forwarded := c.Request().Header.Get(echo.HeaderXForwardedFor); forwarded != "" {
+		chain := strings.Split(forwarded, ",")
+		for i := len(chain) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(chain[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !containsIP(m.trustedProxies, hop) {
+				break
+			}
+		}
+		return ip.String()
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(c.Request().Header.Get(echo.HeaderXRealIP))); realIP != nil {
+		return realIP.String()
+	}
+	return remote
+}
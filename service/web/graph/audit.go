@@ -0,0 +1,66 @@
+package graph
+
+import (
+	"context"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+)
+
+// Ключ пользователя в контексте запроса
+type requesterKey struct{}
+
+// WithRequester возвращает контекст запроса с пользователем requester, от имени которого выполняются резолверы
+func WithRequester(ctx context.Context, requester model.Requester) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+// RequesterFromContext пользователь, выполняющий запрос. Если пользователь не передан через WithRequester,
+// возвращается пользователь без прав администратора
+func RequesterFromContext(ctx context.Context) model.Requester {
+	if requester, ok := ctx.Value(requesterKey{}).(model.Requester); ok {
+		return requester
+	}
+	return model.Requester{}
+}
+
+// Audit записывает в журнал аудита действие action над объектом target, выполненное пользователем запроса ctx.
+// Просмотр персональных данных без записи в журнал не выполняется, поэтому ошибка записи возвращается вызывающему
+func Audit(ctx context.Context, db store.DbStore, action string, target string, details string) error {
+	requester := RequesterFromContext(ctx)
+	return db.SetAudit(model.Audit{
+		CreateAt: time.Now(),
+		User:     requester.User,
+		Action:   action,
+		Target:   target,
+		IP:       requester.IP,
+		Details:  details,
+	})
+}
+
+// Запись в журнал аудита выполненного изменения. Изменение уже произошло, поэтому ошибка записи только логируется
+func (r *Resolver) auditChange(ctx context.Context, action string, target string, details string) {
+	if err := Audit(ctx, r.db, action, target, details); err != nil {
+		r.log.Warnf("ошибка записи в журнал аудита: %v", err)
+	}
+}
+
+// Запись в журнал аудита просмотра данных. Данные возвращаются только при успешной записи
+func (r *Resolver) auditAccess(ctx context.Context, action string, target string, details string) error {
+	if err := Audit(ctx, r.db, action, target, details); err != nil {
+		return errors.Annotate(err, "ошибка записи в журнал аудита")
+	}
+	return nil
+}
+
+// ReportAuditTarget объект журнала аудита для отчёта за сутки day по смене shift: "2006-01-02" или "2006-01-02/смена"
+func ReportAuditTarget(day time.Time, shift string) string {
+	if shift == "" {
+		return day.Format(tool.DateLayout)
+	}
+	return day.Format(tool.DateLayout) + "/" + shift
+}
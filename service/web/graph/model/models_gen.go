@@ -2,10 +2,57 @@
 
 package model
 
+type Alarm struct {
+	ID             string  `json:"id"`
+	CreateAt       string  `json:"createAt"`
+	Temperature    float64 `json:"temperature"`
+	MaxTemperature float64 `json:"maxTemperature"`
+	MinTemperature float64 `json:"minTemperature"`
+	TermopadID     string  `json:"termopadId"`
+	TermopadName   string  `json:"termopadName"`
+	Wigand         string  `json:"wigand"`
+	Family         *string `json:"family"`
+	Name           *string `json:"name"`
+	MiddleName     *string `json:"middleName"`
+	Organization   *string `json:"organization"`
+	Department     *string `json:"department"`
+	Image          *string `json:"image"`
+	HandledAt      *string `json:"handledAt"`
+	HandledBy      *string `json:"handledBy"`
+}
+
+type AuditEntry struct {
+	ID       string `json:"id"`
+	CreateAt string `json:"createAt"`
+	User     string `json:"user"`
+	Action   string `json:"action"`
+	Target   string `json:"target"`
+	IP       string `json:"ip"`
+	Details  string `json:"details"`
+}
+
 type Config struct {
 	TermopadsOnPage int     `json:"termopadsOnPage"`
 	MaxTemperature  float64 `json:"maxTemperature"`
 	MinTemperature  float64 `json:"minTemperature"`
+	Privacy         bool    `json:"privacy"`
+}
+
+type Contact struct {
+	Person    *Person  `json:"person"`
+	Overlaps  int      `json:"overlaps"`
+	Termopads []string `json:"termopads"`
+	FirstAt   string   `json:"firstAt"`
+	LastAt    string   `json:"lastAt"`
+}
+
+type Erasure struct {
+	Wigand       string  `json:"wigand"`
+	Mode         string  `json:"mode"`
+	Pseudonym    *string `json:"pseudonym"`
+	Temperatures int     `json:"temperatures"`
+	Alarms       int     `json:"alarms"`
+	Images       int     `json:"images"`
 }
 
 type LastPerson struct {
@@ -15,7 +62,10 @@ type LastPerson struct {
 	Wigand         string  `json:"wigand"`
 	WigandFasality string  `json:"wigandFasality"`
 	WigandNumber   string  `json:"wigandNumber"`
+	WigandFormat   string  `json:"wigandFormat"`
+	WigandDisplay  string  `json:"wigandDisplay"`
 	Temperature    float64 `json:"temperature"`
+	Invalid        bool    `json:"invalid"`
 	NameFirst      *string `json:"nameFirst"`
 	NameMiddle     *string `json:"nameMiddle"`
 	NameLast       *string `json:"nameLast"`
@@ -31,13 +81,82 @@ type Person struct {
 	Wigand         string  `json:"wigand"`
 	WigandFasality string  `json:"wigandFasality"`
 	WigandNumber   string  `json:"wigandNumber"`
+	WigandFormat   string  `json:"wigandFormat"`
+	WigandDisplay  string  `json:"wigandDisplay"`
 	Temperature    float64 `json:"temperature"`
+	Invalid        bool    `json:"invalid"`
 	NameFirst      *string `json:"nameFirst"`
 	NameMiddle     *string `json:"nameMiddle"`
 	NameLast       *string `json:"nameLast"`
 	Organization   *string `json:"organization"`
 	Departament    *string `json:"departament"`
 	Postion        *string `json:"postion"`
+	Manual         bool    `json:"manual"`
+}
+
+type PersonInput struct {
+	Wigand       string  `json:"wigand"`
+	NameFirst    string  `json:"nameFirst"`
+	NameMiddle   *string `json:"nameMiddle"`
+	NameLast     string  `json:"nameLast"`
+	Organization *string `json:"organization"`
+	Departament  *string `json:"departament"`
+	Postion      *string `json:"postion"`
+}
+
+type PersonList struct {
+	Persons     []*Person `json:"persons"`
+	EndCursor   string    `json:"endCursor"`
+	HasNextPage bool      `json:"hasNextPage"`
+}
+
+type PersonSync struct {
+	ID        string  `json:"id"`
+	StartAt   string  `json:"startAt"`
+	FinishAt  *string `json:"finishAt"`
+	Status    string  `json:"status"`
+	Total     int     `json:"total"`
+	Processed int     `json:"processed"`
+	Updated   int     `json:"updated"`
+	Failed    int     `json:"failed"`
+	Error     *string `json:"error"`
+}
+
+type Report struct {
+	Date          string                `json:"date"`
+	Shift         string                `json:"shift"`
+	From          string                `json:"from"`
+	To            string                `json:"to"`
+	CreatedAt     string                `json:"createdAt"`
+	Total         int                   `json:"total"`
+	Fever         int                   `json:"fever"`
+	Unknown       int                   `json:"unknown"`
+	UnknownRate   float64               `json:"unknownRate"`
+	Invalid       int                   `json:"invalid"`
+	Cabins        []*ReportCabin        `json:"cabins"`
+	Organizations []*ReportOrganization `json:"organizations"`
+}
+
+type ReportCabin struct {
+	Termopad        string  `json:"termopad"`
+	Name            string  `json:"name"`
+	Total           int     `json:"total"`
+	Fever           int     `json:"fever"`
+	Unknown         int     `json:"unknown"`
+	UnknownRate     float64 `json:"unknownRate"`
+	Invalid         int     `json:"invalid"`
+	TemperatureMin  float64 `json:"temperatureMin"`
+	TemperatureMax  float64 `json:"temperatureMax"`
+	DowntimeMinutes int     `json:"downtimeMinutes"`
+}
+
+type ReportOrganization struct {
+	Organization string  `json:"organization"`
+	Total        int     `json:"total"`
+	Fever        int     `json:"fever"`
+	Unknown      int     `json:"unknown"`
+	UnknownRate  float64 `json:"unknownRate"`
+	Invalid      int     `json:"invalid"`
 }
 
 type Temperature struct {
@@ -45,10 +164,14 @@ type Temperature struct {
 	Job            string  `json:"job"`
 	Update         string  `json:"update"`
 	Temperature    float64 `json:"temperature"`
+	Alarm          bool    `json:"alarm"`
+	Invalid        bool    `json:"invalid"`
 	Image          *string `json:"image"`
 	Wigand         string  `json:"wigand"`
 	WigandFasality string  `json:"wigandFasality"`
 	WigandNumber   string  `json:"wigandNumber"`
+	WigandFormat   string  `json:"wigandFormat"`
+	WigandDisplay  string  `json:"wigandDisplay"`
 	NameFirst      *string `json:"nameFirst"`
 	NameMiddle     *string `json:"nameMiddle"`
 	NameLast       *string `json:"nameLast"`
@@ -62,6 +185,7 @@ type TemperatureLogMetric struct {
 	Temperature    float64 `json:"temperature"`
 	TemperatureMax float64 `json:"temperatureMax"`
 	TemperatureMin float64 `json:"temperatureMin"`
+	Invalid        bool    `json:"invalid"`
 	Image          string  `json:"image"`
 	PCreateAt      string  `json:"pCreateAt"`
 	PUpdateAt      string  `json:"pUpdateAt"`
@@ -89,4 +213,30 @@ type Termopad struct {
 	Description    *string `json:"description"`
 	MaxTemperature float64 `json:"maxTemperature"`
 	MinTemperature float64 `json:"minTemperature"`
+	CardFormat     string  `json:"cardFormat"`
+}
+
+type WebhookDeadLetter struct {
+	ID       string `json:"id"`
+	CreateAt string `json:"createAt"`
+	Webhook  string `json:"webhook"`
+	URL      string `json:"url"`
+	EventID  string `json:"eventId"`
+	Event    string `json:"event"`
+	Payload  string `json:"payload"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+type WebhookDelivery struct {
+	ID         string  `json:"id"`
+	CreateAt   string  `json:"createAt"`
+	Webhook    string  `json:"webhook"`
+	EventID    string  `json:"eventId"`
+	Event      string  `json:"event"`
+	Attempt    int     `json:"attempt"`
+	Status     string  `json:"status"`
+	StatusCode *int    `json:"statusCode"`
+	Error      *string `json:"error"`
+	DurationMs int     `json:"durationMs"`
 }
@@ -0,0 +1,73 @@
+package graph
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	modelGraphQl "github.com/kirsrus/termopad-server/service/web/graph/model"
+
+	"github.com/juju/errors"
+)
+
+// Инициал имени для режима приватности: "Иванов" -> "И.". Пустое имя не изменяется
+func initial(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+	first, _ := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(first)) + "."
+}
+
+// initial для необязательного поля GraphQL. Возвращается новый указатель: исходное значение может
+// использоваться в ответах другим клиентам
+func initialOptional(name *string) *string {
+	if name == nil {
+		return nil
+	}
+	result := initial(*name)
+	return &result
+}
+
+// Изменения недоступны пользователям, которым разрешён только просмотр
+func denyReadOnly(ctx context.Context) error {
+	if RequesterFromContext(ctx).ReadOnly() {
+		return errors.New("пользователю доступен только просмотр")
+	}
+	return nil
+}
+
+// Копия замера для подписчика в режиме приватности
+func privateTemperature(temperature *modelGraphQl.Temperature) *modelGraphQl.Temperature {
+	result := *temperature
+	result.NameFirst = initialOptional(temperature.NameFirst)
+	result.NameMiddle = initialOptional(temperature.NameMiddle)
+	result.NameLast = initialOptional(temperature.NameLast)
+	return &result
+}
+
+func privateLastPerson(person *modelGraphQl.LastPerson) {
+	person.NameFirst = initialOptional(person.NameFirst)
+	person.NameMiddle = initialOptional(person.NameMiddle)
+	person.NameLast = initialOptional(person.NameLast)
+}
+
+func privatePerson(person *modelGraphQl.Person) {
+	person.NameFirst = initialOptional(person.NameFirst)
+	person.NameMiddle = initialOptional(person.NameMiddle)
+	person.NameLast = initialOptional(person.NameLast)
+}
+
+func privateMetric(metric *modelGraphQl.TemperatureLogMetric) {
+	metric.PFirstName = initial(metric.PFirstName)
+	metric.PMiddleName = initial(metric.PMiddleName)
+	metric.PLastName = initial(metric.PLastName)
+}
+
+func privateAlarm(alarm *modelGraphQl.Alarm) {
+	alarm.Name = initialOptional(alarm.Name)
+	alarm.MiddleName = initialOptional(alarm.MiddleName)
+	alarm.Family = initialOptional(alarm.Family)
+}
@@ -3,9 +3,14 @@ package graph
 import (
 	"io/ioutil"
 	"strconv"
+	"strings"
 	"sync"
 
+	"github.com/kirsrus/termopad-server/controller"
 	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	"github.com/kirsrus/termopad-server/pkg/validator"
+	"github.com/kirsrus/termopad-server/service"
 	modelGraphQl "github.com/kirsrus/termopad-server/service/web/graph/model"
 	"github.com/kirsrus/termopad-server/store"
 
@@ -13,10 +18,22 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// MaxImageSize максимальный размер загружаемой фотографии персоны по умолчанию
+const MaxImageSize = 2 << 20
+
 const (
 	termopadsOnPage = 16
-	maxTemperature  = 37.5
-	minTemperature  = 34.0
+	personsOnPage   = 50
+	// Количество возвращаемых по умолчанию записей истории синхронизации персон
+	personSyncsOnPage = 20
+	// Количество возвращаемых по умолчанию записей журнала доставки и недоставленных событий вебхуков
+	webhookDeliveriesOnPage = 50
+	// Количество возвращаемых по умолчанию тревог
+	alarmsOnPage = 50
+	// Автор подтверждения обработки тревоги через WEB-интерфейс при отключённой авторизации
+	alarmHandledByWeb = "web"
+	// Количество возвращаемых по умолчанию записей журнала аудита
+	auditOnPage = 100
 )
 
 // Описывает весь список термопадов
@@ -36,26 +53,55 @@ func (m termopads) IssetID(id uint) bool {
 type Resolver struct {
 	log *logrus.Entry
 
+	// Защищает termopads и termopadsOnPage при изменении конфигурации на лету
+	mu        *sync.RWMutex
 	termopads termopads
 	//termperatureEvent        chan model.TermopadTemperatureEvent
 	temperatureSubscribePool        *sync.Map
 	temperatureChangedSubscribePool *sync.Map
 	temperatureUpdateSubscribePool  *sync.Map
+	personSyncSubscribePool         *sync.Map
+	// Защищает пулы подписок от записи в закрытые при завершении работы каналы
+	subscribeMu *sync.RWMutex
+	closed      bool
+
+	db    store.DbStore
+	sudos service.SudosSvc
+
+	personSync controller.PersonSyncCtl
+	report     controller.ReportCtl
+	webhook    service.WebhookSvc
 
-	db store.DbStore
+	thresholds service.ThresholdsSvc
 
 	termopadsOnPage uint
-	maxTemperature  float64
-	minTemperature  float64
+
+	retention controller.RetentionCtl
+
+	maxImageSize int64
 }
 
 // Конфигурация структуры Resolver
 type ConfigResolver struct {
 	Log *logrus.Logger
 
+	// Служба СУДОС для принудительного обновления данных персон (может отсутствовать)
+	SudosSvc service.SudosSvc
+	// Контроллер синхронизации персон с СУДОС (может отсутствовать)
+	PersonSyncCtl controller.PersonSyncCtl
+	// Контроллер сводных отчётов (может отсутствовать)
+	ReportCtl controller.ReportCtl
+	// Рассылка внешним системам событий об изменении персон (может отсутствовать)
+	WebhookSvc service.WebhookSvc
+
+	// Единые пороги нормальной температуры
+	ThresholdsSvc service.ThresholdsSvc
+
 	TermopadsOnPage uint
-	MaxTemperature  float64
-	MinTemperature  float64
+	// Стирание персональных данных (может отсутствовать)
+	RetentionCtl controller.RetentionCtl
+	// Максимальный размер загружаемой фотографии персоны в байтах (по умолчанию MaxImageSize)
+	MaxImageSize int64
 }
 
 // NewResolver конструктор Resolver. Через termperatureEmit возвращается сигнал об измерении температуры
@@ -70,23 +116,41 @@ func NewResolver(termopads []model.TermopadInfo, db store.DbStore, config *Confi
 	if db == nil {
 		return nil, errors.New("не передана база данных")
 	}
+	if config.ThresholdsSvc == nil {
+		return nil, errors.New("не передана служба порогов температуры")
+	}
 
 	resolver := Resolver{
 		log: config.Log.WithFields(map[string]interface{}{
 			"module": "graphql",
 			"scope":  "service",
 		}),
+		mu:        new(sync.RWMutex),
 		termopads: termopads,
 		//termperatureEvent:        termperatureEmit,
 		temperatureSubscribePool:        new(sync.Map),
 		temperatureChangedSubscribePool: new(sync.Map),
 		temperatureUpdateSubscribePool:  new(sync.Map),
+		personSyncSubscribePool:         new(sync.Map),
+		subscribeMu:                     new(sync.RWMutex),
+
+		db:    db,
+		sudos: config.SudosSvc,
 
-		db: db,
+		personSync: config.PersonSyncCtl,
+		report:     config.ReportCtl,
+		webhook:    config.WebhookSvc,
+
+		thresholds: config.ThresholdsSvc,
 
 		termopadsOnPage: termopadsOnPage,
-		maxTemperature:  maxTemperature,
-		minTemperature:  minTemperature,
+
+		retention: config.RetentionCtl,
+
+		maxImageSize: MaxImageSize,
+	}
+	if config.MaxImageSize != 0 {
+		resolver.maxImageSize = config.MaxImageSize
 	}
 	if err := resolver.Configure(config); err != nil {
 		return nil, errors.Trace(err)
@@ -101,19 +165,91 @@ func (r *Resolver) Configure(config *ConfigResolver) error {
 		return errors.New("конфигурация не задана")
 	}
 	if config.TermopadsOnPage != 0 {
+		r.mu.Lock()
 		r.termopadsOnPage = config.TermopadsOnPage
+		r.mu.Unlock()
 	}
-	if config.MaxTemperature != 0 {
-		r.maxTemperature = config.MaxTemperature
+	return nil
+}
+
+// SetTermopads изменение списка термопадов
+func (r *Resolver) SetTermopads(termopads []model.TermopadInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.termopads = termopads
+}
+
+// Формат карт термопада id (пустой, если термопад не найден или использует формат по умолчанию)
+func (r Resolver) termopadCardFormat(id uint) string {
+	for _, v := range r.getTermopads() {
+		if v.ID == id {
+			return v.CardFormat
+		}
 	}
-	if config.MinTemperature != 0 {
-		r.minTemperature = config.MinTemperature
+	return ""
+}
+
+// Текущий список термопадов
+func (r Resolver) getTermopads() termopads {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.termopads
+}
+
+// Текущее количество термопадов на странице
+func (r Resolver) getTermopadsOnPage() uint {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.termopadsOnPage
+}
+
+// Пороги нормальной температуры для термопада с учётом его собственных переопределений
+func (r Resolver) termopadThresholds(termopad model.TermopadInfo) (maxTemperature, minTemperature float64) {
+	thresholds := r.thresholds.TermopadThresholds(termopad)
+	return thresholds.MaxTemperature, thresholds.MinTemperature
+}
+
+// Добавление канала подписчика ch в пул pool. Ошибка, если резолвер уже закрыт
+func (r *Resolver) subscribe(pool *sync.Map, id string, ch interface{}) error {
+	r.subscribeMu.RLock()
+	defer r.subscribeMu.RUnlock()
+	if r.closed {
+		return errors.New("сервер завершает работу")
 	}
+	pool.Store(id, ch)
 	return nil
 }
 
+// Close закрывает каналы всех подписчиков, чтобы их подписки корректно завершились. Новые подписки
+// после этого не принимаются
+func (r *Resolver) Close() {
+	r.subscribeMu.Lock()
+	defer r.subscribeMu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	count := 0
+	for _, pool := range []*sync.Map{r.temperatureSubscribePool, r.personSyncSubscribePool} {
+		pool.Range(func(key, value interface{}) bool {
+			switch ch := value.(type) {
+			case chan *modelGraphQl.Temperature:
+				close(ch)
+			case chan *modelGraphQl.PersonSync:
+				close(ch)
+			}
+			pool.Delete(key)
+			count++
+			return true
+		})
+	}
+	r.log.Infof("закрыто подписок GraphQL: %d", count)
+}
+
 // TemperatureChanged фиксация новой температуры
 func (r Resolver) TemperatureChanged(temperature model.TemperatureChange) {
+	r.subscribeMu.RLock()
+	defer r.subscribeMu.RUnlock()
 	r.temperatureSubscribePool.Range(func(key, value interface{}) bool {
 		inChan, ok := value.(chan *modelGraphQl.Temperature)
 		if !ok {
@@ -127,10 +263,14 @@ func (r Resolver) TemperatureChanged(temperature model.TemperatureChange) {
 			Job:            "set",
 			Update:         temperature.CreateAt.Format("2006.01.02 15:04:05"),
 			Temperature:    temperature.Temperature,
+			Alarm:          temperature.Alarm,
+			Invalid:        temperature.Invalid,
 			Image:          &temperature.Image,
 			Wigand:         strconv.Itoa(int(temperature.Wigand.ID)),
 			WigandFasality: strconv.Itoa(int(temperature.Wigand.Fasality())),
 			WigandNumber:   strconv.Itoa(int(temperature.Wigand.Number())),
+			WigandFormat:   temperature.Wigand.CardFormat().Name,
+			WigandDisplay:  temperature.Wigand.Display(),
 			NameFirst:      &temperature.NameFirst,
 			NameMiddle:     &temperature.NameMiddle,
 			NameLast:       &temperature.NameLast,
@@ -146,3 +286,245 @@ func (r Resolver) TemperatureChanged(temperature model.TemperatureChange) {
 		return true
 	})
 }
+
+// PersonSyncChanged отправка подписчикам хода синхронизации персон
+func (r Resolver) PersonSyncChanged(progress model.PersonSync) {
+	r.subscribeMu.RLock()
+	defer r.subscribeMu.RUnlock()
+	r.personSyncSubscribePool.Range(func(key, value interface{}) bool {
+		inChan, ok := value.(chan *modelGraphQl.PersonSync)
+		if !ok {
+			r.log.Errorf("по каналу personSyncSubscribePool пришёл неожиданный тип данных: %T, а должен быть %T", value, modelGraphQl.PersonSync{})
+			return true
+		}
+		select {
+		case inChan <- personSyncToGraphQL(progress):
+		default:
+			r.log.Warnf("канал %s из personSyncSubscribePool переполнен", key)
+		}
+		return true
+	})
+}
+
+// Преобразование состояния синхронизации персон в формат GraphQL
+func personSyncToGraphQL(progress model.PersonSync) *modelGraphQl.PersonSync {
+	result := modelGraphQl.PersonSync{
+		ID:        strconv.Itoa(int(progress.ID)),
+		StartAt:   progress.StartAt.Format("2006.01.02 15:04:05"),
+		Status:    progress.Status,
+		Total:     int(progress.Total),
+		Processed: int(progress.Processed),
+		Updated:   int(progress.Updated),
+		Failed:    int(progress.Failed),
+	}
+	if progress.FinishAt != nil {
+		finishAt := progress.FinishAt.Format("2006.01.02 15:04:05")
+		result.FinishAt = &finishAt
+	}
+	if progress.Error != "" {
+		result.Error = &progress.Error
+	}
+	return &result
+}
+
+// Преобразование сводного отчёта в формат GraphQL
+func reportToGraphQL(report model.Report) *modelGraphQl.Report {
+	result := modelGraphQl.Report{
+		Date:          report.Date.Format(tool.DateLayout),
+		Shift:         report.Shift,
+		From:          report.From.Format("2006.01.02 15:04:05"),
+		To:            report.To.Format("2006.01.02 15:04:05"),
+		CreatedAt:     report.CreatedAt.Format("2006.01.02 15:04:05"),
+		Total:         int(report.Total),
+		Fever:         int(report.Fever),
+		Unknown:       int(report.Unknown),
+		UnknownRate:   report.UnknownRate(),
+		Invalid:       int(report.Invalid),
+		Cabins:        make([]*modelGraphQl.ReportCabin, 0, len(report.Cabins)),
+		Organizations: make([]*modelGraphQl.ReportOrganization, 0, len(report.Organizations)),
+	}
+	for _, v := range report.Cabins {
+		result.Cabins = append(result.Cabins, &modelGraphQl.ReportCabin{
+			Termopad:        strconv.Itoa(int(v.TermopadID)),
+			Name:            v.Name,
+			Total:           int(v.Total),
+			Fever:           int(v.Fever),
+			Unknown:         int(v.Unknown),
+			UnknownRate:     v.UnknownRate(),
+			Invalid:         int(v.Invalid),
+			TemperatureMin:  v.TemperatureMin,
+			TemperatureMax:  v.TemperatureMax,
+			DowntimeMinutes: int(v.Downtime.Minutes()),
+		})
+	}
+	for _, v := range report.Organizations {
+		result.Organizations = append(result.Organizations, &modelGraphQl.ReportOrganization{
+			Organization: v.Organization,
+			Total:        int(v.Total),
+			Fever:        int(v.Fever),
+			Unknown:      int(v.Unknown),
+			UnknownRate:  v.UnknownRate(),
+			Invalid:      int(v.Invalid),
+		})
+	}
+	return &result
+}
+
+// Преобразование персоны в формат GraphQL. Температурой персоны считается её последний замер, а
+// формат карты определяется по термопаду этого замера
+func (r Resolver) personToGraphQL(person model.Person) *modelGraphQl.Person {
+	temperature, err := r.db.LastTemperature(person.Wigand.ID)
+	if err != nil {
+		if !r.db.IsNotFound(err) {
+			r.log.Warnf("ошибка получения последней температуры персоны %s: %v", person.Wigand, err)
+		}
+		temperature = nil
+	}
+	return r.personWithTemperature(person, temperature)
+}
+
+// Преобразование списка персон в формат GraphQL. Последние замеры персон загружаются одним запросом
+func (r Resolver) personsToGraphQL(persons []model.Person) []*modelGraphQl.Person {
+	wigands := make([]uint, 0, len(persons))
+	for _, v := range persons {
+		wigands = append(wigands, v.Wigand.ID)
+	}
+	temperatures, err := r.db.LastTemperatures(wigands)
+	if err != nil {
+		r.log.Warnf("ошибка получения последних температур персон: %v", err)
+	}
+	result := make([]*modelGraphQl.Person, 0, len(persons))
+	for _, v := range persons {
+		var temperature *model.Temperature
+		if t, ok := temperatures[v.Wigand.ID]; ok {
+			temperature = &t
+		}
+		result = append(result, r.personWithTemperature(v, temperature))
+	}
+	return result
+}
+
+// Преобразование персоны с последним замером temperature (nil - замеров нет) в формат GraphQL
+func (r Resolver) personWithTemperature(person model.Person, temperature *model.Temperature) *modelGraphQl.Person {
+	result := modelGraphQl.Person{
+		Image:        strconv.Itoa(int(person.Wigand.ID)),
+		Wigand:       strconv.Itoa(int(person.Wigand.ID)),
+		NameFirst:    &person.Name,
+		NameMiddle:   &person.MiddleName,
+		NameLast:     &person.Family,
+		Organization: &person.Organization,
+		Departament:  &person.Department,
+		Postion:      &person.Position,
+		Manual:       person.Manual,
+	}
+	if person.CreateAt != nil {
+		result.CreatedAt = person.CreateAt.Format("2006.01.02 15:04:05")
+	}
+	if person.UpdateAt != nil {
+		result.UpdatedAt = person.UpdateAt.Format("2006.01.02 15:04:05")
+	}
+	wigand := person.Wigand
+	if temperature != nil {
+		result.Temperature = temperature.Temperature
+		result.Invalid = temperature.Invalid
+		wigand.Format = r.termopadCardFormat(temperature.TermopadID)
+	}
+	result.WigandFasality = strconv.Itoa(int(wigand.Fasality()))
+	result.WigandNumber = strconv.Itoa(int(wigand.Number()))
+	result.WigandFormat = wigand.CardFormat().Name
+	result.WigandDisplay = wigand.Display()
+	return &result
+}
+
+// Преобразование введённых оператором данных в персону, внесённую вручную
+func personFromInput(input modelGraphQl.PersonInput) (*model.Person, error) {
+	wigandID, err := strconv.Atoi(strings.TrimSpace(input.Wigand))
+	if err != nil || wigandID <= 0 {
+		return nil, errors.Errorf("некорректный идентификатор вигадна: %s", input.Wigand)
+	}
+	person := model.Person{
+		Wigand: model.NewWigand(wigandID),
+		Family: input.NameLast,
+		Name:   input.NameFirst,
+		Manual: true,
+	}
+	if input.NameMiddle != nil {
+		person.MiddleName = *input.NameMiddle
+	}
+	if input.Organization != nil {
+		person.Organization = *input.Organization
+	}
+	if input.Departament != nil {
+		person.Department = *input.Departament
+	}
+	if input.Postion != nil {
+		person.Position = *input.Postion
+	}
+	if err := validator.Get().ValidateWithConform(&person); err != nil {
+		return nil, errors.Annotate(err, "некорректные данные персоны")
+	}
+	return &person, nil
+}
+
+// Преобразование записи журнала доставки вебхука в формат GraphQL
+func webhookDeliveryToGraphQL(delivery model.WebhookDelivery) *modelGraphQl.WebhookDelivery {
+	result := modelGraphQl.WebhookDelivery{
+		ID:         strconv.Itoa(int(delivery.ID)),
+		CreateAt:   delivery.CreateAt.Format("2006.01.02 15:04:05"),
+		Webhook:    delivery.Webhook,
+		EventID:    delivery.EventID,
+		Event:      delivery.Event,
+		Attempt:    int(delivery.Attempt),
+		Status:     delivery.Status,
+		DurationMs: int(delivery.Duration.Milliseconds()),
+	}
+	if delivery.StatusCode != 0 {
+		result.StatusCode = &delivery.StatusCode
+	}
+	if delivery.Error != "" {
+		result.Error = &delivery.Error
+	}
+	return &result
+}
+
+// Маппинг тревоги в модель GraphQL
+func alarmToGraphQL(alarm model.Alarm) *modelGraphQl.Alarm {
+	result := modelGraphQl.Alarm{
+		ID:             strconv.Itoa(int(alarm.ID)),
+		CreateAt:       alarm.CreateAt.Format("2006.01.02 15:04:05"),
+		Temperature:    alarm.Temperature,
+		MaxTemperature: alarm.Thresholds.MaxTemperature,
+		MinTemperature: alarm.Thresholds.MinTemperature,
+		TermopadID:     strconv.Itoa(int(alarm.Termopad.ID)),
+		TermopadName:   alarm.Termopad.Name,
+		Wigand:         strconv.Itoa(int(alarm.Person.Wigand.ID)),
+	}
+	optional := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	result.Family = optional(alarm.Person.Family)
+	result.Name = optional(alarm.Person.Name)
+	result.MiddleName = optional(alarm.Person.MiddleName)
+	result.Organization = optional(alarm.Person.Organization)
+	result.Department = optional(alarm.Person.Department)
+	result.Image = optional(alarm.ImageName)
+	if alarm.HandledAt != nil {
+		result.HandledAt = optional(alarm.HandledAt.Format("2006.01.02 15:04:05"))
+		result.HandledBy = optional(alarm.HandledBy)
+	}
+	return &result
+}
+
+// Количество возвращаемых записей: last, если указан, иначе byDefault
+func pageLimit(last *int, byDefault uint) (uint, error) {
+	if last == nil {
+		return byDefault, nil
+	}
+	if *last <= 0 {
+		return 0, errors.Errorf("некорректное количество записей last=%d", *last)
+	}
+	return uint(*last), nil
+}
@@ -4,23 +4,251 @@ package graph
 // will be copied through when generating and any unknown code will be moved to the end.
 
 import (
+	"bytes"
 	"context"
 	"github.com/juju/errors"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/google/uuid"
+	modelApp "github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/tool"
+	"github.com/kirsrus/termopad-server/pkg/wiegand"
 	"github.com/kirsrus/termopad-server/service/web/graph/generated"
 	"github.com/kirsrus/termopad-server/service/web/graph/model"
 )
 
+func (r *mutationResolver) CreatePerson(ctx context.Context, person model.PersonInput) (*model.Person, error) {
+	if err := denyReadOnly(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	newPerson, err := personFromInput(person)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := r.db.GetPerson(newPerson.Wigand.ID); err == nil {
+		return nil, errors.Errorf("персона с вигандом %s уже существует", person.Wigand)
+	} else if !r.db.IsNotFound(err) {
+		return nil, errors.Trace(err)
+	}
+
+	res, _, err := r.db.SetPerson(*newPerson)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.log.Infof("вручную внесена персона wigand=%s (%s)", res.Wigand, res.Family)
+	r.auditChange(ctx, modelApp.AuditCreatePerson, strconv.Itoa(int(res.Wigand.ID)), "")
+	if r.webhook != nil {
+		r.webhook.PersonUpdated(*res)
+	}
+	return r.personToGraphQL(*res), nil
+}
+
+func (r *mutationResolver) UpdatePerson(ctx context.Context, person model.PersonInput) (*model.Person, error) {
+	if err := denyReadOnly(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	newPerson, err := personFromInput(person)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := r.db.GetPerson(newPerson.Wigand.ID); err != nil {
+		if r.db.IsNotFound(err) {
+			return nil, errors.Errorf("персона с вигандом %s не найдена", person.Wigand)
+		}
+		return nil, errors.Trace(err)
+	}
+
+	res, _, err := r.db.SetPerson(*newPerson)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.log.Infof("вручную изменена персона wigand=%s (%s)", res.Wigand, res.Family)
+	r.auditChange(ctx, modelApp.AuditUpdatePerson, strconv.Itoa(int(res.Wigand.ID)), "")
+	if r.webhook != nil {
+		r.webhook.PersonUpdated(*res)
+	}
+	return r.personToGraphQL(*res), nil
+}
+
+func (r *mutationResolver) UploadPersonImage(ctx context.Context, wigand string, image graphql.Upload) (bool, error) {
+	if err := denyReadOnly(ctx); err != nil {
+		return false, errors.Trace(err)
+	}
+	wigandID, err := strconv.Atoi(strings.TrimSpace(wigand))
+	if err != nil || wigandID <= 0 {
+		return false, errors.Errorf("некорректный идентификатор вигадна: %s", wigand)
+	}
+	if _, err := r.db.GetPerson(uint(wigandID)); err != nil {
+		if r.db.IsNotFound(err) {
+			return false, errors.Errorf("персона с вигандом %s не найдена", wigand)
+		}
+		return false, errors.Trace(err)
+	}
+
+	// Читаем на байт больше допустимого, чтобы отличить файл предельного размера от превышающего его
+	content, err := ioutil.ReadAll(io.LimitReader(image.File, r.maxImageSize+1))
+	if err != nil {
+		return false, errors.Annotate(err, "ошибка чтения загруженного файла")
+	}
+	if int64(len(content)) > r.maxImageSize {
+		return false, errors.Errorf("размер загруженного файла %s превышает %d КБ", image.Filename, r.maxImageSize>>10)
+	}
+	// Сигнатуры недостаточно: файл должен декодироваться как JPEG
+	if _, err := jpeg.Decode(bytes.NewReader(content)); err != nil {
+		return false, errors.Errorf("загруженный файл %s не является изображением JPEG", image.Filename)
+	}
+	if err := r.db.SetPersonImage(uint(wigandID), content); err != nil {
+		return false, errors.Trace(err)
+	}
+	r.log.Infof("загружено изображение персоны wigand=%d (%d байт)", wigandID, len(content))
+	r.auditChange(ctx, modelApp.AuditUploadPersonImage, strconv.Itoa(wigandID), strconv.Itoa(len(content))+" байт")
+	return true, nil
+}
+
+func (r *mutationResolver) RefreshPerson(ctx context.Context, wigand string) (*model.Person, error) {
+	if err := denyReadOnly(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	wigandID, err := strconv.Atoi(strings.TrimSpace(wigand))
+	if err != nil || wigandID <= 0 {
+		return nil, errors.Errorf("некорректный идентификатор вигадна: %s", wigand)
+	}
+	if r.sudos == nil {
+		return nil, errors.New("связь с СУДОС не настроена")
+	}
+
+	person, err := r.sudos.Person(modelApp.NewWigand(wigandID))
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка получения данных из СУДОС")
+	}
+	if len(person.Image) != 0 {
+		if err := r.db.SetPersonImage(person.Wigand.ID, person.Image); err != nil {
+			r.log.Warnf("ошибка сохранения изображения персоны в БД: %v", err)
+		}
+	}
+	// Изображение уже сохранено, а валидатор затыкается на больших BASE64 данных
+	person.Image = make([]byte, 0)
+
+	res, _, err := r.db.SetPerson(*person)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.log.Infof("данные персоны wigand=%s (%s) обновлены из СУДОС", res.Wigand, res.Family)
+	r.auditChange(ctx, modelApp.AuditRefreshPerson, strconv.Itoa(int(res.Wigand.ID)), "")
+	if r.webhook != nil {
+		r.webhook.PersonUpdated(*res)
+	}
+	return r.personToGraphQL(*res), nil
+}
+
+func (r *mutationResolver) SyncPersons(ctx context.Context) (*model.PersonSync, error) {
+	if err := denyReadOnly(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if r.personSync == nil {
+		return nil, errors.New("синхронизация персон с СУДОС не настроена")
+	}
+	progress, err := r.personSync.Sync()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.auditChange(ctx, modelApp.AuditSyncPersons, strconv.Itoa(int(progress.ID)), "")
+	return personSyncToGraphQL(*progress), nil
+}
+
+func (r *mutationResolver) SetThresholds(ctx context.Context, maxTemperature float64, minTemperature float64) (*model.Config, error) {
+	if err := denyReadOnly(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	thresholds := modelApp.Thresholds{
+		MaxTemperature: maxTemperature,
+		MinTemperature: minTemperature,
+	}
+	if err := r.thresholds.SetThresholds(thresholds); err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.auditChange(ctx, modelApp.AuditSetThresholds, "",
+		"max="+strconv.FormatFloat(maxTemperature, 'f', 1, 64)+" min="+strconv.FormatFloat(minTemperature, 'f', 1, 64))
+	return &model.Config{
+		TermopadsOnPage: int(r.getTermopadsOnPage()),
+		MaxTemperature:  thresholds.MaxTemperature,
+		MinTemperature:  thresholds.MinTemperature,
+		Privacy:         RequesterFromContext(ctx).Privacy,
+	}, nil
+}
+
+func (r *mutationResolver) AcknowledgeAlarm(ctx context.Context, id string) (*model.Alarm, error) {
+	if err := denyReadOnly(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	alarmID, err := strconv.Atoi(strings.TrimSpace(id))
+	if err != nil || alarmID <= 0 {
+		return nil, errors.Errorf("некорректный идентификатор тревоги: %s", id)
+	}
+	handledBy := RequesterFromContext(ctx).User
+	if handledBy == "" {
+		handledBy = alarmHandledByWeb
+	}
+	alarm, err := r.db.HandleAlarm(uint(alarmID), handledBy, time.Now())
+	if err != nil {
+		if r.db.IsNotFound(err) {
+			return nil, errors.Errorf("тревога %d не найдена", alarmID)
+		}
+		return nil, errors.Trace(err)
+	}
+	r.log.Infof("тревога %d отмечена обработанной через WEB", alarmID)
+	r.auditChange(ctx, modelApp.AuditAcknowledgeAlarm, strconv.Itoa(alarmID), "")
+	return alarmToGraphQL(*alarm), nil
+}
+
+func (r *mutationResolver) ErasePerson(ctx context.Context, wigand string) (*model.Erasure, error) {
+	if !RequesterFromContext(ctx).IsAdmin() {
+		return nil, errors.New("стирание персональных данных доступно только администраторам")
+	}
+	wigandID, err := strconv.Atoi(strings.TrimSpace(wigand))
+	if err != nil || wigandID <= 0 {
+		return nil, errors.Errorf("некорректный идентификатор вигадна: %s", wigand)
+	}
+	if r.retention == nil {
+		return nil, errors.New("стирание персональных данных не настроено")
+	}
+	erasure, err := r.retention.ErasePerson(uint(wigandID))
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, errors.Errorf("данные персоны с вигандом %s не найдены", wigand)
+		}
+		return nil, errors.Trace(err)
+	}
+	r.log.Infof("стёрты данные персоны wigand=%d: %s", wigandID, erasure.Summary())
+	r.auditChange(ctx, modelApp.AuditErasePerson, strconv.Itoa(wigandID), erasure.Summary())
+
+	result := &model.Erasure{
+		Wigand:       strconv.Itoa(int(erasure.Wigand)),
+		Mode:         erasure.Mode,
+		Temperatures: int(erasure.Temperatures),
+		Alarms:       int(erasure.Alarms),
+		Images:       erasure.Images,
+	}
+	if erasure.Pseudonym != 0 {
+		pseudonym := strconv.FormatUint(uint64(erasure.Pseudonym), 10)
+		result.Pseudonym = &pseudonym
+	}
+	return result, nil
+}
+
 func (r *queryResolver) Config(ctx context.Context) (*model.Config, error) {
-	_ = ctx
+	thresholds := r.thresholds.Thresholds()
 	config := model.Config{
-		TermopadsOnPage: int(r.termopadsOnPage),
-		MaxTemperature:  r.maxTemperature,
-		MinTemperature:  r.minTemperature,
+		TermopadsOnPage: int(r.getTermopadsOnPage()),
+		MaxTemperature:  thresholds.MaxTemperature,
+		MinTemperature:  thresholds.MinTemperature,
+		Privacy:         RequesterFromContext(ctx).Privacy,
 	}
 	return &config, nil
 }
@@ -28,15 +256,18 @@ func (r *queryResolver) Config(ctx context.Context) (*model.Config, error) {
 func (r *queryResolver) Termopads(ctx context.Context) ([]*model.Termopad, error) {
 	_ = ctx
 	result := make([]*model.Termopad, 0)
-	for _, v := range r.termopads {
+	for _, v := range r.getTermopads() {
+		v := v
+		maxTemperature, minTemperature := r.termopadThresholds(v)
 		result = append(result, &model.Termopad{
 			ID:             strconv.Itoa(int(v.ID)),
 			CrateAt:        time.Now().Format("2006.01.02 15:04:05"),
 			Address:        v.URL,
 			Name:           v.Name,
 			Description:    &v.Description,
-			MaxTemperature: r.maxTemperature,
-			MinTemperature: r.minTemperature,
+			MaxTemperature: maxTemperature,
+			MinTemperature: minTemperature,
+			CardFormat:     wiegand.Get(v.CardFormat).Name,
 		})
 	}
 	return result, nil
@@ -48,8 +279,9 @@ func (r *queryResolver) Termopad(ctx context.Context, id string) (*model.Termopa
 	if err != nil {
 		return nil, errors.Errorf("некорректный идентификатор термапада ID:%s: %v", id, err)
 	}
-	for _, term := range r.termopads {
+	for _, term := range r.getTermopads() {
 		if term.ID == uint(termID) {
+			maxTemperature, minTemperature := r.termopadThresholds(term)
 			return &model.Termopad{
 				ID:             strings.TrimSpace(id),
 				SudosID:        int(term.SudosID),
@@ -57,8 +289,9 @@ func (r *queryResolver) Termopad(ctx context.Context, id string) (*model.Termopa
 				Address:        term.URL,
 				Name:           term.Name,
 				Description:    &term.Description,
-				MaxTemperature: r.maxTemperature,
-				MinTemperature: r.minTemperature,
+				MaxTemperature: maxTemperature,
+				MinTemperature: minTemperature,
+				CardFormat:     wiegand.Get(term.CardFormat).Name,
 			}, nil
 		}
 	}
@@ -66,11 +299,10 @@ func (r *queryResolver) Termopad(ctx context.Context, id string) (*model.Termopa
 }
 
 func (r *queryResolver) LastPersons(ctx context.Context) ([]*model.LastPerson, error) {
-	_ = ctx
 	// Список последних персон, зарегистрировавашихся на термопаде, чтобы показывать
 	// их при первой загрузке страницы
 	lastPerson := make([]*model.LastPerson, 0)
-	for _, termInfo := range r.termopads {
+	for _, termInfo := range r.getTermopads() {
 		personDb, err := r.db.LastPerson(termInfo.ID)
 		if err != nil {
 			if r.db.IsNotFound(err) {
@@ -79,14 +311,19 @@ func (r *queryResolver) LastPersons(ctx context.Context) ([]*model.LastPerson, e
 			return nil, errors.Trace(err)
 		}
 
+		wigand := personDb.Termperature.Wigand
+		wigand.Format = termInfo.CardFormat
 		lastPerson = append(lastPerson, &model.LastPerson{
 			ID:             strconv.Itoa(int(termInfo.ID)),
 			UpdateAt:       personDb.CreatedAt.Format("2006.01.02 15:04:05"),
 			Image:          personDb.Termperature.ImagePath,
 			Wigand:         strconv.Itoa(int(personDb.Termperature.Wigand.ID)),
-			WigandFasality: strconv.Itoa(int(personDb.Termperature.Wigand.Fasality())),
-			WigandNumber:   strconv.Itoa(int(personDb.Termperature.Wigand.Number())),
+			WigandFasality: strconv.Itoa(int(wigand.Fasality())),
+			WigandNumber:   strconv.Itoa(int(wigand.Number())),
+			WigandFormat:   wigand.CardFormat().Name,
+			WigandDisplay:  wigand.Display(),
 			Temperature:    personDb.Termperature.Temperature,
+			Invalid:        personDb.Termperature.Invalid,
 			NameFirst:      &personDb.Person.Name,
 			NameMiddle:     &personDb.Person.MiddleName,
 			NameLast:       &personDb.Person.Family,
@@ -95,11 +332,15 @@ func (r *queryResolver) LastPersons(ctx context.Context) ([]*model.LastPerson, e
 			Postion:        &personDb.Person.Position,
 		})
 	}
+	if RequesterFromContext(ctx).Privacy {
+		for _, v := range lastPerson {
+			privateLastPerson(v)
+		}
+	}
 	return lastPerson, nil
 }
 
 func (r *queryResolver) PersonLog(ctx context.Context, id string, days int, offsetDays int, compact bool) ([]*model.TemperatureLogMetric, error) {
-	_ = ctx
 	wigandID, err := strconv.Atoi(id)
 	if err != nil {
 		return nil, errors.Errorf("некорректный идентификатор вигадна: %s", id)
@@ -108,6 +349,10 @@ func (r *queryResolver) PersonLog(ctx context.Context, id string, days int, offs
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	details := "days=" + strconv.Itoa(days) + " offsetDays=" + strconv.Itoa(offsetDays)
+	if err := r.auditAccess(ctx, modelApp.AuditPersonLog, strconv.Itoa(wigandID), details); err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	// Формирование результата
 	result := make([]*model.TemperatureLogMetric, 0)
@@ -115,6 +360,7 @@ func (r *queryResolver) PersonLog(ctx context.Context, id string, days int, offs
 		result = append(result, &model.TemperatureLogMetric{
 			Date:           v.Date.Format("2006.01.02 15:04:05"),
 			Temperature:    v.Temperature,
+			Invalid:        v.Invalid,
 			TemperatureMax: v.TemperatureMax,
 			TemperatureMin: v.TemperatureMin,
 			Image:          v.Image,
@@ -135,11 +381,15 @@ func (r *queryResolver) PersonLog(ctx context.Context, id string, days int, offs
 			TDescription:   v.Termopad.Description,
 		})
 	}
+	if RequesterFromContext(ctx).Privacy {
+		for _, v := range result {
+			privateMetric(v)
+		}
+	}
 	return result, nil
 }
 
 func (r *queryResolver) TermopadLog(ctx context.Context, id string, days int, offsetDays int, compact bool) ([]*model.TemperatureLogMetric, error) {
-	_ = ctx
 	wigandID, err := strconv.Atoi(id)
 	if err != nil {
 		return nil, errors.Errorf("некорректный идентификатор термопада: %s", id)
@@ -155,6 +405,7 @@ func (r *queryResolver) TermopadLog(ctx context.Context, id string, days int, of
 		result = append(result, &model.TemperatureLogMetric{
 			Date:           v.Date.Format("2006.01.02 15:04:05"),
 			Temperature:    v.Temperature,
+			Invalid:        v.Invalid,
 			TemperatureMax: v.TemperatureMax,
 			TemperatureMin: v.TemperatureMin,
 			Image:          v.Image,
@@ -175,6 +426,304 @@ func (r *queryResolver) TermopadLog(ctx context.Context, id string, days int, of
 			TDescription:   v.Termopad.Description,
 		})
 	}
+	if RequesterFromContext(ctx).Privacy {
+		for _, v := range result {
+			privateMetric(v)
+		}
+	}
+	return result, nil
+}
+
+func (r *queryResolver) Persons(ctx context.Context, search *string, first *int, after *string) (*model.PersonList, error) {
+	var searchStr string
+	if search != nil {
+		searchStr = *search
+	}
+	limit := personsOnPage
+	if first != nil {
+		if *first <= 0 {
+			return nil, errors.Errorf("некорректный размер страницы first=%d", *first)
+		}
+		limit = *first
+	}
+	var afterWigand int
+	if after != nil && strings.TrimSpace(*after) != "" {
+		var err error
+		afterWigand, err = strconv.Atoi(strings.TrimSpace(*after))
+		if err != nil || afterWigand < 0 {
+			return nil, errors.Errorf("некорректный курсор after=%s", *after)
+		}
+	}
+
+	// Запрашиваем на одну запись больше, чтобы узнать, есть ли следующая страница
+	rows, err := r.db.Persons(searchStr, uint(afterWigand), uint(limit+1))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := model.PersonList{
+		Persons:     make([]*model.Person, 0),
+		HasNextPage: len(rows) > limit,
+	}
+	if result.HasNextPage {
+		rows = rows[:limit]
+	}
+	privacy := RequesterFromContext(ctx).Privacy
+	for _, person := range r.personsToGraphQL(rows) {
+		if privacy {
+			privatePerson(person)
+		}
+		result.Persons = append(result.Persons, person)
+	}
+	if len(rows) != 0 {
+		result.EndCursor = strconv.Itoa(int(rows[len(rows)-1].Wigand.ID))
+	}
+	return &result, nil
+}
+
+func (r *queryResolver) Person(ctx context.Context, wigand string) (*model.Person, error) {
+	wigandID, err := strconv.Atoi(strings.TrimSpace(wigand))
+	if err != nil || wigandID <= 0 {
+		return nil, errors.Errorf("некорректный идентификатор вигадна: %s", wigand)
+	}
+	person, err := r.db.GetPerson(uint(wigandID))
+	if err != nil {
+		if r.db.IsNotFound(err) {
+			return nil, errors.Errorf("персона с вигандом %s не найдена", wigand)
+		}
+		return nil, errors.Trace(err)
+	}
+	result := r.personToGraphQL(*person)
+	if RequesterFromContext(ctx).Privacy {
+		privatePerson(result)
+	}
+	return result, nil
+}
+
+func (r *queryResolver) PersonSyncs(ctx context.Context, last *int) ([]*model.PersonSync, error) {
+	_ = ctx
+	if r.personSync == nil {
+		return nil, errors.New("синхронизация персон с СУДОС не настроена")
+	}
+	limit := personSyncsOnPage
+	if last != nil {
+		if *last <= 0 {
+			return nil, errors.Errorf("некорректное количество записей last=%d", *last)
+		}
+		limit = *last
+	}
+	rows, err := r.personSync.History(uint(limit))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := make([]*model.PersonSync, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, personSyncToGraphQL(v))
+	}
+	return result, nil
+}
+
+func (r *queryResolver) Contacts(ctx context.Context, wigand string, from string, to string, windowMinutes int, cabins []string) ([]*model.Contact, error) {
+	wigandID, err := strconv.Atoi(strings.TrimSpace(wigand))
+	if err != nil || wigandID <= 0 {
+		return nil, errors.Errorf("некорректный идентификатор вигадна: %s", wigand)
+	}
+	fromTime, toTime, err := tool.ParsePeriod(from, to)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if windowMinutes <= 0 {
+		return nil, errors.Errorf("некорректное окно контакта windowMinutes=%d", windowMinutes)
+	}
+	termopads := make([]uint, 0, len(cabins))
+	for _, v := range cabins {
+		id, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || id <= 0 {
+			return nil, errors.Errorf("некорректный идентификатор термопада: %s", v)
+		}
+		termopads = append(termopads, uint(id))
+	}
+
+	contacts, err := r.db.Contacts(uint(wigandID), fromTime, toTime, time.Duration(windowMinutes)*time.Minute, termopads)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	details := "from=" + from + " to=" + to + " windowMinutes=" + strconv.Itoa(windowMinutes)
+	if err := r.auditAccess(ctx, modelApp.AuditContacts, strconv.Itoa(wigandID), details); err != nil {
+		return nil, errors.Trace(err)
+	}
+	privacy := RequesterFromContext(ctx).Privacy
+	persons := make([]modelApp.Person, 0, len(contacts))
+	for _, v := range contacts {
+		persons = append(persons, v.Person)
+	}
+	graphPersons := r.personsToGraphQL(persons)
+	result := make([]*model.Contact, 0, len(contacts))
+	for i, v := range contacts {
+		ids := make([]string, 0, len(v.Termopads))
+		for _, id := range v.Termopads {
+			ids = append(ids, strconv.Itoa(int(id)))
+		}
+		person := graphPersons[i]
+		if privacy {
+			privatePerson(person)
+		}
+		result = append(result, &model.Contact{
+			Person:    person,
+			Overlaps:  int(v.Overlaps),
+			Termopads: ids,
+			FirstAt:   v.FirstAt.Format("2006.01.02 15:04:05"),
+			LastAt:    v.LastAt.Format("2006.01.02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+func (r *queryResolver) Report(ctx context.Context, date string, shift *string) (*model.Report, error) {
+	if r.report == nil {
+		return nil, errors.New("формирование отчётов не настроено")
+	}
+	day, err := time.ParseInLocation(tool.DateLayout, strings.TrimSpace(date), time.Local)
+	if err != nil {
+		return nil, errors.Errorf("некорректная дата отчёта \"%s\", ожидается %s", date, tool.DateLayout)
+	}
+	shiftName := ""
+	if shift != nil {
+		shiftName = strings.TrimSpace(*shift)
+	}
+	report, err := r.report.Report(day, shiftName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := r.auditAccess(ctx, modelApp.AuditReport, ReportAuditTarget(day, shiftName), ""); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return reportToGraphQL(*report), nil
+}
+
+func (r *queryResolver) WebhookDeliveries(ctx context.Context, webhook *string, event *string, last *int) ([]*model.WebhookDelivery, error) {
+	_ = ctx
+	limit, err := pageLimit(last, webhookDeliveriesOnPage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var hookName, eventName string
+	if webhook != nil {
+		hookName = strings.TrimSpace(*webhook)
+	}
+	if event != nil {
+		eventName = strings.TrimSpace(*event)
+	}
+	rows, err := r.db.WebhookDeliveries(hookName, eventName, limit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := make([]*model.WebhookDelivery, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, webhookDeliveryToGraphQL(v))
+	}
+	return result, nil
+}
+
+func (r *queryResolver) WebhookDeadLetters(ctx context.Context, webhook *string, last *int) ([]*model.WebhookDeadLetter, error) {
+	if RequesterFromContext(ctx).Privacy {
+		return nil, errors.New("недоступно в режиме приватности")
+	}
+	limit, err := pageLimit(last, webhookDeliveriesOnPage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var hookName string
+	if webhook != nil {
+		hookName = strings.TrimSpace(*webhook)
+	}
+	rows, err := r.db.WebhookDeadLetters(hookName, limit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := make([]*model.WebhookDeadLetter, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, &model.WebhookDeadLetter{
+			ID:       strconv.Itoa(int(v.ID)),
+			CreateAt: v.CreateAt.Format("2006.01.02 15:04:05"),
+			Webhook:  v.Webhook,
+			URL:      v.URL,
+			EventID:  v.EventID,
+			Event:    v.Event,
+			Payload:  v.Payload,
+			Attempts: int(v.Attempts),
+			Error:    v.Error,
+		})
+	}
+	return result, nil
+}
+
+func (r *queryResolver) Alarms(ctx context.Context, unhandled *bool, last *int) ([]*model.Alarm, error) {
+	limit, err := pageLimit(last, alarmsOnPage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rows, err := r.db.Alarms(unhandled != nil && *unhandled, limit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := make([]*model.Alarm, 0, len(rows))
+	privacy := RequesterFromContext(ctx).Privacy
+	for _, v := range rows {
+		alarm := alarmToGraphQL(v)
+		if privacy {
+			privateAlarm(alarm)
+		}
+		result = append(result, alarm)
+	}
+	return result, nil
+}
+
+func (r *queryResolver) AuditLog(ctx context.Context, user *string, action *string, target *string, from *string, to *string, last *int) ([]*model.AuditEntry, error) {
+	requester := RequesterFromContext(ctx)
+	if !requester.IsAdmin() {
+		return nil, errors.New("журнал аудита доступен только администраторам")
+	}
+	limit, err := pageLimit(last, auditOnPage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var filter modelApp.AuditFilter
+	if user != nil {
+		filter.User = strings.TrimSpace(*user)
+	}
+	if action != nil {
+		filter.Action = strings.TrimSpace(*action)
+	}
+	if target != nil {
+		filter.Target = strings.TrimSpace(*target)
+	}
+	if (from != nil) != (to != nil) {
+		return nil, errors.New("начало и окончание периода from и to задаются вместе")
+	}
+	if from != nil {
+		if filter.From, filter.To, err = tool.ParsePeriod(*from, *to); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	rows, err := r.db.AuditLog(filter, limit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := r.auditAccess(ctx, modelApp.AuditLog, "", ""); err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := make([]*model.AuditEntry, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, &model.AuditEntry{
+			ID:       strconv.Itoa(int(v.ID)),
+			CreateAt: v.CreateAt.Format("2006.01.02 15:04:05"),
+			User:     v.User,
+			Action:   v.Action,
+			Target:   v.Target,
+			IP:       v.IP,
+			Details:  v.Details,
+		})
+	}
 	return result, nil
 }
 
@@ -182,7 +731,9 @@ func (r *subscriptionResolver) TemperatureChanged(ctx context.Context) (<-chan *
 	// Подписка нового кликнта
 	id := uuid.New().String()               // Новый идентификатор канала в пуле каналов
 	ch := make(chan *model.Temperature, 10) // Новый канал для передачи данных подписавшемуся
-	r.temperatureSubscribePool.Store(id, ch)
+	if err := r.subscribe(r.temperatureSubscribePool, id, ch); err != nil {
+		return nil, err
+	}
 	r.log.Debugf("добавлен канал %s в подписку TemperatureChanged", id)
 	go func() {
 		<-ctx.Done()
@@ -190,14 +741,63 @@ func (r *subscriptionResolver) TemperatureChanged(ctx context.Context) (<-chan *
 		r.log.Debugf("удалён канал %s из подписки TemperatureChanged", id)
 	}()
 
+	if !RequesterFromContext(ctx).Privacy {
+		return ch, nil
+	}
+	// В режиме приватности подписчик получает копии замеров с инициалами вместо ФИО
+	private := make(chan *model.Temperature, cap(ch))
+	go func() {
+		defer close(private)
+		for {
+			select {
+			case v, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case private <- privateTemperature(v):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return private, nil
+}
+
+func (r *subscriptionResolver) PersonSyncProgress(ctx context.Context) (<-chan *model.PersonSync, error) {
+	id := uuid.New().String()
+	ch := make(chan *model.PersonSync, 10)
+	// Сразу сообщаем новому подписчику о выполняющейся синхронизации
+	if r.personSync != nil {
+		if current := r.personSync.Current(); current != nil {
+			ch <- personSyncToGraphQL(*current)
+		}
+	}
+	if err := r.subscribe(r.personSyncSubscribePool, id, ch); err != nil {
+		return nil, err
+	}
+	r.log.Debugf("добавлен канал %s в подписку PersonSyncProgress", id)
+	go func() {
+		<-ctx.Done()
+		r.personSyncSubscribePool.Delete(id)
+		r.log.Debugf("удалён канал %s из подписки PersonSyncProgress", id)
+	}()
+
 	return ch, nil
 }
 
+// Mutation returns generated.MutationResolver implementation.
+func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
+
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
 // Subscription returns generated.SubscriptionResolver implementation.
 func (r *Resolver) Subscription() generated.SubscriptionResolver { return &subscriptionResolver{r} }
 
+type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
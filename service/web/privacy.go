@@ -0,0 +1,49 @@
+package web
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/faceblur"
+	"github.com/kirsrus/termopad-server/service/web/graph"
+
+	"github.com/juju/errors"
+	"github.com/labstack/echo"
+)
+
+const (
+	// Параметр запроса и заголовок, которыми клиент включает режим приватности
+	privacyParam  = "privacy"
+	privacyHeader = "X-Privacy"
+)
+
+// Включён ли для запроса режим приватности: для пользователей с ролью viewer, клиентов из privacyClients и по
+// запросу клиента. Адрес клиента определяется через clientIP, поэтому подставить его заголовком X-Forwarded-For
+// в обход доверенных прокси нельзя. Отключить режим приватности запросом нельзя
+func (m Web) privacy(c echo.Context, role string) bool {
+	if role == model.UserRoleViewer {
+		return true
+	}
+	if ip := net.ParseIP(m.clientIP(c)); ip != nil && containsIP(m.privacyClients, ip) {
+		return true
+	}
+	for _, v := range []string{c.QueryParam(privacyParam), c.Request().Header.Get(privacyHeader)} {
+		if enabled, err := strconv.ParseBool(v); err == nil && enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// Изображение для ответа на запрос: в режиме приватности лица размываются
+func (m Web) privacyImage(c echo.Context, content []byte) ([]byte, error) {
+	if !graph.RequesterFromContext(c.Request().Context()).Privacy {
+		return content, nil
+	}
+	blurred, err := faceblur.Blur(content, m.privacyBlur)
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка размытия изображения")
+	}
+	return blurred, nil
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
 	"time"
 
+	"github.com/kirsrus/termopad-server/controller"
 	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/faceblur"
+	"github.com/kirsrus/termopad-server/pkg/tool"
 	"github.com/kirsrus/termopad-server/pkg/validator"
 	"github.com/kirsrus/termopad-server/service"
 	"github.com/kirsrus/termopad-server/service/web/graph"
@@ -33,19 +37,46 @@ const (
 	webPort                = 80
 	assetsDir              = "./assets/main"
 	personPhotoDir         = "./imagedb"
+	// Запас размера запроса с загружаемым файлом на описание операции GraphQL
+	uploadOverhead = 64 << 10
 )
 
 // ConfigWeb конфигурация структуры Web
 type ConfigWeb struct {
 	Log *logrus.Logger
 
+	// Служба СУДОС для принудительного обновления данных персон
+	SudosSvc service.SudosSvc
+	// Контроллер синхронизации персон с СУДОС
+	PersonSyncCtl controller.PersonSyncCtl
+	// Супервизор фоновых обработчиков для отображения их состояния
+	Supervisor controller.SupervisorCtl
+	// Очередь принятых замеров для отображения её глубины
+	Queue controller.QueueCtl
+	// Контроллер сводных отчётов
+	ReportCtl controller.ReportCtl
+	// Рассылка внешним системам событий об изменении персон
+	WebhookSvc service.WebhookSvc
+
 	WebPort        uint
 	AssetsDir      string
 	PersonPhotoDir string
 
 	TermopadsOnPage uint
-	MaxTemperature  float64
-	MinTemperature  float64
+	// Единые пороги нормальной температуры
+	ThresholdsSvc service.ThresholdsSvc
+	// Пользователи WEB-интерфейса (пустой список отключает авторизацию)
+	Users []User
+	// Стирание персональных данных
+	RetentionCtl controller.RetentionCtl
+	// Адреса и сети (CIDR) клиентов, для которых всегда включён режим приватности
+	PrivacyClients []string
+	// Режим размытия изображений в режиме приватности (faceblur.ModeFrame по умолчанию)
+	PrivacyBlur string
+	// Максимальный размер загружаемой фотографии персоны в байтах (по умолчанию graph.MaxImageSize)
+	MaxImageSize int64
+	// Адреса и сети (CIDR) обратных прокси, заголовкам X-Forwarded-For и X-Real-IP от которых доверяется
+	TrustedProxies []string
 }
 
 // Web служба WEB-сервисов. Инициализируется через WebNew
@@ -58,15 +89,25 @@ type Web struct {
 	playgroundHandler http.HandlerFunc
 	resolver          *graph.Resolver
 
-	dbStore store.DbStore
+	dbStore    store.DbStore
+	supervisor controller.SupervisorCtl
+	queue      controller.QueueCtl
+	report     controller.ReportCtl
 
 	webPort        uint
 	assetsDir      string
 	personPhotoDir string
 
 	termopadsOnPage uint
-	maxTemperature  float64
-	minTemperature  float64
+
+	auth *authenticator
+	// Пути, доступные без авторизации
+	public map[string]bool
+
+	privacyClients []*net.IPNet
+	privacyBlur    string
+	// Доверенные обратные прокси
+	trustedProxies []*net.IPNet
 }
 
 // NewWeb конструктор структкуры Web
@@ -88,15 +129,21 @@ func NewWeb(ctx context.Context, termopads []model.TermopadInfo, dbStore store.D
 		validator: validator.Get(),
 		e:         echo.New(),
 
-		dbStore: dbStore,
+		dbStore:    dbStore,
+		supervisor: config.Supervisor,
+		queue:      config.Queue,
+		report:     config.ReportCtl,
 
 		webPort:        webPort,
 		assetsDir:      assetsDir,
 		personPhotoDir: personPhotoDir,
 
 		termopadsOnPage: 16,
-		maxTemperature:  37.5,
-		minTemperature:  35.0,
+
+		auth:   newAuthenticator(config.Users),
+		public: make(map[string]bool),
+
+		privacyBlur: faceblur.ModeFrame,
 	}
 
 	if config.WebPort != 0 {
@@ -111,11 +158,14 @@ func NewWeb(ctx context.Context, termopads []model.TermopadInfo, dbStore store.D
 	if config.TermopadsOnPage != 0 {
 		web.termopadsOnPage = config.TermopadsOnPage
 	}
-	if config.MaxTemperature != 0 {
-		web.maxTemperature = config.MaxTemperature
+	if web.privacyClients, err = parseNetworks(config.PrivacyClients); err != nil {
+		return nil, errors.Annotate(err, "некорректный список клиентов режима приватности")
+	}
+	if config.PrivacyBlur != "" {
+		web.privacyBlur = config.PrivacyBlur
 	}
-	if config.MinTemperature != 0 {
-		web.minTemperature = config.MinTemperature
+	if web.trustedProxies, err = parseNetworks(config.TrustedProxies); err != nil {
+		return nil, errors.Annotate(err, "некорректный список доверенных прокси")
 	}
 
 	// Настойка WEB-сервера с поддержкой GraphQL
@@ -124,14 +174,25 @@ func NewWeb(ctx context.Context, termopads []model.TermopadInfo, dbStore store.D
 	//web.e.Use(middleware.Logger())
 	web.e.Use(middleware.Recover())
 	web.e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
+		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization,
+			privacyHeader},
 	}))
+	web.e.Use(web.authenticate)
 	// Точки входа в GrahpQL
+	maxImageSize := int64(graph.MaxImageSize)
+	if config.MaxImageSize != 0 {
+		maxImageSize = config.MaxImageSize
+	}
 	web.resolver, err = graph.NewResolver(termopads, dbStore, &graph.ConfigResolver{
 		Log:             config.Log,
+		MaxImageSize:    maxImageSize,
+		SudosSvc:        config.SudosSvc,
+		PersonSyncCtl:   config.PersonSyncCtl,
+		ReportCtl:       config.ReportCtl,
+		WebhookSvc:      config.WebhookSvc,
+		ThresholdsSvc:   config.ThresholdsSvc,
 		TermopadsOnPage: web.termopadsOnPage,
-		MaxTemperature:  web.maxTemperature,
-		MinTemperature:  web.minTemperature,
+		RetentionCtl:    config.RetentionCtl,
 	})
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -140,6 +201,8 @@ func NewWeb(ctx context.Context, termopads []model.TermopadInfo, dbStore store.D
 	web.graphqlHandler = handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: web.resolver}))
 	web.graphqlHandler.Use(extension.Introspection{})
 	web.graphqlHandler.AddTransport(transport.POST{})
+	// Кроме файла запрос содержит описание операции GraphQL
+	web.graphqlHandler.AddTransport(transport.MultipartForm{MaxUploadSize: maxImageSize + uploadOverhead})
 	web.graphqlHandler.AddTransport(
 		transport.Websocket{
 			KeepAlivePingInterval: 10 * time.Second, // Каждые 10 секунд подавать в канал (ping), иначе клиент его закроет
@@ -162,11 +225,25 @@ func (m Web) serve() {
 	for {
 		m.log.Infof("старт HTTP-сервера на порту :%d", m.webPort)
 		err := m.e.Start(fmt.Sprintf(":%d", m.webPort))
+		if err == http.ErrServerClosed {
+			m.log.Info("HTTP-сервер остановлен")
+			return
+		}
 		m.log.Errorf("сервер неожиданно завершил работу: %s", err.Error())
 		time.Sleep(waitRestartStartServer)
 	}
 }
 
+// Shutdown закрывает подписки GraphQL и останавливает HTTP-сервер, дожидаясь завершения текущих
+// запросов, но не дольше ctx
+func (m Web) Shutdown(ctx context.Context) error {
+	m.resolver.Close()
+	if err := m.e.Shutdown(ctx); err != nil {
+		return errors.Annotate(err, "ошибка остановки HTTP-сервера")
+	}
+	return nil
+}
+
 func (m Web) GraphQLApi(path string) {
 	m.e.GET(path, func(c echo.Context) error {
 		req := c.Request()
@@ -206,6 +283,10 @@ func (m Web) TemperatureImage(path string) {
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "ошибка: " + err.Error()})
 		}
+		if content, err = m.privacyImage(c, content); err != nil {
+			m.log.Warnf("изображение %s: %v", name, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "ошибка размытия изображения"})
+		}
 		mime := mimetype.Detect(content).String()
 		return c.Blob(http.StatusOK, mime, content)
 	})
@@ -225,11 +306,123 @@ func (m Web) PersonImage(path string) {
 		if err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "ошибка: " + err.Error()})
 		}
+		if err := graph.Audit(c.Request().Context(), m.dbStore, model.AuditPersonImage, strconv.Itoa(wigand), ""); err != nil {
+			m.log.Warnf("ошибка записи в журнал аудита: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "ошибка записи в журнал аудита"})
+		}
+		if content, err = m.privacyImage(c, content); err != nil {
+			m.log.Warnf("фотография персоны %d: %v", wigand, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "ошибка размытия изображения"})
+		}
 		mime := mimetype.Detect(content).String()
 		return c.Blob(http.StatusOK, mime, content)
 	})
 }
 
+// Состояние фонового обработчика в ответе Health
+type healthWorker struct {
+	Name        string     `json:"name"`
+	Running     bool       `json:"running"`
+	StartedAt   time.Time  `json:"startedAt"`
+	Crashes     uint       `json:"crashes"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// Глубина очереди замеров термопада в ответе Health
+type healthQueue struct {
+	TermopadID uint `json:"termopad"`
+	Queued     uint `json:"queued"`
+	Spilled    uint `json:"spilled"`
+	Dropped    uint `json:"dropped"`
+}
+
+// Health возвращает состояние фоновых обработчиков и глубину очереди замеров. Если какой-либо обработчик ожидает перезапуска
+// после сбоя, возвращается статус 503. Доступно без авторизации, но тексты последних ошибок обработчиков
+// возвращаются только авторизованным пользователям
+func (m Web) Health(path string) {
+	m.public[path] = true
+	m.e.GET(path, func(c echo.Context) error {
+		// Тексты ошибок могут содержать пути, адреса устройств и строки подключения, поэтому возвращаются только
+		// авторизованным пользователям
+		details := !graph.RequesterFromContext(c.Request().Context()).Anonymous()
+		status := "ok"
+		workers := make([]healthWorker, 0)
+		if m.supervisor != nil {
+			for _, v := range m.supervisor.Status() {
+				if !v.Running {
+					status = "degraded"
+				}
+				worker := healthWorker{
+					Name:      v.Name,
+					Running:   v.Running,
+					StartedAt: v.StartedAt,
+					Crashes:   v.Crashes,
+				}
+				if details {
+					worker.LastError, worker.LastErrorAt = v.LastError, v.LastErrorAt
+				}
+				workers = append(workers, worker)
+			}
+		}
+		queue := make([]healthQueue, 0)
+		if m.queue != nil {
+			for _, v := range m.queue.Stats() {
+				queue = append(queue, healthQueue{
+					TermopadID: v.TermopadID,
+					Queued:     v.Queued,
+					Spilled:    v.Spilled,
+					Dropped:    v.Dropped,
+				})
+			}
+		}
+		code := http.StatusOK
+		if status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		return c.JSON(code, map[string]interface{}{"status": status, "workers": workers, "queue": queue})
+	})
+}
+
+// Report возвращает сводный отчёт за сутки из параметра :date ("2006-01-02") по смене из параметра запроса shift
+// (по умолчанию - сутки целиком) в формате из параметра format: html (по умолчанию) или csv
+func (m Web) Report(path string) {
+	m.e.GET(path, func(c echo.Context) error {
+		if m.report == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "формирование отчётов не настроено"})
+		}
+		date, err := time.ParseInLocation(tool.DateLayout, c.Param("date"), time.Local)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("некорректная дата отчёта: %s", c.Param("date"))})
+		}
+		format := c.QueryParam("format")
+		contentType := echo.MIMETextHTMLCharsetUTF8
+		switch format {
+		case "", model.ReportFormatHTML:
+			format = model.ReportFormatHTML
+		case model.ReportFormatCSV:
+			contentType = "text/csv; charset=UTF-8"
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("неизвестный формат отчёта: %s", format)})
+		}
+		report, err := m.report.Report(date, c.QueryParam("shift"))
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return c.JSON(http.StatusNotFound, map[string]string{"message": err.Error()})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "ошибка: " + err.Error()})
+		}
+		target := graph.ReportAuditTarget(date, c.QueryParam("shift"))
+		if err := graph.Audit(c.Request().Context(), m.dbStore, model.AuditReport, target, "format="+format); err != nil {
+			m.log.Warnf("ошибка записи в журнал аудита: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "ошибка записи в журнал аудита"})
+		}
+		c.Response().Header().Set(echo.HeaderContentType, contentType)
+		c.Response().WriteHeader(http.StatusOK)
+		return m.report.Render(c.Response(), report, format)
+	})
+}
+
 // Static ожидаем имя изображения в параметре name
 func (m Web) Static(path string) {
 	m.e.Static(path, m.assetsDir)
@@ -240,3 +433,16 @@ func (m Web) TemperatureChanged(temperature model.TemperatureChange) {
 	m.log.Debugf("отсылка температуры на WEB")
 	m.resolver.TemperatureChanged(temperature)
 }
+
+// SetTermopads изменение списка термопадов и их количества на странице (0 - без изменений)
+func (m Web) SetTermopads(termopads []model.TermopadInfo, termopadsOnPage uint) {
+	m.resolver.SetTermopads(termopads)
+	if err := m.resolver.Configure(&graph.ConfigResolver{TermopadsOnPage: termopadsOnPage}); err != nil {
+		m.log.Warn(err)
+	}
+}
+
+// PersonSyncChanged изменился ход синхронизации персон с СУДОС
+func (m Web) PersonSyncChanged(progress model.PersonSync) {
+	m.resolver.PersonSyncChanged(progress)
+}
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Супервизор с одним упавшим обработчиком
+type failedSupervisor struct{}
+
+func (failedSupervisor) Go(ctx context.Context, name string, fn func() error) {}
+
+func (failedSupervisor) Status() []model.WorkerStatus {
+	at := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	return []model.WorkerStatus{{Name: "manager.clean", Crashes: 1, LastError: "open /var/lib/termopad/db.sqlite", LastErrorAt: &at}}
+}
+
+func TestWeb_Health(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []User{{Name: "operator", PasswordHash: string(hash), Role: model.UserRoleOperator}}
+
+	tests := []struct {
+		name        string
+		users       []User
+		user        string
+		wantDetails bool
+	}{
+		{name: "без авторизации", users: users, wantDetails: false},
+		{name: "авторизованный пользователь", users: users, user: "operator", wantDetails: true},
+		{name: "авторизация отключена", wantDetails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			web := Web{
+				e:          echo.New(),
+				auth:       newAuthenticator(tt.users),
+				public:     make(map[string]bool),
+				supervisor: failedSupervisor{},
+			}
+			web.e.Use(web.authenticate)
+			web.Health("/health")
+
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, "secret")
+			}
+			rec := httptest.NewRecorder()
+			web.e.ServeHTTP(rec, req)
+			if rec.Code != http.StatusServiceUnavailable {
+				t.Fatalf("код ответа = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+			}
+			var got struct {
+				Workers []healthWorker `json:"workers"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Workers) != 1 || got.Workers[0].Crashes != 1 {
+				t.Fatalf("обработчики = %+v", got.Workers)
+			}
+			if details := got.Workers[0].LastError != "" || got.Workers[0].LastErrorAt != nil; details != tt.wantDetails {
+				t.Errorf("обработчик = %+v, ожидаются подробности ошибки: %v", got.Workers[0], tt.wantDetails)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+)
+
+// Тело запроса вебхука: конверт события с данными, зависящими от типа события
+type envelope struct {
+	ID       string      `json:"id"`
+	Event    string      `json:"event"`
+	CreateAt time.Time   `json:"created_at"`
+	Data     interface{} `json:"data"`
+}
+
+// Персона в событиях вебхуков. Для неизвестных карт заполняется только виганд
+type personPayload struct {
+	Wigand       uint   `json:"wigand"`
+	Family       string `json:"family,omitempty"`
+	Name         string `json:"name,omitempty"`
+	MiddleName   string `json:"middle_name,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Department   string `json:"department,omitempty"`
+	Position     string `json:"position,omitempty"`
+	Manual       bool   `json:"manual,omitempty"`
+}
+
+func newPersonPayload(person model.Person) personPayload {
+	return personPayload{
+		Wigand:       person.Wigand.ID,
+		Family:       person.Family,
+		Name:         person.Name,
+		MiddleName:   person.MiddleName,
+		Organization: person.Organization,
+		Department:   person.Department,
+		Position:     person.Position,
+		Manual:       person.Manual,
+	}
+}
+
+// Данные события WebhookEventMeasurement
+type measurementPayload struct {
+	TermopadID  uint          `json:"termopad_id"`
+	CreateAt    time.Time     `json:"created_at"`
+	Temperature float64       `json:"temperature"`
+	Alarm       bool          `json:"alarm"`
+	Invalid     bool          `json:"invalid"`
+	Image       string        `json:"image,omitempty"`
+	Person      personPayload `json:"person"`
+}
+
+// Данные события WebhookEventAlarm
+type alarmPayload struct {
+	TermopadID     uint          `json:"termopad_id"`
+	TermopadName   string        `json:"termopad_name"`
+	CreateAt       time.Time     `json:"created_at"`
+	Temperature    float64       `json:"temperature"`
+	MaxTemperature float64       `json:"max_temperature"`
+	MinTemperature float64       `json:"min_temperature"`
+	Image          string        `json:"image,omitempty"`
+	Person         personPayload `json:"person"`
+}
+
+// Данные события WebhookEventTermopadDown
+type termopadDownPayload struct {
+	TermopadID uint      `json:"termopad_id"`
+	DownAt     time.Time `json:"down_at"`
+}
@@ -0,0 +1,436 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/service"
+	"github.com/kirsrus/termopad-server/store"
+
+	"github.com/juju/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Ёмкость очереди событий каждого вебхука
+	queueCapacity = 100
+	// Повторных попыток доставки после первой неудачной
+	retries = 5
+	// Задержка перед первой повторной попыткой; каждая следующая вдвое дольше, но не больше maxBackoff
+	backoff    = time.Second
+	maxBackoff = 5 * time.Minute
+	// Ограничение времени одного запроса
+	timeout = 10 * time.Second
+
+	// Заголовки запроса вебхука
+	headerEvent     = "X-Termopad-Event"
+	headerDelivery  = "X-Termopad-Delivery"
+	headerTimestamp = "X-Termopad-Timestamp"
+	headerSignature = "X-Termopad-Signature"
+)
+
+// Hook описание вебхука
+type Hook struct {
+	// Имя вебхука (для журнала доставки)
+	Name string
+	URL  string
+	// Ключ подписи HMAC-SHA256 (пустой - запросы не подписываются)
+	Secret string
+	// Типы событий (model.WebhookEvents), которые рассылаются вебхуку. Пустой список - все события
+	Events []string
+}
+
+// Подписан ли вебхук на событие event
+func (m Hook) subscribed(event string) bool {
+	if len(m.Events) == 0 {
+		return true
+	}
+	for _, v := range m.Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Событие в очереди доставки вебхуку
+type delivery struct {
+	id      string
+	event   string
+	payload []byte
+}
+
+// Webhook рассылка событий внешним системам HTTP-запросами POST с телом в JSON. Имплементирует интерфейс
+// WebhookSvc. Инициализируется через NewWebhook. У каждого вебхука своя очередь, поэтому недоступная
+// система не задерживает доставку другим. Неудачные запросы повторяются с экспоненциально растущей
+// задержкой; события, которые так и не удалось доставить, сохраняются в БД как недоставленные
+type Webhook struct {
+	ctx     context.Context
+	log     *logrus.Entry
+	dbStore store.DbStore
+	client  *http.Client
+
+	hooks  []Hook
+	queues []chan delivery
+
+	retries    uint
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	// События, поставленные в очередь, но ещё не доставленные или не перенесённые в недоставленные
+	pending sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+	// Закрывается при завершении работы: повторные попытки прекращаются
+	stop chan struct{}
+}
+
+// ConfigWebhook конфигурация Webhook
+type ConfigWebhook struct {
+	Log   *logrus.Logger
+	Hooks []Hook
+	// Повторных попыток доставки после первой неудачной
+	Retries uint
+	// Задержка перед первой повторной попыткой и её предел
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Ограничение времени одного запроса
+	Timeout time.Duration
+}
+
+// NewWebhook конструктор Webhook
+func NewWebhook(ctx context.Context, dbStore store.DbStore, config *ConfigWebhook) (service.WebhookSvc, error) {
+	if config == nil {
+		return nil, errors.New("не задана конфигурация config")
+	}
+	if config.Log == nil {
+		config.Log = logrus.New()
+		config.Log.Out = ioutil.Discard
+	}
+	if dbStore == nil {
+		return nil, errors.New("не указана служба dbStore")
+	}
+
+	webhook := &Webhook{
+		ctx: ctx,
+		log: config.Log.WithFields(map[string]interface{}{
+			"module": "webhook",
+			"scope":  "service",
+		}),
+		dbStore:    dbStore,
+		client:     &http.Client{Timeout: timeout},
+		hooks:      config.Hooks,
+		queues:     make([]chan delivery, len(config.Hooks)),
+		retries:    retries,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		stop:       make(chan struct{}),
+	}
+	if config.Retries != 0 {
+		webhook.retries = config.Retries
+	}
+	if config.Backoff != 0 {
+		webhook.backoff = config.Backoff
+	}
+	if config.MaxBackoff != 0 {
+		webhook.maxBackoff = config.MaxBackoff
+	}
+	if config.Timeout != 0 {
+		webhook.client.Timeout = config.Timeout
+	}
+
+	names := make(map[string]bool)
+	for i, hook := range config.Hooks {
+		if hook.Name == "" || hook.URL == "" {
+			return nil, errors.Errorf("не указано имя или адрес вебхука %d", i)
+		}
+		if names[hook.Name] {
+			return nil, errors.Errorf("повторяющееся имя вебхука \"%s\"", hook.Name)
+		}
+		names[hook.Name] = true
+		webhook.queues[i] = make(chan delivery, queueCapacity)
+	}
+	for i := range webhook.hooks {
+		go webhook.loop(webhook.hooks[i], webhook.queues[i])
+	}
+
+	return webhook, nil
+}
+
+// Measurement рассылает событие о принятом замере температуры
+func (m *Webhook) Measurement(change model.TemperatureChange) {
+	m.emit(model.WebhookEventMeasurement, measurementPayload{
+		TermopadID:  change.ID,
+		CreateAt:    change.CreateAt,
+		Temperature: change.Temperature,
+		Alarm:       change.Alarm,
+		Invalid:     change.Invalid,
+		Image:       change.Image,
+		Person: personPayload{
+			Wigand:       change.Wigand.ID,
+			Family:       change.NameLast,
+			Name:         change.NameFirst,
+			MiddleName:   change.NameMiddle,
+			Organization: change.Organization,
+			Department:   change.Departament,
+			Position:     change.Postion,
+		},
+	})
+}
+
+// Alarm рассылает событие о повышенной температуре
+func (m *Webhook) Alarm(alarm model.Alarm) {
+	m.emit(model.WebhookEventAlarm, alarmPayload{
+		TermopadID:     alarm.Termopad.ID,
+		TermopadName:   alarm.Termopad.Name,
+		CreateAt:       alarm.CreateAt,
+		Temperature:    alarm.Temperature,
+		MaxTemperature: alarm.Thresholds.MaxTemperature,
+		MinTemperature: alarm.Thresholds.MinTemperature,
+		Image:          alarm.ImageName,
+		Person:         newPersonPayload(alarm.Person),
+	})
+}
+
+// TermopadDown рассылает событие о потере связи с термопадом в момент at
+func (m *Webhook) TermopadDown(termopadID uint, at time.Time) {
+	m.emit(model.WebhookEventTermopadDown, termopadDownPayload{TermopadID: termopadID, DownAt: at})
+}
+
+// PersonUpdated рассылает событие о добавлении или изменении данных персоны
+func (m *Webhook) PersonUpdated(person model.Person) {
+	m.emit(model.WebhookEventPersonUpdated, newPersonPayload(person))
+}
+
+// Close прекращает приём событий и повторные попытки доставки и дожидается обработки событий из очередей,
+// но не дольше ctx. События, не доставленные с первой попытки, переносятся в недоставленные
+func (m *Webhook) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.stop)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Annotate(ctx.Err(), "не все события вебхуков обработаны")
+	}
+}
+
+// Постановка события в очереди подписанных на него вебхуков без ожидания
+func (m *Webhook) emit(event string, data interface{}) {
+	if len(m.hooks) == 0 {
+		return
+	}
+	id, err := newEventID()
+	if err != nil {
+		m.log.Warnf("ошибка формирования идентификатора события %s: %v", event, err)
+		return
+	}
+	payload, err := json.Marshal(envelope{ID: id, Event: event, CreateAt: time.Now(), Data: data})
+	if err != nil {
+		m.log.Warnf("ошибка формирования события %s: %v", event, err)
+		return
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return
+	}
+	for i, hook := range m.hooks {
+		if !hook.subscribed(event) {
+			continue
+		}
+		item := delivery{id: id, event: event, payload: payload}
+		m.pending.Add(1)
+		select {
+		case m.queues[i] <- item:
+		default:
+			m.dead(hook, item, 0, "очередь вебхука переполнена")
+			m.pending.Done()
+		}
+	}
+}
+
+// Доставка событий из очереди вебхуку hook
+func (m *Webhook) loop(hook Hook, queue chan delivery) {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case item := <-queue:
+			m.deliver(hook, item)
+			m.pending.Done()
+		}
+	}
+}
+
+// Доставка события с повторными попытками. Неустранимые ошибки (например, 404) не повторяются
+func (m *Webhook) deliver(hook Hook, item delivery) {
+	for attempt := uint(1); ; attempt++ {
+		start := time.Now()
+		statusCode, retry, err := m.post(hook, item)
+		record := model.WebhookDelivery{
+			CreateAt:   start,
+			Webhook:    hook.Name,
+			EventID:    item.id,
+			Event:      item.event,
+			Attempt:    attempt,
+			Status:     model.WebhookDelivered,
+			StatusCode: statusCode,
+			Duration:   time.Since(start),
+		}
+		if err == nil {
+			m.record(record)
+			return
+		}
+		record.Error = err.Error()
+
+		if !retry || attempt > m.retries || m.stopping() {
+			record.Status = model.WebhookDead
+			m.record(record)
+			m.dead(hook, item, attempt, record.Error)
+			return
+		}
+		record.Status = model.WebhookFailed
+		m.record(record)
+
+		delay := m.delay(attempt)
+		m.log.Debugf("событие %s вебхуку %s не доставлено (%v), повтор через %s", item.id, hook.Name, err, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-m.stop:
+		case <-m.ctx.Done():
+		}
+		timer.Stop()
+		if m.stopping() {
+			m.dead(hook, item, attempt, record.Error+"; повторные попытки прерваны завершением работы")
+			return
+		}
+	}
+}
+
+// Прекращены ли повторные попытки доставки (завершение работы)
+func (m *Webhook) stopping() bool {
+	select {
+	case <-m.stop:
+		return true
+	case <-m.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Задержка перед повторной попыткой после неудачной попытки attempt
+func (m *Webhook) delay(attempt uint) time.Duration {
+	delay := float64(m.backoff) * math.Pow(2, float64(attempt-1))
+	if delay > float64(m.maxBackoff) {
+		return m.maxBackoff
+	}
+	return time.Duration(delay)
+}
+
+// Отправка события вебхуку. Возвращает HTTP-код ответа и признак того, что неудачную попытку имеет смысл
+// повторить
+func (m *Webhook) post(hook Hook, item delivery) (int, bool, error) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(item.payload))
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "termopad-server")
+	request.Header.Set(headerEvent, item.event)
+	request.Header.Set(headerDelivery, item.id)
+	request.Header.Set(headerTimestamp, timestamp)
+	if hook.Secret != "" {
+		request.Header.Set(headerSignature, Signature(hook.Secret, timestamp, item.payload))
+	}
+
+	response, err := m.client.Do(request)
+	if err != nil {
+		return 0, true, errors.Trace(err)
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response.StatusCode, false, nil
+	}
+	// Повторяются ошибки сервера и явные просьбы повторить позже; остальные ответы 4xx означают, что
+	// запрос не будет принят и при повторе
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout ||
+		response.StatusCode == http.StatusTooManyRequests
+	return response.StatusCode, retry, errors.Errorf("ответ %s", response.Status)
+}
+
+// Запись попытки доставки в журнал
+func (m *Webhook) record(delivery model.WebhookDelivery) {
+	if err := m.dbStore.SetWebhookDelivery(delivery); err != nil {
+		m.log.Warnf("ошибка записи журнала доставки вебхука %s: %v", delivery.Webhook, err)
+	}
+}
+
+// Перенос события в недоставленные
+func (m *Webhook) dead(hook Hook, item delivery, attempts uint, reason string) {
+	m.log.Warnf("событие %s (%s) не доставлено вебхуку %s: %s", item.id, item.event, hook.Name, reason)
+	err := m.dbStore.SetWebhookDeadLetter(model.WebhookDeadLetter{
+		CreateAt: time.Now(),
+		Webhook:  hook.Name,
+		URL:      hook.URL,
+		EventID:  item.id,
+		Event:    item.event,
+		Payload:  string(item.payload),
+		Attempts: attempts,
+		Error:    reason,
+	})
+	if err != nil {
+		m.log.Errorf("ошибка сохранения недоставленного события %s: %v", item.id, err)
+	}
+}
+
+// Signature подпись запроса вебхука: "sha256=" и HMAC-SHA256 в hex от строки "<timestamp>.<тело запроса>"
+// с ключом secret. Получатель сверяет её с заголовком X-Termopad-Signature, а по X-Termopad-Timestamp
+// отбрасывает устаревшие (повторно отправленные злоумышленником) запросы
+func Signature(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Случайный идентификатор события
+func newEventID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Trace(err)
+	}
+	return fmt.Sprintf("%x", buf), nil
+}
@@ -0,0 +1,209 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/config"
+	dbStoreMod "github.com/kirsrus/termopad-server/store/db"
+)
+
+func TestWebhook_Delivery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dbStore, err := dbStoreMod.NewDb(ctx, &dbStoreMod.ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ответы сервера по очереди для каждого пути; последний ответ повторяется
+	responses := map[string][]int{
+		"/ok":      {http.StatusOK},
+		"/flaky":   {http.StatusBadGateway, http.StatusTooManyRequests, http.StatusNoContent},
+		"/missing": {http.StatusNotFound},
+		"/down":    {http.StatusServiceUnavailable},
+	}
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	var received []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		codes := responses[r.URL.Path]
+		code := codes[len(codes)-1]
+		if calls[r.URL.Path] < len(codes) {
+			code = codes[calls[r.URL.Path]]
+		}
+		calls[r.URL.Path]++
+		if r.URL.Path == "/ok" {
+			received = append(received, r)
+			bodies = append(bodies, body)
+		}
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	webhookSvc, err := NewWebhook(ctx, dbStore, &ConfigWebhook{
+		Hooks: []Hook{
+			{Name: "ok", URL: server.URL + "/ok", Secret: "secret", Events: []string{model.WebhookEventAlarm}},
+			{Name: "flaky", URL: server.URL + "/flaky", Events: []string{model.WebhookEventAlarm}},
+			{Name: "missing", URL: server.URL + "/missing", Events: []string{model.WebhookEventAlarm}},
+			{Name: "down", URL: server.URL + "/down", Events: []string{model.WebhookEventAlarm}},
+		},
+		Retries:    3,
+		Backoff:    time.Millisecond,
+		MaxBackoff: 4 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// На замеры не подписан ни один вебхук
+	webhookSvc.Measurement(model.TemperatureChange{ID: 1, Temperature: 36.6})
+	webhookSvc.Alarm(model.Alarm{
+		CreateAt:    time.Now(),
+		Temperature: 38.2,
+		Thresholds:  model.Thresholds{MaxTemperature: 37.5, MinTemperature: 35.0},
+		Person:      model.Person{Wigand: model.NewWigand(100), Family: "Иванов", Name: "Иван"},
+		Termopad:    model.TermopadInfo{ID: 1, Name: "Кабина 1"},
+	})
+
+	// Close прерывает повторные попытки, поэтому сначала дожидаемся всех запросов
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		mu.Lock()
+		done := calls["/flaky"] == 3 && calls["/down"] == 4
+		mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались повторных попыток: %v", calls)
+		}
+	}
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer closeCancel()
+	if err := webhookSvc.Close(closeCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Подпись и тело запроса
+	if len(received) != 1 {
+		t.Fatalf("вебхуку ok отправлено запросов %d, want 1", len(received))
+	}
+	request, body := received[0], bodies[0]
+	if got := request.Header.Get(headerEvent); got != model.WebhookEventAlarm {
+		t.Errorf("%s = %q", headerEvent, got)
+	}
+	if want := Signature("secret", request.Header.Get(headerTimestamp), body); request.Header.Get(headerSignature) != want {
+		t.Errorf("%s = %q, want %q", headerSignature, request.Header.Get(headerSignature), want)
+	}
+	var event struct {
+		ID    string       `json:"id"`
+		Event string       `json:"event"`
+		Data  alarmPayload `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != request.Header.Get(headerDelivery) || event.Data.Temperature != 38.2 ||
+		event.Data.Person.Family != "Иванов" || event.Data.TermopadName != "Кабина 1" {
+		t.Errorf("тело запроса = %s", body)
+	}
+
+	tests := []struct {
+		name       string
+		wantStatus []string
+		wantDead   uint
+	}{
+		{
+			name:       "ok",
+			wantStatus: []string{model.WebhookDelivered},
+		},
+		{
+			name:       "flaky",
+			wantStatus: []string{model.WebhookFailed, model.WebhookFailed, model.WebhookDelivered},
+		},
+		{
+			name:       "missing",
+			wantStatus: []string{model.WebhookDead},
+			wantDead:   1,
+		},
+		{
+			name:       "down",
+			wantStatus: []string{model.WebhookFailed, model.WebhookFailed, model.WebhookFailed, model.WebhookDead},
+			wantDead:   4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries, err := dbStore.WebhookDeliveries(tt.name, "", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			status := make([]string, 0, len(deliveries))
+			for i := len(deliveries) - 1; i >= 0; i-- {
+				status = append(status, deliveries[i].Status)
+				if deliveries[i].EventID != event.ID {
+					t.Errorf("идентификатор события = %s, want %s", deliveries[i].EventID, event.ID)
+				}
+			}
+			if !reflect.DeepEqual(status, tt.wantStatus) {
+				t.Errorf("журнал доставки = %v, want %v", status, tt.wantStatus)
+			}
+
+			letters, err := dbStore.WebhookDeadLetters(tt.name, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantDead == 0 {
+				if len(letters) != 0 {
+					t.Errorf("недоставленные события = %+v, want нет", letters)
+				}
+				return
+			}
+			if len(letters) != 1 || letters[0].Attempts != tt.wantDead || letters[0].Payload == "" {
+				t.Errorf("недоставленные события = %+v, want попыток %d", letters, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestWebhook_delay(t *testing.T) {
+	webhook := &Webhook{backoff: time.Second, maxBackoff: 5 * time.Second}
+	tests := []struct {
+		attempt uint
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := webhook.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
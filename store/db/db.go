@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/patrickmn/go-cache"
 	"io/ioutil"
@@ -11,14 +12,18 @@ import (
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kirsrus/termopad-server/model"
 	"github.com/kirsrus/termopad-server/pkg/config"
+	"github.com/kirsrus/termopad-server/pkg/imagecrypt"
 	"github.com/kirsrus/termopad-server/pkg/tool"
 	"github.com/kirsrus/termopad-server/pkg/validator"
 	"github.com/kirsrus/termopad-server/store"
 
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/juju/errors"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/sqlite"
@@ -29,6 +34,20 @@ import (
 const (
 	cacheDuration = 10 * time.Minute
 	cacheCleared  = time.Hour
+	// Псевдонимы стёртых персон начинаются выше любого номера карты Wiegand (не более 37 бит)
+	pseudonymWigandBase = 1 << 40
+	// Идентификатор единственной записи в таблице config
+	configID = 1
+	// Количество персон, запрашиваемых из БД за один запрос
+	personsBatch = 500
+	// Количество изображений или записей, обрабатываемых при сверке одним запросом
+	reconcileBatch = 500
+
+	// Права доступа к директориям и файлам изображений: изображения содержат персональные данные
+	imageDirPerm  = 0700
+	imageFilePerm = 0600
+	// Суффикс временного файла при записи изображения
+	imageTempSuffix = ".tmp"
 )
 
 // Db обращение к базе данных. Инициируется через NewDb
@@ -40,6 +59,12 @@ type Db struct {
 	RootTemperatureDir string
 	RootPersonDir      string
 	globalConfig       *config.Config
+	// Шифрование файлов изображений (nil - изображения хранятся открыто)
+	cipher *imagecrypt.Cipher
+
+	// Описание термопадов по их идентификаторам (изменяется на лету через SetTermopads)
+	termopadsMu *sync.RWMutex
+	termopads   map[uint]model.TermopadInfo
 
 	personCache *cache.Cache
 }
@@ -76,7 +101,8 @@ func NewDb(ctx context.Context, config *ConfigDb) (store.DbStore, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, "ошибка подключения к файлу БД")
 	}
-	err = conn.AutoMigrate(Config{}, Person{}, Termopad{}, Temperature{})
+	err = conn.AutoMigrate(Config{}, Person{}, Termopad{}, Temperature{}, PersonSync{}, TermopadDowntime{}, Report{},
+		WebhookDelivery{}, WebhookDeadLetter{}, Alarm{}, AuditLog{})
 	if err != nil {
 		return nil, errors.Annotate(err, "ошибка миграции БД")
 	}
@@ -93,11 +119,27 @@ func NewDb(ctx context.Context, config *ConfigDb) (store.DbStore, error) {
 		RootPersonDir:      config.GlobalConfig.Sudos.Path,
 		globalConfig:       config.GlobalConfig,
 
+		termopadsMu: new(sync.RWMutex),
+		termopads:   make(map[uint]model.TermopadInfo),
+
 		personCache: cache.New(cacheDuration, cacheCleared),
 	}
 	if config.RootTemperatureDir != "" {
 		db.RootTemperatureDir = config.RootTemperatureDir
 	}
+	encryption := config.GlobalConfig.Images.Encryption
+	key, err := imagecrypt.LoadKey(encryption.KeyFile, encryption.KeyEnv)
+	if err != nil {
+		return nil, errors.Annotate(err, "ошибка загрузки ключа шифрования изображений")
+	}
+	if key != nil {
+		if db.cipher, err = imagecrypt.NewCipher(key); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	for _, t := range config.GlobalConfig.TermopadsInfo() {
+		db.termopads[t.ID] = t
+	}
 
 	return &db, nil
 }
@@ -107,6 +149,14 @@ func (m Db) IsNotFound(err error) bool {
 	return err.Error() == gorm.ErrRecordNotFound.Error()
 }
 
+// ErrDuplicate запись с таким ключом уже существует
+var ErrDuplicate = errors.New("запись уже существует")
+
+// IsDuplicate проверяет, что ошибка err обозначает повторную запись уже сохранённых данных
+func (m Db) IsDuplicate(err error) bool {
+	return errors.Cause(err) == ErrDuplicate
+}
+
 // GetPerson получает персону из БД по номеру wigand. Отсутсвие персоны в БД проверяется через IsNotFound
 func (m Db) GetPerson(wigandID uint) (*model.Person, error) {
 	if wigandID == 0 {
@@ -132,10 +182,386 @@ func (m Db) GetPerson(wigandID uint) (*model.Person, error) {
 		Organization: person.Organization,
 		Department:   person.Department,
 		Position:     person.Position,
+		Manual:       person.Manual,
+	}
+	return &res, nil
+}
+
+// Persons возвращает не более limit персон, упорядоченных по номеру виганда и начиная со следующей
+// за afterWigand. Если search не пустой, выбираются только персоны, у которых совпадает с search
+// часть ФИО, организации, отдела, должности или номера виганда. Псевдонимы стёртых персон не возвращаются
+func (m Db) Persons(search string, afterWigand uint, limit uint) ([]model.Person, error) {
+	query := m.db.Where("wigand > ? AND pseudonymized = ?", afterWigand, false)
+	if search = strings.TrimSpace(search); search != "" {
+		like := "%" + search + "%"
+		query = query.Where("family LIKE ? OR name LIKE ? OR middle_name LIKE ? OR organization LIKE ? OR "+
+			"department LIKE ? OR position LIKE ? OR CAST(wigand AS TEXT) LIKE ?", like, like, like, like, like, like, like)
+	}
+	if limit != 0 {
+		query = query.Limit(int(limit))
+	}
+
+	rows := make([]Person, 0)
+	if err := query.Order("wigand").Find(&rows).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+
+	result := make([]model.Person, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, v.ToPerson())
+	}
+	return result, nil
+}
+
+// KnownWigands возвращает номера вигандов всех известных системе персон из СУДОС: имеющихся в справочнике
+// (кроме внесённых вручную) и встречавшихся в логе замеров температуры
+func (m Db) KnownWigands() ([]uint, error) {
+	persons := make([]int, 0)
+	if err := m.db.Model(&Person{}).Where("manual = ? AND pseudonymized = ?", false, false).Pluck("wigand", &persons).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	// Внесённые вручную и псевдонимы стёртых персон в СУДОС не запрашиваются
+	manual := make([]int, 0)
+	if err := m.db.Model(&Person{}).Where("manual = ? OR pseudonymized = ?", true, true).Pluck("wigand", &manual).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	logged := make([]int, 0)
+	if err := m.db.Model(&Temperature{}).Distinct("person_id").Where("person_id > 0").Pluck("person_id", &logged).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+
+	skip := make(map[int]bool)
+	for _, v := range manual {
+		skip[v] = true
+	}
+	result := make([]uint, 0, len(persons)+len(logged))
+	for _, v := range append(persons, logged...) {
+		if v <= 0 || skip[v] {
+			continue
+		}
+		skip[v] = true
+		result = append(result, uint(v))
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result, nil
+}
+
+// SetPersonSync сохраняет запись истории синхронизации персон. Если ID записи равен 0, создаётся
+// новая запись и возвращается с присвоенным ID
+func (m Db) SetPersonSync(sync model.PersonSync) (*model.PersonSync, error) {
+	row := PersonSync{}
+	row.FromPersonSync(sync)
+	if err := m.db.Save(&row).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	res := row.ToPersonSync()
 	return &res, nil
 }
 
+// PersonSyncs возвращает не более limit последних записей истории синхронизации персон
+func (m Db) PersonSyncs(limit uint) ([]model.PersonSync, error) {
+	rows := make([]PersonSync, 0)
+	if err := m.db.Order("id DESC").Limit(int(limit)).Find(&rows).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	result := make([]model.PersonSync, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, v.ToPersonSync())
+	}
+	return result, nil
+}
+
+// SetWebhookDelivery добавляет запись в журнал доставки событий вебхукам
+func (m Db) SetWebhookDelivery(delivery model.WebhookDelivery) error {
+	row := WebhookDelivery{
+		Webhook:    delivery.Webhook,
+		EventID:    delivery.EventID,
+		Event:      delivery.Event,
+		Attempt:    int(delivery.Attempt),
+		Status:     delivery.Status,
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		Duration:   delivery.Duration.Milliseconds(),
+	}
+	row.CreatedAt = delivery.CreateAt
+	if err := m.db.Create(&row).Error; err != nil {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// WebhookDeliveries возвращает не более limit последних записей журнала доставки. Непустые webhook и event
+// отбирают записи указанного вебхука и типа события
+func (m Db) WebhookDeliveries(webhook string, event string, limit uint) ([]model.WebhookDelivery, error) {
+	query := m.db.Order("id DESC").Limit(int(limit))
+	if webhook != "" {
+		query = query.Where("webhook = ?", webhook)
+	}
+	if event != "" {
+		query = query.Where("event = ?", event)
+	}
+	rows := make([]WebhookDelivery, 0)
+	if err := query.Find(&rows).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	result := make([]model.WebhookDelivery, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, v.ToWebhookDelivery())
+	}
+	return result, nil
+}
+
+// SetWebhookDeadLetter сохраняет событие, которое не удалось доставить вебхуку
+func (m Db) SetWebhookDeadLetter(letter model.WebhookDeadLetter) error {
+	row := WebhookDeadLetter{
+		Webhook:  letter.Webhook,
+		URL:      letter.URL,
+		EventID:  letter.EventID,
+		Event:    letter.Event,
+		Payload:  letter.Payload,
+		Attempts: int(letter.Attempts),
+		Error:    letter.Error,
+	}
+	row.CreatedAt = letter.CreateAt
+	if err := m.db.Create(&row).Error; err != nil {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// WebhookDeadLetters возвращает не более limit последних недоставленных событий (непустой webhook - только
+// указанного вебхука)
+func (m Db) WebhookDeadLetters(webhook string, limit uint) ([]model.WebhookDeadLetter, error) {
+	query := m.db.Order("id DESC").Limit(int(limit))
+	if webhook != "" {
+		query = query.Where("webhook = ?", webhook)
+	}
+	rows := make([]WebhookDeadLetter, 0)
+	if err := query.Find(&rows).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	result := make([]model.WebhookDeadLetter, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, v.ToWebhookDeadLetter())
+	}
+	return result, nil
+}
+
+// SetAlarm сохраняет тревогу о повышенной температуре и возвращает её идентификатор
+func (m Db) SetAlarm(alarm model.Alarm) (uint, error) {
+	row := Alarm{
+		TermopadID:     int(alarm.Termopad.ID),
+		TermopadName:   alarm.Termopad.Name,
+		Wigand:         int(alarm.Person.Wigand.ID),
+		Family:         alarm.Person.Family,
+		Name:           alarm.Person.Name,
+		MiddleName:     alarm.Person.MiddleName,
+		Organization:   alarm.Person.Organization,
+		Department:     alarm.Person.Department,
+		Temperature:    alarm.Temperature,
+		MaxTemperature: alarm.Thresholds.MaxTemperature,
+		MinTemperature: alarm.Thresholds.MinTemperature,
+		ImageName:      alarm.ImageName,
+	}
+	row.CreatedAt = alarm.CreateAt
+	if err := m.db.Create(&row).Error; err != nil {
+		m.log.Warn(err)
+		return 0, errors.Trace(err)
+	}
+	return uint(row.ID), nil
+}
+
+// HandleAlarm отмечает тревогу id обработанной пользователем by в момент at. Уже обработанная тревога не
+// изменяется (сохраняется первое подтверждение). Отсутствие тревоги проверяется через IsNotFound
+func (m Db) HandleAlarm(id uint, by string, at time.Time) (*model.Alarm, error) {
+	var row Alarm
+	if err := m.db.Take(&row, id).Error; err != nil {
+		if m.IsNotFound(err) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	if row.HandledAt == nil {
+		res := m.db.Model(&row).Where("handled_at IS NULL").Updates(map[string]interface{}{
+			"handled_at": at,
+			"handled_by": by,
+		})
+		if res.Error != nil {
+			m.log.Warn(res.Error)
+			return nil, errors.Trace(res.Error)
+		}
+		// Тревогу могли подтвердить одновременно из другого канала
+		if err := m.db.Take(&row, id).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	result := row.ToAlarm()
+	return &result, nil
+}
+
+// Alarms возвращает не более limit последних тревог (при unhandled=true - только необработанных)
+func (m Db) Alarms(unhandled bool, limit uint) ([]model.Alarm, error) {
+	query := m.db.Order("id DESC").Limit(int(limit))
+	if unhandled {
+		query = query.Where("handled_at IS NULL")
+	}
+	rows := make([]Alarm, 0)
+	if err := query.Find(&rows).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	result := make([]model.Alarm, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, v.ToAlarm())
+	}
+	return result, nil
+}
+
+// SetAudit добавляет запись в журнал аудита. Пустое время записи заменяется текущим
+func (m Db) SetAudit(audit model.Audit) error {
+	row := AuditLog{
+		User:    audit.User,
+		Action:  audit.Action,
+		Target:  audit.Target,
+		IP:      audit.IP,
+		Details: audit.Details,
+	}
+	row.CreatedAt = audit.CreateAt
+	if row.CreatedAt.IsZero() {
+		row.CreatedAt = time.Now()
+	}
+	if err := m.db.Create(&row).Error; err != nil {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// AuditLog возвращает не более limit последних записей журнала аудита, отобранных по filter
+func (m Db) AuditLog(filter model.AuditFilter, limit uint) ([]model.Audit, error) {
+	query := m.db.Order("id DESC").Limit(int(limit))
+	if filter.User != "" {
+		query = query.Where("user = ?", filter.User)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	rows := make([]AuditLog, 0)
+	if err := query.Find(&rows).Error; err != nil {
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	result := make([]model.Audit, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, v.ToAudit())
+	}
+	return result, nil
+}
+
+// Thresholds возвращает сохранённые в БД пороги нормальной температуры. Если они ещё не сохранялись,
+// возвращается ошибка, проверяемая Db.IsNotFound
+func (m Db) Thresholds() (*model.Thresholds, error) {
+	var row Config
+	if err := m.db.Take(&row, configID).Error; err != nil {
+		if m.IsNotFound(err) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	return &model.Thresholds{
+		MaxTemperature: row.MaxTemperature,
+		MinTemperature: row.MinTemperature,
+	}, nil
+}
+
+// SetThresholds сохраняет в БД пороги нормальной температуры
+func (m Db) SetThresholds(thresholds model.Thresholds) error {
+	if err := thresholds.Validate(); err != nil {
+		return errors.Annotate(err, "ошибка валидации")
+	}
+	var row Config
+	if err := m.db.Take(&row, configID).Error; err != nil && !m.IsNotFound(err) {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	row.ID = configID
+	row.MaxTemperature = thresholds.MaxTemperature
+	row.MinTemperature = thresholds.MinTemperature
+	if err := m.db.Save(&row).Error; err != nil {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// LastTemperature возвращает последний замер температуры персоны с wigandID. Если замеров не было,
+// возвращается ошибка, проверяемая Db.IsNotFound
+func (m Db) LastTemperature(wigandID uint) (*model.Temperature, error) {
+	var temperature Temperature
+	if err := m.db.Where("person_id = ?", wigandID).Last(&temperature).Error; err != nil {
+		if m.IsNotFound(err) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return nil, errors.Trace(err)
+	}
+	return &model.Temperature{
+		TermopadID:  uint(temperature.TermopadID),
+		Wigand:      model.NewWigand(temperature.PersonID),
+		Temperature: temperature.Temperature,
+		ImagePath:   temperature.ImageName,
+		Invalid:     temperature.Invalid,
+	}, nil
+}
+
+// LastTemperatures возвращает последние замеры температуры персон wigandIDs одним запросом на каждые personsBatch
+// персон. Персоны без замеров в результат не попадают
+func (m Db) LastTemperatures(wigandIDs []uint) (map[uint]model.Temperature, error) {
+	result := make(map[uint]model.Temperature, len(wigandIDs))
+	for start := 0; start < len(wigandIDs); start += personsBatch {
+		finish := start + personsBatch
+		if finish > len(wigandIDs) {
+			finish = len(wigandIDs)
+		}
+		rows := make([]Temperature, 0)
+		last := m.db.Model(&Temperature{}).Select("MAX(id)").Where("person_id IN ?", wigandIDs[start:finish]).
+			Group("person_id")
+		if err := m.db.Where("id IN (?)", last).Find(&rows).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, v := range rows {
+			result[uint(v.PersonID)] = model.Temperature{
+				TermopadID:  uint(v.TermopadID),
+				Wigand:      model.NewWigand(v.PersonID),
+				Temperature: v.Temperature,
+				ImagePath:   v.ImageName,
+				Invalid:     v.Invalid,
+			}
+		}
+	}
+	return result, nil
+}
+
 // SetPerson добавляет персону в БД. Если персоны нет, она будет добавлена и вернётся true.
 // Если персона уже была, она будет обновлена и вернётся false
 func (m Db) SetPerson(person model.Person) (*model.Person, bool, error) {
@@ -166,12 +592,14 @@ func (m Db) SetPerson(person model.Person) (*model.Person, bool, error) {
 			Organization: newPerson.Organization,
 			Department:   newPerson.Department,
 			Position:     newPerson.Position,
+			Manual:       newPerson.Manual,
 		}
 		return &res, true, nil
 	} else {
 		// Обновляем существующую
 		isPerson.Update(newPerson)
-		err := m.db.Model(&isPerson).Updates(isPerson).Error
+		// Сохраняем все поля, чтобы очищенные значения и признак Manual тоже попали в БД
+		err := m.db.Save(&isPerson).Error
 		if err != nil {
 			return nil, false, errors.Annotate(err, "ошибка обновления записи")
 		}
@@ -185,26 +613,35 @@ func (m Db) SetPerson(person model.Person) (*model.Person, bool, error) {
 			Organization: isPerson.Organization,
 			Department:   isPerson.Department,
 			Position:     isPerson.Position,
+			Manual:       isPerson.Manual,
 		}
 		return &res, false, nil
 	}
 }
 
-// TempImage получает изображение из файловой БД по его полному поути
-func (m Db) TempImage(filePath string) ([]byte, error) {
+// Полный путь к изображению замера по его имени: изображения лежат в поддиректориях дня и часа
+func (m Db) tempImagePath(name string) (string, error) {
 	// Вычлиняем подпапку с часом
 	//2020.12.13_13.27.28_525935.jpeg
-	match := regexp.MustCompile(`^(\d+\.\d+\.\d+_\d+\.\d+\.\d+)_`).FindStringSubmatch(filePath)
+	match := regexp.MustCompile(`^(\d+\.\d+\.\d+_\d+\.\d+\.\d+)_`).FindStringSubmatch(name)
 	if len(match) == 0 {
-		m.log.Warnf("некорректное имя файла изображения: %s", filePath)
-		return nil, errors.Errorf("некорректное имя файла изображения: %s", filePath)
+		m.log.Warnf("некорректное имя файла изображения: %s", name)
+		return "", errors.Errorf("некорректное имя файла изображения: %s", name)
 	}
 	t, err := time.Parse("2006.01.02_15.04.05", match[1])
 	if err != nil {
 		m.log.Warnf("время в имени файла указано некорректно: %s", match[1])
-		return nil, errors.Errorf("время в имени файла указано некорректно: %s", match[1])
+		return "", errors.Errorf("время в имени файла указано некорректно: %s", match[1])
+	}
+	return filepath.Join(m.RootTemperatureDir, t.Format("2006.01.02"), fmt.Sprintf("%02d", t.Hour()), name), nil
+}
+
+// TempImage получает изображение из файловой БД по его полному поути
+func (m Db) TempImage(filePath string) ([]byte, error) {
+	fileName, err := m.tempImagePath(filePath)
+	if err != nil {
+		return nil, errors.Trace(err)
 	}
-	fileName := filepath.Join(m.RootTemperatureDir, t.Format("2006.01.02"), fmt.Sprintf("%02d", t.Hour()), filePath)
 	if _, err := os.Stat(fileName); err != nil {
 		if os.IsNotExist(err) {
 			m.log.Warnf("не найден указанный файл: %s", fileName)
@@ -213,7 +650,7 @@ func (m Db) TempImage(filePath string) ([]byte, error) {
 		m.log.Errorf("ошибка чтения файла: %s", fileName)
 		return nil, errors.Annotatef(err, "ошибка чтения %s", fileName)
 	}
-	content, err := ioutil.ReadFile(fileName)
+	content, err := m.readImage(fileName)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -229,7 +666,7 @@ func (m Db) SetTempImage(create time.Time, wigand model.Wigand, content []byte)
 	// Создаём директорию, если её нет
 	if _, err := os.Stat(fPath); err != nil {
 		if os.IsNotExist(err) {
-			if err = os.MkdirAll(fPath, os.ModePerm); err != nil {
+			if err = os.MkdirAll(fPath, imageDirPerm); err != nil {
 				m.log.Errorf("ошибка создания отсутсвующей директории %s: %s", fPath, err)
 				return nil, errors.Trace(err)
 			}
@@ -240,7 +677,7 @@ func (m Db) SetTempImage(create time.Time, wigand model.Wigand, content []byte)
 	}
 	// Сохраняем файл, если его ещё нет
 	if _, err := os.Stat(filepath.Join(fPath, fName)); err != nil && os.IsNotExist(err) {
-		if err := ioutil.WriteFile(filepath.Join(fPath, fName), content, os.ModePerm); err != nil {
+		if err := m.writeImage(filepath.Join(fPath, fName), content, m.cipher); err != nil {
 			m.log.Errorf("ошибка сохранения файла %s: %s", filepath.Join(fPath, fName), err)
 			return nil, errors.Trace(err)
 		}
@@ -269,9 +706,12 @@ func (m Db) TemperatureLogByPerson(personID uint, duration time.Duration) ([]sto
 		}
 		// Формирование резлультата
 		result = append(result, store.TemperatureLog{
-			ID:         v.ID,
-			TermopadID: v.TermopadID,
-			CreatedAt:  &v.CreatedAt,
+			ID:          v.ID,
+			TermopadID:  v.TermopadID,
+			CreatedAt:   &v.CreatedAt,
+			Temperature: v.Temperature,
+			ImageName:   v.ImageName,
+			Invalid:     v.Invalid,
 			Person: model.Person{
 				Wigand:       model.Wigand{ID: uint(person.Wigand)},
 				Family:       person.Family,
@@ -308,9 +748,12 @@ func (m Db) TemperatureLogByTermopad(termopadID uint, duration time.Duration) ([
 		}
 		// Создаём результат
 		result = append(result, store.TemperatureLog{
-			ID:         v.ID,
-			TermopadID: v.TermopadID,
-			CreatedAt:  &v.CreatedAt,
+			ID:          v.ID,
+			TermopadID:  v.TermopadID,
+			CreatedAt:   &v.CreatedAt,
+			Temperature: v.Temperature,
+			ImageName:   v.ImageName,
+			Invalid:     v.Invalid,
 			Person: model.Person{
 				Wigand:       model.Wigand{ID: uint(person.Wigand)},
 				Family:       person.Family,
@@ -325,21 +768,183 @@ func (m Db) TemperatureLogByTermopad(termopadID uint, duration time.Duration) ([
 	return result, nil
 }
 
-// SetTemperatureLog сохраняет основные данные о температуре и термопаде с именем imageName в лог базы данных
-func (m Db) SetTemperatureLog(termopadID uint, wigandID uint, temperature float64, imageName string) error {
+// TemperatureLogByPeriod получение лога температур всех термопадов за период [from, to)
+func (m Db) TemperatureLogByPeriod(from time.Time, to time.Time) ([]store.TemperatureLog, error) {
+	temps := make([]Temperature, 0)
+	if err := m.db.Where("created_at >= ? AND created_at < ?", from, to).Order("created_at").Find(&temps).Error; err != nil {
+		m.log.Error(err)
+		return nil, errors.Trace(err)
+	}
+	// Справочник персон, встречающихся в логе
+	wigands := make([]int, 0)
+	for _, v := range temps {
+		wigands = append(wigands, v.PersonID)
+	}
+	persons := make(map[int]model.Person)
+	for start := 0; start < len(wigands); start += personsBatch {
+		finish := start + personsBatch
+		if finish > len(wigands) {
+			finish = len(wigands)
+		}
+		rows := make([]Person, 0)
+		if err := m.db.Where("wigand IN ?", wigands[start:finish]).Find(&rows).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, v := range rows {
+			persons[v.Wigand] = v.ToPerson()
+		}
+	}
+
+	result := make([]store.TemperatureLog, 0, len(temps))
+	for i := range temps {
+		v := temps[i]
+		person, ok := persons[v.PersonID]
+		if !ok {
+			person = model.Person{Wigand: model.NewWigand(v.PersonID)}
+		}
+		result = append(result, store.TemperatureLog{
+			ID:          v.ID,
+			TermopadID:  v.TermopadID,
+			CreatedAt:   &temps[i].CreatedAt,
+			Person:      person,
+			Temperature: v.Temperature,
+			ImageName:   v.ImageName,
+			Invalid:     v.Invalid,
+		})
+	}
+	return result, nil
+}
+
+// Contacts возвращает персон, измерявшихся на тех же термопадах в пределах window от замеров персоны wigandID
+// за период [from, to). Замеры без распознанной карты не учитываются
+func (m Db) Contacts(wigandID uint, from time.Time, to time.Time, window time.Duration, termopads []uint) ([]store.Contact, error) {
+	if wigandID == 0 {
+		return nil, errors.New("передан некорректный номер wigand=0")
+	}
+	query := m.db.Where("person_id = ? AND created_at >= ? AND created_at < ?", wigandID, from, to)
+	if len(termopads) != 0 {
+		query = query.Where("termopad_id IN ?", termopads)
+	}
+	index := make([]Temperature, 0)
+	if err := query.Order("created_at").Find(&index).Error; err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	contacts := make(map[int]*store.Contact)
+	for _, reading := range index {
+		rows := make([]Temperature, 0)
+		err := m.db.Where("termopad_id = ? AND created_at >= ? AND created_at <= ? AND person_id NOT IN ?",
+			reading.TermopadID, reading.CreatedAt.Add(-window), reading.CreatedAt.Add(window), []int{0, int(wigandID)}).
+			Find(&rows).Error
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// Несколько замеров контакта в окне одного замера считаются одним пересечением
+		counted := make(map[int]bool)
+		for _, v := range rows {
+			contact, ok := contacts[v.PersonID]
+			if !ok {
+				contact = &store.Contact{
+					Person:    model.Person{Wigand: model.NewWigand(v.PersonID)},
+					Termopads: make([]uint, 0),
+					FirstAt:   v.CreatedAt,
+					LastAt:    v.CreatedAt,
+				}
+				contacts[v.PersonID] = contact
+			}
+			if !counted[v.PersonID] {
+				counted[v.PersonID] = true
+				contact.Overlaps++
+			}
+			if !containsUint(contact.Termopads, uint(v.TermopadID)) {
+				contact.Termopads = append(contact.Termopads, uint(v.TermopadID))
+			}
+			if v.CreatedAt.Before(contact.FirstAt) {
+				contact.FirstAt = v.CreatedAt
+			}
+			if v.CreatedAt.After(contact.LastAt) {
+				contact.LastAt = v.CreatedAt
+			}
+		}
+	}
+
+	// Данные персон из справочника
+	wigands := make([]int, 0, len(contacts))
+	for k := range contacts {
+		wigands = append(wigands, k)
+	}
+	for start := 0; start < len(wigands); start += personsBatch {
+		finish := start + personsBatch
+		if finish > len(wigands) {
+			finish = len(wigands)
+		}
+		rows := make([]Person, 0)
+		if err := m.db.Where("wigand IN ?", wigands[start:finish]).Find(&rows).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, v := range rows {
+			contacts[v.Wigand].Person = v.ToPerson()
+		}
+	}
+
+	result := make([]store.Contact, 0, len(contacts))
+	for _, v := range contacts {
+		sort.Slice(v.Termopads, func(i, j int) bool { return v.Termopads[i] < v.Termopads[j] })
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Overlaps != result[j].Overlaps {
+			return result[i].Overlaps > result[j].Overlaps
+		}
+		if !result[i].FirstAt.Equal(result[j].FirstAt) {
+			return result[i].FirstAt.Before(result[j].FirstAt)
+		}
+		return result[i].Person.Wigand.ID < result[j].Person.Wigand.ID
+	})
+	return result, nil
+}
+
+func containsUint(list []uint, value uint) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTemperatureLog сохраняет основные данные о температуре и термопаде с именем imageName в лог базы данных.
+// Повторная запись замера с тем же fileName с того же термопада возвращает ErrDuplicate
+func (m Db) SetTemperatureLog(termopadID uint, fileName string, wigandID uint, temperature float64, imageName string) error {
 	tempLog := Temperature{
 		PersonID:    int(wigandID),
 		TermopadID:  int(termopadID),
 		Temperature: math.Round(temperature*10) / 10,
 		ImageName:   imageName,
+		Invalid:     model.IsInvalidReading(temperature),
+	}
+	if fileName != "" {
+		tempLog.FileName = &fileName
 	}
 	if err := m.db.Create(&tempLog).Error; err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrDuplicate
+		}
 		m.log.Error(err)
 		return errors.Trace(err)
 	}
 	return nil
 }
 
+// HasTemperatureLog проверяет, сохранён ли в логе замер с именем файла fileName с термопада termopadID
+func (m Db) HasTemperatureLog(termopadID uint, fileName string) (bool, error) {
+	var count int64
+	if err := m.db.Model(&Temperature{}).Where("termopad_id = ? AND file_name = ?", termopadID, fileName).Count(&count).Error; err != nil {
+		return false, errors.Trace(err)
+	}
+	return count != 0, nil
+}
+
 // LastPerson возвращает описание последней замерившейся персоны и её температуры на термопаде.
 // Если запись не найдена или не найдена персона для этой записи, возвращается ошибка, проверяемая Db.IsNotFound
 func (m Db) LastPerson(termopadID uint) (*store.LastPerson, error) {
@@ -369,6 +974,7 @@ func (m Db) LastPerson(termopadID uint) (*store.LastPerson, error) {
 			Temperature: temperature.Temperature,
 			ImagePath:   temperature.ImageName,
 			Image:       nil,
+			Invalid:     temperature.Invalid,
 		},
 		Person: model.Person{
 			CreateAt:     &person.CreatedAt,
@@ -415,20 +1021,7 @@ func (m Db) PersonLog(wigandID uint, days uint, offsetDays uint, compact bool) (
 	for _, v := range rows {
 
 		// Получение инфромации о термопаде
-		var termopad *model.TermopadInfo
-		for _, t := range m.globalConfig.Termopad.Info {
-			if t.ID == uint(v.TermopadID) {
-				termopad = &model.TermopadInfo{
-					ID:           uint(v.TermopadID),
-					URL:          t.Address,
-					SudosID:      t.Cabina,
-					Name:         t.Name,
-					SerialNumber: 0,
-					Description:  t.Description,
-				}
-				break
-			}
-		}
+		termopad := m.termopadInfo(uint(v.TermopadID))
 		if termopad == nil {
 			m.log.Warnf("термопад с ID:%v не описан в конфигурации", v.TermopadID)
 			return nil, errors.Errorf("термопад с ID:%v не описан в конфигурации", v.TermopadID)
@@ -440,6 +1033,7 @@ func (m Db) PersonLog(wigandID uint, days uint, offsetDays uint, compact bool) (
 			Temperature:    math.Round(v.Temperature*10) / 10,
 			TemperatureMax: 0,
 			TemperatureMin: 0,
+			Invalid:        v.Invalid,
 			Image:          v.ImageName,
 			Person:         *person,
 			Termopad:       *termopad,
@@ -458,13 +1052,25 @@ func (m Db) PersonLog(wigandID uint, days uint, offsetDays uint, compact bool) (
 // каждому замеру температуры. Если compact=true - данные замеров сжимаются до дней и температура показыватся
 // только минимальная и максимальная для каждого дня
 func (m Db) TermopadLog(termopadID uint, days uint, offsetDays uint, compact bool) ([]model.TemperatureMetric, error) {
+	startDays, finishDays := m.calculateDate(days, offsetDays)
+	return m.termopadLog(termopadID, "created_at > ? AND created_at < ?", startDays, finishDays, compact)
+}
+
+// TermopadPeriodLog возвращает значения температур для термопада с termopadID за период [from, to).
+// Если compact=true - замеры сжимаются до дней, как в TermopadLog
+func (m Db) TermopadPeriodLog(termopadID uint, from time.Time, to time.Time, compact bool) ([]model.TemperatureMetric, error) {
+	return m.termopadLog(termopadID, "created_at >= ? AND created_at < ?", from, to, compact)
+}
+
+// Лог температур термопада termopadID за период, заданный условием period на created_at с границами from и to.
+// Для персон, не найденных в справочнике (в т.ч. неизвестных карт), заполняется только виганд
+func (m Db) termopadLog(termopadID uint, period string, from time.Time, to time.Time, compact bool) ([]model.TemperatureMetric, error) {
 	if termopadID == 0 {
 		m.log.Warn("передан некорректный идентификатор термопада termopadID=0")
 		return nil, errors.New("передан некорректный идентификатор термопада termopadID=0")
 	}
-	startDays, finishDays := m.calculateDate(days, offsetDays)
 	rows := make([]Temperature, 0)
-	if err := m.db.Where("termopad_id = ? AND created_at > ? AND created_at < ?", termopadID, startDays, finishDays).Find(&rows).Error; err != nil {
+	if err := m.db.Where("termopad_id = ? AND "+period, termopadID, from, to).Order("created_at").Find(&rows).Error; err != nil {
 		if m.IsNotFound(err) {
 			return make([]model.TemperatureMetric, 0), nil
 		}
@@ -472,57 +1078,42 @@ func (m Db) TermopadLog(termopadID uint, days uint, offsetDays uint, compact boo
 		return nil, errors.Trace(err)
 	}
 
+	// Получение инфромации о термопаде
+	termopad := m.termopadInfo(termopadID)
+	if termopad == nil && len(rows) != 0 {
+		m.log.Warnf("термопад с ID:%v не описан в конфигурации", termopadID)
+		return nil, errors.Errorf("термопад с ID:%v не описан в конфигурации", termopadID)
+	}
+
 	// Подготовка резльтата (пока развёрнутного, для compact=false)
 	result := make([]model.TemperatureMetric, 0)
 	for _, v := range rows {
 
 		// Получаем информацию о персоне из кэша или БД
+		person := model.Person{Wigand: model.NewWigand(v.PersonID)}
 		wigandKey := strconv.Itoa(v.PersonID)
 		var personDb Person
 		if p, found := m.personCache.Get(wigandKey); found {
 			personDb = p.(Person)
-		} else {
-			if err := m.db.Where("wigand = ?", v.PersonID).Take(&personDb).Error; err != nil {
-				if m.IsNotFound(err) {
-					m.log.Warnf("персоны с ID:%v в БД не найдена", v.PersonID)
-					return nil, errors.Errorf("персоны с ID:%v в БД не найдена", v.PersonID)
-				}
-				m.log.Warn(err)
-				return nil, errors.Trace(err)
-			}
+		} else if err := m.db.Where("wigand = ?", v.PersonID).Take(&personDb).Error; err == nil {
 			m.personCache.Set(wigandKey, personDb, cache.DefaultExpiration)
+		} else if !m.IsNotFound(err) {
+			m.log.Warn(err)
+			return nil, errors.Trace(err)
 		}
-		person := model.Person{
-			CreateAt:     &personDb.CreatedAt,
-			UpdateAt:     &personDb.UpdatedAt,
-			Wigand:       model.NewWigand(v.PersonID),
-			Family:       personDb.Family,
-			Name:         personDb.Name,
-			MiddleName:   personDb.MiddleName,
-			Organization: personDb.Organization,
-			Department:   personDb.Department,
-			Position:     personDb.Position,
-		}
-
-		// Получение инфромации о термопаде
-		var termopad *model.TermopadInfo
-		for _, t := range m.globalConfig.Termopad.Info {
-			if t.ID == termopadID {
-				termopad = &model.TermopadInfo{
-					ID:           termopadID,
-					URL:          t.Address,
-					SudosID:      t.Cabina,
-					Name:         t.Name,
-					SerialNumber: 0,
-					Description:  t.Description,
-				}
-				break
+		if personDb.ID != 0 {
+			person = model.Person{
+				CreateAt:     &personDb.CreatedAt,
+				UpdateAt:     &personDb.UpdatedAt,
+				Wigand:       model.NewWigand(v.PersonID),
+				Family:       personDb.Family,
+				Name:         personDb.Name,
+				MiddleName:   personDb.MiddleName,
+				Organization: personDb.Organization,
+				Department:   personDb.Department,
+				Position:     personDb.Position,
 			}
 		}
-		if termopad == nil {
-			m.log.Warnf("термопад с ID:%v не описан в конфигурации", termopadID)
-			return nil, errors.Errorf("термопад с ID:%v не описан в конфигурации", termopadID)
-		}
 
 		// Формирование результата
 		result = append(result, model.TemperatureMetric{
@@ -530,6 +1121,7 @@ func (m Db) TermopadLog(termopadID uint, days uint, offsetDays uint, compact boo
 			Temperature:    math.Round(v.Temperature*10) / 10,
 			TemperatureMax: 0,
 			TemperatureMin: 0,
+			Invalid:        v.Invalid,
 			Image:          v.ImageName,
 			Person:         person,
 			Termopad:       *termopad,
@@ -544,12 +1136,133 @@ func (m Db) TermopadLog(termopadID uint, days uint, offsetDays uint, compact boo
 	return result, nil
 }
 
-// Сжатие лога температуры до однодневного лога с указанием максимальной и минимальной температуры
+// SetTermopadStatus отмечает доступность термопада termopadID в момент at. Потеря связи открывает период
+// недоступности, восстановление закрывает его. Повторные отметки того же состояния ничего не меняют
+func (m Db) SetTermopadStatus(termopadID uint, online bool, at time.Time) error {
+	var open TermopadDowntime
+	err := m.db.Where("termopad_id = ? AND finish_at IS NULL", termopadID).Take(&open).Error
+	if err != nil && !m.IsNotFound(err) {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	found := err == nil
+	switch {
+	case online && found:
+		err = m.db.Model(&open).Update("finish_at", at).Error
+	case !online && !found:
+		err = m.db.Create(&TermopadDowntime{
+			GormModelUnscoped: GormModelUnscoped{CreatedAt: at},
+			TermopadID:        int(termopadID),
+		}).Error
+	}
+	if err != nil {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// TermopadDowntime возвращает суммарное время недоступности термопада termopadID в пределах периода
+// [from, to). Незакрытый период недоступности считается продолжающимся до текущего момента
+func (m Db) TermopadDowntime(termopadID uint, from time.Time, to time.Time) (time.Duration, error) {
+	rows := make([]TermopadDowntime, 0)
+	if err := m.db.Where("termopad_id = ? AND created_at < ? AND (finish_at IS NULL OR finish_at > ?)", termopadID, to, from).
+		Find(&rows).Error; err != nil {
+		m.log.Warn(err)
+		return 0, errors.Trace(err)
+	}
+	now := time.Now()
+	var result time.Duration
+	for _, v := range rows {
+		start, finish := v.CreatedAt, now
+		if v.FinishAt != nil {
+			finish = *v.FinishAt
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if finish.After(to) {
+			finish = to
+		}
+		if finish.After(start) {
+			result += finish.Sub(start)
+		}
+	}
+	return result, nil
+}
+
+// Report возвращает сохранённый отчёт за сутки date по смене shift. Отсутствие отчёта проверяется
+// через Db.IsNotFound
+func (m Db) Report(date time.Time, shift string) (*model.Report, error) {
+	var row Report
+	if err := m.db.Where("date = ? AND shift = ?", tool.RoundToDate(date), shift).Take(&row).Error; err != nil {
+		if m.IsNotFound(err) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		m.log.Warn(err)
+		return nil, errors.Trace(err)
+	}
+	var result model.Report
+	if err := json.Unmarshal([]byte(row.Content), &result); err != nil {
+		return nil, errors.Annotatef(err, "повреждён сохранённый отчёт за %s", row.Date.Format(tool.DateLayout))
+	}
+	return &result, nil
+}
+
+// SetReport сохраняет отчёт, заменяя ранее сохранённый за те же сутки и смену
+func (m Db) SetReport(report model.Report) error {
+	content, err := json.Marshal(report)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	date := tool.RoundToDate(report.Date)
+	var row Report
+	if err := m.db.Where("date = ? AND shift = ?", date, report.Shift).Take(&row).Error; err != nil && !m.IsNotFound(err) {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	row.Date = date
+	row.Shift = report.Shift
+	row.Content = string(content)
+	if err := m.db.Save(&row).Error; err != nil {
+		m.log.Warn(err)
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// SetTermopads изменяет список описаний термопадов, используемых в логах температуры
+func (m Db) SetTermopads(termopads []model.TermopadInfo) {
+	m.termopadsMu.Lock()
+	defer m.termopadsMu.Unlock()
+	for k := range m.termopads {
+		delete(m.termopads, k)
+	}
+	for _, t := range termopads {
+		m.termopads[t.ID] = t
+	}
+}
+
+// Описание термопада с идентификатором id или nil, если такой термопад не описан в конфигурации
+func (m Db) termopadInfo(id uint) *model.TermopadInfo {
+	m.termopadsMu.RLock()
+	defer m.termopadsMu.RUnlock()
+	if t, ok := m.termopads[id]; ok {
+		return &t
+	}
+	return nil
+}
+
+// Сжатие лога температуры до однодневного лога с указанием максимальной и минимальной температуры.
+// Недостоверные замеры в сжатый лог не попадают
 func (m Db) compactTemperature(temperature []model.TemperatureMetric) []model.TemperatureMetric {
 
 	// Делаем промежуточную карту для объединения температур в один день
 	cacheLoc := make(map[string]model.TemperatureMetric)
 	for _, v := range temperature {
+		if v.Invalid {
+			continue
+		}
 		// Обединяем температуры в один день
 		date := tool.RoundToDate(v.Date)
 		dateStr := date.Format("2006.01.02")
@@ -599,21 +1312,21 @@ func (m Db) calculateDate(days uint, offset uint) (startDate, finishDate time.Ti
 // PersonImage возвращает путь до изображения персоны
 func (m Db) PersonImage(wigand uint) ([]byte, error) {
 	file := filepath.Join(m.RootPersonDir, fmt.Sprintf("%d.jpeg", wigand))
-	content, err := ioutil.ReadFile(file)
+	content, err := m.readImage(file)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	return content, nil
 }
 
-// SetPersonImage сохраняет изображения персоны в БД и возвращает имя созданного файла
+// SetPersonImage сохраняет изображения персоны в БД, заменяя предыдущее
 func (m Db) SetPersonImage(wigand uint, content []byte) error {
 	fPath := m.RootPersonDir
 	fName := fmt.Sprintf("%d.jpeg", wigand)
 	// Создаём директорию, если её нет
 	if _, err := os.Stat(fPath); err != nil {
 		if os.IsNotExist(err) {
-			if err = os.MkdirAll(fPath, os.ModePerm); err != nil {
+			if err = os.MkdirAll(fPath, imageDirPerm); err != nil {
 				m.log.Errorf("ошибка создания отсутсвующей директории %s: %s", fPath, err)
 				return errors.Trace(err)
 			}
@@ -622,70 +1335,627 @@ func (m Db) SetPersonImage(wigand uint, content []byte) error {
 			return errors.Trace(err)
 		}
 	}
-	// Сохраняем файл, если его ещё нет
-	if _, err := os.Stat(filepath.Join(fPath, fName)); err != nil && os.IsNotExist(err) {
-		if err := ioutil.WriteFile(filepath.Join(fPath, fName), content, os.ModePerm); err != nil {
-			m.log.Errorf("ошибка сохранения файла %s: %s", filepath.Join(fPath, fName), err)
-			return errors.Trace(err)
-		}
+	// Сохраняем файл (старое изображение персоны заменяется новым)
+	if err := m.writeImage(filepath.Join(fPath, fName), content, m.cipher); err != nil {
+		m.log.Errorf("ошибка сохранения файла %s: %s", filepath.Join(fPath, fName), err)
+		return errors.Trace(err)
 	}
 	return nil
 }
 
-// Clean очищает записи в БД старше времени, указанного в duration
-// todo: в процессе создания
-func (m Db) Clean(days int) error {
-	// 1) удаляем все записи в БД, старше указанных дней
-	// 2) удаляем все директории с изображениями замеров, старше указанных дней
+// ErasePerson стирает персональные данные персоны wigandID: запись справочника, фотографию и изображения
+// замеров. Записи лога температуры и тревоги удаляются, а при pseudonymize=true сохраняются для статистики
+// под псевдонимом без ФИО и номера карты. Если о персоне нет никаких данных, возвращается ошибка,
+// проверяемая IsNotFound
+func (m Db) ErasePerson(wigandID uint, pseudonymize bool) (*model.Erasure, error) {
+	if wigandID == 0 || wigandID >= pseudonymWigandBase {
+		return nil, errors.Errorf("передан некорректный номер wigand=%d", wigandID)
+	}
+	result := model.Erasure{Wigand: wigandID, Mode: model.ErasureDelete}
+	if pseudonymize {
+		result.Mode = model.ErasurePseudonymize
+	}
 
-	m.log.Info("запуск процесса очистки старых данных архива")
+	images := make([]string, 0)
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		var person Person
+		err := tx.Where("wigand = ? AND pseudonymized = ?", wigandID, false).Take(&person).Error
+		if err != nil && !m.IsNotFound(err) {
+			return errors.Trace(err)
+		}
 
-	// Удаление записей в базе данных
+		// Изображения замеров и тревог удаляются в обоих режимах: по ним можно опознать персону
+		tempImages := make([]string, 0)
+		if err := tx.Model(&Temperature{}).Where("person_id = ? AND image_name <> ''", wigandID).
+			Pluck("image_name", &tempImages).Error; err != nil {
+			return errors.Trace(err)
+		}
+		alarmImages := make([]string, 0)
+		if err := tx.Model(&Alarm{}).Where("wigand = ? AND image_name <> ''", wigandID).
+			Pluck("image_name", &alarmImages).Error; err != nil {
+			return errors.Trace(err)
+		}
+		images = append(tempImages, alarmImages...)
 
-	lastDate, _ := m.calculateDate(uint(days), 0)
-	rows := make([]Temperature, 0)
-	if err := m.db.Where("created_at > ?", lastDate).Find(&rows).Error; err != nil {
-		if m.IsNotFound(err) {
-			m.log.Info("записей в архиве для удаления нет")
+		if !pseudonymize {
+			res := tx.Where("person_id = ?", wigandID).Delete(&Temperature{})
+			if res.Error != nil {
+				return errors.Trace(res.Error)
+			}
+			result.Temperatures = res.RowsAffected
+			if res = tx.Where("wigand = ?", wigandID).Delete(&Alarm{}); res.Error != nil {
+				return errors.Trace(res.Error)
+			}
+			result.Alarms = res.RowsAffected
+			if person.ID != 0 {
+				if err := tx.Delete(&person).Error; err != nil {
+					return errors.Trace(err)
+				}
+			} else if result.Temperatures == 0 && result.Alarms == 0 {
+				return gorm.ErrRecordNotFound
+			}
 			return nil
 		}
+
+		// Псевдоним: запись справочника сохраняет только организацию и подразделение. Номер карты
+		// встречается также в именах файлов замеров, поэтому они очищаются
+		created := person.ID == 0
+		if created {
+			person = Person{Pseudonymized: true}
+			if err := tx.Create(&person).Error; err != nil {
+				return errors.Trace(err)
+			}
+		}
+		pseudonym := pseudonymWigandBase + person.ID
+		err = tx.Model(&person).Updates(map[string]interface{}{"wigand": pseudonym, "family": "", "name": "",
+			"middle_name": "", "position": "", "manual": false, "pseudonymized": true}).Error
+		if err != nil {
+			return errors.Trace(err)
+		}
+		res := tx.Model(&Temperature{}).Where("person_id = ?", wigandID).
+			Updates(map[string]interface{}{"person_id": pseudonym, "image_name": "", "file_name": nil})
+		if res.Error != nil {
+			return errors.Trace(res.Error)
+		}
+		result.Temperatures = res.RowsAffected
+		res = tx.Model(&Alarm{}).Where("wigand = ?", wigandID).
+			Updates(map[string]interface{}{"wigand": pseudonym, "family": "", "name": "", "middle_name": "", "image_name": ""})
+		if res.Error != nil {
+			return errors.Trace(res.Error)
+		}
+		result.Alarms = res.RowsAffected
+		if created && result.Temperatures == 0 && result.Alarms == 0 {
+			// Данных о персоне не было: созданный псевдоним не нужен
+			return gorm.ErrRecordNotFound
+		}
+		result.Pseudonym = uint(pseudonym)
+		return nil
+	})
+	if err != nil {
+		if m.IsNotFound(err) {
+			return nil, gorm.ErrRecordNotFound
+		}
 		m.log.Warn(err)
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
+	m.personCache.Delete(strconv.Itoa(int(wigandID)))
 
-	//pp.Println(len(rows))
+	// Файлы удаляются после фиксации изменений в БД: повторное стирание удалит оставшиеся
+	files := []string{filepath.Join(m.RootPersonDir, fmt.Sprintf("%d.jpeg", wigandID))}
+	unique := make(map[string]bool)
+	for _, v := range images {
+		if unique[v] {
+			continue
+		}
+		unique[v] = true
+		file, err := m.tempImagePath(v)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	for _, v := range files {
+		if err := os.Remove(v); err != nil {
+			if !os.IsNotExist(err) {
+				m.log.Warnf("ошибка удаления изображения %s: %v", v, err)
+			}
+			continue
+		}
+		result.Images++
+	}
 
-	// Удаляем записи архивов
+	m.log.Infof("стёрты данные персоны wigand=%d (%s): замеров %d, тревог %d, изображений %d",
+		wigandID, result.Mode, result.Temperatures, result.Alarms, result.Images)
+	return &result, nil
+}
 
-	dir, err := os.Open(m.RootTemperatureDir)
+// InactivePersons возвращает номера вигандов персон справочника, внесённых раньше before и не проходивших
+// замеров начиная с before. Псевдонимы стёртых персон не возвращаются
+func (m Db) InactivePersons(before time.Time) ([]uint, error) {
+	rows := make([]int, 0)
+	err := m.db.Model(&Person{}).
+		Where("pseudonymized = ? AND created_at < ?", false, before).
+		Where("NOT EXISTS (SELECT 1 FROM temperature_log WHERE temperature_log.person_id = persons.wigand "+
+			"AND temperature_log.created_at >= ?)", before).
+		Order("wigand").Pluck("wigand", &rows).Error
 	if err != nil {
 		m.log.Warn(err)
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+	result := make([]uint, 0, len(rows))
+	for _, v := range rows {
+		result = append(result, uint(v))
+	}
+	return result, nil
+}
+
+// Clean очищает записи лога температуры и директории изображений замеров старше days дней
+func (m Db) Clean(days int, dryRun bool) (*store.CleanResult, error) {
+	m.log.Infof("запуск процесса очистки данных архива старше %d дней (dryRun=%t)", days, dryRun)
+
+	lastDate, _ := m.calculateDate(uint(days), 0)
+	result := store.CleanResult{ImageDirs: make([]string, 0)}
+
+	// Удаление записей в базе данных
+	query := m.db.Where("created_at < ?", lastDate)
+	if dryRun {
+		if err := query.Model(&Temperature{}).Count(&result.Temperatures).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else {
+		res := query.Delete(&Temperature{})
+		if res.Error != nil {
+			return nil, errors.Trace(res.Error)
+		}
+		result.Temperatures = res.RowsAffected
 	}
-	defer func() { _ = dir.Close() }()
 
-	fileInfos, err := dir.Readdir(-1)
+	// Журнал доставки событий вебхукам хранится столько же, сколько лог замеров. Недоставленные
+	// события не удаляются - они разбираются вручную
+	query = m.db.Where("created_at < ?", lastDate)
+	if dryRun {
+		if err := query.Model(&WebhookDelivery{}).Count(&result.WebhookDeliveries).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else {
+		res := query.Delete(&WebhookDelivery{})
+		if res.Error != nil {
+			return nil, errors.Trace(res.Error)
+		}
+		result.WebhookDeliveries = res.RowsAffected
+	}
+
+	// Тревоги ссылаются на изображения замеров и удаляются вместе с ними
+	query = m.db.Where("created_at < ?", lastDate)
+	if dryRun {
+		if err := query.Model(&Alarm{}).Count(&result.Alarms).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else {
+		res := query.Delete(&Alarm{})
+		if res.Error != nil {
+			return nil, errors.Trace(res.Error)
+		}
+		result.Alarms = res.RowsAffected
+	}
+
+	// Удаление директорий с изображениями замеров за дни, целиком попадающие в период очистки
+	fileInfos, err := ioutil.ReadDir(m.RootTemperatureDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
+	}
+	lastDay := time.Date(lastDate.Year(), lastDate.Month(), lastDate.Day(), 0, 0, 0, 0, time.Local)
+	for _, fi := range fileInfos {
+		if !fi.IsDir() {
+			continue
+		}
+		dirDate, err := time.ParseInLocation("2006.01.02", fi.Name(), time.Local)
+		if err != nil || !dirDate.Before(lastDay) {
+			continue
+		}
+		dir := filepath.Join(m.RootTemperatureDir, fi.Name())
+		if !dryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				return nil, errors.Annotatef(err, "ошибка удаления директории %s", dir)
+			}
+		}
+		result.ImageDirs = append(result.ImageDirs, dir)
+	}
+
+	m.log.Infof("очистка архива: записей %d, записей журнала вебхуков %d, тревог %d, директорий изображений %d",
+		result.Temperatures, result.WebhookDeliveries, result.Alarms, len(result.ImageDirs))
+	return &result, nil
+}
+
+// ReprocessImages проверяет файлы изображений замеров и персон. Изображения замеров, лежащие не в директории
+// своего дня и часа (например, после ручного восстановления), перемещаются на место
+func (m Db) ReprocessImages(dryRun bool) (*store.ReprocessResult, error) {
+	result := store.ReprocessResult{
+		Moved:   make([]string, 0),
+		Invalid: make([]string, 0),
+	}
+	reTemp := regexp.MustCompile(`^(\d+\.\d+\.\d+_\d+\.\d+\.\d+)_\d+\.jpeg$`)
+	reTempDir := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+	// Изображения замеров
+	err := filepath.Walk(m.RootTemperatureDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == m.RootTemperatureDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		result.Checked++
+		if !m.isJPEG(path) {
+			result.Invalid = append(result.Invalid, path)
+			return nil
+		}
+		match := reTemp.FindStringSubmatch(info.Name())
+		if len(match) == 0 {
+			result.Invalid = append(result.Invalid, path)
+			return nil
+		}
+		t, err := time.Parse("2006.01.02_15.04.05", match[1])
+		if err != nil {
+			result.Invalid = append(result.Invalid, path)
+			return nil
+		}
+		dir := filepath.Join(m.RootTemperatureDir, t.Format("2006.01.02"), fmt.Sprintf("%02d", t.Hour()))
+		if filepath.Dir(path) == dir {
+			return nil
+		}
+		if !dryRun {
+			if err := os.MkdirAll(dir, imageDirPerm); err != nil {
+				return errors.Trace(err)
+			}
+			if err := os.Rename(path, filepath.Join(dir, info.Name())); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		result.Moved = append(result.Moved, path)
+		return nil
+	})
 	if err != nil {
-		m.log.Warn(err)
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+	// Удаляем опустевшие после перемещения директории часов
+	if !dryRun && len(result.Moved) != 0 {
+		days, _ := ioutil.ReadDir(m.RootTemperatureDir)
+		for _, day := range days {
+			if !day.IsDir() || !reTempDir.MatchString(day.Name()) {
+				continue
+			}
+			hours, _ := ioutil.ReadDir(filepath.Join(m.RootTemperatureDir, day.Name()))
+			for _, hour := range hours {
+				// Непустые директории os.Remove не удаляет
+				_ = os.Remove(filepath.Join(m.RootTemperatureDir, day.Name(), hour.Name()))
+			}
+		}
+	}
+
+	// Изображения персон
+	rePerson := regexp.MustCompile(`^\d+\.jpeg$`)
+	fileInfos, err := ioutil.ReadDir(m.RootPersonDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Trace(err)
 	}
-	deleteDirs := make([]string, 0)
 	for _, fi := range fileInfos {
-		re := regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
-		match := re.FindStringSubmatch(fi.Name())
-		if len(match) > 0 {
-			year, _ := strconv.Atoi(match[1])
-			month, _ := strconv.Atoi(match[2])
-			day, _ := strconv.Atoi(match[3])
-			dirDate := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
-			if lastDate.After(dirDate) {
-				deleteDirs = append(deleteDirs, fi.Name())
+		if fi.IsDir() {
+			continue
+		}
+		path := filepath.Join(m.RootPersonDir, fi.Name())
+		result.Checked++
+		if !rePerson.MatchString(fi.Name()) || !m.isJPEG(path) {
+			result.Invalid = append(result.Invalid, path)
+		}
+	}
+
+	m.log.Infof("проверено изображений %d, перемещено %d, некорректных %d", result.Checked, len(result.Moved), len(result.Invalid))
+	return &result, nil
+}
+
+// Проверка, что файл является изображением JPEG (зашифрованный файл проверяется после расшифровки)
+func (m Db) isJPEG(path string) bool {
+	content, err := m.readImage(path)
+	return err == nil && mimetype.Detect(content).Is("image/jpeg")
+}
+
+// RekeyImages перешифровывает все файлы изображений замеров и персон ключом newKey (nil - расшифровывает).
+// Файлы читаются с текущим ключом из конфигурации, открытые файлы шифруются. Файлы, уже зашифрованные newKey
+// (после прерванного перешифрования), пропускаются. Права доступа к файлам и директориям ограничиваются
+// владельцем. Ключ самого экземпляра Db не меняется
+func (m Db) RekeyImages(newKey []byte) (*store.RekeyResult, error) {
+	var newCipher *imagecrypt.Cipher
+	if newKey != nil {
+		var err error
+		if newCipher, err = imagecrypt.NewCipher(newKey); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	result := store.RekeyResult{
+		Failed: make([]string, 0),
+	}
+	for _, root := range []string{m.RootTemperatureDir, m.RootPersonDir} {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == root {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if info.IsDir() {
+				return errors.Trace(os.Chmod(path, imageDirPerm))
 			}
+			// Остатки прерванной записи
+			if strings.HasSuffix(path, imageTempSuffix) {
+				return nil
+			}
+			result.Checked++
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			plain, err := m.decryptImage(path, content)
+			if err != nil {
+				if newCipher != nil && imagecrypt.IsEncrypted(content) {
+					if _, err := newCipher.Open(content); err == nil {
+						result.Skipped++
+						return errors.Trace(os.Chmod(path, imageFilePerm))
+					}
+				}
+				m.log.Warnf("ошибка расшифровки изображения: %v", err)
+				result.Failed = append(result.Failed, path)
+				return nil
+			}
+			if err := m.writeImage(path, plain, newCipher); err != nil {
+				return errors.Annotatef(err, "ошибка записи %s", path)
+			}
+			result.Rekeyed++
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
 		}
 	}
+	m.log.Infof("перешифровано изображений %d из %d, пропущено %d, ошибок %d",
+		result.Rekeyed, result.Checked, result.Skipped, len(result.Failed))
+	return &result, nil
+}
 
-	//pp.Println(days, lastDate)
-	//pp.Println(deleteDirs)
+// Reconcile сверяет изображения замеров с логом температуры. Изображение без записи в логе остаётся, если запись
+// замера не удалось сохранить; запись без изображения - если файл удалён или утерян. Учитываются только
+// изображения и записи, появившиеся раньше before, чтобы не задеть замеры, обрабатываемые в этот момент.
+// Изображения, лежащие не в директории своего дня и часа, раскладываются командой reprocess-images
+func (m Db) Reconcile(before time.Time, options store.ReconcileOptions) (*store.ReconcileResult, error) {
+	if options.Recreate && options.Termopad != 0 && m.termopadInfo(options.Termopad) == nil {
+		return nil, errors.Errorf("термопад %d не описан в конфигурации", options.Termopad)
+	}
+	result := store.ReconcileResult{
+		OrphanImages:       make([]string, 0),
+		OrphanTemperatures: make([]store.TemperatureLog, 0),
+		Recreated:          make([]string, 0),
+		Erased:             make([]string, 0),
+		RemovedImages:      make([]string, 0),
+	}
 
+	// Изображения замеров по именам
+	reTemp := regexp.MustCompile(`^(\d+\.\d+\.\d+_\d+\.\d+\.\d+)_(\d+)\.jpeg$`)
+	images := make(map[string]string)
+	err := filepath.Walk(m.RootTemperatureDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == m.RootTemperatureDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || !reTemp.MatchString(info.Name()) {
+			return nil
+		}
+		result.Images++
+		images[info.Name()] = path
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// Записи лога с изображениями (у псевдонимов стёртых персон изображений нет)
+	rows := make([]Temperature, 0)
+	if err := m.db.Where("image_name <> ''").Find(&rows).Error; err != nil {
+		return nil, errors.Trace(err)
+	}
+	result.Temperatures = len(rows)
+	referenced := make(map[string]bool, len(rows))
+	orphanRows := make([]int, 0)
+	for _, v := range rows {
+		referenced[v.ImageName] = true
+		if _, ok := images[v.ImageName]; ok || !v.CreatedAt.Before(before) {
+			continue
+		}
+		createdAt := v.CreatedAt
+		result.OrphanTemperatures = append(result.OrphanTemperatures, store.TemperatureLog{
+			ID:          v.ID,
+			TermopadID:  v.TermopadID,
+			CreatedAt:   &createdAt,
+			Person:      model.Person{Wigand: model.NewWigand(v.PersonID)},
+			Temperature: v.Temperature,
+			ImageName:   v.ImageName,
+			Invalid:     v.Invalid,
+		})
+		orphanRows = append(orphanRows, v.ID)
+	}
+
+	// Изображения без записей: время замера и виганд берутся из имени файла
+	type orphanImage struct {
+		name   string
+		at     time.Time
+		wigand int
+	}
+	orphans := make([]orphanImage, 0)
+	for name, path := range images {
+		if referenced[name] {
+			continue
+		}
+		match := reTemp.FindStringSubmatch(name)
+		at, err := time.ParseInLocation("2006.01.02_15.04.05", match[1], time.Local)
+		if err != nil || !at.Before(before) {
+			continue
+		}
+		wigand, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		orphans = append(orphans, orphanImage{name: name, at: at, wigand: wigand})
+		result.OrphanImages = append(result.OrphanImages, path)
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].name < orphans[j].name })
+	sort.Strings(result.OrphanImages)
+
+	recreated := make(map[string]bool)
+	if options.Recreate && len(orphans) != 0 {
+		// Тревоги с изображениями без записей хранят их термопад и температуру
+		names := make([]string, 0, len(orphans))
+		for _, v := range orphans {
+			names = append(names, v.name)
+		}
+		alarms := make(map[string]Alarm)
+		for start := 0; start < len(names); start += reconcileBatch {
+			finish := start + reconcileBatch
+			if finish > len(names) {
+				finish = len(names)
+			}
+			rows := make([]Alarm, 0)
+			if err := m.db.Where("image_name IN ?", names[start:finish]).Find(&rows).Error; err != nil {
+				return nil, errors.Trace(err)
+			}
+			for _, v := range rows {
+				alarms[v.ImageName] = v
+			}
+		}
+		// Изображения, оставшиеся после стирания персоны, не должны возвращать её данные в лог
+		erasures := make([]AuditLog, 0)
+		if err := m.db.Where("action = ?", model.AuditErasePerson).Find(&erasures).Error; err != nil {
+			return nil, errors.Trace(err)
+		}
+		erased := make(map[string]time.Time)
+		for _, v := range erasures {
+			if v.CreatedAt.After(erased[v.Target]) {
+				erased[v.Target] = v.CreatedAt
+			}
+		}
+
+		for _, v := range orphans {
+			path := images[v.name]
+			if at, ok := erased[strconv.Itoa(v.wigand)]; ok && !v.at.After(at) {
+				result.Erased = append(result.Erased, path)
+				continue
+			}
+			row := Temperature{
+				GormModelUnscoped: GormModelUnscoped{CreatedAt: v.at},
+				PersonID:          v.wigand,
+				ImageName:         v.name,
+			}
+			if alarm, ok := alarms[v.name]; ok {
+				row.TermopadID = alarm.TermopadID
+				row.Temperature = alarm.Temperature
+				row.Invalid = model.IsInvalidReading(alarm.Temperature)
+			} else if options.Termopad != 0 {
+				// Температура по изображению неизвестна: замер сохраняется как недостоверный
+				row.TermopadID = int(options.Termopad)
+				row.Invalid = true
+			} else {
+				continue
+			}
+			if err := m.db.Create(&row).Error; err != nil {
+				return nil, errors.Annotatef(err, "ошибка восстановления записи для %s", v.name)
+			}
+			recreated[v.name] = true
+			result.Recreated = append(result.Recreated, path)
+		}
+	}
+
+	if options.CleanImages {
+		for _, v := range orphans {
+			if recreated[v.name] {
+				continue
+			}
+			path := images[v.name]
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, errors.Annotatef(err, "ошибка удаления изображения %s", path)
+			}
+			result.RemovedImages = append(result.RemovedImages, path)
+		}
+	}
+
+	if options.CleanTemperatures {
+		for start := 0; start < len(orphanRows); start += reconcileBatch {
+			finish := start + reconcileBatch
+			if finish > len(orphanRows) {
+				finish = len(orphanRows)
+			}
+			res := m.db.Where("id IN ?", orphanRows[start:finish]).Delete(&Temperature{})
+			if res.Error != nil {
+				return nil, errors.Trace(res.Error)
+			}
+			result.RemovedTemperatures += res.RowsAffected
+		}
+	}
+
+	m.log.Infof("сверка изображений %d и записей лога %d: изображений без записей %d, записей без изображений %d, "+
+		"восстановлено записей %d, удалено изображений %d, удалено записей %d", result.Images, result.Temperatures,
+		len(result.OrphanImages), len(result.OrphanTemperatures), len(result.Recreated), len(result.RemovedImages),
+		result.RemovedTemperatures)
+	return &result, nil
+}
+
+// Чтение файла изображения с расшифровкой
+func (m Db) readImage(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return m.decryptImage(path, content)
+}
+
+// Расшифровка содержимого файла изображения path текущим ключом. Открытые файлы (сохранённые до включения
+// шифрования) возвращаются как есть
+func (m Db) decryptImage(path string, content []byte) ([]byte, error) {
+	if !imagecrypt.IsEncrypted(content) {
+		return content, nil
+	}
+	if m.cipher == nil {
+		return nil, errors.Errorf("изображение %s зашифровано, а ключ шифрования не задан", path)
+	}
+	plain, err := m.cipher.Open(content)
+	if err != nil {
+		return nil, errors.Annotatef(err, "изображение %s", path)
+	}
+	return plain, nil
+}
+
+// Запись файла изображения, зашифрованного cipher (nil - без шифрования). Запись идёт во временный файл, который
+// затем переименовывается, чтобы прерванная запись не повредила прежнее содержимое
+func (m Db) writeImage(path string, content []byte, cipher *imagecrypt.Cipher) error {
+	if cipher != nil {
+		var err error
+		if content, err = cipher.Seal(content); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	temp := path + imageTempSuffix
+	// Права доступа применяются только к новому файлу, поэтому остатки прошлой записи удаляются
+	if err := os.Remove(temp); err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	if err := ioutil.WriteFile(temp, content, imageFilePerm); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		_ = os.Remove(temp)
+		return errors.Trace(err)
+	}
 	return nil
 }
@@ -0,0 +1,736 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kirsrus/termopad-server/model"
+	"github.com/kirsrus/termopad-server/pkg/config"
+	"github.com/kirsrus/termopad-server/pkg/imagecrypt"
+	"github.com/kirsrus/termopad-server/store"
+)
+
+func TestDb_Contacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewDb(context.Background(), &ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.(*Db)
+
+	// Персона 100 измерялась на термопаде 1 в 10:00 и 12:00 и на термопаде 2 в 11:00
+	base := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	rows := []Temperature{
+		{PersonID: 100, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: base}},
+		{PersonID: 100, TermopadID: 2, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(time.Hour)}},
+		{PersonID: 100, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(2 * time.Hour)}},
+		// Контакт у обоих замеров на термопаде 1 (дважды в окне первого)
+		{PersonID: 200, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(-5 * time.Minute)}},
+		{PersonID: 200, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(3 * time.Minute)}},
+		{PersonID: 200, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(2*time.Hour + 10*time.Minute)}},
+		// Контакт на термопаде 2
+		{PersonID: 300, TermopadID: 2, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(time.Hour - 10*time.Minute)}},
+		// Вне окна, на другом термопаде и без распознанной карты
+		{PersonID: 400, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(30 * time.Minute)}},
+		{PersonID: 500, TermopadID: 3, GormModelUnscoped: GormModelUnscoped{CreatedAt: base}},
+		{PersonID: 0, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: base}},
+	}
+	if err := db.db.Create(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.db.Create(&Person{Wigand: 200, Family: "Иванов", Name: "Иван"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		termopads     []uint
+		wantWigands   []uint
+		wantOverlaps  []uint
+		wantTermopads [][]uint
+	}{
+		{
+			name:          "все термопады",
+			wantWigands:   []uint{200, 300},
+			wantOverlaps:  []uint{2, 1},
+			wantTermopads: [][]uint{{1}, {2}},
+		},
+		{
+			name:          "только термопад 2",
+			termopads:     []uint{2},
+			wantWigands:   []uint{300},
+			wantOverlaps:  []uint{1},
+			wantTermopads: [][]uint{{2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.Contacts(100, base.Add(-time.Hour), base.Add(5*time.Hour), 15*time.Minute, tt.termopads)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wigands := make([]uint, 0)
+			overlaps := make([]uint, 0)
+			termopads := make([][]uint, 0)
+			for _, v := range got {
+				wigands = append(wigands, v.Person.Wigand.ID)
+				overlaps = append(overlaps, v.Overlaps)
+				termopads = append(termopads, v.Termopads)
+			}
+			if !reflect.DeepEqual(wigands, tt.wantWigands) {
+				t.Errorf("Contacts() персоны = %v, want %v", wigands, tt.wantWigands)
+			}
+			if !reflect.DeepEqual(overlaps, tt.wantOverlaps) {
+				t.Errorf("Contacts() пересечения = %v, want %v", overlaps, tt.wantOverlaps)
+			}
+			if !reflect.DeepEqual(termopads, tt.wantTermopads) {
+				t.Errorf("Contacts() термопады = %v, want %v", termopads, tt.wantTermopads)
+			}
+			if len(got) != 0 && got[0].Person.Wigand.ID == 200 && got[0].Person.Family != "Иванов" {
+				t.Errorf("Contacts() не заполнены данные персоны из справочника: %+v", got[0].Person)
+			}
+		})
+	}
+}
+
+func TestDb_AuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewDb(context.Background(), &ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	entries := []model.Audit{
+		{CreateAt: base, User: "ivanov", Action: model.AuditPersonLog, Target: "100", IP: "10.0.0.1"},
+		{CreateAt: base.Add(time.Hour), User: "petrov", Action: model.AuditPersonImage, Target: "100", IP: "10.0.0.2"},
+		{CreateAt: base.Add(2 * time.Hour), User: "ivanov", Action: model.AuditSetThresholds, Details: "max=37.5 min=35.0"},
+		{CreateAt: base.AddDate(0, 0, 1), User: "ivanov", Action: model.AuditPersonLog, Target: "200"},
+	}
+	for _, v := range entries {
+		if err := store.SetAudit(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		filter  model.AuditFilter
+		limit   uint
+		wantIDs []uint
+	}{
+		{
+			name:    "все записи от новых к старым",
+			limit:   10,
+			wantIDs: []uint{4, 3, 2, 1},
+		},
+		{
+			name:    "ограничение количества",
+			limit:   2,
+			wantIDs: []uint{4, 3},
+		},
+		{
+			name:    "пользователь и действие",
+			filter:  model.AuditFilter{User: "ivanov", Action: model.AuditPersonLog},
+			limit:   10,
+			wantIDs: []uint{4, 1},
+		},
+		{
+			name:    "объект за период",
+			filter:  model.AuditFilter{Target: "100", From: base.Add(time.Minute), To: base.AddDate(0, 0, 1)},
+			limit:   10,
+			wantIDs: []uint{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.AuditLog(tt.filter, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]uint, 0, len(got))
+			for _, v := range got {
+				ids = append(ids, v.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("AuditLog() = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestDb_ErasePerson(t *testing.T) {
+	base := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	tests := []struct {
+		name         string
+		wigand       uint
+		pseudonymize bool
+		wantErr      bool
+		wantTemps    int64
+		wantAlarms   int64
+		wantImages   int
+		// Записей лога, оставшихся после стирания (с псевдонимом или других персон)
+		wantLeft int64
+	}{
+		{
+			name:       "удаление",
+			wigand:     100,
+			wantTemps:  2,
+			wantAlarms: 1,
+			wantImages: 3,
+			wantLeft:   1,
+		},
+		{
+			name:         "псевдоним",
+			wigand:       100,
+			pseudonymize: true,
+			wantTemps:    2,
+			wantAlarms:   1,
+			wantImages:   3,
+			wantLeft:     3,
+		},
+		{
+			name:    "нет данных о персоне",
+			wigand:  999,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "termopad-db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			store, err := NewDb(context.Background(), &ConfigDb{
+				DbFile:       filepath.Join(dir, "test.sqlite"),
+				GlobalConfig: &config.Config{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db := store.(*Db)
+			db.RootTemperatureDir = filepath.Join(dir, "temperature")
+			db.RootPersonDir = filepath.Join(dir, "persons")
+
+			if _, _, err := db.SetPerson(model.Person{Wigand: model.NewWigand(100), Family: "Иванов", Name: "Иван",
+				Organization: "Альфа", Department: "Цех 1"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.SetPersonImage(100, []byte("photo")); err != nil {
+				t.Fatal(err)
+			}
+			for i, wigand := range []uint{100, 100, 200} {
+				at := base.Add(time.Duration(i) * time.Minute)
+				name, err := db.SetTempImage(at, model.NewWigand(int(wigand)), []byte("jpeg"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				fileName := *name
+				row := Temperature{PersonID: int(wigand), TermopadID: 1, Temperature: 36.6, ImageName: *name, FileName: &fileName,
+					GormModelUnscoped: GormModelUnscoped{CreatedAt: at}}
+				if err := db.db.Create(&row).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := db.SetAlarm(model.Alarm{CreateAt: base, Temperature: 38.1, Termopad: model.TermopadInfo{ID: 1},
+				Person: model.Person{Wigand: model.NewWigand(100), Family: "Иванов", Organization: "Альфа"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			erasure, err := db.ErasePerson(tt.wigand, tt.pseudonymize)
+			if tt.wantErr {
+				if err == nil || !db.IsNotFound(err) {
+					t.Fatalf("ErasePerson() error = %v, want не найдено", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if erasure.Temperatures != tt.wantTemps || erasure.Alarms != tt.wantAlarms || erasure.Images != tt.wantImages {
+				t.Errorf("ErasePerson() = %+v", erasure)
+			}
+			if (erasure.Pseudonym != 0) != tt.pseudonymize {
+				t.Errorf("псевдоним = %d", erasure.Pseudonym)
+			}
+
+			if _, err := db.GetPerson(tt.wigand); !db.IsNotFound(err) {
+				t.Errorf("персона после стирания: %v", err)
+			}
+			if _, err := db.PersonImage(tt.wigand); err == nil {
+				t.Error("фотография персоны не удалена")
+			}
+			var left, identified int64
+			db.db.Model(&Temperature{}).Count(&left)
+			db.db.Model(&Temperature{}).Where("person_id = ?", tt.wigand).Count(&identified)
+			if left != tt.wantLeft || identified != 0 {
+				t.Errorf("записей лога осталось %d (персоны %d), want %d", left, identified, tt.wantLeft)
+			}
+			alarms, err := db.Alarms(false, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.pseudonymize {
+				pseudonym, err := db.GetPerson(erasure.Pseudonym)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if pseudonym.Family != "" || pseudonym.Organization != "Альфа" || pseudonym.Department != "Цех 1" {
+					t.Errorf("псевдоним персоны = %+v", pseudonym)
+				}
+				if len(alarms) != 1 || alarms[0].Person.Family != "" || alarms[0].Person.Organization != "Альфа" {
+					t.Errorf("тревоги = %+v", alarms)
+				}
+				known, err := db.KnownWigands()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(known, []uint{200}) {
+					t.Errorf("KnownWigands() = %v, want [200]", known)
+				}
+			} else if len(alarms) != 0 {
+				t.Errorf("тревоги = %+v, want нет", alarms)
+			}
+		})
+	}
+}
+
+func TestDb_InactivePersons(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewDb(context.Background(), &ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.(*Db)
+
+	before := time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)
+	old := GormModelUnscoped{CreatedAt: before.AddDate(0, -6, 0)}
+	persons := []Person{
+		// Давно внесена и не измерялась
+		{Wigand: 100, GormModelUnscoped: old},
+		// Давно внесена, но измерялась после before
+		{Wigand: 200, GormModelUnscoped: old},
+		// Измерялась только до before
+		{Wigand: 300, GormModelUnscoped: old},
+		// Внесена недавно
+		{Wigand: 400, GormModelUnscoped: GormModelUnscoped{CreatedAt: before.AddDate(0, 0, 1)}},
+		// Псевдоним стёртой персоны
+		{Wigand: pseudonymWigandBase + 1, Pseudonymized: true, GormModelUnscoped: old},
+	}
+	if err := db.db.Create(&persons).Error; err != nil {
+		t.Fatal(err)
+	}
+	temps := []Temperature{
+		{PersonID: 200, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: before.AddDate(0, 0, 2)}},
+		{PersonID: 300, TermopadID: 1, GormModelUnscoped: GormModelUnscoped{CreatedAt: before.AddDate(0, 0, -2)}},
+	}
+	if err := db.db.Create(&temps).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.InactivePersons(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []uint{100, 300}) {
+		t.Errorf("InactivePersons() = %v, want [100 300]", got)
+	}
+}
+
+func TestDb_RekeyImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termopad-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Экземпляр Db с ключом из файла keyFile (пустой - без шифрования)
+	open := func(keyFile string) *Db {
+		cfg := &config.Config{}
+		cfg.Images.Path = filepath.Join(dir, "temperature")
+		cfg.Sudos.Path = filepath.Join(dir, "persons")
+		cfg.Images.Encryption.KeyFile = keyFile
+		store, err := NewDb(context.Background(), &ConfigDb{
+			DbFile:       filepath.Join(dir, "test.sqlite"),
+			GlobalConfig: cfg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.(*Db)
+	}
+	keys := make([][]byte, 2)
+	keyFiles := make([]string, 2)
+	for i := range keys {
+		keys[i] = bytes.Repeat([]byte{byte(i + 1)}, imagecrypt.KeySize)
+		keyFiles[i] = filepath.Join(dir, fmt.Sprintf("key%d", i))
+		if err := ioutil.WriteFile(keyFiles[i], []byte(hex.EncodeToString(keys[i])), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	image, photo := []byte("temperature jpeg"), []byte("person jpeg")
+	plainDb := open("")
+	name, err := plainDb.SetTempImage(time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local), model.NewWigand(100), image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plainDb.SetPersonImage(100, photo); err != nil {
+		t.Fatal(err)
+	}
+	imagePath, err := plainDb.tempImagePath(*name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photoPath := filepath.Join(plainDb.RootPersonDir, "100.jpeg")
+	for path, perm := range map[string]os.FileMode{
+		imagePath:               imageFilePerm,
+		photoPath:               imageFilePerm,
+		filepath.Dir(imagePath): imageDirPerm,
+		plainDb.RootPersonDir:   imageDirPerm,
+	} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != perm {
+			t.Errorf("права доступа %s: %v %v, want %v", path, info.Mode().Perm(), err, perm)
+		}
+	}
+
+	// Проверка содержимого файлов: зашифрованы ли они и расшифровывает ли их db
+	check := func(step string, db *Db, encrypted bool) {
+		for _, path := range []string{imagePath, photoPath} {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imagecrypt.IsEncrypted(content) != encrypted {
+				t.Errorf("%s: файл %s зашифрован %v, want %v", step, path, !encrypted, encrypted)
+			}
+		}
+		got, err := db.TempImage(*name)
+		if err != nil || !bytes.Equal(got, image) {
+			t.Errorf("%s: TempImage() = %q, %v", step, got, err)
+		}
+		got, err = db.PersonImage(100)
+		if err != nil || !bytes.Equal(got, photo) {
+			t.Errorf("%s: PersonImage() = %q, %v", step, got, err)
+		}
+	}
+	check("без шифрования", plainDb, false)
+
+	tests := []struct {
+		name string
+		// Ключ, которым открывается Db (-1 - без ключа)
+		from int
+		// Новый ключ (-1 - расшифровка)
+		to          int
+		wantRekeyed int
+		wantSkipped int
+	}{
+		{name: "шифрование открытых файлов", from: -1, to: 0, wantRekeyed: 2},
+		{name: "смена ключа", from: 0, to: 1, wantRekeyed: 2},
+		{name: "повтор после прерванной смены ключа", from: 0, to: 1, wantSkipped: 2},
+		{name: "расшифровка", from: 1, to: -1, wantRekeyed: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, newDb := plainDb, plainDb
+			var newKey []byte
+			if tt.from >= 0 {
+				db = open(keyFiles[tt.from])
+			}
+			if tt.to >= 0 {
+				newKey, newDb = keys[tt.to], open(keyFiles[tt.to])
+			}
+			got, err := db.RekeyImages(newKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Checked != 2 || got.Rekeyed != tt.wantRekeyed || got.Skipped != tt.wantSkipped || len(got.Failed) != 0 {
+				t.Errorf("RekeyImages() = %+v", got)
+			}
+			check("после перешифрования", newDb, tt.to >= 0)
+			if tt.to >= 0 {
+				if _, err := plainDb.TempImage(*name); err == nil {
+					t.Error("зашифрованное изображение прочитано без ключа")
+				}
+			}
+		})
+	}
+}
+
+func TestDb_Reconcile(t *testing.T) {
+	old := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	const (
+		withRow   = "2026.03.02_10.00.00_100.jpeg"
+		withAlarm = "2026.03.02_10.01.00_200.jpeg"
+		unknown   = "2026.03.02_10.02.00_300.jpeg"
+		erased    = "2026.03.02_10.03.00_400.jpeg"
+		lost      = "2026.03.02_10.04.00_500.jpeg"
+	)
+
+	// БД с изображениями: с записью в логе, с тревогой, без сведений о замере, стёртой персоны и только что
+	// сохранённым, а также с записью лога без изображения
+	open := func(t *testing.T) *Db {
+		dir := t.TempDir()
+		cfg := &config.Config{}
+		cfg.Images.Path = filepath.Join(dir, "temperature")
+		store, err := NewDb(context.Background(), &ConfigDb{
+			DbFile:       filepath.Join(dir, "test.sqlite"),
+			GlobalConfig: cfg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := store.(*Db)
+		db.SetTermopads([]model.TermopadInfo{{ID: 1, Name: "T1"}})
+		for i, wigand := range []int{100, 200, 300, 400} {
+			if _, err := db.SetTempImage(old.Add(time.Duration(i)*time.Minute), model.NewWigand(wigand), []byte("jpeg")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.SetTempImage(time.Now(), model.NewWigand(600), []byte("jpeg")); err != nil {
+			t.Fatal(err)
+		}
+		rows := []Temperature{
+			{PersonID: 100, TermopadID: 1, Temperature: 36.6, ImageName: withRow, GormModelUnscoped: GormModelUnscoped{CreatedAt: old}},
+			{PersonID: 500, TermopadID: 1, Temperature: 36.7, ImageName: lost, GormModelUnscoped: GormModelUnscoped{CreatedAt: old.Add(4 * time.Minute)}},
+		}
+		if err := db.db.Create(&rows).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.db.Create(&Alarm{TermopadID: 1, Wigand: 200, Temperature: 38.2, ImageName: withAlarm}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetAudit(model.Audit{CreateAt: old.Add(time.Hour), Action: model.AuditErasePerson, Target: "400"}); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	tests := []struct {
+		name                string
+		options             store.ReconcileOptions
+		wantErr             bool
+		wantRecreated       []string
+		wantErased          int
+		wantRemovedImages   int
+		wantRemovedRows     int64
+		wantOrphanImagesNow int
+		wantOrphanRowsNow   int
+	}{
+		{
+			name:                "только поиск",
+			wantOrphanImagesNow: 3,
+			wantOrphanRowsNow:   1,
+		},
+		{
+			name:                "восстановление по тревогам",
+			options:             store.ReconcileOptions{Recreate: true},
+			wantRecreated:       []string{withAlarm},
+			wantErased:          1,
+			wantOrphanImagesNow: 2,
+			wantOrphanRowsNow:   1,
+		},
+		{
+			name:                "восстановление на термопаде",
+			options:             store.ReconcileOptions{Recreate: true, Termopad: 1},
+			wantRecreated:       []string{withAlarm, unknown},
+			wantErased:          1,
+			wantOrphanImagesNow: 1,
+			wantOrphanRowsNow:   1,
+		},
+		{
+			name:              "восстановление и очистка",
+			options:           store.ReconcileOptions{Recreate: true, Termopad: 1, CleanImages: true, CleanTemperatures: true},
+			wantRecreated:     []string{withAlarm, unknown},
+			wantErased:        1,
+			wantRemovedImages: 1,
+			wantRemovedRows:   1,
+		},
+		{
+			name:    "неизвестный термопад",
+			options: store.ReconcileOptions{Recreate: true, Termopad: 9},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := open(t)
+			before := time.Now().Add(-time.Minute)
+			got, err := db.Reconcile(before, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Images != 5 || got.Temperatures != 2 || len(got.OrphanImages) != 3 || len(got.OrphanTemperatures) != 1 {
+				t.Errorf("Reconcile() = %+v", got)
+			}
+			if len(got.OrphanTemperatures) == 1 && got.OrphanTemperatures[0].ImageName != lost {
+				t.Errorf("OrphanTemperatures = %+v, want %s", got.OrphanTemperatures, lost)
+			}
+			recreated := make([]string, 0)
+			for _, v := range got.Recreated {
+				recreated = append(recreated, filepath.Base(v))
+			}
+			if len(tt.wantRecreated) == 0 {
+				tt.wantRecreated = []string{}
+			}
+			if !reflect.DeepEqual(recreated, tt.wantRecreated) {
+				t.Errorf("Recreated = %v, want %v", recreated, tt.wantRecreated)
+			}
+			if len(got.Erased) != tt.wantErased || len(got.RemovedImages) != tt.wantRemovedImages ||
+				got.RemovedTemperatures != tt.wantRemovedRows {
+				t.Errorf("Erased = %v, RemovedImages = %v, RemovedTemperatures = %d", got.Erased, got.RemovedImages,
+					got.RemovedTemperatures)
+			}
+
+			// Восстановленная по тревоге запись получает её термопад и температуру
+			if len(tt.wantRecreated) != 0 {
+				var row Temperature
+				if err := db.db.Where("image_name = ?", withAlarm).Take(&row).Error; err != nil {
+					t.Fatal(err)
+				}
+				if row.TermopadID != 1 || row.Temperature != 38.2 || row.PersonID != 200 || !row.CreatedAt.Equal(old.Add(time.Minute)) {
+					t.Errorf("восстановленная запись = %+v", row)
+				}
+			}
+
+			now, err := db.Reconcile(before, store.ReconcileOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(now.OrphanImages) != tt.wantOrphanImagesNow || len(now.OrphanTemperatures) != tt.wantOrphanRowsNow {
+				t.Errorf("после сверки: изображений без записей %d, записей без изображений %d", len(now.OrphanImages),
+					len(now.OrphanTemperatures))
+			}
+		})
+	}
+}
+
+func TestDb_LastTemperatures(t *testing.T) {
+	store, err := NewDb(context.Background(), &ConfigDb{
+		DbFile:       filepath.Join(t.TempDir(), "test.sqlite"),
+		GlobalConfig: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.(*Db)
+	base := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	rows := []Temperature{
+		{PersonID: 100, TermopadID: 1, Temperature: 36.6, GormModelUnscoped: GormModelUnscoped{CreatedAt: base}},
+		{PersonID: 200, TermopadID: 1, Temperature: 36.5, GormModelUnscoped: GormModelUnscoped{CreatedAt: base}},
+		{PersonID: 100, TermopadID: 2, Temperature: 37.9, GormModelUnscoped: GormModelUnscoped{CreatedAt: base.Add(time.Hour)}},
+		{PersonID: 300, TermopadID: 1, Temperature: 36.7, GormModelUnscoped: GormModelUnscoped{CreatedAt: base}},
+	}
+	if err := db.db.Create(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		wigands []uint
+		want    map[uint]float64
+	}{
+		{name: "последние замеры", wigands: []uint{100, 200}, want: map[uint]float64{100: 37.9, 200: 36.5}},
+		{name: "персона без замеров", wigands: []uint{400, 300}, want: map[uint]float64{300: 36.7}},
+		{name: "пустой список", wigands: []uint{}, want: map[uint]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.LastTemperatures(tt.wigands)
+			if err != nil {
+				t.Fatal(err)
+			}
+			temperatures := make(map[uint]float64, len(got))
+			for k, v := range got {
+				temperatures[k] = v.Temperature
+			}
+			if !reflect.DeepEqual(temperatures, tt.want) {
+				t.Errorf("LastTemperatures() = %v, want %v", temperatures, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDb_termopads(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "config.yaml")
+	content := `
+termopad:
+  maxtemperature: 37.7
+  mintemperature: 35.0
+  info:
+    - id: 1
+      cabina: 4
+      address: ws://127.0.0.1:11000/feed
+      name: Кабина 4
+      cardformat: H10304
+      cardframe: true
+sudos:
+  address: ws://127.0.0.1:34888
+recognize:
+  url: http://127.0.0.1:2222/msg
+`
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Images.Path = filepath.Join(dir, "temperature")
+	dbStore, err := NewDb(context.Background(), &ConfigDb{
+		DbFile:       filepath.Join(dir, "test.sqlite"),
+		GlobalConfig: cfg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := model.TermopadInfo{
+		ID:         1,
+		URL:        "ws://127.0.0.1:11000/feed",
+		SudosID:    4,
+		Name:       "Кабина 4",
+		CardFormat: "H10304",
+		CardFrame:  true,
+	}
+	if got := dbStore.(*Db).termopads[1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("термопад 1 = %+v, want %+v", got, want)
+	}
+}
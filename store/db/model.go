@@ -14,11 +14,11 @@ type (
 		UpdatedAt time.Time
 	}
 
-	// Config конфигурация программы
+	// Config конфигурация программы, изменяемая во время работы (хранится в единственной записи)
 	Config struct {
 		GormModelUnscoped
-		MaxTemperature int
-		MinTemperature int
+		MaxTemperature float64
+		MinTemperature float64
 	}
 )
 
@@ -38,6 +38,11 @@ type (
 		Organization string
 		Department   string
 		Position     string
+		// Персона внесена вручную (не из СУДОС)
+		Manual bool
+		// Псевдоним стёртой персоны: запись хранит только организацию и подразделение для статистики,
+		// Wigand - псевдоним, а не номер карты
+		Pseudonymized bool `gorm:"not null;default:false"`
 	}
 )
 
@@ -54,6 +59,7 @@ func (m *Person) Update(person Person) {
 	m.Organization = person.Organization
 	m.Department = person.Department
 	m.Position = person.Position
+	m.Manual = person.Manual
 }
 
 // ToPerson маппинг данных в структуру Person
@@ -68,6 +74,7 @@ func (m Person) ToPerson() model.Person {
 		Organization: m.Organization,
 		Department:   m.Department,
 		Position:     m.Position,
+		Manual:       m.Manual,
 	}
 	return person
 }
@@ -82,6 +89,7 @@ func (m *Person) FromPerson(person model.Person) {
 		Organization: person.Organization,
 		Department:   person.Department,
 		Position:     person.Position,
+		Manual:       person.Manual,
 	}
 }
 
@@ -112,9 +120,14 @@ type (
 		// В качестве PersonID используется полный номер Wigand
 		PersonID int
 		// В качестве TermopadID используется ID кабины, присваиваемый БД
-		TermopadID  int
+		TermopadID  int `gorm:"uniqueIndex:idx_temperature_log_ingestion"`
 		Temperature float64
 		ImageName   string
+		// Имя файла изображения на термопаде. Вместе с TermopadID образует ключ приёма, исключающий
+		// повторную запись замера (NULL для замеров без имени файла)
+		FileName *string `gorm:"uniqueIndex:idx_temperature_log_ingestion"`
+		// Замер вне границ температуры тела (model.IsInvalidReading). Значение хранится со знаком
+		Invalid bool
 	}
 )
 
@@ -122,3 +135,238 @@ type (
 func (Temperature) TableName() string {
 	return "temperature_log"
 }
+
+type (
+	// PersonSync история массовой синхронизации персон с СУДОС
+	PersonSync struct {
+		GormModelUnscoped
+		FinishAt  *time.Time
+		Status    string
+		Total     int
+		Processed int
+		Updated   int
+		Failed    int
+		Error     string
+	}
+)
+
+// TableName имя таблицы
+func (PersonSync) TableName() string {
+	return "person_sync"
+}
+
+// ToPersonSync маппинг данных в структуру model.PersonSync
+func (m PersonSync) ToPersonSync() model.PersonSync {
+	return model.PersonSync{
+		ID:        uint(m.ID),
+		StartAt:   m.CreatedAt,
+		FinishAt:  m.FinishAt,
+		Status:    m.Status,
+		Total:     uint(m.Total),
+		Processed: uint(m.Processed),
+		Updated:   uint(m.Updated),
+		Failed:    uint(m.Failed),
+		Error:     m.Error,
+	}
+}
+
+// FromPersonSync заполняет текущую структуру из структуры model.PersonSync
+func (m *PersonSync) FromPersonSync(sync model.PersonSync) {
+	*m = PersonSync{
+		GormModelUnscoped: GormModelUnscoped{
+			ID:        int(sync.ID),
+			CreatedAt: sync.StartAt,
+		},
+		FinishAt:  sync.FinishAt,
+		Status:    sync.Status,
+		Total:     int(sync.Total),
+		Processed: int(sync.Processed),
+		Updated:   int(sync.Updated),
+		Failed:    int(sync.Failed),
+		Error:     sync.Error,
+	}
+}
+
+type (
+	// TermopadDowntime период недоступности термопада. FinishAt=NULL - термопад недоступен до сих пор
+	TermopadDowntime struct {
+		GormModelUnscoped
+		TermopadID int `gorm:"index"`
+		FinishAt   *time.Time
+	}
+)
+
+// TableName имя таблицы
+func (TermopadDowntime) TableName() string {
+	return "termopad_downtime"
+}
+
+type (
+	// Report сохранённый сводный отчёт. Содержимое хранится в JSON, отчёт однозначно определяется
+	// сутками и сменой
+	Report struct {
+		GormModelUnscoped
+		Date    time.Time `gorm:"uniqueIndex:idx_report_period"`
+		Shift   string    `gorm:"uniqueIndex:idx_report_period"`
+		Content string
+	}
+)
+
+// TableName имя таблицы
+func (Report) TableName() string {
+	return "reports"
+}
+
+type (
+	// WebhookDelivery журнал попыток доставки событий вебхукам
+	WebhookDelivery struct {
+		GormModelUnscoped
+		Webhook    string `gorm:"index"`
+		EventID    string `gorm:"index"`
+		Event      string
+		Attempt    int
+		Status     string
+		StatusCode int
+		Error      string
+		// Длительность попытки в миллисекундах
+		Duration int64
+	}
+)
+
+// TableName имя таблицы
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// ToWebhookDelivery маппинг данных в структуру model.WebhookDelivery
+func (m WebhookDelivery) ToWebhookDelivery() model.WebhookDelivery {
+	return model.WebhookDelivery{
+		ID:         uint(m.ID),
+		CreateAt:   m.CreatedAt,
+		Webhook:    m.Webhook,
+		EventID:    m.EventID,
+		Event:      m.Event,
+		Attempt:    uint(m.Attempt),
+		Status:     m.Status,
+		StatusCode: m.StatusCode,
+		Error:      m.Error,
+		Duration:   time.Duration(m.Duration) * time.Millisecond,
+	}
+}
+
+type (
+	// WebhookDeadLetter события, которые не удалось доставить вебхукам
+	WebhookDeadLetter struct {
+		GormModelUnscoped
+		Webhook  string `gorm:"index"`
+		URL      string
+		EventID  string
+		Event    string
+		Payload  string
+		Attempts int
+		Error    string
+	}
+)
+
+// TableName имя таблицы
+func (WebhookDeadLetter) TableName() string {
+	return "webhook_dead_letters"
+}
+
+// ToWebhookDeadLetter маппинг данных в структуру model.WebhookDeadLetter
+func (m WebhookDeadLetter) ToWebhookDeadLetter() model.WebhookDeadLetter {
+	return model.WebhookDeadLetter{
+		ID:       uint(m.ID),
+		CreateAt: m.CreatedAt,
+		Webhook:  m.Webhook,
+		URL:      m.URL,
+		EventID:  m.EventID,
+		Event:    m.Event,
+		Payload:  m.Payload,
+		Attempts: uint(m.Attempts),
+		Error:    m.Error,
+	}
+}
+
+type (
+	// Alarm тревога о повышенной температуре и её подтверждение ответственным лицом
+	Alarm struct {
+		GormModelUnscoped
+		TermopadID     int `gorm:"index"`
+		TermopadName   string
+		Wigand         int
+		Family         string
+		Name           string
+		MiddleName     string
+		Organization   string
+		Department     string
+		Temperature    float64
+		MaxTemperature float64
+		MinTemperature float64
+		ImageName      string
+		// Время подтверждения обработки тревоги (NULL - тревога не обработана)
+		HandledAt *time.Time `gorm:"index"`
+		HandledBy string
+	}
+)
+
+// TableName имя таблицы
+func (Alarm) TableName() string {
+	return "alarms"
+}
+
+// ToAlarm маппинг данных в структуру model.Alarm
+func (m Alarm) ToAlarm() model.Alarm {
+	return model.Alarm{
+		ID:          uint(m.ID),
+		CreateAt:    m.CreatedAt,
+		Temperature: m.Temperature,
+		Thresholds: model.Thresholds{
+			MaxTemperature: m.MaxTemperature,
+			MinTemperature: m.MinTemperature,
+		},
+		Person: model.Person{
+			Wigand:       model.Wigand{ID: uint(m.Wigand)},
+			Family:       m.Family,
+			Name:         m.Name,
+			MiddleName:   m.MiddleName,
+			Organization: m.Organization,
+			Department:   m.Department,
+		},
+		Termopad:  model.TermopadInfo{ID: uint(m.TermopadID), Name: m.TermopadName},
+		ImageName: m.ImageName,
+		HandledAt: m.HandledAt,
+		HandledBy: m.HandledBy,
+	}
+}
+
+type (
+	// AuditLog запись журнала аудита действий пользователей. Записи только добавляются и не удаляются при
+	// очистке архива
+	AuditLog struct {
+		GormModelUnscoped
+		User    string `gorm:"index"`
+		Action  string `gorm:"index"`
+		Target  string `gorm:"index"`
+		IP      string
+		Details string
+	}
+)
+
+// TableName имя таблицы
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
+
+// ToAudit маппинг данных в структуру model.Audit
+func (m AuditLog) ToAudit() model.Audit {
+	return model.Audit{
+		ID:       uint(m.ID),
+		CreateAt: m.CreatedAt,
+		User:     m.User,
+		Action:   m.Action,
+		Target:   m.Target,
+		IP:       m.IP,
+		Details:  m.Details,
+	}
+}
@@ -11,6 +11,8 @@ import (
 type DbStore interface {
 	// Проверяет, что ошибка err обозначает, что записи не найдены
 	IsNotFound(err error) bool
+	// Проверяет, что ошибка err обозначает повторную запись уже сохранённых данных
+	IsDuplicate(err error) bool
 
 	// Получает персону из БД по номеру wigand. Отсутсвие персоны в БД проверяется через IsNotFound
 	GetPerson(wigandID uint) (*model.Person, error)
@@ -18,6 +20,16 @@ type DbStore interface {
 	// Добавляет персону в БД. Если персоны нет, она будет добавлена и вернётся true.
 	// Если персона уже была, она будет обновлена и вернётся false
 	SetPerson(model.Person) (*model.Person, bool, error)
+	// Возвращает не более limit персон, упорядоченных по номеру виганда, начиная со следующей
+	// за afterWigand. Непустой search отбирает персоны по части ФИО, организации, должности или виганда
+	Persons(search string, afterWigand uint, limit uint) ([]model.Person, error)
+	// Возвращает номера вигандов всех известных персон из СУДОС (из справочника и лога замеров)
+	KnownWigands() ([]uint, error)
+
+	// Сохраняет запись истории синхронизации персон с СУДОС. При ID=0 создаётся новая запись
+	SetPersonSync(model.PersonSync) (*model.PersonSync, error)
+	// Возвращает не более limit последних записей истории синхронизации персон
+	PersonSyncs(limit uint) ([]model.PersonSync, error)
 
 	// Получает изображение по его идентификационнай имени в БД
 	TempImage(string) ([]byte, error)
@@ -26,7 +38,7 @@ type DbStore interface {
 
 	// Возвращает путь до изображения персоны
 	PersonImage(wigand uint) ([]byte, error)
-	// Сохраняет изображения персоны в БД и возвращает имя созданного файла
+	// Сохраняет изображения персоны в БД, заменяя предыдущее
 	SetPersonImage(wigand uint, content []byte) error
 
 	// Получение лога температур для указаной персоны, за период, не более указанного
@@ -34,8 +46,23 @@ type DbStore interface {
 	TemperatureLogByPerson(uint, time.Duration) ([]TemperatureLog, error)
 	// Получение лога температур по выбранному термопаду, за период, не более указанного
 	TemperatureLogByTermopad(uint, time.Duration) ([]TemperatureLog, error)
-	// Сохранение текущего замера температуры в лог замеров
-	SetTemperatureLog(termopadID uint, wigandID uint, temperature float64, imageName string) error
+	// Получение лога температур всех термопадов за период [from, to), упорядоченного по времени. Для персон,
+	// не найденных в справочнике, заполняется только виганд
+	TemperatureLogByPeriod(from time.Time, to time.Time) ([]TemperatureLog, error)
+	// Контакты персоны wigandID: персоны, измерявшиеся на тех же термопадах не дальше window до или после её
+	// замеров за период [from, to). termopads ограничивает поиск указанными термопадами (пустой - все).
+	// Результат упорядочен по убыванию количества пересечений
+	Contacts(wigandID uint, from time.Time, to time.Time, window time.Duration, termopads []uint) ([]Contact, error)
+	// Сохранение текущего замера температуры в лог замеров. fileName - имя файла на термопаде; если замер
+	// с таким же именем файла с этого термопада уже сохранён, возвращается ошибка, проверяемая IsDuplicate
+	SetTemperatureLog(termopadID uint, fileName string, wigandID uint, temperature float64, imageName string) error
+	// Проверяет, сохранён ли в логе замер с именем файла fileName с термопада termopadID
+	HasTemperatureLog(termopadID uint, fileName string) (bool, error)
+	// Возвращает последний замер температуры персоны. Отсутствие замеров проверяется через IsNotFound
+	LastTemperature(wigandID uint) (*model.Temperature, error)
+	// Возвращает последние замеры температуры персон wigandIDs по их вигандам. Персоны без замеров в результат
+	// не попадают
+	LastTemperatures(wigandIDs []uint) (map[uint]model.Temperature, error)
 	// Возвращает описание последней замерившейся персоны и её температуры на термопаде.
 	// Если запись не найдена или не найдена персона для этой записи, возвращается gorm.ErrRecordNotFound
 	LastPerson(termopadID uint) (*LastPerson, error)
@@ -49,9 +76,69 @@ type DbStore interface {
 	// каждому замеру температуры. Если compact=true - данные замеров сжимаются до дней и температура показыватся
 	// только минимальная и максимальная для каждого дня
 	TermopadLog(termopadID uint, days uint, offsetDays uint, compact bool) ([]model.TemperatureMetric, error)
+	// Возвращает значения температур для термопада с termopadID за период [from, to). При compact=true замеры
+	// сжимаются до дней, как в TermopadLog. Для неизвестных карт заполняется только виганд персоны
+	TermopadPeriodLog(termopadID uint, from time.Time, to time.Time, compact bool) ([]model.TemperatureMetric, error)
+
+	// Отмечает потерю (online=false) или восстановление связи с термопадом в момент at
+	SetTermopadStatus(termopadID uint, online bool, at time.Time) error
+	// Возвращает суммарное время недоступности термопада в пределах периода [from, to)
+	TermopadDowntime(termopadID uint, from time.Time, to time.Time) (time.Duration, error)
+
+	// Возвращает сохранённый отчёт за сутки date по смене shift. Отсутствие отчёта проверяется через IsNotFound
+	Report(date time.Time, shift string) (*model.Report, error)
+	// Сохраняет отчёт, заменяя ранее сохранённый за те же сутки и смену
+	SetReport(model.Report) error
+
+	// Добавляет запись в журнал доставки событий вебхукам
+	SetWebhookDelivery(model.WebhookDelivery) error
+	// Возвращает не более limit последних записей журнала доставки. Непустые webhook и event отбирают
+	// записи указанного вебхука и типа события
+	WebhookDeliveries(webhook string, event string, limit uint) ([]model.WebhookDelivery, error)
+	// Сохраняет событие, которое не удалось доставить вебхуку
+	SetWebhookDeadLetter(model.WebhookDeadLetter) error
+	// Возвращает не более limit последних недоставленных событий (непустой webhook - только указанного вебхука)
+	WebhookDeadLetters(webhook string, limit uint) ([]model.WebhookDeadLetter, error)
+
+	// Сохраняет тревогу о повышенной температуре и возвращает её идентификатор
+	SetAlarm(model.Alarm) (uint, error)
+	// Отмечает тревогу id обработанной пользователем by в момент at и возвращает её. Уже обработанная тревога
+	// не изменяется. Отсутствие тревоги проверяется через IsNotFound
+	HandleAlarm(id uint, by string, at time.Time) (*model.Alarm, error)
+	// Возвращает не более limit последних тревог (при unhandled=true - только необработанных)
+	Alarms(unhandled bool, limit uint) ([]model.Alarm, error)
 
-	// Очищает записи в БД старше days дней
-	Clean(days int) error
+	// Добавляет запись в журнал аудита. Записи журнала не изменяются и не удаляются
+	SetAudit(model.Audit) error
+	// Возвращает не более limit последних записей журнала аудита, отобранных по filter
+	AuditLog(filter model.AuditFilter, limit uint) ([]model.Audit, error)
+
+	// Возвращает сохранённые пороги нормальной температуры. Отсутствие записи проверяется через IsNotFound
+	Thresholds() (*model.Thresholds, error)
+	// Сохраняет пороги нормальной температуры
+	SetThresholds(model.Thresholds) error
+
+	// Изменяет список описаний термопадов, используемых в логах температуры
+	SetTermopads([]model.TermopadInfo)
+
+	// Стирает персональные данные персоны: запись справочника, фотографию и изображения замеров. Записи лога
+	// температуры и тревоги удаляются, а при pseudonymize=true сохраняются под псевдонимом без идентифицирующих
+	// данных. Отсутствие данных о персоне проверяется через IsNotFound
+	ErasePerson(wigandID uint, pseudonymize bool) (*model.Erasure, error)
+	// Возвращает виганды персон справочника, внесённых раньше before и не проходивших замеров начиная с before
+	InactivePersons(before time.Time) ([]uint, error)
+	// Очищает записи лога температуры и директории изображений замеров старше days дней. При dryRun=true
+	// ничего не удаляет, а только возвращает то, что было бы удалено
+	Clean(days int, dryRun bool) (*CleanResult, error)
+	// Проверяет файлы изображений замеров и персон: перемещает изображения замеров в директории, соответствующие
+	// их имени, и возвращает список файлов, не являющихся изображениями JPEG. При dryRun=true ничего не перемещает
+	ReprocessImages(dryRun bool) (*ReprocessResult, error)
+	// Перешифровывает файлы изображений замеров и персон ключом newKey (nil - расшифровывает). Текущий ключ
+	// берётся из конфигурации, открытые файлы шифруются
+	RekeyImages(newKey []byte) (*RekeyResult, error)
+	// Сверяет изображения замеров с логом температуры: находит изображения без записи в логе и записи лога без
+	// изображения, появившиеся раньше before. По options восстанавливает записи лога или удаляет найденное
+	Reconcile(before time.Time, options ReconcileOptions) (*ReconcileResult, error)
 }
 
 // TemperatureLog описывает данные из лога температуры
@@ -61,6 +148,90 @@ type TemperatureLog struct {
 	TermopadID int
 	CreatedAt  *time.Time
 	Person     model.Person
+	// Значение замера и имя файла изображения замера
+	Temperature float64
+	ImageName   string
+	// Замер недостоверен как температура тела
+	Invalid bool
+}
+
+// CleanResult результат очистки БД
+type CleanResult struct {
+	// Количество удалённых записей лога температуры
+	Temperatures int64
+	// Количество удалённых записей журнала доставки событий вебхукам
+	WebhookDeliveries int64
+	// Количество удалённых тревог
+	Alarms int64
+	// Удалённые директории изображений замеров (по одной на день)
+	ImageDirs []string
+}
+
+// ReprocessResult результат проверки файлов изображений
+type ReprocessResult struct {
+	// Количество проверенных файлов
+	Checked int
+	// Изображения замеров, перемещённые в соответствующие их имени директории
+	Moved []string
+	// Файлы, не являющиеся изображениями JPEG или с некорректным именем
+	Invalid []string
+}
+
+// RekeyResult результат перешифрования файлов изображений
+type RekeyResult struct {
+	// Количество проверенных файлов
+	Checked int
+	// Количество перешифрованных файлов
+	Rekeyed int
+	// Количество файлов, уже зашифрованных новым ключом
+	Skipped int
+	// Файлы, которые не удалось расшифровать текущим ключом
+	Failed []string
+}
+
+// ReconcileOptions действия над найденными при сверке изображениями и записями лога температуры. Если действия
+// не заданы, найденное только возвращается
+type ReconcileOptions struct {
+	// Восстановить записи лога для изображений без записи. Термопад и температура берутся из тревоги с тем же
+	// изображением, иначе записывается недостоверный замер на термопаде Termopad
+	Recreate bool
+	// Термопад восстанавливаемых записей, не найденных в тревогах (0 - такие записи не восстанавливаются)
+	Termopad uint
+	// Удалить изображения без записи в логе, для которых запись не восстановлена
+	CleanImages bool
+	// Удалить записи лога, изображение которых отсутствует
+	CleanTemperatures bool
+}
+
+// ReconcileResult результат сверки изображений замеров с логом температуры
+type ReconcileResult struct {
+	// Количество проверенных изображений и записей лога
+	Images       int
+	Temperatures int
+	// Изображения без записи в логе температуры
+	OrphanImages []string
+	// Записи лога температуры, изображение которых отсутствует
+	OrphanTemperatures []TemperatureLog
+	// Изображения, для которых восстановлены записи лога
+	Recreated []string
+	// Изображения стёртых персон: записи для них не восстанавливаются
+	Erased []string
+	// Удалённые изображения и количество удалённых записей лога
+	RemovedImages       []string
+	RemovedTemperatures int64
+}
+
+// Contact персона, измерявшаяся рядом по времени с замерами другой персоны на том же термопаде
+type Contact struct {
+	// Для персон, не найденных в справочнике, заполняется только виганд
+	Person model.Person
+	// Количество замеров исходной персоны, в окно которых попал контакт
+	Overlaps uint
+	// Термопады, на которых произошли контакты
+	Termopads []uint
+	// Время первого и последнего замера контакта в пределах окон
+	FirstAt time.Time
+	LastAt  time.Time
 }
 
 // LastPerson информация о последней зарегистрированной на термопаде персоны